@@ -1,13 +1,62 @@
 package main
 
 import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/intra-sh/icap"
+	"github.com/konflux-ci/caching/internal/buildinfo"
+	"github.com/konflux-ci/caching/internal/cdnpatterns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// defaultModifyMethods lists the HTTP methods eligible for modification when ICAP_MODIFY_METHODS is unset
+const defaultModifyMethods = "GET,HEAD"
+
+var (
+	// rateLimiter limits Authorization removal per destination host (disabled by default)
+	rateLimiter = newHostRateLimiter(0, 0)
+
+	// previewBytes is the preview size advertised in OPTIONS responses (no preview by default)
+	previewBytes = 0
+
+	// maxBodyBytes is the largest request body returned in a modified request (unlimited when 0)
+	maxBodyBytes int64 = 0
+
+	// modifyMethods are the HTTP methods whose requests may be modified (downloads by default)
+	modifyMethods = parseModifyMethods(defaultModifyMethods)
+
+	icapRateLimitedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "icap_rate_limited_total",
+			Help: "Total number of REQMOD requests passed through unmodified because the per-host rate limit was exceeded",
+		},
+	)
+
+	icapBodyLimitExceededTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "icap_body_limit_exceeded_total",
+			Help: "Total number of REQMOD requests passed through unmodified because the request body exceeds ICAP_MAX_BODY_BYTES",
+		},
+	)
+
+	icapBuildInfo = buildinfo.NewGauge("icap_build_info", "ICAP server build information, always 1")
+)
+
+func init() {
+	prometheus.MustRegister(icapRateLimitedTotal)
+	prometheus.MustRegister(icapBodyLimitExceededTotal)
+	prometheus.MustRegister(icapBuildInfo)
+}
+
 // reqmodHandler handles REQMOD requests
 func reqmodHandler(w icap.ResponseWriter, req *icap.Request) {
 	h := w.Header()
@@ -19,8 +68,11 @@ func reqmodHandler(w icap.ResponseWriter, req *icap.Request) {
 		h.Set("Methods", "REQMOD")
 		// Support 204 responses (if the client also allows it)
 		h.Set("Allow", "204")
-		// Don't allow clients to send preview bytes
-		h.Set("Preview", "0")
+		// Number of body bytes clients send as preview before the full message
+		h.Set("Preview", strconv.Itoa(previewBytes))
+		if previewBytes > 0 {
+			h.Set("Transfer-Preview", "*")
+		}
 		writeHeaderAndLog(w, req, 200)
 	case "REQMOD":
 		// If there is no encapsulated HTTP request, return a 200 response
@@ -30,22 +82,42 @@ func reqmodHandler(w icap.ResponseWriter, req *icap.Request) {
 		}
 
 		// Squid's adaptation_access ACLs ensure we receive URLs from cache.allowList.
-		// Only remove Authorization header for content-addressable URLs (containing SHA256).
-		if strings.Contains(req.Request.URL.Path, "/sha256/") {
+		// Only remove Authorization header for content-addressable URLs (containing SHA256 or
+		// matching a known registry CDN pattern). Registry token requests always keep their credentials,
+		// and so do methods that are not eligible for modification (e.g. PUT for uploads).
+		if modifyMethods[req.Request.Method] &&
+			cdnpatterns.IsContentAddressable(req.Request.URL) && !isTokenEndpoint(req.Request.URL) {
+			// When the destination host exceeds its rate limit, pass the request through
+			// unmodified instead of blocking it
+			if !rateLimiter.Allow(requestHost(req.Request)) {
+				icapRateLimitedTotal.Inc()
+				writeUnmodified(w, req)
+				return
+			}
+
+			// Modified requests are echoed with their body, so large uploads are not relayed
+			// through the ICAP server when a body limit is configured
+			if bodyExceedsLimit(req.Request) {
+				icapBodyLimitExceededTotal.Inc()
+				log.Println(req.Method, "body exceeds ICAP_MAX_BODY_BYTES, passing request through unmodified")
+				writeUnmodified(w, req)
+				return
+			}
+
+			// A modified request must be returned with its complete body, so the rest of a
+			// previewed body is requested before the response header is written
+			if isPreview(req) && !continueAfterPreview(req) {
+				writeHeaderAndLog(w, req, 500)
+				return
+			}
+
 			req.Request.Header.Del("Authorization")
 			writeHeaderAndLog(w, req, 200)
 			return
 		}
 
 		// No modification is needed for the request
-		// If the client allows 204 responses, use that to reduce bandwidth usage
-		if req.Header.Get("Allow") == "204" {
-			writeHeaderAndLog(w, req, 204)
-			return
-		}
-
-		// Otherwise, return a 200 response
-		writeHeaderAndLog(w, req, 200)
+		writeUnmodified(w, req)
 	default:
 		// Unsupported method
 		writeHeaderAndLog(w, req, 405)
@@ -54,27 +126,199 @@ func reqmodHandler(w icap.ResponseWriter, req *icap.Request) {
 
 // writeHeaderAndLog writes the ICAP response header and logs the request with the resulting status code
 func writeHeaderAndLog(w icap.ResponseWriter, req *icap.Request, code int) {
-	url := ""
-	if req.Request != nil {
-		// Remove credentials and potentially sensitive query parameters from the encapsulate HTTP request URL
-		url = strings.SplitN(req.Request.URL.Redacted(), "?", 2)[0]
-	}
-
-	log.Println(req.Method, code, url)
+	log.Println(req.Method, code, requestLogURL(req))
 
 	if req.Request != nil && code == 200 {
-		w.WriteHeader(code, req.Request, false)
+		// A 200 response replaces the request, so its body must be sent back as well
+		hasBody := hasRequestBody(req.Request)
+		w.WriteHeader(code, req.Request, hasBody)
+		if hasBody {
+			writeRequestBody(w, req.Request)
+		}
 	} else {
 		w.WriteHeader(code, nil, false)
 	}
 }
 
+// hasRequestBody returns true if the encapsulated HTTP request has a (possibly chunked) body
+func hasRequestBody(r *http.Request) bool {
+	return r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0
+}
+
+// writeRequestBody streams the encapsulated request body into the ICAP response. The body is
+// copied through a fixed-size buffer as it arrives, so uploads are never held in memory.
+func writeRequestBody(w icap.ResponseWriter, r *http.Request) {
+	if _, err := io.Copy(w, r.Body); err != nil {
+		log.Println("Error writing request body:", err)
+	}
+}
+
+// bodyExceedsLimit returns true if a body limit is configured and the request body is larger than
+// it. Chunked bodies of unknown length are treated as exceeding the limit, since the response
+// header must be sent before the body is read.
+func bodyExceedsLimit(r *http.Request) bool {
+	if maxBodyBytes <= 0 || !hasRequestBody(r) {
+		return false
+	}
+	return r.ContentLength < 0 || r.ContentLength > maxBodyBytes
+}
+
+// writeUnmodified tells the client to use the request as-is. A 204 response avoids sending the
+// request back; it is allowed when the client advertises "Allow: 204", and always after a preview.
+func writeUnmodified(w icap.ResponseWriter, req *icap.Request) {
+	if req.Header.Get("Allow") == "204" || isPreview(req) {
+		writeHeaderAndLog(w, req, 204)
+		return
+	}
+	writeHeaderAndLog(w, req, 200)
+}
+
+// isPreview returns true if the client sent a preview of the encapsulated body
+func isPreview(req *icap.Request) bool {
+	return req.Header.Get("Preview") != ""
+}
+
+// continueAfterPreview makes the rest of a previewed request body available. Reading past the
+// preview asks the client for the rest of the body with "100 Continue", which must be sent before
+// the response header, so the first byte after the preview is read ahead. It returns false if the
+// rest of the body cannot be read.
+func continueAfterPreview(req *icap.Request) bool {
+	body := bufio.NewReaderSize(req.Request.Body, len(req.Preview)+1)
+	if _, err := body.Peek(len(req.Preview) + 1); err != nil && err != io.EOF {
+		log.Println(req.Method, "error reading the body after the preview:", err)
+		return false
+	}
+	req.Request.Body = io.NopCloser(body)
+	return true
+}
+
+// previewBytesFromEnv returns the preview size from ICAP_PREVIEW_BYTES.
+// Previews are disabled (0) when it is unset, invalid, or negative.
+func previewBytesFromEnv() int {
+	value := os.Getenv("ICAP_PREVIEW_BYTES")
+	if value == "" {
+		return 0
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 0 {
+		log.Printf("Ignoring invalid ICAP_PREVIEW_BYTES %q", value)
+		return 0
+	}
+	log.Printf("Advertising a preview of %d bytes", size)
+	return size
+}
+
+// maxBodyBytesFromEnv returns the request body limit from ICAP_MAX_BODY_BYTES.
+// The limit is disabled (0) when it is unset, invalid, or negative.
+func maxBodyBytesFromEnv() int64 {
+	value := os.Getenv("ICAP_MAX_BODY_BYTES")
+	if value == "" {
+		return 0
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		log.Printf("Ignoring invalid ICAP_MAX_BODY_BYTES %q", value)
+		return 0
+	}
+	log.Printf("Passing through request bodies larger than %d bytes unmodified", size)
+	return size
+}
+
+// parseModifyMethods parses a comma-separated list of HTTP methods into a set
+func parseModifyMethods(value string) map[string]bool {
+	methods := make(map[string]bool)
+	for _, method := range strings.Split(value, ",") {
+		if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+			methods[method] = true
+		}
+	}
+	return methods
+}
+
+// modifyMethodsFromEnv returns the HTTP methods eligible for modification from ICAP_MODIFY_METHODS
+// (comma-separated), or GET and HEAD when it is unset or lists no methods
+func modifyMethodsFromEnv() map[string]bool {
+	value := os.Getenv("ICAP_MODIFY_METHODS")
+	if value == "" {
+		return parseModifyMethods(defaultModifyMethods)
+	}
+	methods := parseModifyMethods(value)
+	if len(methods) == 0 {
+		log.Printf("Ignoring invalid ICAP_MODIFY_METHODS %q", value)
+		return parseModifyMethods(defaultModifyMethods)
+	}
+	log.Printf("Modifying requests with methods %s", value)
+	return methods
+}
+
+// requestLogURL returns the encapsulated HTTP request URL in a form that is safe to log
+func requestLogURL(req *icap.Request) string {
+	if req.Request == nil {
+		return ""
+	}
+	if isTokenEndpoint(req.Request.URL) {
+		// Keep the token scope for troubleshooting but redact credentials and tokens
+		return redactTokenURL(req.Request.URL)
+	}
+	// Remove credentials and potentially sensitive query parameters from the encapsulate HTTP request URL
+	return strings.SplitN(req.Request.URL.Redacted(), "?", 2)[0]
+}
+
+// requestHost returns the destination host of the encapsulated HTTP request
+func requestHost(r *http.Request) string {
+	if host := r.URL.Hostname(); host != "" {
+		return host
+	}
+	return r.Host
+}
+
+// newRateLimiterFromEnv creates the rate limiter from ICAP_RATE_LIMIT (requests per second per host)
+// and ICAP_RATE_BURST. Rate limiting is disabled when ICAP_RATE_LIMIT is unset, invalid, or <= 0.
+func newRateLimiterFromEnv() *hostRateLimiter {
+	rate, err := strconv.ParseFloat(os.Getenv("ICAP_RATE_LIMIT"), 64)
+	if err != nil || rate <= 0 {
+		return newHostRateLimiter(0, 0)
+	}
+
+	// Default the burst to one second worth of requests
+	burst := int(rate)
+	if value := os.Getenv("ICAP_RATE_BURST"); value != "" {
+		if b, err := strconv.Atoi(value); err == nil && b > 0 {
+			burst = b
+		}
+	}
+
+	log.Printf("Rate limiting enabled: %g requests/s per host, burst %d", rate, burst)
+	return newHostRateLimiter(rate, burst)
+}
+
+// serveMetrics exposes Prometheus metrics on addr in the background
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		log.Println("Serving metrics on", addr)
+		//nolint:gosec // metrics listener; HTTP server timeouts not required
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Println("Error serving metrics:", err)
+		}
+	}()
+}
+
 func logICAPStartup(port string) {
 	//nolint:gosec // G706: port may flow from ICAP_PORT (os.Getenv); deployment-controlled, default is numeric
 	log.Println("Starting ICAP server on port", port)
 }
 
 func main() {
+	showVersion := flag.Bool("version", false, "Print version information and exit")
+	flag.Parse()
+	if *showVersion {
+		fmt.Println(buildinfo.String("icap-server"))
+		return
+	}
+
 	log.SetOutput(os.Stdout)
 
 	port := os.Getenv("ICAP_PORT")
@@ -82,10 +326,26 @@ func main() {
 		port = "1344"
 	}
 
+	rateLimiter = newRateLimiterFromEnv()
+	previewBytes = previewBytesFromEnv()
+	maxBodyBytes = maxBodyBytesFromEnv()
+	modifyMethods = modifyMethodsFromEnv()
+	tokenEndpointPatterns = tokenEndpointPatternsFromEnv()
+
+	// Metrics are only served when ICAP_METRICS_ADDR is set (e.g. ":9303")
+	if metricsAddr := os.Getenv("ICAP_METRICS_ADDR"); metricsAddr != "" {
+		serveMetrics(metricsAddr)
+	}
+
 	icap.HandleFunc("/reqmod", reqmodHandler)
 
 	logICAPStartup(port)
-	if err := icap.ListenAndServe(":"+port, nil); err != nil {
+	listener, err := newListener(":" + port)
+	if err != nil {
+		log.Println("Error starting server:", err)
+		os.Exit(1)
+	}
+	if err := icap.Serve(listener, nil); err != nil {
 		log.Println("Error starting server:", err)
 		os.Exit(1)
 	}
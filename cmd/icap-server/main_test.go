@@ -1,15 +1,27 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httputil"
 	"net/textproto"
+	"runtime"
+	"strconv"
 	"strings"
+	"testing/iotest"
+	"time"
 
 	"github.com/intra-sh/icap"
+	"github.com/konflux-ci/caching/internal/cdnpatterns"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 )
 
 var _ = Describe("reqmodHandler", func() {
@@ -36,6 +48,18 @@ var _ = Describe("reqmodHandler", func() {
 			Expect(mockWriter.Header().Get("Methods")).To(Equal("REQMOD"))
 			Expect(mockWriter.Header().Get("Allow")).To(Equal("204"))
 			Expect(mockWriter.Header().Get("Preview")).To(Equal("0"))
+			Expect(mockWriter.Header().Get("Transfer-Preview")).To(BeEmpty())
+			Expect(mockWriter.StatusCode).To(Equal(200))
+		})
+
+		It("should advertise the configured preview size", func() {
+			previewBytes = 4096
+			DeferCleanup(func() { previewBytes = 0 })
+
+			reqmodHandler(mockWriter, &icap.Request{Method: "OPTIONS", Header: make(textproto.MIMEHeader)})
+
+			Expect(mockWriter.Header().Get("Preview")).To(Equal("4096"))
+			Expect(mockWriter.Header().Get("Transfer-Preview")).To(Equal("*"))
 			Expect(mockWriter.StatusCode).To(Equal(200))
 		})
 	})
@@ -75,6 +99,207 @@ var _ = Describe("reqmodHandler", func() {
 				Expect(httpReq.Header.Get("Authorization")).To(BeEmpty())
 				Expect(httpReq.Header.Get("User-Agent")).To(Equal("test-agent"))
 			})
+
+			It("should remove Authorization header for all shared CDN pattern examples", func() {
+				for _, pattern := range cdnpatterns.Patterns {
+					httpReq, _ := http.NewRequest("GET", pattern.Example, nil)
+					httpReq.Header.Set("Authorization", "Bearer token123")
+
+					reqmodHandler(mockWriter, &icap.Request{
+						Method:  "REQMOD",
+						Header:  make(textproto.MIMEHeader),
+						Request: httpReq,
+					})
+
+					Expect(httpReq.Header.Get("Authorization")).To(BeEmpty(), "pattern %s", pattern.Name)
+				}
+			})
+
+			It("should remove Authorization header for signature and attestation blobs", func() {
+				httpReq, _ := http.NewRequest("GET", "https://cdn02.quay.io/quayio-production-s3/sha256/3f/"+
+					"3fa1c9e6b0d24d0c6a3c5e8f1b2d4a6c8e0f2a4b6c8d0e2f4a6b8c0d2e4f6a8b?X-Amz-Signature=abc", nil)
+				httpReq.Header.Set("Authorization", "Bearer token123")
+
+				reqmodHandler(mockWriter, &icap.Request{Method: "REQMOD", Header: make(textproto.MIMEHeader), Request: httpReq})
+
+				Expect(httpReq.Header.Get("Authorization")).To(BeEmpty())
+			})
+
+			DescribeTable("should keep Authorization header for mutable signature references",
+				func(rawURL string) {
+					httpReq, _ := http.NewRequest("GET", rawURL, nil)
+					httpReq.Header.Set("Authorization", "Bearer token123")
+
+					reqmodHandler(mockWriter, &icap.Request{Method: "REQMOD", Header: make(textproto.MIMEHeader), Request: httpReq})
+
+					Expect(httpReq.Header.Get("Authorization")).To(Equal("Bearer token123"))
+				},
+				Entry("cosign signature tag", "https://quay.io/v2/konflux-ci/caching/manifests/sha256-"+strings.Repeat("ab", 32)+".sig"),
+				Entry("referrers API", "https://quay.io/v2/konflux-ci/caching/referrers/sha256:"+strings.Repeat("ab", 32)),
+			)
+
+			It("should return the modified request with its body", func() {
+				setModifyMethods("GET,HEAD,PUT")
+				httpReq, _ := http.NewRequest("PUT", "https://cdn.example.com/blobs/sha256/ab/abcdef1234567890", strings.NewReader("payload"))
+				httpReq.Header.Set("Authorization", "Bearer token123")
+
+				reqmodHandler(mockWriter, &icap.Request{Method: "REQMOD", Header: make(textproto.MIMEHeader), Request: httpReq})
+
+				Expect(mockWriter.StatusCode).To(Equal(200))
+				Expect(mockWriter.HasBody).To(BeTrue())
+				Expect(mockWriter.Body.String()).To(Equal("payload"))
+				Expect(httpReq.Header.Get("Authorization")).To(BeEmpty())
+			})
+
+			It("should stream large request bodies without buffering them", func() {
+				const size = 256 << 20
+				setModifyMethods("GET,HEAD,PUT")
+				httpReq, _ := http.NewRequest("PUT", "https://cdn.example.com/blobs/sha256/ab/abcdef1234567890", &zeroBodyReader{remaining: size})
+				httpReq.ContentLength = size
+				httpReq.Header.Set("Authorization", "Bearer token123")
+				writer := &countingResponseWriter{MockResponseWriter: MockResponseWriter{HeaderMap: make(http.Header)}}
+
+				var before, after runtime.MemStats
+				runtime.ReadMemStats(&before)
+				reqmodHandler(writer, &icap.Request{Method: "REQMOD", Header: make(textproto.MIMEHeader), Request: httpReq})
+				runtime.ReadMemStats(&after)
+
+				Expect(writer.StatusCode).To(Equal(200))
+				Expect(writer.HasBody).To(BeTrue())
+				Expect(writer.BodyBytes).To(BeEquivalentTo(size))
+				Expect(after.TotalAlloc-before.TotalAlloc).To(BeNumerically("<", 4<<20), "the body should be streamed, not buffered")
+			})
+
+			Context("when the request body exceeds the body limit", func() {
+				BeforeEach(func() {
+					maxBodyBytes = 4
+					DeferCleanup(func() { maxBodyBytes = 0 })
+					setModifyMethods("GET,HEAD,PUT")
+				})
+
+				newBodyRequest := func(body io.Reader, allow204 bool) (*icap.Request, *http.Request) {
+					httpReq, _ := http.NewRequest("PUT", "https://cdn.example.com/blobs/sha256/ab/abcdef1234567890", body)
+					httpReq.Header.Set("Authorization", "Bearer token123")
+					icapReq := &icap.Request{Method: "REQMOD", Header: make(textproto.MIMEHeader), Request: httpReq}
+					if allow204 {
+						icapReq.Header.Set("Allow", "204")
+					}
+					return icapReq, httpReq
+				}
+
+				It("should return 204 and count the request when the client allows 204 responses", func() {
+					icapReq, httpReq := newBodyRequest(strings.NewReader("payload"), true)
+					before := counterValue(icapBodyLimitExceededTotal)
+
+					reqmodHandler(mockWriter, icapReq)
+
+					Expect(mockWriter.StatusCode).To(Equal(204))
+					Expect(httpReq.Header.Get("Authorization")).To(Equal("Bearer token123"))
+					Expect(counterValue(icapBodyLimitExceededTotal)).To(Equal(before + 1))
+				})
+
+				It("should return 200 and pass the request through unmodified with its body", func() {
+					icapReq, httpReq := newBodyRequest(strings.NewReader("payload"), false)
+
+					reqmodHandler(mockWriter, icapReq)
+
+					Expect(mockWriter.StatusCode).To(Equal(200))
+					Expect(mockWriter.Body.String()).To(Equal("payload"))
+					Expect(httpReq.Header.Get("Authorization")).To(Equal("Bearer token123"))
+				})
+
+				It("should pass chunked bodies of unknown length through unmodified", func() {
+					icapReq, httpReq := newBodyRequest(strings.NewReader("ab"), true)
+					httpReq.ContentLength = -1
+
+					reqmodHandler(mockWriter, icapReq)
+
+					Expect(mockWriter.StatusCode).To(Equal(204))
+					Expect(httpReq.Header.Get("Authorization")).To(Equal("Bearer token123"))
+				})
+
+				It("should modify requests whose body is within the limit", func() {
+					icapReq, httpReq := newBodyRequest(strings.NewReader("pay"), true)
+
+					reqmodHandler(mockWriter, icapReq)
+
+					Expect(mockWriter.StatusCode).To(Equal(200))
+					Expect(mockWriter.Body.String()).To(Equal("pay"))
+					Expect(httpReq.Header.Get("Authorization")).To(BeEmpty())
+				})
+			})
+
+			Context("when the destination host exceeds the rate limit", func() {
+				BeforeEach(func() {
+					old := rateLimiter
+					rateLimiter = newHostRateLimiter(0.001, 1)
+					DeferCleanup(func() { rateLimiter = old })
+				})
+
+				It("should return 200 and pass the request through unmodified", func() {
+					newRequest := func() *http.Request {
+						httpReq, _ := http.NewRequest("GET", "https://limited.example.com/blobs/sha256/ab/abcdef1234567890", nil)
+						httpReq.Header.Set("Authorization", "Bearer token123")
+						return httpReq
+					}
+					before := counterValue(icapRateLimitedTotal)
+
+					// The first request consumes the only token
+					first := newRequest()
+					reqmodHandler(mockWriter, &icap.Request{Method: "REQMOD", Header: make(textproto.MIMEHeader), Request: first})
+					Expect(mockWriter.StatusCode).To(Equal(200))
+					Expect(first.Header.Get("Authorization")).To(BeEmpty())
+
+					// The second request is rate limited
+					second := newRequest()
+					reqmodHandler(mockWriter, &icap.Request{Method: "REQMOD", Header: make(textproto.MIMEHeader), Request: second})
+					Expect(mockWriter.StatusCode).To(Equal(200))
+					Expect(mockWriter.HttpMessage).To(Equal(second))
+					Expect(second.Header.Get("Authorization")).To(Equal("Bearer token123"))
+					Expect(counterValue(icapRateLimitedTotal)).To(Equal(before + 1))
+				})
+			})
+		})
+
+		Context("with an HTTP method that is not eligible for modification", func() {
+			newRequest := func(method string) *http.Request {
+				httpReq, _ := http.NewRequest(method, "https://cdn.example.com/blobs/sha256/ab/abcdef1234567890", nil)
+				httpReq.Header.Set("Authorization", "Bearer token123")
+				return httpReq
+			}
+
+			It("should keep the Authorization header on a PUT while stripping it from a GET", func() {
+				put := newRequest("PUT")
+				reqmodHandler(mockWriter, &icap.Request{Method: "REQMOD", Header: make(textproto.MIMEHeader), Request: put})
+				Expect(mockWriter.StatusCode).To(Equal(200))
+				Expect(mockWriter.HttpMessage).To(Equal(put))
+				Expect(put.Header.Get("Authorization")).To(Equal("Bearer token123"))
+
+				get := newRequest("GET")
+				reqmodHandler(mockWriter, &icap.Request{Method: "REQMOD", Header: make(textproto.MIMEHeader), Request: get})
+				Expect(mockWriter.StatusCode).To(Equal(200))
+				Expect(get.Header.Get("Authorization")).To(BeEmpty())
+			})
+
+			It("should return 204 when the client allows 204 responses", func() {
+				put := newRequest("PUT")
+				icapReq := &icap.Request{Method: "REQMOD", Header: make(textproto.MIMEHeader), Request: put}
+				icapReq.Header.Set("Allow", "204")
+
+				reqmodHandler(mockWriter, icapReq)
+
+				Expect(mockWriter.StatusCode).To(Equal(204))
+				Expect(put.Header.Get("Authorization")).To(Equal("Bearer token123"))
+			})
+
+			It("should modify the method once it is configured as eligible", func() {
+				setModifyMethods("GET,HEAD,PUT")
+				put := newRequest("PUT")
+
+				reqmodHandler(mockWriter, &icap.Request{Method: "REQMOD", Header: make(textproto.MIMEHeader), Request: put})
+
+				Expect(put.Header.Get("Authorization")).To(BeEmpty())
+			})
 		})
 
 		Context("with a non-content-addressable URL", func() {
@@ -118,6 +343,76 @@ var _ = Describe("reqmodHandler", func() {
 		})
 	})
 
+	When("handling REQMOD requests with a preview", func() {
+		BeforeEach(func() {
+			setModifyMethods("GET,HEAD,POST")
+		})
+
+		newPreviewRequest := func(requestURL, body string, preview string) (*icap.Request, *http.Request) {
+			httpReq, _ := http.NewRequest("POST", requestURL, strings.NewReader(body))
+			httpReq.Header.Set("Authorization", "Bearer token123")
+			icapReq := &icap.Request{
+				Method:  "REQMOD",
+				Header:  make(textproto.MIMEHeader),
+				Request: httpReq,
+				Preview: []byte(preview),
+			}
+			icapReq.Header.Set("Preview", strconv.Itoa(len(preview)))
+			return icapReq, httpReq
+		}
+
+		It("should return 204 when no modification is needed, even without Allow: 204", func() {
+			icapReq, httpReq := newPreviewRequest("https://example.com/some/path", "payload", "pay")
+
+			reqmodHandler(mockWriter, icapReq)
+
+			Expect(mockWriter.StatusCode).To(Equal(204))
+			Expect(httpReq.Header.Get("Authorization")).To(Equal("Bearer token123"))
+		})
+
+		It("should return the modified request with its body when the preview holds the entire body", func() {
+			icapReq, httpReq := newPreviewRequest("https://cdn.example.com/blobs/sha256/ab/abcdef1234567890", "payload", "payload")
+
+			reqmodHandler(mockWriter, icapReq)
+
+			Expect(mockWriter.StatusCode).To(Equal(200))
+			Expect(mockWriter.HttpMessage).To(Equal(httpReq))
+			Expect(mockWriter.HasBody).To(BeTrue())
+			Expect(mockWriter.Body.String()).To(Equal("payload"))
+			Expect(httpReq.Header.Get("Authorization")).To(BeEmpty())
+		})
+
+		It("should return the modified request without a body for an empty preview of a bodiless request", func() {
+			icapReq, httpReq := newPreviewRequest("https://cdn.example.com/blobs/sha256/ab/abcdef1234567890", "", "")
+
+			reqmodHandler(mockWriter, icapReq)
+
+			Expect(mockWriter.StatusCode).To(Equal(200))
+			Expect(mockWriter.HasBody).To(BeFalse())
+			Expect(httpReq.Header.Get("Authorization")).To(BeEmpty())
+		})
+
+		It("should return the modified request with its complete body when the body exceeds the preview", func() {
+			icapReq, httpReq := newPreviewRequest("https://cdn.example.com/blobs/sha256/ab/abcdef1234567890", "payload", "pay")
+
+			reqmodHandler(mockWriter, icapReq)
+
+			Expect(mockWriter.StatusCode).To(Equal(200))
+			Expect(mockWriter.Body.String()).To(Equal("payload"))
+			Expect(httpReq.Header.Get("Authorization")).To(BeEmpty())
+		})
+
+		It("should fail instead of passing the request through when the rest of the body cannot be read", func() {
+			icapReq, httpReq := newPreviewRequest("https://cdn.example.com/blobs/sha256/ab/abcdef1234567890", "", "pay")
+			httpReq.Body = io.NopCloser(io.MultiReader(strings.NewReader("pay"), iotest.ErrReader(io.ErrUnexpectedEOF)))
+
+			reqmodHandler(mockWriter, icapReq)
+
+			Expect(mockWriter.StatusCode).To(Equal(500))
+			Expect(mockWriter.HttpMessage).To(BeNil())
+		})
+	})
+
 	When("handling unsupported methods", func() {
 		It("should return 405", func() {
 			mockRequest := &icap.Request{
@@ -132,6 +427,135 @@ var _ = Describe("reqmodHandler", func() {
 	})
 })
 
+var _ = Describe("reqmodHandler over an ICAP connection", func() {
+	const blobURL = "http://cdn.example.com/blobs/sha256/ab/abcdef1234567890"
+
+	var conn net.Conn
+
+	BeforeEach(func() {
+		setModifyMethods("GET,HEAD,POST")
+		old := log.Writer()
+		log.SetOutput(io.Discard)
+		DeferCleanup(func() { log.SetOutput(old) })
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(listener.Close)
+		go func() { _ = icap.Serve(listener, icap.HandlerFunc(reqmodHandler)) }()
+
+		conn, err = net.Dial("tcp", listener.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(conn.Close)
+		Expect(conn.SetDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+	})
+
+	// sendPreview writes a REQMOD request for an upload of body with Authorization, sending the
+	// first previewSize bytes of the body as a preview
+	sendPreview := func(body string, previewSize int) {
+		httpHeader := "POST " + blobURL + " HTTP/1.1\r\nHost: cdn.example.com\r\n" +
+			"Authorization: Bearer token123\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n"
+		preview := body[:previewSize]
+		end := "0\r\n\r\n"
+		if previewSize == len(body) {
+			end = "0; ieof\r\n\r\n"
+		}
+		_, err := fmt.Fprintf(conn, "REQMOD icap://127.0.0.1/reqmod ICAP/1.0\r\nHost: 127.0.0.1\r\nAllow: 204\r\n"+
+			"Preview: %d\r\nEncapsulated: req-hdr=0, req-body=%d\r\n\r\n%s%x\r\n%s\r\n%s",
+			previewSize, len(httpHeader), httpHeader, len(preview), preview, end)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	// readModifiedRequest reads the ICAP response and returns the encapsulated HTTP request with its body
+	readModifiedRequest := func(reader *bufio.Reader) (*http.Request, string) {
+		status, err := reader.ReadString('\n')
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status).To(HavePrefix("ICAP/1.0 200"))
+		_, err = textproto.NewReader(reader).ReadMIMEHeader()
+		Expect(err).NotTo(HaveOccurred())
+
+		httpReq, err := http.ReadRequest(reader)
+		Expect(err).NotTo(HaveOccurred())
+		body, err := io.ReadAll(httputil.NewChunkedReader(reader))
+		Expect(err).NotTo(HaveOccurred())
+		return httpReq, string(body)
+	}
+
+	It("should ask for the rest of a body that exceeds the preview and remove Authorization", func() {
+		body := strings.Repeat("payload-", 64)
+		sendPreview(body, 16)
+
+		reader := bufio.NewReader(conn)
+		status, err := reader.ReadString('\n')
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status).To(Equal("ICAP/1.0 100 Continue\r\n"))
+		Expect(reader.ReadString('\n')).To(Equal("\r\n"))
+
+		_, err = fmt.Fprintf(conn, "%x\r\n%s\r\n0\r\n\r\n", len(body)-16, body[16:])
+		Expect(err).NotTo(HaveOccurred())
+
+		httpReq, modifiedBody := readModifiedRequest(reader)
+		Expect(httpReq.Header.Get("Authorization")).To(BeEmpty())
+		Expect(modifiedBody).To(Equal(body))
+	})
+
+	It("should not ask for more when the preview holds the entire body", func() {
+		sendPreview("payload", 7)
+
+		httpReq, modifiedBody := readModifiedRequest(bufio.NewReader(conn))
+		Expect(httpReq.Header.Get("Authorization")).To(BeEmpty())
+		Expect(modifiedBody).To(Equal("payload"))
+	})
+})
+
+var _ = Describe("previewBytesFromEnv", func() {
+	DescribeTable("should parse ICAP_PREVIEW_BYTES",
+		func(value string, expected int) {
+			GinkgoT().Setenv("ICAP_PREVIEW_BYTES", value)
+			Expect(previewBytesFromEnv()).To(Equal(expected))
+		},
+		Entry("unset", "", 0),
+		Entry("valid size", "1024", 1024),
+		Entry("invalid size", "1k", 0),
+		Entry("negative size", "-1", 0),
+	)
+})
+
+var _ = Describe("maxBodyBytesFromEnv", func() {
+	DescribeTable("should parse ICAP_MAX_BODY_BYTES",
+		func(value string, expected int64) {
+			GinkgoT().Setenv("ICAP_MAX_BODY_BYTES", value)
+			Expect(maxBodyBytesFromEnv()).To(Equal(expected))
+		},
+		Entry("unset", "", int64(0)),
+		Entry("valid size", "1073741824", int64(1073741824)),
+		Entry("invalid size", "1G", int64(0)),
+		Entry("negative size", "-1", int64(0)),
+	)
+})
+
+var _ = Describe("modifyMethodsFromEnv", func() {
+	DescribeTable("should parse ICAP_MODIFY_METHODS",
+		func(value string, expected []string) {
+			GinkgoT().Setenv("ICAP_MODIFY_METHODS", value)
+			Expect(modifyMethodsFromEnv()).To(HaveLen(len(expected)))
+			for _, method := range expected {
+				Expect(modifyMethodsFromEnv()).To(HaveKey(method))
+			}
+		},
+		Entry("unset", "", []string{"GET", "HEAD"}),
+		Entry("single method", "GET", []string{"GET"}),
+		Entry("spaces and lower case", " get , head,put ", []string{"GET", "HEAD", "PUT"}),
+		Entry("no methods", " , ", []string{"GET", "HEAD"}),
+	)
+})
+
+// setModifyMethods makes the comma-separated methods eligible for modification for the current spec
+func setModifyMethods(value string) {
+	old := modifyMethods
+	modifyMethods = parseModifyMethods(value)
+	DeferCleanup(func() { modifyMethods = old })
+}
+
 var _ = Describe("logICAPStartup", func() {
 	It("logs the listen port", func() {
 		logOutput := &bytes.Buffer{}
@@ -200,12 +624,46 @@ var _ = Describe("writeHeaderAndLog", func() {
 	})
 })
 
+// counterValue reads the current value of a Prometheus counter
+func counterValue(c prometheus.Counter) float64 {
+	pb := &dto.Metric{}
+	Expect(c.Write(pb)).To(Succeed())
+	return pb.GetCounter().GetValue()
+}
+
+// zeroBodyReader is a request body of remaining zero bytes that is generated as it is read
+type zeroBodyReader struct {
+	remaining int64
+}
+
+func (z *zeroBodyReader) Read(p []byte) (int, error) {
+	if z.remaining == 0 {
+		return 0, io.EOF
+	}
+	n := min(int64(len(p)), z.remaining)
+	clear(p[:n])
+	z.remaining -= n
+	return int(n), nil
+}
+
+// countingResponseWriter is a MockResponseWriter that counts body bytes without retaining them
+type countingResponseWriter struct {
+	MockResponseWriter
+	BodyBytes int64
+}
+
+func (c *countingResponseWriter) Write(p []byte) (int, error) {
+	c.BodyBytes += int64(len(p))
+	return len(p), nil
+}
+
 // MockResponseWriter implements icap.ResponseWriter for testing
 type MockResponseWriter struct {
 	HeaderMap   http.Header
 	StatusCode  int
 	HttpMessage interface{}
 	HasBody     bool
+	Body        bytes.Buffer
 }
 
 func (m *MockResponseWriter) Header() http.Header {
@@ -213,7 +671,7 @@ func (m *MockResponseWriter) Header() http.Header {
 }
 
 func (m *MockResponseWriter) Write(p []byte) (n int, err error) {
-	return len(p), nil
+	return m.Body.Write(p)
 }
 
 func (m *MockResponseWriter) WriteRaw(p string) {
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// tokenBucket tracks the available tokens for a single destination host
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// idleBucketTTL is how long a bucket must have been idle and full before it is evicted.
+// A full bucket behaves like a new one, so eviction only bounds the memory used for hosts
+// that are no longer requested.
+const idleBucketTTL = 10 * time.Minute
+
+// hostRateLimiter is a concurrency-safe token-bucket rate limiter keyed by destination host.
+// Each host gets its own bucket that refills at rate tokens per second up to burst tokens.
+// A limiter with a rate <= 0 is disabled and allows every request.
+type hostRateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	// lastSweep is when idle buckets were last evicted
+	lastSweep time.Time
+	// now returns the current time (allows injecting a clock in tests)
+	now func() time.Time
+}
+
+// newHostRateLimiter creates a limiter allowing rate requests per second per host with the given burst.
+// The burst is raised to at least 1 so an enabled limiter can always admit a request.
+func newHostRateLimiter(rate float64, burst int) *hostRateLimiter {
+	return &hostRateLimiter{
+		rate:    rate,
+		burst:   math.Max(float64(burst), 1),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// Enabled reports whether the limiter enforces a rate
+func (l *hostRateLimiter) Enabled() bool {
+	return l.rate > 0
+}
+
+// Allow reports whether a request to host may proceed, consuming a token if so
+func (l *hostRateLimiter) Allow(host string) bool {
+	if !l.Enabled() {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= idleBucketTTL {
+		l.evictIdleBuckets(now)
+	}
+
+	bucket, ok := l.buckets[host]
+	if !ok {
+		// New hosts start with a full bucket
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[host] = bucket
+	} else if elapsed := now.Sub(bucket.last).Seconds(); elapsed > 0 {
+		// Refill tokens for the time elapsed since the last request
+		bucket.tokens = math.Min(l.burst, bucket.tokens+elapsed*l.rate)
+		bucket.last = now
+	}
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// evictIdleBuckets removes the buckets that have been idle for idleBucketTTL and refilled to
+// the burst. It is called with l.mu held, at most once per idleBucketTTL.
+func (l *hostRateLimiter) evictIdleBuckets(now time.Time) {
+	for host, bucket := range l.buckets {
+		idle := now.Sub(bucket.last)
+		if idle >= idleBucketTTL && bucket.tokens+idle.Seconds()*l.rate >= l.burst {
+			delete(l.buckets, host)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("hostRateLimiter", func() {
+	var (
+		limiter *hostRateLimiter
+		now     time.Time
+	)
+
+	// newLimiterWithClock creates a limiter whose clock only moves when the test advances now
+	newLimiterWithClock := func(rate float64, burst int) *hostRateLimiter {
+		l := newHostRateLimiter(rate, burst)
+		l.now = func() time.Time { return now }
+		return l
+	}
+
+	BeforeEach(func() {
+		now = time.Unix(1732700000, 0)
+	})
+
+	When("the rate is zero", func() {
+		It("should be disabled and allow every request", func() {
+			limiter = newLimiterWithClock(0, 0)
+			Expect(limiter.Enabled()).To(BeFalse())
+			for range 100 {
+				Expect(limiter.Allow("cdn.example.com")).To(BeTrue())
+			}
+		})
+	})
+
+	When("the rate is positive", func() {
+		BeforeEach(func() {
+			limiter = newLimiterWithClock(2, 3)
+		})
+
+		It("should allow up to burst requests and then deny", func() {
+			Expect(limiter.Enabled()).To(BeTrue())
+			Expect(limiter.Allow("cdn.example.com")).To(BeTrue())
+			Expect(limiter.Allow("cdn.example.com")).To(BeTrue())
+			Expect(limiter.Allow("cdn.example.com")).To(BeTrue())
+			Expect(limiter.Allow("cdn.example.com")).To(BeFalse())
+		})
+
+		It("should refill tokens over time without exceeding the burst", func() {
+			for range 3 {
+				Expect(limiter.Allow("cdn.example.com")).To(BeTrue())
+			}
+			Expect(limiter.Allow("cdn.example.com")).To(BeFalse())
+
+			// 2 requests/s: half a second refills exactly one token
+			now = now.Add(500 * time.Millisecond)
+			Expect(limiter.Allow("cdn.example.com")).To(BeTrue())
+			Expect(limiter.Allow("cdn.example.com")).To(BeFalse())
+
+			// A long idle period refills only up to the burst
+			now = now.Add(time.Hour)
+			for range 3 {
+				Expect(limiter.Allow("cdn.example.com")).To(BeTrue())
+			}
+			Expect(limiter.Allow("cdn.example.com")).To(BeFalse())
+		})
+
+		It("should track each host independently", func() {
+			for range 3 {
+				Expect(limiter.Allow("cdn.example.com")).To(BeTrue())
+			}
+			Expect(limiter.Allow("cdn.example.com")).To(BeFalse())
+			Expect(limiter.Allow("other.example.com")).To(BeTrue())
+		})
+
+		It("should evict hosts that have been idle for the TTL", func() {
+			Expect(limiter.Allow("idle.example.com")).To(BeTrue())
+			Expect(limiter.buckets).To(HaveKey("idle.example.com"))
+
+			now = now.Add(idleBucketTTL)
+			Expect(limiter.Allow("cdn.example.com")).To(BeTrue())
+			Expect(limiter.buckets).NotTo(HaveKey("idle.example.com"))
+			Expect(limiter.buckets).To(HaveKey("cdn.example.com"))
+		})
+
+		It("should keep buckets idle for less than the TTL", func() {
+			Expect(limiter.Allow("other.example.com")).To(BeTrue())
+			now = now.Add(idleBucketTTL / 2)
+			Expect(limiter.Allow("cdn.example.com")).To(BeTrue())
+
+			// The next sweep evicts the host idle for the TTL but not the one idle for half of it
+			now = now.Add(idleBucketTTL / 2)
+			Expect(limiter.Allow("third.example.com")).To(BeTrue())
+			Expect(limiter.buckets).NotTo(HaveKey("other.example.com"))
+			Expect(limiter.buckets).To(HaveKey("cdn.example.com"))
+		})
+
+		It("should keep buckets that have not refilled to the burst", func() {
+			// 1 request every 20 minutes, so the bucket is still refilling after the TTL
+			limiter = newLimiterWithClock(1.0/1200, 1)
+			Expect(limiter.Allow("cdn.example.com")).To(BeTrue())
+
+			now = now.Add(idleBucketTTL)
+			Expect(limiter.Allow("other.example.com")).To(BeTrue())
+			Expect(limiter.buckets).To(HaveKey("cdn.example.com"))
+			Expect(limiter.Allow("cdn.example.com")).To(BeFalse(), "Eviction should not hand out tokens early")
+		})
+
+		It("should not refill tokens when the clock goes backwards", func() {
+			for range 3 {
+				Expect(limiter.Allow("cdn.example.com")).To(BeTrue())
+			}
+			now = now.Add(-time.Minute)
+			Expect(limiter.Allow("cdn.example.com")).To(BeFalse())
+		})
+	})
+
+	When("the burst is lower than 1", func() {
+		It("should still allow one request per bucket", func() {
+			limiter = newLimiterWithClock(1, 0)
+			Expect(limiter.Allow("cdn.example.com")).To(BeTrue())
+			Expect(limiter.Allow("cdn.example.com")).To(BeFalse())
+		})
+	})
+
+	It("should be safe for concurrent use", func() {
+		limiter = newLimiterWithClock(1, 50)
+
+		var allowed atomic.Int32
+		var wg sync.WaitGroup
+		for i := range 200 {
+			wg.Add(1)
+			go func(host string) {
+				defer wg.Done()
+				if limiter.Allow(host) {
+					allowed.Add(1)
+				}
+			}(fmt.Sprintf("host-%d.example.com", i%2))
+		}
+		wg.Wait()
+
+		// The clock never moves, so each of the 2 hosts admits exactly its burst
+		Expect(allowed.Load()).To(Equal(int32(100)))
+	})
+})
+
+var _ = Describe("newRateLimiterFromEnv", func() {
+	It("should disable rate limiting when ICAP_RATE_LIMIT is unset", func() {
+		GinkgoT().Setenv("ICAP_RATE_LIMIT", "")
+		Expect(newRateLimiterFromEnv().Enabled()).To(BeFalse())
+	})
+
+	It("should disable rate limiting when ICAP_RATE_LIMIT is invalid", func() {
+		GinkgoT().Setenv("ICAP_RATE_LIMIT", "fast")
+		Expect(newRateLimiterFromEnv().Enabled()).To(BeFalse())
+	})
+
+	It("should use ICAP_RATE_LIMIT and ICAP_RATE_BURST when set", func() {
+		GinkgoT().Setenv("ICAP_RATE_LIMIT", "5")
+		GinkgoT().Setenv("ICAP_RATE_BURST", "20")
+		limiter := newRateLimiterFromEnv()
+		Expect(limiter.Enabled()).To(BeTrue())
+		Expect(limiter.rate).To(Equal(5.0))
+		Expect(limiter.burst).To(Equal(20.0))
+	})
+
+	It("should default the burst to one second worth of requests", func() {
+		GinkgoT().Setenv("ICAP_RATE_LIMIT", "5")
+		GinkgoT().Setenv("ICAP_RATE_BURST", "")
+		Expect(newRateLimiterFromEnv().burst).To(Equal(5.0))
+	})
+})
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"os"
+)
+
+// loadTLSConfig builds the TLS configuration for ICAPS (ICAP over TLS).
+// It returns a nil config when certFile or keyFile is empty, meaning plaintext ICAP.
+// When caFile is set, clients must present a certificate signed by that CA (mutual TLS),
+// so a caFile without certFile and keyFile is an error rather than plaintext ICAP.
+func loadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		if caFile != "" {
+			return nil, fmt.Errorf("client CA file %s requires a TLS certificate and key", caFile)
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", caFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// newListener listens on addr, wrapping the listener in TLS when ICAP_TLS_CERT_FILE and
+// ICAP_TLS_KEY_FILE are set. ICAP_TLS_CA_FILE additionally enables mutual TLS.
+func newListener(addr string) (net.Listener, error) {
+	tlsConfig, err := loadTLSConfig(
+		os.Getenv("ICAP_TLS_CERT_FILE"),
+		os.Getenv("ICAP_TLS_KEY_FILE"),
+		os.Getenv("ICAP_TLS_CA_FILE"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case tlsConfig == nil:
+		log.Println("TLS disabled; serving plaintext ICAP")
+		return net.Listen("tcp", addr)
+	case tlsConfig.ClientCAs != nil:
+		log.Println("Mutual TLS enabled; serving ICAPS and requiring client certificates")
+	default:
+		log.Println("TLS enabled; serving ICAPS")
+	}
+
+	return tls.Listen("tcp", addr, tlsConfig)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("loadTLSConfig", func() {
+	var certFile, keyFile string
+
+	BeforeEach(func() {
+		certFile, keyFile = writeSelfSignedCert(GinkgoT().TempDir())
+	})
+
+	It("returns nil when no certificate is configured", func() {
+		config, err := loadTLSConfig("", "", "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config).To(BeNil())
+	})
+
+	It("loads the server certificate without client authentication", func() {
+		config, err := loadTLSConfig(certFile, keyFile, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.Certificates).To(HaveLen(1))
+		Expect(config.MinVersion).To(Equal(uint16(tls.VersionTLS12)))
+		Expect(config.ClientAuth).To(Equal(tls.NoClientCert))
+	})
+
+	It("requires client certificates when a CA file is configured", func() {
+		config, err := loadTLSConfig(certFile, keyFile, certFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.ClientCAs).ToNot(BeNil())
+		Expect(config.ClientAuth).To(Equal(tls.RequireAndVerifyClientCert))
+	})
+
+	It("returns an error for a missing certificate", func() {
+		_, err := loadTLSConfig(filepath.Join(GinkgoT().TempDir(), "missing.crt"), keyFile, "")
+		Expect(err).To(MatchError(ContainSubstring("failed to load TLS certificate")))
+	})
+
+	DescribeTable("returns an error for a CA file without a certificate and key",
+		func(withCert, withKey bool) {
+			cert, key := "", ""
+			if withCert {
+				cert = certFile
+			}
+			if withKey {
+				key = keyFile
+			}
+
+			config, err := loadTLSConfig(cert, key, certFile)
+			Expect(err).To(MatchError(ContainSubstring("requires a TLS certificate and key")))
+			Expect(config).To(BeNil())
+		},
+		Entry("without certificate and key", false, false),
+		Entry("without certificate", false, true),
+		Entry("without key", true, false),
+	)
+
+	It("returns an error for a CA file without certificates", func() {
+		caFile := filepath.Join(GinkgoT().TempDir(), "ca.crt")
+		Expect(os.WriteFile(caFile, []byte("not a certificate"), 0o600)).To(Succeed())
+
+		_, err := loadTLSConfig(certFile, keyFile, caFile)
+		Expect(err).To(MatchError(ContainSubstring("no certificates found")))
+	})
+})
+
+var _ = Describe("newListener", func() {
+	var certFile, keyFile string
+
+	BeforeEach(func() {
+		certFile, keyFile = writeSelfSignedCert(GinkgoT().TempDir())
+	})
+
+	It("serves plaintext when TLS is not configured", func() {
+		GinkgoT().Setenv("ICAP_TLS_CERT_FILE", "")
+		GinkgoT().Setenv("ICAP_TLS_KEY_FILE", "")
+		GinkgoT().Setenv("ICAP_TLS_CA_FILE", "")
+
+		listener, err := newListener("127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		defer listener.Close()
+
+		go func() {
+			conn, err := listener.Accept()
+			if err == nil {
+				_, _ = conn.Write([]byte("ICAP/1.0"))
+				_ = conn.Close()
+			}
+		}()
+
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		buf := make([]byte, 8)
+		_, err = conn.Read(buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(buf)).To(Equal("ICAP/1.0"))
+	})
+
+	It("returns an error when the certificate cannot be loaded", func() {
+		GinkgoT().Setenv("ICAP_TLS_CERT_FILE", filepath.Join(GinkgoT().TempDir(), "missing.crt"))
+		GinkgoT().Setenv("ICAP_TLS_KEY_FILE", keyFile)
+
+		_, err := newListener("127.0.0.1:0")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("refuses to serve plaintext when only the client CA is configured", func() {
+		GinkgoT().Setenv("ICAP_TLS_CERT_FILE", "")
+		GinkgoT().Setenv("ICAP_TLS_KEY_FILE", "")
+		GinkgoT().Setenv("ICAP_TLS_CA_FILE", certFile)
+
+		listener, err := newListener("127.0.0.1:0")
+		Expect(err).To(MatchError(ContainSubstring("requires a TLS certificate and key")))
+		Expect(listener).To(BeNil())
+	})
+
+	Context("with mutual TLS", func() {
+		var listener net.Listener
+
+		BeforeEach(func() {
+			GinkgoT().Setenv("ICAP_TLS_CERT_FILE", certFile)
+			GinkgoT().Setenv("ICAP_TLS_KEY_FILE", keyFile)
+			GinkgoT().Setenv("ICAP_TLS_CA_FILE", certFile)
+
+			var err error
+			listener, err = newListener("127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(listener.Close)
+
+			// Complete a handshake for every accepted connection
+			go func() {
+				for {
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					go func() {
+						defer conn.Close()
+						_ = conn.(*tls.Conn).Handshake()
+						_, _ = conn.Read(make([]byte, 1))
+					}()
+				}
+			}()
+		})
+
+		It("accepts clients presenting a trusted certificate", func() {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			Expect(err).ToNot(HaveOccurred())
+
+			conn, err := tls.Dial("tcp", listener.Addr().String(), clientTLSConfig(certFile, []tls.Certificate{cert}))
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			Expect(conn.Handshake()).To(Succeed())
+		})
+
+		It("rejects clients without a certificate", func() {
+			conn, err := tls.Dial("tcp", listener.Addr().String(), clientTLSConfig(certFile, nil))
+			if err == nil {
+				defer conn.Close()
+				// With TLS 1.3 the server reports the failed client authentication after the handshake
+				Expect(conn.SetReadDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+				_, err = conn.Read(make([]byte, 1))
+			}
+			Expect(err).To(MatchError(ContainSubstring("certificate required")))
+		})
+	})
+})
+
+// clientTLSConfig returns a client configuration that trusts the certificate in caFile
+func clientTLSConfig(caFile string, certs []tls.Certificate) *tls.Config {
+	caPEM, err := os.ReadFile(caFile)
+	Expect(err).ToNot(HaveOccurred())
+	pool := x509.NewCertPool()
+	Expect(pool.AppendCertsFromPEM(caPEM)).To(BeTrue())
+
+	return &tls.Config{
+		RootCAs:      pool,
+		Certificates: certs,
+		ServerName:   "localhost",
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// writeSelfSignedCert writes a self-signed certificate and key usable as server certificate,
+// client certificate, and CA, returning the file paths
+func writeSelfSignedCert(dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	Expect(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)).To(Succeed())
+	Expect(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)).To(Succeed())
+	return certFile, keyFile
+}
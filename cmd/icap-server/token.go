@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// defaultTokenEndpointPatterns match the Docker Hub registry auth/token endpoints
+var defaultTokenEndpointPatterns = []string{
+	`^https://auth\.docker\.io/token(\?|$)`,
+	`^https://registry-1\.docker\.io/v2/token(\?|$)`,
+}
+
+// tokenQueryParams are the token endpoint query parameters that are safe to log.
+// All other parameters (e.g. refresh_token, account) are redacted.
+var tokenQueryParams = map[string]bool{
+	"scope":     true,
+	"service":   true,
+	"client_id": true,
+}
+
+// tokenEndpointPatterns identify registry auth/token endpoints. Requests to these keep their
+// Authorization header; only the logged URL is redacted.
+var tokenEndpointPatterns = compileTokenEndpointPatterns(defaultTokenEndpointPatterns)
+
+// compileTokenEndpointPatterns compiles patterns, skipping (and logging) invalid ones
+func compileTokenEndpointPatterns(patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Printf("Ignoring invalid token endpoint pattern %q: %v", pattern, err)
+			continue
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled
+}
+
+// tokenEndpointPatternsFromEnv returns the patterns from ICAP_TOKEN_ENDPOINT_PATTERNS
+// (comma-separated regular expressions), or the Docker Hub defaults when unset
+func tokenEndpointPatternsFromEnv() []*regexp.Regexp {
+	value := os.Getenv("ICAP_TOKEN_ENDPOINT_PATTERNS")
+	if value == "" {
+		return compileTokenEndpointPatterns(defaultTokenEndpointPatterns)
+	}
+	return compileTokenEndpointPatterns(strings.Split(value, ","))
+}
+
+// isTokenEndpoint returns true if u is a registry auth/token endpoint
+func isTokenEndpoint(u *url.URL) bool {
+	s := u.String()
+	for _, pattern := range tokenEndpointPatterns {
+		if pattern.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactTokenURL returns u with credentials removed and all query parameter values except
+// tokenQueryParams replaced, so token requests can be logged with their scope
+func redactTokenURL(u *url.URL) string {
+	redacted := *u
+	query := redacted.Query()
+	for key, values := range query {
+		if tokenQueryParams[key] {
+			continue
+		}
+		for i := range values {
+			values[i] = "xxxxx"
+		}
+	}
+	redacted.RawQuery = query.Encode()
+	return redacted.Redacted()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// hitRatioBuckets is the number of ring buffer buckets a hit-ratio window is divided into.
+// Events age out one bucket (window/hitRatioBuckets) at a time.
+const hitRatioBuckets = 30
+
+// defaultHitRatioWindow is the default time window of squid_site_hit_ratio_5m
+const defaultHitRatioWindow = 5 * time.Minute
+
+// hitRatioWindow is a Prometheus collector exposing the per-site hit ratio over a sliding time
+// window. Unlike the lifetime squid_site_hit_ratio gauge, it reflects recent changes on
+// long-lived pods. Ratios are computed at scrape time, so they decay without new traffic, and
+// sites without requests in the window are dropped.
+type hitRatioWindow struct {
+	mutex       sync.Mutex
+	desc        *prometheus.Desc
+	bucketWidth time.Duration
+	sites       map[string]*hitRatioRing
+	now         func() time.Time
+}
+
+// hitRatioRing holds the ring buffer counters of one site
+type hitRatioRing struct {
+	labelValues []string
+	buckets     [hitRatioBuckets]hitRatioBucket
+}
+
+// hitRatioBucket counts the events of one time slot, identified by its index since the epoch
+type hitRatioBucket struct {
+	slot     int64
+	hits     float64
+	requests float64
+}
+
+// newHitRatioWindow returns a collector computing hit ratios over window, labeled by labels and constLabels
+func newHitRatioWindow(window time.Duration, labels []string, constLabels prometheus.Labels) *hitRatioWindow {
+	return &hitRatioWindow{
+		desc: prometheus.NewDesc("squid_site_hit_ratio_5m",
+			"Hit ratio per site over the configured sliding window (default 5m)", labels, constLabels),
+		bucketWidth: max(window/hitRatioBuckets, time.Nanosecond),
+		sites:       map[string]*hitRatioRing{},
+		now:         time.Now,
+	}
+}
+
+// currentSlot returns the index of the time slot containing the current time
+func (w *hitRatioWindow) currentSlot() int64 {
+	return w.now().UnixNano() / int64(w.bucketWidth)
+}
+
+// record counts a request for the site identified by labelValues at the current time
+func (w *hitRatioWindow) record(labelValues []string, hit bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	ring, found := w.sites[key]
+	if !found {
+		ring = &hitRatioRing{labelValues: append([]string(nil), labelValues...)}
+		w.sites[key] = ring
+	}
+
+	slot := w.currentSlot()
+	bucket := &ring.buckets[slot%hitRatioBuckets]
+	if bucket.slot != slot {
+		// The bucket still holds events from an expired slot
+		*bucket = hitRatioBucket{slot: slot}
+	}
+	bucket.requests++
+	if hit {
+		bucket.hits++
+	}
+}
+
+// sum returns the hits and requests of ring within the window ending at slot
+func (r *hitRatioRing) sum(slot int64) (float64, float64) {
+	var hits, requests float64
+	for _, bucket := range r.buckets {
+		if bucket.slot > slot-hitRatioBuckets && bucket.slot <= slot {
+			hits += bucket.hits
+			requests += bucket.requests
+		}
+	}
+	return hits, requests
+}
+
+// ratio returns the windowed hit ratio of the site identified by labelValues,
+// or false if the site had no requests within the window
+func (w *hitRatioWindow) ratio(labelValues ...string) (float64, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	ring, found := w.sites[strings.Join(labelValues, "\xff")]
+	if !found {
+		return 0, false
+	}
+	hits, requests := ring.sum(w.currentSlot())
+	if requests == 0 {
+		return 0, false
+	}
+	return hits / requests, true
+}
+
+// Describe implements prometheus.Collector
+func (w *hitRatioWindow) Describe(ch chan<- *prometheus.Desc) {
+	ch <- w.desc
+}
+
+// Collect implements prometheus.Collector
+func (w *hitRatioWindow) Collect(ch chan<- prometheus.Metric) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	slot := w.currentSlot()
+	for key, ring := range w.sites {
+		hits, requests := ring.sum(slot)
+		if requests == 0 {
+			// All events aged out; forget the site so idle hosts don't accumulate
+			delete(w.sites, key)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(w.desc, prometheus.GaugeValue, hits/requests, ring.labelValues...)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("hitRatioWindow", func() {
+	var (
+		window *hitRatioWindow
+		now    time.Time
+	)
+
+	BeforeEach(func() {
+		now = time.Unix(1732700000, 0)
+		window = newHitRatioWindow(5*time.Minute, []string{"hostname"}, nil)
+		window.now = func() time.Time { return now }
+	})
+
+	ratioOf := func(w *hitRatioWindow, labelValues ...string) float64 {
+		ratio, ok := w.ratio(labelValues...)
+		Expect(ok).To(BeTrue(), "site %v should have requests within the window", labelValues)
+		return ratio
+	}
+
+	recordAt := func(at time.Time, hits, misses int) {
+		now = at
+		for i := 0; i < hits; i++ {
+			window.record([]string{"example.com"}, true)
+		}
+		for i := 0; i < misses; i++ {
+			window.record([]string{"example.com"}, false)
+		}
+	}
+
+	It("should compute the ratio of the events within the window", func() {
+		start := now
+		recordAt(start, 3, 1)
+
+		ratio, ok := window.ratio("example.com")
+		Expect(ok).To(BeTrue())
+		Expect(ratio).To(Equal(0.75))
+	})
+
+	It("should decay as old events age out", func() {
+		start := now
+		recordAt(start, 4, 0)
+		recordAt(start.Add(3*time.Minute), 0, 4)
+
+		ratio, ok := window.ratio("example.com")
+		Expect(ok).To(BeTrue())
+		Expect(ratio).To(Equal(0.5), "hits and misses are both within the window")
+
+		now = start.Add(5 * time.Minute)
+		ratio, ok = window.ratio("example.com")
+		Expect(ok).To(BeTrue())
+		Expect(ratio).To(Equal(0.0), "the hits should have aged out")
+
+		now = start.Add(8 * time.Minute)
+		_, ok = window.ratio("example.com")
+		Expect(ok).To(BeFalse(), "all events should have aged out")
+	})
+
+	It("should reuse ring buckets once their slot expires", func() {
+		start := now
+		recordAt(start, 0, 5)
+		recordAt(start.Add(10*time.Minute), 1, 0)
+
+		ratio, ok := window.ratio("example.com")
+		Expect(ok).To(BeTrue())
+		Expect(ratio).To(Equal(1.0), "the misses of the expired slot should not be counted")
+	})
+
+	It("should keep sites separate", func() {
+		window.record([]string{"hits.example.com"}, true)
+		window.record([]string{"misses.example.com"}, false)
+
+		Expect(ratioOf(window, "hits.example.com")).To(Equal(1.0))
+		Expect(ratioOf(window, "misses.example.com")).To(Equal(0.0))
+	})
+
+	It("should expose windowed ratios and drop idle sites on collect", func() {
+		start := now
+		recordAt(start, 1, 1)
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(window)
+
+		gathered, err := registry.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gathered).To(HaveLen(1))
+		Expect(gathered[0].GetName()).To(Equal("squid_site_hit_ratio_5m"))
+		Expect(gathered[0].GetMetric()).To(HaveLen(1))
+		Expect(gathered[0].GetMetric()[0].GetGauge().GetValue()).To(Equal(0.5))
+		Expect(gathered[0].GetMetric()[0].GetLabel()[0].GetValue()).To(Equal("example.com"))
+
+		now = start.Add(10 * time.Minute)
+		gathered, err = registry.Gather()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gathered).To(BeEmpty())
+		Expect(window.sites).To(BeEmpty(), "idle sites should be forgotten")
+	})
+
+	It("should be updated by parseLogLine alongside the lifetime ratio", func() {
+		setTrackPort(false)
+		exporter := NewExporter()
+		exporter.parseLogLine("1732700000 5 10.0.0.1 TCP_HIT/200 10 GET http://window.example.com/a - DIRECT/- text/plain")
+		exporter.parseLogLine("1732700000 5 10.0.0.1 TCP_MISS/200 10 GET http://window.example.com/b - DIRECT/- text/plain")
+
+		Expect(ratioOf(squidHitRatioWindow, "window.example.com")).To(Equal(0.5))
+	})
+})
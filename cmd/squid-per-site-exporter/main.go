@@ -3,16 +3,23 @@ package main
 import (
 	"bufio"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/konflux-ci/caching/internal/buildinfo"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	dto "github.com/prometheus/client_model/go"
@@ -37,6 +44,16 @@ func getEnvDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvIntDefault returns the integer from env or the provided default
+func getEnvIntDefault(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
+
 // getEnvDurationDefault returns the duration from env or the provided default
 func getEnvDurationDefault(key string, defaultValue time.Duration) time.Duration {
 	if value := os.Getenv(key); value != "" {
@@ -47,73 +64,370 @@ func getEnvDurationDefault(key string, defaultValue time.Duration) time.Duration
 	return defaultValue
 }
 
+// trackPort adds a port label to the per-site metrics, so the same host accessed on
+// different ports produces distinct series. Set it with setTrackPort.
+var trackPort bool
+
+// pathDepth adds a path_prefix label with the first pathDepth segments of the URL path to the
+// per-site metrics, 0 to disable it. Set it with setPathDepth.
+var pathDepth int
+
+// podName adds a constant pod label with this value to the per-site metrics, empty to disable it.
+// Set it with setPodName.
+var podName string
+
+// hitRatioWindowSize is the sliding window of squidHitRatioWindow, read when newMetrics creates it,
+// so changes only apply to metrics created afterwards
+var hitRatioWindowSize = defaultHitRatioWindow
+
+// Prometheus metrics, created by newMetrics and registered by registerMetrics
 var (
-	// Prometheus metrics
+	squidHitRatio       *prometheus.GaugeVec
+	squidHitRatioWindow *hitRatioWindow
+	squidHitTotal       *prometheus.CounterVec
+	squidMissTotal      *prometheus.CounterVec
+	squidRequestsTotal  *prometheus.CounterVec
+	squidBytesTotal     *prometheus.CounterVec
+	squidBytesSaved     *prometheus.CounterVec
+	squidDeniedTotal    *prometheus.CounterVec
+	squidResponsesTotal *prometheus.CounterVec
+	squidResponseTime   *prometheus.HistogramVec
+)
+
+// siteSeries holds the metric handles of one label set, so a log line updates the per-site metrics
+// without looking up its labels in every vector. hitCount and requestCount count the hits and the
+// requests with a hit or miss, so the hit ratio is computed without reading the counters back.
+type siteSeries struct {
+	requests     prometheus.Counter
+	bytes        prometheus.Counter
+	bytesSaved   prometheus.Counter
+	hits         prometheus.Counter
+	misses       prometheus.Counter
+	denied       prometheus.Counter
+	responseTime prometheus.Observer
+	hitRatio     prometheus.Gauge
+	hitCount     atomic.Uint64
+	requestCount atomic.Uint64
+}
+
+// siteSeriesByLabels holds the siteSeries of the current per-site metrics by joined label values.
+// It is reset by newMetrics along with the metrics.
+var (
+	siteSeriesMutex    sync.Mutex
+	siteSeriesByLabels map[string]*siteSeries
+)
+
+// lookupSiteSeries returns the siteSeries of labels, creating its series on first use. The hit and
+// miss counters are created together, so both appear (possibly at 0) once a site has a request.
+func lookupSiteSeries(labels []string) *siteSeries {
+	key := strings.Join(labels, "\xff")
+
+	siteSeriesMutex.Lock()
+	defer siteSeriesMutex.Unlock()
+	series, found := siteSeriesByLabels[key]
+	if !found {
+		series = &siteSeries{
+			requests:     squidRequestsTotal.WithLabelValues(labels...),
+			bytes:        squidBytesTotal.WithLabelValues(labels...),
+			bytesSaved:   squidBytesSaved.WithLabelValues(labels...),
+			hits:         squidHitTotal.WithLabelValues(labels...),
+			misses:       squidMissTotal.WithLabelValues(labels...),
+			denied:       squidDeniedTotal.WithLabelValues(labels...),
+			responseTime: squidResponseTime.WithLabelValues(labels...),
+			hitRatio:     squidHitRatio.WithLabelValues(labels...),
+		}
+		siteSeriesByLabels[key] = series
+	}
+	return series
+}
+
+// squidExporterStdoutErrors counts access log lines that could not be forwarded to stdout
+var squidExporterStdoutErrors = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "squid_exporter_stdout_errors_total",
+	Help: "Total number of access log lines that failed to be forwarded to stdout",
+})
+
+var squidPerSiteExporterBuildInfo = buildinfo.NewGauge("squid_per_site_exporter_build_info",
+	"Per-site exporter build information, always 1")
+
+// setTrackPort enables the port label and recreates the per-site metrics.
+// Existing series are dropped, so it must be called before metrics are registered.
+func setTrackPort(enabled bool) {
+	trackPort = enabled
+	newMetrics()
+}
+
+// setPathDepth sets the number of path segments in the path_prefix label (0 removes the label)
+// and recreates the per-site metrics. Existing series are dropped, so it must be called before
+// metrics are registered.
+func setPathDepth(depth int) {
+	pathDepth = depth
+	newMetrics()
+}
+
+// setPodName sets the value of the pod label (empty removes the label) and recreates the per-site
+// metrics. Existing series are dropped, so it must be called before metrics are registered.
+func setPodName(name string) {
+	podName = name
+	newMetrics()
+}
+
+// podLabelValue returns the value of the pod label: the POD_NAME environment variable, set from the
+// pod name with the downward API, when enabled, or empty otherwise
+func podLabelValue(enabled bool) (string, error) {
+	if !enabled {
+		return "", nil
+	}
+	name := os.Getenv("POD_NAME")
+	if name == "" {
+		return "", fmt.Errorf("the POD_NAME environment variable is not set")
+	}
+	return name, nil
+}
+
+// newMetrics creates the per-site metrics, labeled by hostname and, when enabled, port, path prefix
+// and pod
+func newMetrics() {
+	labels := []string{"hostname"}
+	if trackPort {
+		labels = append(labels, "port")
+	}
+	if pathDepth > 0 {
+		labels = append(labels, "path_prefix")
+	}
+	var constLabels prometheus.Labels
+	if podName != "" {
+		constLabels = prometheus.Labels{"pod": podName}
+	}
+
 	squidHitRatio = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "squid_site_hit_ratio",
-			Help: "Hit ratio per site (hits / (hits + misses))",
+			Name:        "squid_site_hit_ratio",
+			Help:        "Hit ratio per site (hits / (hits + misses))",
+			ConstLabels: constLabels,
 		},
-		[]string{"hostname"},
+		labels,
 	)
-
+	squidHitRatioWindow = newHitRatioWindow(hitRatioWindowSize, labels, constLabels)
 	squidHitTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "squid_site_hits_total",
-			Help: "Total number of cache hits per site",
+			Name:        "squid_site_hits_total",
+			Help:        "Total number of cache hits per site",
+			ConstLabels: constLabels,
 		},
-		[]string{"hostname"},
+		labels,
 	)
-
 	squidMissTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "squid_site_misses_total",
-			Help: "Total number of cache misses per site",
+			Name:        "squid_site_misses_total",
+			Help:        "Total number of cache misses per site",
+			ConstLabels: constLabels,
 		},
-		[]string{"hostname"},
+		labels,
 	)
-
 	squidRequestsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "squid_site_requests_total",
-			Help: "Total number of requests per site",
+			Name:        "squid_site_requests_total",
+			Help:        "Total number of requests per site",
+			ConstLabels: constLabels,
 		},
-		[]string{"hostname"},
+		labels,
 	)
-
 	squidBytesTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "squid_site_bytes_total",
-			Help: "Total bytes transferred per site",
+			Name:        "squid_site_bytes_total",
+			Help:        "Total bytes transferred per site",
+			ConstLabels: constLabels,
 		},
-		[]string{"hostname"},
+		labels,
+	)
+	squidBytesSaved = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        "squid_site_bytes_saved_total",
+			Help:        "Total bytes served from cache per site, without fetching them from the origin",
+			ConstLabels: constLabels,
+		},
+		labels,
+	)
+	squidDeniedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        "squid_site_denied_total",
+			Help:        "Total number of requests denied by access controls per site, counted neither as hits nor misses",
+			ConstLabels: constLabels,
+		},
+		labels,
+	)
+	squidResponsesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        "squid_site_responses_total",
+			Help:        "Total number of responses per site and HTTP status class (2xx, 3xx, 4xx, 5xx, or none without a response)",
+			ConstLabels: constLabels,
+		},
+		append(labels[:len(labels):len(labels)], "status_class"),
 	)
-
 	squidResponseTime = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
-			Name:    "squid_site_response_time_seconds",
-			Help:    "Response time per site in seconds",
-			Buckets: prometheus.DefBuckets,
+			Name:        "squid_site_response_time_seconds",
+			Help:        "Response time per site in seconds",
+			ConstLabels: constLabels,
+			Buckets:     prometheus.DefBuckets,
 		},
-		[]string{"hostname"},
+		labels,
 	)
-)
+
+	siteSeriesMutex.Lock()
+	siteSeriesByLabels = map[string]*siteSeries{}
+	siteSeriesMutex.Unlock()
+}
+
+// registerMetrics registers the per-site and build info metrics with reg
+func registerMetrics(reg prometheus.Registerer) {
+	reg.MustRegister(squidHitRatio, squidHitRatioWindow, squidHitTotal, squidMissTotal, squidRequestsTotal, squidBytesTotal, squidBytesSaved, squidDeniedTotal, squidResponsesTotal, squidResponseTime)
+	reg.MustRegister(squidExporterStdoutErrors, squidPerSiteExporterBuildInfo)
+}
+
+// sitePort returns the destination port of u, defaulting to the scheme's well-known port
+func sitePort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	switch u.Scheme {
+	case "https":
+		return "443"
+	case "http":
+		return "80"
+	default:
+		return ""
+	}
+}
+
+// maxPathPrefixes bounds the number of distinct hostname and path prefix pairs with their own
+// series. Prefixes first seen once the limit is reached are counted under otherPathPrefix.
+const maxPathPrefixes = 1000
+
+// otherPathPrefix is the path_prefix label of requests whose prefix exceeds maxPathPrefixes
+const otherPathPrefix = "other"
+
+// maxPathSegmentLength is the longest path segment kept in a path prefix. Longer segments are
+// usually generated identifiers, so the prefix stops before them.
+const maxPathSegmentLength = 64
+
+// digestHexLengths are the hex encoded lengths of the content digests recognized in paths, by algorithm
+var digestHexLengths = map[string]int{"sha256": 64, "sha512": 128}
+
+// isHashSegment reports whether a path segment is a content digest ("sha256:<hex>" or
+// "sha512:<hex>") or a bare hex hash, which would give every object its own series
+func isHashSegment(segment string) bool {
+	if algorithm, encoded, found := strings.Cut(segment, ":"); found {
+		length, known := digestHexLengths[algorithm]
+		return known && len(encoded) == length && isHex(encoded)
+	}
+	return len(segment) >= 32 && isHex(segment)
+}
+
+// isHex reports whether s only contains hex digits
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// pathPrefix returns the first depth segments of u's path, e.g. "/v2/library" for depth 2.
+// The prefix stops before digests, hashes and overly long segments to bound label cardinality.
+func pathPrefix(u *url.URL, depth int) string {
+	var prefix strings.Builder
+	segments := 0
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segments == depth {
+			break
+		}
+		if segment == "" {
+			continue
+		}
+		if isHashSegment(segment) || len(segment) > maxPathSegmentLength {
+			break
+		}
+		prefix.WriteString("/" + segment)
+		segments++
+	}
+	if prefix.Len() == 0 {
+		return "/"
+	}
+	return prefix.String()
+}
+
+// defaultCountMethods are the request methods counted in the per-site metrics by default.
+// Only GET and HEAD responses are cacheable without explicit response headers, so they are
+// the methods whose hit ratio is meaningful.
+// See: https://wiki.squid-cache.org/SquidFaq/SquidLogs#request-methods
+const defaultCountMethods = "GET,HEAD"
+
+// parseMethods parses a comma-separated list of request methods into a set
+func parseMethods(list string) map[string]bool {
+	methods := map[string]bool{}
+	for _, method := range strings.Split(list, ",") {
+		if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+			methods[method] = true
+		}
+	}
+	return methods
+}
+
+// defaultElapsedUnit is the unit of the elapsed time column. Squid's native access log format
+// writes %tr, the response time in milliseconds.
+const defaultElapsedUnit = "ms"
+
+// elapsedUnitDivisors convert an elapsed time column in the given unit to seconds
+var elapsedUnitDivisors = map[string]float64{
+	"s":  1,
+	"ms": 1e3,
+	"us": 1e6,
+}
+
+// parseElapsedUnit returns the divisor converting elapsed times in unit to seconds
+func parseElapsedUnit(unit string) (float64, error) {
+	divisor, found := elapsedUnitDivisors[unit]
+	if !found {
+		return 0, fmt.Errorf("unknown elapsed time unit %q: must be one of s, ms, us", unit)
+	}
+	return divisor, nil
+}
 
 type Exporter struct {
 	mutex     sync.RWMutex
 	parseFunc func(string)
+	// countMethods are the request methods that produce metrics; other methods are skipped
+	countMethods map[string]bool
+	// parsed is set once the first access log line has been recorded in the metrics
+	parsed atomic.Bool
+	// fatalOnStdoutError stops reading when a line cannot be forwarded to stdout
+	fatalOnStdoutError bool
+	// pathPrefixes are the hostname and path prefix pairs with their own series, see maxPathPrefixes
+	pathPrefixes map[[2]string]bool
+	// elapsedDivisor converts the elapsed time column to seconds, see parseElapsedUnit
+	elapsedDivisor float64
+	// countDeniedRequests counts requests denied by access controls in squid_site_requests_total
+	countDeniedRequests bool
 }
 
 func NewExporter() *Exporter {
-	e := &Exporter{}
+	e := &Exporter{
+		countMethods:        parseMethods(defaultCountMethods),
+		pathPrefixes:        map[[2]string]bool{},
+		elapsedDivisor:      elapsedUnitDivisors[defaultElapsedUnit],
+		countDeniedRequests: true,
+	}
 	// Default parsing function
 	e.parseFunc = e.parseLogLine
 	return e
 }
 
 // getCounterValue reads the current value of a labeled Counter from a CounterVec
-func getCounterValue(vec *prometheus.CounterVec, hostname string) (float64, error) {
-	m, err := vec.GetMetricWithLabelValues(hostname)
+func getCounterValue(vec *prometheus.CounterVec, labelValues ...string) (float64, error) {
+	m, err := vec.GetMetricWithLabelValues(labelValues...)
 	if err != nil {
 		return 0, err
 	}
@@ -127,11 +441,180 @@ func getCounterValue(vec *prometheus.CounterVec, hostname string) (float64, erro
 	return pb.Counter.GetValue(), nil
 }
 
+// normalizeHostname returns the value used for the hostname metric label.
+// Hostnames are lowercased, and IP literals are stored bare (without brackets)
+// in their canonical form, so "[2606:4700:0::1]" and "[2606:4700::1]" both map to "2606:4700::1".
+func normalizeHostname(hostname string) string {
+	// Only IPv4 literals start with a digit and only IPv6 literals contain a colon, so DNS names
+	// skip the (comparatively slow) failed parse
+	if hostname != "" && ('0' <= hostname[0] && hostname[0] <= '9' || strings.Contains(hostname, ":")) {
+		if addr, err := netip.ParseAddr(hostname); err == nil {
+			return addr.String()
+		}
+	}
+	return strings.ToLower(hostname)
+}
+
+// statusClass returns the status class ("2xx" etc.) of the status in a code/status access log
+// field, or "none" when Squid sent no response (e.g. NONE_NONE/000) or the status is missing
+func statusClass(codeStatus string) string {
+	_, statusStr, found := strings.Cut(codeStatus, "/")
+	if !found {
+		return "none"
+	}
+	status, err := strconv.Atoi(statusStr)
+	if err != nil || status < 100 || status > 599 {
+		return "none"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// accessLogFields is the number of leading access log fields parseLogLine uses, up to the URL
+const accessLogFields = 7
+
+// asciiSpace marks the ASCII whitespace bytes that strings.Fields splits on
+var asciiSpace = [256]bool{'\t': true, '\n': true, '\v': true, '\f': true, '\r': true, ' ': true}
+
+// spaceWidth returns the byte length of the whitespace rune at line[i], or 0 if it is not whitespace
+func spaceWidth(line string, i int) int {
+	if line[i] < utf8.RuneSelf {
+		if asciiSpace[line[i]] {
+			return 1
+		}
+		return 0
+	}
+	r, size := utf8.DecodeRuneInString(line[i:])
+	if unicode.IsSpace(r) {
+		return size
+	}
+	return 0
+}
+
+// closingDelimiter returns the index of the byte closing the quoted or bracketed field that opens
+// at line[start], or -1 if line[start] opens no such field or it is not closed. Quotes escaped
+// with a backslash, as written by Squid's quoting logformat modifiers, do not close a field.
+func closingDelimiter(line string, start int) int {
+	switch line[start] {
+	case '"':
+		for i := start + 1; i < len(line); i++ {
+			switch line[i] {
+			case '\\':
+				i++
+			case '"':
+				return i
+			}
+		}
+	case '[':
+		if end := strings.IndexByte(line[start+1:], ']'); end >= 0 {
+			return start + 1 + end
+		}
+	}
+	return -1
+}
+
+// splitAccessLogFields stores the first accessLogFields fields of line in fields and returns the
+// number of fields in line (at most accessLogFields). Fields are separated by whitespace like
+// strings.Fields, except that a field enclosed in double quotes or square brackets, such as a
+// quoted User-Agent header of a custom logformat, is one field even if it contains whitespace.
+// Such fields are stored without their delimiters and escapes inside them are kept. An
+// unterminated quote or bracket is split like any other field. Lines are split without allocating.
+func splitAccessLogFields(line string, fields *[accessLogFields]string) int {
+	n := 0
+	i := 0
+	for n < accessLogFields {
+		for i < len(line) {
+			width := spaceWidth(line, i)
+			if width == 0 {
+				break
+			}
+			i += width
+		}
+		if i == len(line) {
+			break
+		}
+
+		if end := closingDelimiter(line, i); end >= 0 {
+			fields[n] = line[i+1 : end]
+			n++
+			i = end + 1
+			continue
+		}
+
+		start := i
+		for i < len(line) && spaceWidth(line, i) == 0 {
+			i++
+		}
+		fields[n] = line[start:i]
+		n++
+	}
+	return n
+}
+
+// parseSiteURL parses an access log URL. Plain http(s) URLs with a DNS hostname, an optional
+// numeric port and an unescaped path are split directly, since url.Parse dominates the per-line
+// cost; all other URLs, including IP literals and URLs with userinfo or escapes, use url.Parse.
+func parseSiteURL(rawURL string) (*url.URL, error) {
+	var scheme, rest string
+	switch {
+	case strings.HasPrefix(rawURL, "https://"):
+		scheme, rest = "https", rawURL[len("https://"):]
+	case strings.HasPrefix(rawURL, "http://"):
+		scheme, rest = "http", rawURL[len("http://"):]
+	default:
+		return url.Parse(rawURL)
+	}
+
+	hostEnd := strings.IndexAny(rest, "/?#")
+	if hostEnd < 0 {
+		hostEnd = len(rest)
+	}
+	host := rest[:hostEnd]
+	path := rest[hostEnd:]
+	if pathEnd := strings.IndexAny(path, "?#"); pathEnd >= 0 {
+		path = path[:pathEnd]
+	}
+	if !isPlainHost(host) || strings.ContainsRune(path, '%') || hasControlByte(rawURL) {
+		return url.Parse(rawURL)
+	}
+	return &url.URL{Scheme: scheme, Host: host, Path: path}, nil
+}
+
+// isPlainHost reports whether host is a non-empty DNS name of letters, digits, dots, hyphens and
+// underscores, optionally followed by a non-empty numeric port
+func isPlainHost(host string) bool {
+	name, port, hasPort := strings.Cut(host, ":")
+	if name == "" || (hasPort && port == "") {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	for i := 0; i < len(port); i++ {
+		if port[i] < '0' || port[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// hasControlByte reports whether s contains an ASCII control character, which url.Parse rejects
+func hasControlByte(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
 func (e *Exporter) parseLogLine(line string) {
 	// Squid log format: timestamp elapsedtime remotehost code/status bytes method URL rfc931 peerstatus/peerhost type
-	fields := strings.Fields(line)
-	if len(fields) < 7 {
-		log.Printf("Malformed access log entry: need >=7 fields, got %d: %q", len(fields), line)
+	var fields [accessLogFields]string
+	if n := splitAccessLogFields(line, &fields); n < accessLogFields {
+		log.Printf("Malformed access log entry: need >=7 fields, got %d: %q", n, line)
 		return
 	}
 
@@ -148,22 +631,22 @@ func (e *Exporter) parseLogLine(line string) {
 		return
 	}
 
-	// Silently skip uncacheable methods to avoid filling the logs with unnecessary noise.
-	// GET and HEAD requests are cacheable.
-	// POST and PATCH are conditionally cacheable if the necessary response headers are set.
-	// See: https://wiki.squid-cache.org/SquidFaq/SquidLogs#request-methods
-	if method != "GET" && method != "HEAD" && method != "POST" && method != "PATCH" {
+	// Silently skip methods that aren't counted to avoid filling the logs with unnecessary noise.
+	// CONNECT tunnels and methods like PUT or DELETE are never cached, so counting them would
+	// only dilute the hit ratio; POST and PATCH can be opted in with -count-methods since they
+	// are conditionally cacheable if the necessary response headers are set.
+	if !e.countMethods[method] {
 		return
 	}
 
 	// Parse URL to extract hostname
-	parsedURL, err := url.Parse(urlStr)
+	parsedURL, err := parseSiteURL(urlStr)
 	if err != nil {
 		log.Printf("Invalid request URL %q: %v", urlStr, err)
 		return
 	}
 
-	hostname := parsedURL.Hostname()
+	hostname := normalizeHostname(parsedURL.Hostname())
 	if hostname == "" {
 		log.Printf("Missing hostname in URL %q", urlStr)
 		return
@@ -190,32 +673,67 @@ func (e *Exporter) parseLogLine(line string) {
 		statusToken = codeStatus[:idx]
 	}
 	isHit := strings.HasSuffix(statusToken, "_HIT") || strings.HasSuffix(statusToken, "REFRESH_UNMODIFIED")
+	// TCP_DENIED, TCP_DENIED_REPLY = blocked by http_access or http_reply_access, never looked up in the cache
+	isDenied := strings.Contains(statusToken, "_DENIED")
+
+	labels := []string{hostname}
+	if trackPort {
+		labels = append(labels, sitePort(parsedURL))
+	}
 
 	// Update Prometheus metrics
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
 
-	squidRequestsTotal.WithLabelValues(hostname).Inc()
-	squidBytesTotal.WithLabelValues(hostname).Add(float64(bytes))
-	squidResponseTime.WithLabelValues(hostname).Observe(elapsedTime / 1000.0) // Convert ms to seconds
+	if pathDepth > 0 {
+		labels = append(labels, e.boundedPathPrefix(hostname, pathPrefix(parsedURL, pathDepth)))
+	}
+
+	series := lookupSiteSeries(labels)
+	if !isDenied || e.countDeniedRequests {
+		series.requests.Inc()
+	}
+	series.bytes.Add(float64(bytes))
+	squidResponsesTotal.WithLabelValues(append(labels[:len(labels):len(labels)], statusClass(codeStatus))...).Inc()
+	series.responseTime.Observe(elapsedTime / e.elapsedDivisor)
+	e.parsed.Store(true)
+
+	// Denied requests are neither hits nor misses, so they don't dilute the hit ratio
+	if isDenied {
+		series.denied.Inc()
+		return
+	}
 
+	hits := series.hitCount.Load()
 	if isHit {
-		squidHitTotal.WithLabelValues(hostname).Inc()
+		series.hits.Inc()
+		series.bytesSaved.Add(float64(bytes))
+		hits = series.hitCount.Add(1)
 	} else {
-		squidMissTotal.WithLabelValues(hostname).Inc()
+		series.misses.Inc()
 	}
+	reqs := series.requestCount.Add(1)
+	series.hitRatio.Set(float64(hits) / float64(reqs))
+	squidHitRatioWindow.record(labels, isHit)
+}
 
-	// Ensure both hit and miss counters are initialized (even if 0) for this hostname
-	// This ensures squid_site_hits_total appears in metrics output even with 0 value
-	squidHitTotal.WithLabelValues(hostname).Add(0)
-	squidMissTotal.WithLabelValues(hostname).Add(0)
-
-	// Update hit ratio from Prometheus counters to keep alignment with exported metrics
-	hits, _ := getCounterValue(squidHitTotal, hostname)
-	reqs, _ := getCounterValue(squidRequestsTotal, hostname)
-	if reqs > 0 {
-		squidHitRatio.WithLabelValues(hostname).Set(hits / reqs)
+// boundedPathPrefix returns prefix, or otherPathPrefix once maxPathPrefixes distinct hostname and
+// prefix pairs have been seen. Must be called with the mutex held.
+func (e *Exporter) boundedPathPrefix(hostname, prefix string) string {
+	key := [2]string{hostname, prefix}
+	if e.pathPrefixes[key] {
+		return prefix
+	}
+	if len(e.pathPrefixes) >= maxPathPrefixes {
+		return otherPathPrefix
 	}
+	e.pathPrefixes[key] = true
+	return prefix
+}
+
+// HasParsed reports whether at least one access log line has been recorded in the metrics
+func (e *Exporter) HasParsed() bool {
+	return e.parsed.Load()
 }
 
 func (e *Exporter) readFromStdin() {
@@ -224,15 +742,27 @@ func (e *Exporter) readFromStdin() {
 		panic("Exporter not initialized correctly: use NewExporter() to set parseFunc")
 	}
 	log.Printf("Reading squid logs from stdin")
-	scanner := bufio.NewScanner(os.Stdin)
+	if err := e.readLines(os.Stdin, os.Stdout); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+// readLines parses each line of in and forwards it to out. A failed forward is logged and
+// counted, and only returned as an error (stopping the read) when fatalOnStdoutError is set.
+func (e *Exporter) readLines(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
 
 	for scanner.Scan() {
 		line := scanner.Text()
 		if line != "" {
 			e.parseFunc(line)
 			// Forward input to stdout so container logs still contain Squid access logs
-			if _, err := os.Stdout.WriteString(line + "\n"); err != nil {
-				log.Fatalf("Failed to forward log line to stdout: %v", err)
+			if _, err := io.WriteString(out, line+"\n"); err != nil {
+				squidExporterStdoutErrors.Inc()
+				if e.fatalOnStdoutError {
+					return fmt.Errorf("failed to forward log line to stdout: %w", err)
+				}
+				log.Printf("Failed to forward log line to stdout: %v", err)
 			}
 		}
 	}
@@ -240,16 +770,22 @@ func (e *Exporter) readFromStdin() {
 	if err := scanner.Err(); err != nil {
 		log.Printf("Error reading from stdin: %v", err)
 	}
+	return nil
 }
 
 func init() {
-	// Register Prometheus metrics
-	prometheus.MustRegister(squidHitRatio)
-	prometheus.MustRegister(squidHitTotal)
-	prometheus.MustRegister(squidMissTotal)
-	prometheus.MustRegister(squidRequestsTotal)
-	prometheus.MustRegister(squidBytesTotal)
-	prometheus.MustRegister(squidResponseTime)
+	// Metrics without the port and path prefix labels until main applies -track-port and -path-depth
+	newMetrics()
+}
+
+// newMetricsHandler returns the /metrics handler for gatherer. The Prometheus text format is
+// served unless openMetrics is set and the scraper accepts OpenMetrics, which also exposes the
+// _created series of counters and histograms.
+func newMetricsHandler(gatherer prometheus.Gatherer, openMetrics bool) http.Handler {
+	return promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics:                   openMetrics,
+		EnableOpenMetricsTextCreatedSamples: openMetrics,
+	})
 }
 
 func indexPageHandler(w http.ResponseWriter, _ *http.Request) {
@@ -275,6 +811,47 @@ func healthCheckHandler(squidAddr string, timeout time.Duration) http.HandlerFun
 	}
 }
 
+// readinessHandler reports ready once the exporter has parsed an access log line, or once warmup
+// has elapsed since start so pods without traffic still become ready (a zero warmup waits forever)
+func readinessHandler(e *Exporter, start time.Time, warmup time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if !e.HasParsed() && (warmup <= 0 || time.Since(start) < warmup) {
+			http.Error(w, "no access log lines parsed yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("OK"))
+	}
+}
+
+// serverTimeouts bounds how long a client may hold a connection to the metrics server, so slow
+// clients cannot exhaust the metrics and health endpoints
+type serverTimeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+const (
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultReadTimeout       = 10 * time.Second
+	defaultWriteTimeout      = 30 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+)
+
+// newServer returns the HTTP server for the metrics, health and readiness endpoints
+func newServer(addr string, handler http.Handler, timeouts serverTimeouts) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: timeouts.ReadHeader,
+		ReadTimeout:       timeouts.Read,
+		WriteTimeout:      timeouts.Write,
+		IdleTimeout:       timeouts.Idle,
+	}
+}
+
 func main() {
 	// Configuration with environment variable fallbacks for container-friendly deployment
 	listenAddress := flag.String("web.listen-address",
@@ -302,29 +879,167 @@ func main() {
 		getEnvDurationDefault("SQUID_HEALTH_TIMEOUT", 500*time.Millisecond),
 		"Timeout for Squid health dial (e.g., 500ms). (Env: SQUID_HEALTH_TIMEOUT)")
 
+	countMethods := flag.String("count-methods",
+		getEnvDefault("COUNT_METHODS", defaultCountMethods),
+		"Comma-separated request methods that produce per-site metrics. (Env: COUNT_METHODS)")
+
+	trackPortFlag := flag.Bool("track-port",
+		getEnvDefault("TRACK_PORT", "false") == "true",
+		"Add a port label to the per-site metrics (defaults to 443 for https and 80 for http). (Env: TRACK_PORT)")
+
+	pathDepthFlag := flag.Int("path-depth",
+		getEnvIntDefault("PATH_DEPTH", 0),
+		"Add a path_prefix label with the first N URL path segments to the per-site metrics, 0 to disable. "+
+			"(Env: PATH_DEPTH)")
+
+	hitRatioWindowFlag := flag.Duration("hit-ratio-window",
+		getEnvDurationDefault("HIT_RATIO_WINDOW", defaultHitRatioWindow),
+		"Sliding window of the squid_site_hit_ratio_5m gauge. (Env: HIT_RATIO_WINDOW)")
+
+	podLabel := flag.Bool("pod-label",
+		getEnvDefault("POD_LABEL", "false") == "true",
+		"Add a pod label with the value of the POD_NAME environment variable to the per-site metrics. "+
+			"(Env: POD_LABEL)")
+
+	elapsedUnit := flag.String("elapsed-unit",
+		getEnvDefault("ELAPSED_UNIT", defaultElapsedUnit),
+		"Unit of the elapsed time column of the access log: ms (Squid's %tr), s or us. (Env: ELAPSED_UNIT)")
+
+	countDeniedRequests := flag.Bool("count-denied-requests",
+		getEnvDefault("COUNT_DENIED_REQUESTS", "true") == "true",
+		"Count requests denied by access controls in squid_site_requests_total. "+
+			"They are never counted as hits or misses. (Env: COUNT_DENIED_REQUESTS)")
+
+	openMetrics := flag.Bool("openmetrics",
+		getEnvDefault("OPENMETRICS", "false") == "true",
+		"Serve the OpenMetrics format to scrapers that request it instead of the Prometheus text format. "+
+			"(Env: OPENMETRICS)")
+
+	storeIDMetricsDir := flag.String("store-id-metrics-dir",
+		getEnvDefault("STORE_ID_METRICS_DIR", ""),
+		"Directory of the metrics files written by the store-id helper children to serve with the "+
+			"per-site metrics, disabled when empty. (Env: STORE_ID_METRICS_DIR)")
+
+	// Readiness options
+	readinessEnabled := flag.Bool("web.readiness-enabled",
+		getEnvDefault("WEB_READINESS_ENABLED", "false") == "true",
+		"Serve /ready, which fails until the first access log line is parsed. (Env: WEB_READINESS_ENABLED)")
+	readinessWarmup := flag.Duration("web.readiness-warmup",
+		getEnvDurationDefault("WEB_READINESS_WARMUP", 0),
+		"Report ready after this duration even if no log line was parsed, 0 to wait for a line. "+
+			"(Env: WEB_READINESS_WARMUP)")
+
+	fatalOnStdoutError := flag.Bool("fatal-on-stdout-error",
+		getEnvDefault("FATAL_ON_STDOUT_ERROR", "false") == "true",
+		"Exit when an access log line cannot be forwarded to stdout instead of counting the error. "+
+			"(Env: FATAL_ON_STDOUT_ERROR)")
+
+	// Server timeouts
+	readHeaderTimeout := flag.Duration("web.read-header-timeout",
+		getEnvDurationDefault("WEB_READ_HEADER_TIMEOUT", defaultReadHeaderTimeout),
+		"Maximum time to read request headers, 0 for no limit. (Env: WEB_READ_HEADER_TIMEOUT)")
+	readTimeout := flag.Duration("web.read-timeout",
+		getEnvDurationDefault("WEB_READ_TIMEOUT", defaultReadTimeout),
+		"Maximum time to read the entire request, 0 for no limit. (Env: WEB_READ_TIMEOUT)")
+	writeTimeout := flag.Duration("web.write-timeout",
+		getEnvDurationDefault("WEB_WRITE_TIMEOUT", defaultWriteTimeout),
+		"Maximum time to write the response, 0 for no limit. (Env: WEB_WRITE_TIMEOUT)")
+	idleTimeout := flag.Duration("web.idle-timeout",
+		getEnvDurationDefault("WEB_IDLE_TIMEOUT", defaultIdleTimeout),
+		"Maximum time to keep an idle keep-alive connection open, 0 to use the read timeout. "+
+			"(Env: WEB_IDLE_TIMEOUT)")
+
+	showVersion := flag.Bool("version", false, "Print version information and exit")
+
 	flag.Parse()
 
+	if *showVersion {
+		fmt.Println(buildinfo.String("squid-per-site-exporter"))
+		return
+	}
+
 	log.Printf("Starting squid per-site exporter")
 	log.Printf("Listening on %s", *listenAddress)
 	log.Printf("Reading logs from stdin (use shell redirection for files)")
 
+	if *hitRatioWindowFlag <= 0 {
+		log.Fatalf("Invalid -hit-ratio-window %s: must be positive", *hitRatioWindowFlag)
+	}
+	if *pathDepthFlag < 0 {
+		log.Fatalf("Invalid -path-depth %d: must not be negative", *pathDepthFlag)
+	}
+	elapsedDivisor, err := parseElapsedUnit(*elapsedUnit)
+	if err != nil {
+		log.Fatalf("Invalid -elapsed-unit: %v", err)
+	}
+	for name, d := range map[string]time.Duration{
+		"web.read-header-timeout": *readHeaderTimeout,
+		"web.read-timeout":        *readTimeout,
+		"web.write-timeout":       *writeTimeout,
+		"web.idle-timeout":        *idleTimeout,
+	} {
+		if d < 0 {
+			log.Fatalf("Invalid -%s %s: must not be negative", name, d)
+		}
+	}
+	hitRatioWindowSize = *hitRatioWindowFlag
+	if *trackPortFlag {
+		log.Printf("Tracking destination ports in per-site metrics")
+	}
+	if *pathDepthFlag > 0 {
+		log.Printf("Tracking the first %d URL path segments in per-site metrics", *pathDepthFlag)
+	}
+	pod, err := podLabelValue(*podLabel)
+	if err != nil {
+		log.Fatalf("Invalid -pod-label: %v", err)
+	}
+	if pod != "" {
+		log.Printf("Labeling per-site metrics with pod %s", pod)
+	}
+	podName = pod
+	trackPort = *trackPortFlag
+	setPathDepth(*pathDepthFlag)
+	registerMetrics(prometheus.DefaultRegisterer)
+
+	start := time.Now()
 	exporter := NewExporter()
+	exporter.countMethods = parseMethods(*countMethods)
+	exporter.fatalOnStdoutError = *fatalOnStdoutError
+	exporter.elapsedDivisor = elapsedDivisor
+	exporter.countDeniedRequests = *countDeniedRequests
+	log.Printf("Counting request methods: %s", *countMethods)
 
 	// Start reading from stdin in background
 	go exporter.readFromStdin()
 
 	// Setup HTTP handlers
-	// Use HandlerFor with custom options to control content type format
-	handler := promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
-		// Disable the escaping=values parameter to match expected format
-		EnableOpenMetrics: false,
-	})
-	http.Handle("/metrics", handler)
+	if *openMetrics {
+		log.Printf("Serving OpenMetrics to scrapers that accept it")
+	}
+	var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+	if *storeIDMetricsDir != "" {
+		log.Printf("Serving store-id helper metrics from %s", *storeIDMetricsDir)
+		gatherer = prometheus.Gatherers{prometheus.DefaultGatherer, storeIDMetricsGatherer(*storeIDMetricsDir)}
+	}
+	http.Handle("/metrics", newMetricsHandler(gatherer, *openMetrics))
 	http.HandleFunc("/", indexPageHandler)
 
 	// Health check endpoint: validates exporter process and Squid TCP port
 	http.HandleFunc("/health", healthCheckHandler(*squidHealthAddr, *squidHealthTimeout))
 
+	// Readiness endpoint: fails until metrics have been populated from the access log
+	if *readinessEnabled {
+		log.Printf("Serving /ready (warm-up %s)", *readinessWarmup)
+		http.HandleFunc("/ready", readinessHandler(exporter, start, *readinessWarmup))
+	}
+
+	server := newServer(*listenAddress, http.DefaultServeMux, serverTimeouts{
+		ReadHeader: *readHeaderTimeout,
+		Read:       *readTimeout,
+		Write:      *writeTimeout,
+		Idle:       *idleTimeout,
+	})
+
 	// Start server based on TLS configuration
 	certPresent := fileExists(*tlsCertFile) && fileExists(*tlsKeyFile)
 	if *tlsRequired {
@@ -332,18 +1047,15 @@ func main() {
 			log.Printf("Starting HTTPS server on %s", *listenAddress)
 			log.Printf("Using TLS cert: %s", *tlsCertFile)
 			log.Printf("Using TLS key: %s", *tlsKeyFile)
-			//nolint:gosec // metrics sidecar; HTTP server timeouts not required
-			log.Fatal(http.ListenAndServeTLS(*listenAddress, *tlsCertFile, *tlsKeyFile, nil))
+			log.Fatal(server.ListenAndServeTLS(*tlsCertFile, *tlsKeyFile))
 		}
 		log.Fatalf("TLS required but certificate or key not found (cert: %s, key: %s).", *tlsCertFile, *tlsKeyFile)
 	} else {
 		if certPresent {
 			log.Printf("TLS not required but certificates found; starting HTTPS on %s", *listenAddress)
-			//nolint:gosec // metrics sidecar; HTTP server timeouts not required
-			log.Fatal(http.ListenAndServeTLS(*listenAddress, *tlsCertFile, *tlsKeyFile, nil))
+			log.Fatal(server.ListenAndServeTLS(*tlsCertFile, *tlsKeyFile))
 		}
 		log.Printf("TLS disabled; starting HTTP server on %s", *listenAddress)
-		//nolint:gosec // metrics sidecar; HTTP server timeouts not required
-		log.Fatal(http.ListenAndServe(*listenAddress, nil))
+		log.Fatal(server.ListenAndServe())
 	}
 }
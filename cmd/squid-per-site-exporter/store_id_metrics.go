@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// storeIDMetricsMaxAge is how long a store-id helper child's metrics file is served without being
+// rewritten. Children rewrite their file every 10 seconds and remove it on exit, so older files
+// belong to children that were killed.
+const storeIDMetricsMaxAge = time.Minute
+
+// storeIDMetricsGatherer returns a gatherer serving the metrics files that the store-id helper
+// children write to dir (see the helper's -metrics-dir). Squid runs several children, which
+// cannot share a listen address, so the exporter serves them all, with a pid label telling the
+// children apart. Unreadable, corrupt and stale files are skipped so they never fail a scrape.
+func storeIDMetricsGatherer(dir string) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.prom"))
+		if err != nil {
+			return nil, err
+		}
+		families := map[string]*dto.MetricFamily{}
+		for _, path := range paths {
+			parsed, err := readStoreIDMetricsFile(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				// The child exited between the glob and the read
+				continue
+			}
+			if err != nil {
+				log.Printf("Skipping store-id metrics file %s: %v", path, err)
+				continue
+			}
+			labelName := "pid"
+			pid := strings.TrimSuffix(filepath.Base(path), ".prom")
+			for name, family := range parsed {
+				for _, metric := range family.Metric {
+					metric.Label = append(metric.Label, &dto.LabelPair{Name: &labelName, Value: &pid})
+					slices.SortFunc(metric.Label, func(a, b *dto.LabelPair) int {
+						return strings.Compare(a.GetName(), b.GetName())
+					})
+				}
+				if merged, ok := families[name]; ok {
+					merged.Metric = append(merged.Metric, family.Metric...)
+				} else {
+					families[name] = family
+				}
+			}
+		}
+		result := make([]*dto.MetricFamily, 0, len(families))
+		for _, family := range families {
+			result = append(result, family)
+		}
+		return result, nil
+	})
+}
+
+// readStoreIDMetricsFile parses the metric families of one helper child's file, returning none
+// when the file is stale
+func readStoreIDMetricsFile(path string) (map[string]*dto.MetricFamily, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(info.ModTime()) > storeIDMetricsMaxAge {
+		return nil, nil
+	}
+	parser := expfmt.NewTextParser(model.LegacyValidation)
+	return parser.TextToMetricFamilies(file)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("storeIDMetricsGatherer", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+	})
+
+	writeChild := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		Expect(os.WriteFile(path, []byte(content), 0o644)).To(Succeed())
+		return path
+	}
+
+	// inflightByPid returns the storeid_inflight_requests value of each child, keyed by pid
+	inflightByPid := func(families []*dto.MetricFamily) map[string]float64 {
+		values := map[string]float64{}
+		for _, family := range families {
+			if family.GetName() != "storeid_inflight_requests" {
+				continue
+			}
+			for _, metric := range family.Metric {
+				for _, label := range metric.Label {
+					if label.GetName() == "pid" {
+						values[label.GetValue()] = metric.GetGauge().GetValue()
+					}
+				}
+			}
+		}
+		return values
+	}
+
+	It("should serve the metrics of every child with a pid label", func() {
+		writeChild("101.prom", "# TYPE storeid_inflight_requests gauge\nstoreid_inflight_requests 2\n")
+		writeChild("102.prom", "# TYPE storeid_inflight_requests gauge\nstoreid_inflight_requests 5\n"+
+			"# TYPE storeid_auth_errors_total counter\nstoreid_auth_errors_total{reason=\"timeout\"} 1\n")
+
+		families, err := storeIDMetricsGatherer(dir).Gather()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(inflightByPid(families)).To(Equal(map[string]float64{"101": 2, "102": 5}))
+
+		var authErrors *dto.MetricFamily
+		for _, family := range families {
+			if family.GetName() == "storeid_auth_errors_total" {
+				authErrors = family
+			}
+		}
+		Expect(authErrors).NotTo(BeNil())
+		Expect(authErrors.Metric).To(HaveLen(1))
+		Expect(authErrors.Metric[0].Label).To(HaveLen(2))
+		Expect(authErrors.Metric[0].Label[0].GetName()).To(Equal("pid"), "labels should stay sorted")
+		Expect(authErrors.Metric[0].Label[1].GetName()).To(Equal("reason"))
+	})
+
+	It("should skip corrupt and stale files without failing the scrape", func() {
+		writeChild("101.prom", "# TYPE storeid_inflight_requests gauge\nstoreid_inflight_requests 2\n")
+		writeChild("102.prom", "storeid_inflight_requests not-a-number\n")
+		stale := writeChild("103.prom", "# TYPE storeid_inflight_requests gauge\nstoreid_inflight_requests 7\n")
+		old := time.Now().Add(-2 * storeIDMetricsMaxAge)
+		Expect(os.Chtimes(stale, old, old)).To(Succeed())
+		writeChild("104.prom.tmp", "# TYPE storeid_inflight_requests gauge\nstoreid_inflight_requests 9\n")
+
+		families, err := storeIDMetricsGatherer(dir).Gather()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(inflightByPid(families)).To(Equal(map[string]float64{"101": 2}))
+	})
+
+	It("should serve nothing when no child has written metrics", func() {
+		families, err := storeIDMetricsGatherer(filepath.Join(dir, "missing")).Gather()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(families).To(BeEmpty())
+	})
+})
@@ -2,19 +2,113 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
+
+	"github.com/konflux-ci/caching/internal/buildinfo"
+	"github.com/konflux-ci/caching/internal/cdnpatterns"
+	"github.com/konflux-ci/caching/internal/storeid"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 )
 
+var (
+	// storeIDInflightRequests tracks lines currently being processed. A steadily growing value means
+	// Squid is sending requests faster than the CDN authorization checks complete.
+	storeIDInflightRequests = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "storeid_inflight_requests",
+			Help: "Number of store-id requests currently being processed",
+		},
+	)
+
+	// storeIDAuthErrorsTotal counts content-addressable URLs that fell back to the original URL
+	// because the origin authorization check failed
+	storeIDAuthErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "storeid_auth_errors_total",
+			Help: "Total number of origin authorization checks that failed, by reason",
+		},
+		[]string{"reason"},
+	)
+
+	// storeIDSoftDeadlineExceededTotal counts authorization checks that did not complete within the
+	// soft deadline, so the original URL was returned to Squid while the check kept running
+	storeIDSoftDeadlineExceededTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "storeid_soft_deadline_exceeded_total",
+			Help: "Total number of origin authorization checks that exceeded the soft deadline",
+		},
+	)
+
+	storeIDBuildInfo = buildinfo.NewGauge("storeid_build_info", "Store-ID helper build information, always 1")
+)
+
+// Reasons an origin authorization check failed
+const (
+	authErrorTimeout           = "timeout"
+	authErrorConnectionRefused = "connection_refused"
+	authErrorRequest           = "request_error"
+	authErrorStatus            = "unexpected_status"
+)
+
+func init() {
+	prometheus.MustRegister(storeIDInflightRequests)
+	prometheus.MustRegister(storeIDAuthErrorsTotal)
+	prometheus.MustRegister(storeIDSoftDeadlineExceededTotal)
+	prometheus.MustRegister(storeIDBuildInfo)
+}
+
 // HTTPClient interface for making HTTP requests (allows mocking)
 type HTTPClient interface {
-	Get(url string) (*http.Response, error)
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// newHTTPClient returns the client used for origin authorization checks. Squid sends bursts of
+// blob URLs for the same CDN host during parallel pulls, so idle connections are kept per host
+// to avoid a TLS handshake for every request. When parentProxy is set, origins are reached
+// through it like Squid's own requests, for clusters without direct egress.
+func newHTTPClient(maxIdleConnsPerHost int, idleConnTimeout time.Duration, parentProxy *url.URL) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 0 // no global limit, bounded per host
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	transport.IdleConnTimeout = idleConnTimeout
+	if parentProxy != nil {
+		transport.Proxy = http.ProxyURL(parentProxy)
+	}
+	return &http.Client{Transport: transport}
+}
+
+// parseParentProxy parses the -parent-proxy flag: an http:// URL of the parent proxy, or an
+// empty string for none
+func parseParentProxy(value string) (*url.URL, error) {
+	if value == "" {
+		return nil, nil
+	}
+	proxyURL, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme != "http" || proxyURL.Host == "" {
+		return nil, fmt.Errorf("%q must be an http://<host>:<port> URL", value)
+	}
+	return proxyURL, nil
 }
 
 // isChannelID checks if a string represents a positive integer (for channel-ID detection)
@@ -23,39 +117,412 @@ func isChannelID(s string) bool {
 	return err == nil && val >= 0
 }
 
-// normalizeStoreID normalizes the store-id for caching by removing query parameters from CDN URLs.
-// Only content-addressable URLs (containing SHA256 hashes) are normalized.
-// The request URL must return a 200 status code to ensure the request is authorized.
+// packageRegistryNormalization enables store-id normalization for package registry URLs
+// (see storeid.Options). Disabled by default so container-only deployments are unaffected.
+var packageRegistryNormalization bool
+
+// storeIDOptions returns the storeid.Options configured by the command line flags
+func storeIDOptions() storeid.Options {
+	return storeid.Options{PackageRegistries: packageRegistryNormalization}
+}
+
+// minSizeBytes limits normalization to resources whose size (see resourceSize) exceeds it.
+// Manifests and small blobs are cheap to re-fetch, so caching them per URL keeps meaningful
+// query parameters.
+// Disabled (0) by default.
+var minSizeBytes int64
+
+// softDeadline bounds how long normalizeStoreID waits for the origin authorization check before
+// returning the original URL, so a slow CDN doesn't hold Squid past its own helper timeout.
+// Disabled (0) by default.
+var softDeadline time.Duration
+
+// backgroundAuth keeps the results of authorization checks that exceeded softDeadline in
+// lateAuthCache, so the next request for the same URL is answered without a new check
+var backgroundAuth bool
+
+// skipAuthCheck normalizes content-addressable URLs without the origin authorization check,
+// for environments where all traffic is already authenticated upstream. minSizeBytes is
+// ignored since the size is never fetched. Disabled by default.
+var skipAuthCheck bool
+
+// lateAuthCache holds the store-ids of checks completed after softDeadline, see backgroundAuth
+var lateAuthCache = newAuthCache(5*time.Minute, 10000)
+
+// authCache remembers the store-ids of authorized URLs for a limited time. Signed CDN URLs expire,
+// so entries must not outlive the signature by much.
+type authCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]authCacheEntry
+}
+
+type authCacheEntry struct {
+	storeID string
+	expires time.Time
+}
+
+func newAuthCache(ttl time.Duration, maxEntries int) *authCache {
+	return &authCache{ttl: ttl, maxEntries: maxEntries, entries: make(map[string]authCacheEntry)}
+}
+
+// get returns the cached store-id of requestURL if it has not expired
+func (c *authCache) get(requestURL string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[requestURL]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, requestURL)
+		return "", false
+	}
+	return entry.storeID, true
+}
+
+// put caches the store-id of requestURL. Expired entries are dropped once the cache is full,
+// and new entries are skipped while it stays full.
+func (c *authCache) put(requestURL, storeID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.maxEntries {
+		now := time.Now()
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= c.maxEntries {
+			return
+		}
+	}
+	c.entries[requestURL] = authCacheEntry{storeID: storeID, expires: time.Now().Add(c.ttl)}
+}
+
+// Helper response formats. formatAnnotated appends key=value annotations such as ttl=3600 to
+// normalized store-ids, for Squid versions that record helper annotations.
+const (
+	formatSimple    = "simple"
+	formatAnnotated = "annotated"
+)
+
+// outputFormat is the helper response format, formatSimple by default
+var outputFormat = formatSimple
+
+// storeIDTTL is the ttl annotation of normalized store-ids in formatAnnotated, omitted when 0
+var storeIDTTL time.Duration
+
+// patternTTLs override storeIDTTL for URLs matching the named CDN patterns (see internal/cdnpatterns)
+var patternTTLs map[string]time.Duration
+
+// parsePatternTTLs parses a comma-separated list of <pattern>=<duration> pairs, e.g.
+// "quay=24h,dockerhub-r2=1h". Pattern names must be known CDN patterns.
+func parsePatternTTLs(list string) (map[string]time.Duration, error) {
+	known := make(map[string]bool, len(cdnpatterns.Patterns))
+	for _, pattern := range cdnpatterns.Patterns {
+		known[pattern.Name] = true
+	}
+
+	ttls := make(map[string]time.Duration)
+	for _, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid pattern TTL %q: expected <pattern>=<duration>", pair)
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("invalid pattern TTL %q: unknown CDN pattern %q", pair, name)
+		}
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf("invalid pattern TTL %q: expected a non-negative duration", pair)
+		}
+		ttls[name] = ttl
+	}
+	return ttls, nil
+}
+
+// storeIDAnnotations returns the annotations appended to the normalized store-id of requestURL
+// in formatAnnotated, separated by spaces
+func storeIDAnnotations(requestURL string) string {
+	ttl := storeIDTTL
+	if name, ok := cdnpatterns.Match(requestURL); ok {
+		if patternTTL, ok := patternTTLs[name]; ok {
+			ttl = patternTTL
+		}
+	}
+	if ttl <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("ttl=%d", int64(ttl/time.Second))
+}
+
+// levelAudit is the log level of the per-request audit records enabled with -audit, between
+// Info and Warn so the records can be told apart from regular log lines
+const levelAudit = slog.LevelInfo + 2
+
+// Authorization statuses of audit records. Failed checks are recorded with their
+// storeid_auth_errors_total reason instead.
+const (
+	authStatusAuthorized       = "authorized"
+	authStatusNotRequired      = "not_required"
+	authStatusSkipped          = "skipped"
+	authStatusCached           = "cached"
+	authStatusDeadlineExceeded = "soft_deadline_exceeded"
+)
+
+// auditLogger records the normalization decision of each request, discarded unless -audit is set
+var auditLogger = slog.New(slog.DiscardHandler)
+
+// newAuditLogger returns a logger writing audit records as JSON through the standard logger, so
+// they go to stderr with the helper's log prefix like any other log line
+func newAuditLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(logWriter{}, &slog.HandlerOptions{
+		Level: levelAudit,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) > 0 {
+				return a
+			}
+			switch a.Key {
+			case slog.TimeKey:
+				// Already added by the standard logger
+				return slog.Attr{}
+			case slog.LevelKey:
+				if a.Value.Any() == levelAudit {
+					return slog.String(slog.LevelKey, "AUDIT")
+				}
+			}
+			return a
+		},
+	}))
+}
+
+// logWriter writes each record to the standard logger
+type logWriter struct{}
+
+func (logWriter) Write(p []byte) (int, error) {
+	log.Print(string(p))
+	return len(p), nil
+}
+
+// auditStoreID logs the pattern requestURL matched, its host, the chosen store-id and the
+// authorization status at levelAudit. The store-id is redacted since it is the original URL,
+// including any signature or token, when normalization did not happen.
+func auditStoreID(requestURL, storeID, authStatus string) {
+	ctx := context.Background()
+	if !auditLogger.Enabled(ctx, levelAudit) {
+		return
+	}
+	var host string
+	if u, err := url.Parse(requestURL); err == nil {
+		host = u.Host
+	}
+	auditLogger.LogAttrs(ctx, levelAudit, "store-id",
+		slog.String("pattern", storeid.Pattern(requestURL, storeIDOptions())),
+		slog.String("original_host", host),
+		slog.String("store_id", redactURL(storeID)),
+		slog.String("auth_status", authStatus),
+	)
+}
+
+// redactURL returns rawURL without user info and with all query parameter values replaced,
+// so signed CDN URLs can be logged
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "<unparsable URL>"
+	}
+	u.User = nil
+	if u.RawQuery != "" {
+		query := u.Query()
+		for _, values := range query {
+			for i := range values {
+				values[i] = "xxxxx"
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// normalizeStoreID normalizes the store-id for caching by removing query parameters from CDN URLs
+// and namespacing registry CDN URLs by registry (see storeid.Key).
+// Only content-addressable URLs (see storeid.IsContentAddressable) are normalized.
+// The request URL must return a 200 (or 206) status code to ensure the request is authorized, and
+// when minSizeBytes is set its size must exceed minSizeBytes.
+// When the check exceeds softDeadline, the original URL is returned without waiting for it.
+// With skipAuthCheck, no request is made and content-addressable URLs are always normalized.
+// The decision is recorded in the audit log (see auditStoreID).
 func normalizeStoreID(client HTTPClient, requestURL string) string {
-	// Only normalize content-addressable URLs (those with SHA256 hashes in the path).
+	storeID, authStatus := checkStoreID(client, requestURL)
+	auditStoreID(requestURL, storeID, authStatus)
+	return storeID
+}
+
+// ComputeStoreID returns the store-id Squid would get for requestURL, without the soft deadline,
+// metrics and audit log of normalizeStoreID. With matchOnly, the origin authorization check is
+// skipped and content-addressable URLs are assumed authorized, so the result depends on
+// requestURL alone (see storeid.Compute).
+func ComputeStoreID(requestURL string, matchOnly bool) string {
+	if matchOnly {
+		return storeid.Compute(requestURL, storeIDOptions())
+	}
+	storeID, _ := resolveStoreID(http.DefaultClient, requestURL)
+	return storeID
+}
+
+// checkStoreID returns the store-id of normalizeStoreID and the authorization status of requestURL
+func checkStoreID(client HTTPClient, requestURL string) (string, string) {
+	if !storeid.IsContentAddressable(requestURL, storeIDOptions()) {
+		return requestURL, authStatusNotRequired
+	}
+	if skipAuthCheck {
+		return storeid.Key(requestURL), authStatusSkipped
+	}
+	if softDeadline <= 0 {
+		return recordStoreID(resolveStoreID(client, requestURL))
+	}
+	if storeID, ok := lateAuthCache.get(requestURL); ok {
+		return storeID, authStatusCached
+	}
+
+	type checkResult struct{ storeID, authStatus string }
+
+	cache, keepLateResult := lateAuthCache, backgroundAuth
+	// Buffered so the check can complete after the deadline without blocking
+	result := make(chan checkResult, 1)
+	deadlineExceeded := make(chan struct{})
+	go func() {
+		storeID, authStatus := recordStoreID(resolveStoreID(client, requestURL))
+		result <- checkResult{storeID, authStatus}
+		select {
+		case <-deadlineExceeded:
+			// The original URL was already returned for this request; remember the result
+			// of successful checks for the next one
+			if keepLateResult && storeID != requestURL {
+				cache.put(requestURL, storeID)
+			}
+		default:
+		}
+	}()
+
+	timer := time.NewTimer(softDeadline)
+	defer timer.Stop()
+	select {
+	case r := <-result:
+		return r.storeID, r.authStatus
+	case <-timer.C:
+		close(deadlineExceeded)
+		// The check may have completed concurrently with the deadline
+		select {
+		case r := <-result:
+			return r.storeID, r.authStatus
+		default:
+		}
+		storeIDSoftDeadlineExceededTotal.Inc()
+		log.Printf("Authorization check exceeded the soft deadline of %s, using the original URL", softDeadline)
+		return requestURL, authStatusDeadlineExceeded
+	}
+}
+
+// recordStoreID counts the authorization failure reason of resolveStoreID, if any, and returns the
+// store-id with the authorization status: the failure reason, or authStatusAuthorized
+func recordStoreID(storeID, authError string) (string, string) {
+	if authError != "" {
+		storeIDAuthErrorsTotal.WithLabelValues(authError).Inc()
+		return storeID, authError
+	}
+	return storeID, authStatusAuthorized
+}
+
+// resolveStoreID returns the store-id for requestURL. When the URL is content-addressable but the
+// origin authorization check fails, it returns the original URL and the failure reason;
+// the reason is empty for URLs that don't need normalization or were normalized successfully.
+func resolveStoreID(client HTTPClient, requestURL string) (string, string) {
+	// Only normalize content-addressable URLs.
 	// This prevents breaking caching for arbitrary URLs with meaningful query parameters.
-	if !strings.Contains(requestURL, "/sha256/") {
-		return requestURL
+	if !storeid.IsContentAddressable(requestURL, storeIDOptions()) {
+		return requestURL, ""
 	}
 
-	// Issue the request to the CDN/S3 to check authorization but don't read the body
-	resp, err := client.Get(requestURL)
+	// Issue the request to the CDN/S3 to check authorization. Only the first byte is requested,
+	// so the response can be drained and its connection reused for the next check.
+	req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	if err != nil {
+		log.Printf("Error creating request: %v", err)
+		return requestURL, authErrorRequest
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := client.Do(req)
 	if err != nil {
 		// Don't log the request URL to avoid leaking sensitive information
 		log.Printf("Error getting URL: %v", err)
-		return requestURL
+		return requestURL, classifyRequestError(err)
 	}
 
-	defer func() { _ = resp.Body.Close() }()
+	defer func() {
+		// Closing an unread body drops the connection, so drain origins ignoring the Range
+		// header as long as it is cheap
+		_, _ = io.CopyN(io.Discard, resp.Body, maxDrainBytes)
+		_ = resp.Body.Close()
+	}()
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
 		log.Printf("Error getting URL, status code: %v", resp.StatusCode)
-		return requestURL
+		return requestURL, authErrorStatus
+	}
+
+	// Keep the original URL for small (or unknown-size) resources
+	if minSizeBytes > 0 && resourceSize(resp) <= minSizeBytes {
+		return requestURL, ""
+	}
+
+	return storeid.Key(requestURL), ""
+}
+
+// maxDrainBytes is the most resolveStoreID reads from a response body to reuse its connection
+const maxDrainBytes = 64 << 10
+
+// resourceSize returns the size of the resource of resp, a full (200) or partial (206) response,
+// or -1 if unknown
+func resourceSize(resp *http.Response) int64 {
+	if resp.StatusCode != http.StatusPartialContent {
+		return resp.ContentLength
+	}
+	// Content-Range: bytes 0-0/<size>, where <size> may be "*"
+	_, size, ok := strings.Cut(resp.Header.Get("Content-Range"), "/")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return -1
 	}
+	return n
+}
 
-	// Return the URL without query parameters as the cache key
-	return strings.SplitN(requestURL, "?", 2)[0]
+// classifyRequestError maps an HTTP client error to an authorization failure reason
+func classifyRequestError(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return authErrorTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return authErrorConnectionRefused
+	default:
+		return authErrorRequest
+	}
 }
 
 // parseLine parses the input line according to Squid protocol:
 // [channel-ID <SP>] request-URL [<SP> extras] <NL>
 // and returns the response for Squid.
-func parseLine(line string, normalizeFunc func(HTTPClient, string) string) string {
+func parseLine(line string, client HTTPClient, normalizeFunc func(HTTPClient, string) string) string {
 	parts := strings.Fields(line)
 
 	var requestURL string
@@ -70,11 +537,16 @@ func parseLine(line string, normalizeFunc func(HTTPClient, string) string) strin
 	requestURL = parts[0]
 
 	// Normalize the store-id for caching
-	storeID := normalizeFunc(http.DefaultClient, requestURL)
+	storeID := normalizeFunc(client, requestURL)
 
 	if storeID != requestURL {
 		// Return the normalized store-id for caching
 		response += fmt.Sprintf("OK store-id=%s", storeID)
+		if outputFormat == formatAnnotated {
+			if annotations := storeIDAnnotations(requestURL); annotations != "" {
+				response += " " + annotations
+			}
+		}
 	} else {
 		// No normalization needed
 		response += "OK"
@@ -82,8 +554,8 @@ func parseLine(line string, normalizeFunc func(HTTPClient, string) string) strin
 	return response
 }
 
-// processInput reads lines from in, processes each concurrently, and writes responses to out
-func processInput(in io.Reader, out io.Writer, normalizeFunc func(HTTPClient, string) string) error {
+// processInput reads lines from in, processes each concurrently using client, and writes responses to out
+func processInput(in io.Reader, out io.Writer, client HTTPClient, normalizeFunc func(HTTPClient, string) string) error {
 	scanner := bufio.NewScanner(in)
 
 	// Use a wait group to ensure all goroutines gracefully exit
@@ -97,9 +569,11 @@ func processInput(in io.Reader, out io.Writer, normalizeFunc func(HTTPClient, st
 		}
 
 		wg.Add(1)
+		storeIDInflightRequests.Inc()
 		go func(l string) {
 			defer wg.Done()
-			response := parseLine(l, normalizeFunc)
+			defer storeIDInflightRequests.Dec()
+			response := parseLine(l, client, normalizeFunc)
 			log.Printf("Response: %s", response)
 			_, _ = fmt.Fprintln(out, response)
 		}(line)
@@ -116,14 +590,151 @@ func processInput(in io.Reader, out io.Writer, normalizeFunc func(HTTPClient, st
 	return nil
 }
 
+// metricsFileInterval is how often each helper child rewrites its metrics file
+const metricsFileInterval = 10 * time.Second
+
+// storeIDMetrics gathers the storeid_* metrics, leaving out the Go runtime and process metrics
+// of the default registry, which the per-site exporter serves for itself
+var storeIDMetrics = prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	return slices.DeleteFunc(families, func(family *dto.MetricFamily) bool {
+		return !strings.HasPrefix(family.GetName(), "storeid_")
+	}), err
+})
+
+// exportMetrics writes the metrics of gatherer to <dir>/<pid>.prom now and every interval in the
+// background, and returns a function that stops the updates and removes the file. Squid runs
+// several helper children, which cannot share a listen address, so each child writes its own
+// file and the per-site exporter serves the files of all children (see its -store-id-metrics-dir).
+func exportMetrics(dir string, gatherer prometheus.Gatherer, interval time.Duration) (func(), error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create metrics directory: %w", err)
+	}
+	path := filepath.Join(dir, strconv.Itoa(os.Getpid())+".prom")
+	if err := prometheus.WriteToTextfile(path, gatherer); err != nil {
+		return nil, fmt.Errorf("failed to write metrics file: %w", err)
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := prometheus.WriteToTextfile(path, gatherer); err != nil {
+					log.Println("Error writing metrics file:", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		if err := os.Remove(path); err != nil {
+			log.Println("Error removing metrics file:", err)
+		}
+	}, nil
+}
+
 func main() {
 	// Initialize logging to stderr so it doesn't interfere with stdout communication
 	log.SetOutput(os.Stderr)
 	log.SetPrefix("[squid-store-id] ")
 
+	// Flags are passed by Squid from the store_id_program directive
+	flag.BoolVar(&packageRegistryNormalization, "package-registries", false,
+		"Also normalize artifact URLs from PyPI, npm and crates.io")
+	flag.Int64Var(&minSizeBytes, "min-size-bytes", 0,
+		"Only normalize resources whose size exceeds this many bytes, 0 to normalize regardless of size")
+	metricsDir := flag.String("metrics-dir", os.Getenv("STORE_ID_METRICS_DIR"),
+		"Directory to write this helper child's metrics to for the per-site exporter, disabled when empty. "+
+			"(Env: STORE_ID_METRICS_DIR)")
+	maxIdleConnsPerHost := flag.Int("max-idle-conns-per-host", 16,
+		"Maximum idle connections kept per CDN host for authorization checks")
+	idleConnTimeout := flag.Duration("idle-conn-timeout", 90*time.Second,
+		"How long idle CDN connections are kept before closing")
+	flag.BoolVar(&skipAuthCheck, "skip-auth-check", false,
+		"Normalize content-addressable URLs without checking that the origin authorizes them, "+
+			"for trusted environments only")
+	flag.DurationVar(&softDeadline, "soft-deadline", 0,
+		"Return the original URL when the CDN authorization check takes longer than this, 0 to always wait")
+	flag.BoolVar(&backgroundAuth, "background-auth", false,
+		"Finish authorization checks that exceed -soft-deadline in the background and reuse their result "+
+			"for the same URL")
+	flag.StringVar(&outputFormat, "output-format", formatSimple,
+		"Response format: simple (OK store-id=<id>) or annotated (OK store-id=<id> ttl=<seconds>)")
+	flag.DurationVar(&storeIDTTL, "ttl", 0,
+		"ttl annotation of normalized store-ids in the annotated format, 0 to omit")
+	patternTTLList := flag.String("pattern-ttls", "",
+		"Comma-separated <pattern>=<duration> pairs overriding -ttl for CDN patterns, e.g. quay=24h")
+	parentProxyURL := flag.String("parent-proxy", "",
+		"URL of the parent proxy to send authorization checks through, e.g. http://proxy.corp:3128")
+	audit := flag.Bool("audit", false,
+		"Log the matched pattern, host, redacted store-id and authorization status of each request")
+	showVersion := flag.Bool("version", false, "Print version information and exit")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(buildinfo.String("squid-store-id"))
+		return
+	}
+
+	if outputFormat != formatSimple && outputFormat != formatAnnotated {
+		log.Fatalf("Invalid -output-format %q: must be %s or %s", outputFormat, formatSimple, formatAnnotated)
+	}
+	if storeIDTTL < 0 {
+		log.Fatalf("Invalid -ttl %s: must not be negative", storeIDTTL)
+	}
+	ttls, err := parsePatternTTLs(*patternTTLList)
+	if err != nil {
+		log.Fatalf("Invalid -pattern-ttls: %v", err)
+	}
+	patternTTLs = ttls
+	parentProxy, err := parseParentProxy(*parentProxyURL)
+	if err != nil {
+		log.Fatalf("Invalid -parent-proxy: %v", err)
+	}
+
 	log.Println("Starting Squid store-id helper")
+	if outputFormat == formatAnnotated {
+		log.Println("Annotating normalized store-ids")
+	}
+	if packageRegistryNormalization {
+		log.Println("Package registry normalization enabled")
+	}
+	if skipAuthCheck {
+		log.Println("Origin authorization check disabled")
+	} else if softDeadline > 0 {
+		log.Printf("Authorization check soft deadline: %s (background completion: %t)", softDeadline, backgroundAuth)
+	}
+	if parentProxy != nil {
+		log.Printf("Sending authorization checks through the parent proxy %s", parentProxy.Redacted())
+	}
+	if *audit {
+		auditLogger = newAuditLogger()
+		log.Println("Audit logging enabled")
+	}
+	stopMetrics := func() {}
+	if *metricsDir != "" {
+		// Metrics are optional, so the helper keeps serving Squid when they cannot be written
+		if stop, err := exportMetrics(*metricsDir, storeIDMetrics, metricsFileInterval); err != nil {
+			log.Printf("Error exporting metrics: %v", err)
+		} else {
+			stopMetrics = stop
+			log.Println("Writing metrics to", *metricsDir)
+		}
+	}
 
-	if err := processInput(os.Stdin, os.Stdout, normalizeStoreID); err != nil {
+	client := newHTTPClient(*maxIdleConnsPerHost, *idleConnTimeout, parentProxy)
+	err = processInput(os.Stdin, os.Stdout, client, normalizeStoreID)
+	stopMetrics()
+	if err != nil {
 		log.Printf("Error reading from stdin: %v", err)
 		os.Exit(1)
 	}
@@ -25,15 +25,20 @@ clean_cache() {
   if [ -n "${usage}" ] && [ "${usage}" -gt "${threshold}" ]; then
     echo "Cache usage: ${usage}% - cleaning cache on startup to prevent crash..."
     # Since we're on startup, Squid isn't running, so we skip "squid -k shutdown"
-    # Remove aufs cache directories (16 first-level dirs: 00-0f in hex, case-insensitive)
-    # Use [0-9a-fA-F] to match both lowercase (00-09) and uppercase (0A-0F) hex directories
-    rm -rf "${CACHE_DIR}"/[0-9a-fA-F][0-9a-fA-F] 2>/dev/null || true
-    # Remove swap state files
-    rm -f "${CACHE_DIR}"/swap* 2>/dev/null || true
-    # Remove network database files
-    rm -f "${CACHE_DIR}"/netdb* 2>/dev/null || true
-    # Remove log files
-    rm -f "${CACHE_DIR}"/*.log 2>/dev/null || true
+    # With SMP workers, each worker has its own cache_dir named after its process number
+    local dir
+    for dir in "${CACHE_DIR}" "${CACHE_DIR}"/[1-9]*; do
+      [ -d "${dir}" ] || continue
+      # Remove aufs cache directories (16 first-level dirs: 00-0f in hex, case-insensitive)
+      # Use [0-9a-fA-F] to match both lowercase (00-09) and uppercase (0A-0F) hex directories
+      rm -rf "${dir}"/[0-9a-fA-F][0-9a-fA-F] 2>/dev/null || true
+      # Remove swap state files
+      rm -f "${dir}"/swap* 2>/dev/null || true
+      # Remove network database files
+      rm -f "${dir}"/netdb* 2>/dev/null || true
+      # Remove log files
+      rm -f "${dir}"/*.log 2>/dev/null || true
+    done
     echo "Cache cleaned. Will reinitialize with squid -z."
   fi
 }
@@ -52,6 +57,12 @@ init_ssl_db() {
   echo "Initialization complete."
 }
 
+# Write the squid.conf include that names this pod in the X-Squid-Pod response
+# header. It is only referenced when forwarding.podHeader is enabled.
+write_pod_identity() {
+  printf 'reply_header_add X-Squid-Pod "%s" all\n' "${HOSTNAME}" > /tmp/squid-pod-identity.conf
+}
+
 # Initialize cache directories
 init_cache_dirs() {
   /usr/sbin/squid -d 1 --foreground -f /etc/squid/squid.conf -z
@@ -114,6 +125,7 @@ esac
 
 clean_cache
 init_ssl_db
+write_pod_identity
 init_cache_dirs
 start_squid "$@"
 watch_cert
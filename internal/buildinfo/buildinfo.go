@@ -0,0 +1,35 @@
+// Package buildinfo holds the version information of the caching binaries, injected at build time:
+//
+//	go build -ldflags "-X github.com/konflux-ci/caching/internal/buildinfo.Version=v1.2.3 \
+//	  -X github.com/konflux-ci/caching/internal/buildinfo.Commit=$(git rev-parse --short HEAD) \
+//	  -X github.com/konflux-ci/caching/internal/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package buildinfo
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Build information, overridden via -ldflags
+var (
+	Version = "dev"
+	Commit  = "unknown"
+	Date    = "unknown"
+)
+
+// String returns the build information as printed by the -version flag
+func String(program string) string {
+	return fmt.Sprintf("%s version %s (commit %s, built %s)", program, Version, Commit, Date)
+}
+
+// NewGauge returns a gauge named name with version and commit labels, set to 1
+func NewGauge(name, help string) prometheus.Gauge {
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        name,
+		Help:        help,
+		ConstLabels: prometheus.Labels{"version": Version, "commit": Commit},
+	})
+	gauge.Set(1)
+	return gauge
+}
@@ -0,0 +1,33 @@
+package buildinfo
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("buildinfo", func() {
+	BeforeEach(func() {
+		oldVersion, oldCommit, oldDate := Version, Commit, Date
+		Version, Commit, Date = "v1.2.3", "abc1234", "2024-01-01T00:00:00Z"
+		DeferCleanup(func() { Version, Commit, Date = oldVersion, oldCommit, oldDate })
+	})
+
+	It("should format the version string", func() {
+		Expect(String("test-program")).To(Equal("test-program version v1.2.3 (commit abc1234, built 2024-01-01T00:00:00Z)"))
+	})
+
+	It("should create a build info gauge set to 1", func() {
+		gauge := NewGauge("test_build_info", "Build information")
+
+		metric := &dto.Metric{}
+		Expect(gauge.Write(metric)).To(Succeed())
+		Expect(metric.GetGauge().GetValue()).To(Equal(1.0))
+
+		labels := map[string]string{}
+		for _, label := range metric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		Expect(labels).To(Equal(map[string]string{"version": "v1.2.3", "commit": "abc1234"}))
+	})
+})
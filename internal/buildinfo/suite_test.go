@@ -0,0 +1,13 @@
+package buildinfo
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestBuildInfoUnit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Build Info Unit Suite (package buildinfo)")
+}
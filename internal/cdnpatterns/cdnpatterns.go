@@ -0,0 +1,139 @@
+// Package cdnpatterns defines the registry CDN URL patterns shared by the squid-store-id helper
+// and the ICAP server, so both agree on which URLs serve content-addressable blobs.
+package cdnpatterns
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	sha256Hex = `[a-f0-9]{64}`
+	// blobPath is the docker distribution storage layout used by registries backed by object storage
+	blobPath = `/docker/registry/v2/blobs/sha256/[a-f0-9]{2}/` + sha256Hex + `/data`
+)
+
+// NamedPattern is a CDN URL pattern for content-addressable blobs
+type NamedPattern struct {
+	Name string
+	// Registry identifies the registry served by the CDN. Patterns for the CDNs of the same
+	// registry share it, so their blobs share one store-id namespace.
+	Registry string
+	Regexp   *regexp.Regexp
+	// Example is a URL matched by Regexp, used to keep consumers in sync
+	Example string
+}
+
+// Patterns lists the known registry CDN URL patterns.
+// Cosign signatures and attestations are OCI artifacts whose layers are stored as regular
+// blobs, so their CDN URLs match the same patterns. The sha256-<digest>.sig/.att tags and the
+// referrers API (/v2/<name>/referrers/sha256:<digest>) are mutable and must not match.
+var Patterns = []NamedPattern{
+	{
+		Name:     "quay",
+		Registry: "quay",
+		Regexp:   regexp.MustCompile(`^https://cdn([0-9]{2})?\.quay\.io/.+/sha256/.+/` + sha256Hex),
+		Example:  "https://cdn01.quay.io/quayio-production-s3/sha256/ab/" + strings.Repeat("ab", 32) + "?X-Amz-Signature=abc",
+	},
+	{
+		Name:     "quay-s3",
+		Registry: "quay",
+		Regexp:   regexp.MustCompile(`^https://s3\.[a-z0-9-]+\.amazonaws\.com/quayio-production-s3/sha256/.+/` + sha256Hex),
+		Example:  "https://s3.us-east-1.amazonaws.com/quayio-production-s3/sha256/ab/" + strings.Repeat("ab", 32) + "?X-Amz-Signature=abc",
+	},
+	{
+		Name:     "quay-s3-virtual-host",
+		Registry: "quay",
+		Regexp:   regexp.MustCompile(`^https://quayio-production-s3\.s3[a-z0-9.-]*\.amazonaws\.com/sha256/.+/` + sha256Hex),
+		Example:  "https://quayio-production-s3.s3.amazonaws.com/sha256/ab/" + strings.Repeat("ab", 32) + "?X-Amz-Signature=abc",
+	},
+	{
+		Name:     "dockerhub-r2",
+		Registry: "dockerhub",
+		Regexp:   regexp.MustCompile(`^https://docker-images-prod\.[a-f0-9]{32}\.r2\.cloudflarestorage\.com/registry-v2` + blobPath),
+		Example:  "https://docker-images-prod." + strings.Repeat("a", 32) + ".r2.cloudflarestorage.com/registry-v2/docker/registry/v2/blobs/sha256/ab/" + strings.Repeat("ab", 32) + "/data?X-Amz-Signature=abc",
+	},
+	{
+		Name:     "dockerhub-cloudflare",
+		Registry: "dockerhub",
+		Regexp:   regexp.MustCompile(`^https://production\.cloudflare\.docker\.com/registry-v2` + blobPath),
+		Example:  "https://production.cloudflare.docker.com/registry-v2/docker/registry/v2/blobs/sha256/ab/" + strings.Repeat("ab", 32) + "/data?verify=abc",
+	},
+	{
+		Name:     "dockerhub-cloudfront",
+		Registry: "dockerhub",
+		Regexp:   regexp.MustCompile(`^https://production\.cloudfront\.docker\.com/registry-v2` + blobPath),
+		Example:  "https://production.cloudfront.docker.com/registry-v2/docker/registry/v2/blobs/sha256/ab/" + strings.Repeat("ab", 32) + "/data?Signature=abc",
+	},
+	{
+		Name:     "dockerhub-s3",
+		Registry: "dockerhub",
+		Regexp:   regexp.MustCompile(`^https://docker-images-prod\.s3[a-z0-9.-]*\.amazonaws\.com/registry-v2` + blobPath),
+		Example:  "https://docker-images-prod.s3.dualstack.us-east-1.amazonaws.com/registry-v2/docker/registry/v2/blobs/sha256/ab/" + strings.Repeat("ab", 32) + "/data?X-Amz-Signature=abc",
+	},
+	{
+		Name:     "fedora",
+		Registry: "fedora",
+		Regexp:   regexp.MustCompile(`^https://cdn\.registry\.fedoraproject\.org/v2/.+/blobs/sha256:` + sha256Hex),
+		Example:  "https://cdn.registry.fedoraproject.org/v2/fedora/blobs/sha256:" + strings.Repeat("ab", 32),
+	},
+	{
+		Name:     "openshift-ci-r2",
+		Registry: "openshift-ci",
+		Regexp:   regexp.MustCompile(`^https://[a-f0-9]{32}\.r2\.cloudflarestorage\.com/app-ci-image-registry` + blobPath),
+		Example:  "https://" + strings.Repeat("a", 32) + ".r2.cloudflarestorage.com/app-ci-image-registry/docker/registry/v2/blobs/sha256/ab/" + strings.Repeat("ab", 32) + "/data?X-Amz-Signature=abc",
+	},
+	{
+		// Azure Container Registry redirects blob downloads to its storage account with a SAS token
+		Name:     "acr-blob",
+		Registry: "acr",
+		Regexp:   regexp.MustCompile(`^https://[a-z0-9]+\.blob\.core\.windows\.net/[a-z0-9-]+` + blobPath),
+		Example:  "https://weumanaged12.blob.core.windows.net/" + strings.Repeat("a", 32) + "-" + strings.Repeat("b", 10) + "/docker/registry/v2/blobs/sha256/ab/" + strings.Repeat("ab", 32) + "/data?se=2026-01-01T00%3A00%3A00Z&sig=abc&sp=r&sr=b&sv=2018-03-28",
+	},
+	{
+		// Google Container Registry redirects blob downloads to a signed GCS URL. Artifact Registry
+		// (*-docker.pkg.dev) redirects to artifacts-downloads URLs whose opaque token changes per
+		// request and doesn't contain the digest, so they can't be normalized.
+		Name:     "gcr-gcs",
+		Registry: "gcr",
+		Regexp:   regexp.MustCompile(`^https://storage\.googleapis\.com/((eu|us|asia)\.)?artifacts\.[a-z0-9.:-]+\.appspot\.com/containers/images/sha256:` + sha256Hex),
+		Example:  "https://storage.googleapis.com/us.artifacts.my-project.appspot.com/containers/images/sha256:" + strings.Repeat("ab", 32) + "?GoogleAccessId=gcr@example.iam.gserviceaccount.com&Expires=1732700000&Signature=abc",
+	},
+	{
+		Name:     "nvcr",
+		Registry: "nvcr",
+		Regexp:   regexp.MustCompile(`^https://layers\.nvcr\.io/registry` + blobPath),
+		Example:  "https://layers.nvcr.io/registry/docker/registry/v2/blobs/sha256/ab/" + strings.Repeat("ab", 32) + "/data?Signature=abc",
+	},
+}
+
+// Match returns the name of the first pattern matching rawURL
+func Match(rawURL string) (string, bool) {
+	for _, pattern := range Patterns {
+		if pattern.Regexp.MatchString(rawURL) {
+			return pattern.Name, true
+		}
+	}
+	return "", false
+}
+
+// MatchRegistry returns the registry of the first pattern matching rawURL
+func MatchRegistry(rawURL string) (string, bool) {
+	for _, pattern := range Patterns {
+		if pattern.Regexp.MatchString(rawURL) {
+			return pattern.Registry, true
+		}
+	}
+	return "", false
+}
+
+// IsContentAddressable returns true if u identifies an immutable blob, either because it
+// matches a known CDN pattern or because its path contains a /sha256/ segment
+func IsContentAddressable(u *url.URL) bool {
+	if strings.Contains(u.Path, "/sha256/") {
+		return true
+	}
+	_, ok := Match(u.String())
+	return ok
+}
@@ -0,0 +1,110 @@
+package cdnpatterns
+
+import (
+	"net/url"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Patterns", func() {
+	It("should have unique names and matching examples", func() {
+		names := map[string]bool{}
+		for _, pattern := range Patterns {
+			Expect(names).NotTo(HaveKey(pattern.Name), "duplicate pattern name %s", pattern.Name)
+			names[pattern.Name] = true
+
+			name, ok := Match(pattern.Example)
+			Expect(ok).To(BeTrue(), "example for %s should match", pattern.Name)
+			Expect(name).To(Equal(pattern.Name))
+			Expect(pattern.Registry).NotTo(BeEmpty(), "pattern %s should name its registry", pattern.Name)
+			Expect(pattern.Registry).NotTo(ContainSubstring(":"), "registry of %s is used as a store-id prefix", pattern.Name)
+		}
+	})
+})
+
+var _ = Describe("MatchRegistry", func() {
+	It("should return the registry shared by the CDNs of one registry", func() {
+		for _, example := range []string{Patterns[0].Example, Patterns[1].Example, Patterns[2].Example} {
+			registry, ok := MatchRegistry(example)
+			Expect(ok).To(BeTrue())
+			Expect(registry).To(Equal("quay"))
+		}
+	})
+
+	It("should not match non-blob URLs", func() {
+		_, ok := MatchRegistry("https://quay.io/v2/konflux-ci/caching/manifests/latest")
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("Match", func() {
+	DescribeTable("should not match non-blob URLs",
+		func(rawURL string) {
+			_, ok := Match(rawURL)
+			Expect(ok).To(BeFalse())
+		},
+		Entry("registry API", "https://quay.io/v2/konflux-ci/caching/manifests/latest"),
+		Entry("plain HTTP CDN", "http://cdn01.quay.io/quayio-production-s3/sha256/ab/"+strings.Repeat("ab", 32)),
+		Entry("other host", "https://example.com/registry-v2/docker/registry/v2/blobs/sha256/ab/"+strings.Repeat("ab", 32)+"/data"),
+		Entry("short digest", "https://production.cloudflare.docker.com/registry-v2/docker/registry/v2/blobs/sha256/ab/abcdef/data"),
+		Entry("cosign signature tag", "https://quay.io/v2/konflux-ci/caching/manifests/sha256-"+strings.Repeat("ab", 32)+".sig"),
+		Entry("cosign attestation tag", "https://quay.io/v2/konflux-ci/caching/manifests/sha256-"+strings.Repeat("ab", 32)+".att"),
+		Entry("quay referrers API", "https://quay.io/v2/konflux-ci/caching/referrers/sha256:"+strings.Repeat("ab", 32)),
+		Entry("docker hub referrers API", "https://registry-1.docker.io/v2/library/alpine/referrers/sha256:"+strings.Repeat("ab", 32)+"?artifactType=application%2Fvnd.dev.cosign.artifact.sig.v1%2Bjson"),
+		Entry("fedora referrers API", "https://cdn.registry.fedoraproject.org/v2/fedora/referrers/sha256:"+strings.Repeat("ab", 32)),
+		Entry("fedora signature tag", "https://cdn.registry.fedoraproject.org/v2/fedora/manifests/sha256-"+strings.Repeat("ab", 32)+".sig"),
+	)
+
+	DescribeTable("should match cloud provider registry storage redirects",
+		func(rawURL, expected string, matches bool) {
+			name, ok := Match(rawURL)
+			Expect(ok).To(Equal(matches))
+			if matches {
+				Expect(name).To(Equal(expected))
+			}
+		},
+		Entry("ACR blob storage", "https://eusreplstore42.blob.core.windows.net/"+strings.Repeat("c", 32)+"-"+strings.Repeat("d", 10)+"/docker/registry/v2/blobs/sha256/ab/"+strings.Repeat("ab", 32)+"/data?sig=abc", "acr-blob", true),
+		Entry("ACR wrong host", "https://eusreplstore42.blob.core.example.net/container/docker/registry/v2/blobs/sha256/ab/"+strings.Repeat("ab", 32)+"/data", "", false),
+		Entry("ACR short digest", "https://eusreplstore42.blob.core.windows.net/container/docker/registry/v2/blobs/sha256/ab/abcdef/data", "", false),
+		Entry("ACR plain HTTP", "http://eusreplstore42.blob.core.windows.net/container/docker/registry/v2/blobs/sha256/ab/"+strings.Repeat("ab", 32)+"/data", "", false),
+		Entry("GCR GCS", "https://storage.googleapis.com/artifacts.my-project.appspot.com/containers/images/sha256:"+strings.Repeat("ab", 32)+"?Signature=abc", "gcr-gcs", true),
+		Entry("GCR GCS regional", "https://storage.googleapis.com/eu.artifacts.my-project.appspot.com/containers/images/sha256:"+strings.Repeat("ab", 32)+"?Signature=abc", "gcr-gcs", true),
+		Entry("GCR GCS domain-scoped project", "https://storage.googleapis.com/artifacts.example.com:my-project.appspot.com/containers/images/sha256:"+strings.Repeat("ab", 32), "gcr-gcs", true),
+		Entry("GCR wrong host", "https://storage.example.com/artifacts.my-project.appspot.com/containers/images/sha256:"+strings.Repeat("ab", 32), "", false),
+		Entry("GCR other bucket", "https://storage.googleapis.com/my-bucket/containers/images/sha256:"+strings.Repeat("ab", 32), "", false),
+		Entry("GCR short digest", "https://storage.googleapis.com/artifacts.my-project.appspot.com/containers/images/sha256:abcdef", "", false),
+		Entry("GCR plain HTTP", "http://storage.googleapis.com/artifacts.my-project.appspot.com/containers/images/sha256:"+strings.Repeat("ab", 32), "", false),
+		Entry("Artifact Registry download token", "https://us-docker.pkg.dev/artifacts-downloads/namespaces/my-project/repositories/images/downloads/ALDjsF3kq9", "", false),
+	)
+
+	// Signature and attestation layers are regular blobs, served from the same CDN storage layout
+	DescribeTable("should match signature and attestation blobs",
+		func(rawURL, expected string) {
+			name, ok := Match(rawURL)
+			Expect(ok).To(BeTrue())
+			Expect(name).To(Equal(expected))
+		},
+		Entry("quay cosign signature layer", "https://cdn02.quay.io/quayio-production-s3/sha256/3f/3fa1c9e6b0d24d0c6a3c5e8f1b2d4a6c8e0f2a4b6c8d0e2f4a6b8c0d2e4f6a8b?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Expires=600&X-Amz-Signature=abc", "quay"),
+		Entry("quay in-toto attestation layer", "https://quayio-production-s3.s3.amazonaws.com/sha256/9c/9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d?X-Amz-Signature=abc", "quay-s3-virtual-host"),
+		Entry("docker hub signature layer", "https://production.cloudflare.docker.com/registry-v2/docker/registry/v2/blobs/sha256/5e/5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f/data?verify=1732700000-abc", "dockerhub-cloudflare"),
+		Entry("fedora signature layer", "https://cdn.registry.fedoraproject.org/v2/fedora/blobs/sha256:1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b", "fedora"),
+	)
+})
+
+var _ = Describe("IsContentAddressable", func() {
+	DescribeTable("should classify URLs",
+		func(rawURL string, expected bool) {
+			u, err := url.Parse(rawURL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(IsContentAddressable(u)).To(Equal(expected))
+		},
+		Entry("sha256 path segment", "https://cdn.example.com/blobs/sha256/ab/abcdef", true),
+		Entry("known CDN pattern without a sha256 segment", "https://cdn.registry.fedoraproject.org/v2/fedora/blobs/sha256:"+strings.Repeat("ab", 32), true),
+		Entry("sha256 only in the query", "https://example.com/path?next=/sha256/ab", false),
+		Entry("cosign signature tag", "https://quay.io/v2/konflux-ci/caching/manifests/sha256-"+strings.Repeat("ab", 32)+".sig", false),
+		Entry("referrers API", "https://quay.io/v2/konflux-ci/caching/referrers/sha256:"+strings.Repeat("ab", 32), false),
+		Entry("arbitrary URL", "https://example.com/path", false),
+	)
+})
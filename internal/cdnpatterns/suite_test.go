@@ -0,0 +1,13 @@
+package cdnpatterns
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestCDNPatternsUnit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CDN Patterns Unit Suite (package cdnpatterns)")
+}
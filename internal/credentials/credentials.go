@@ -0,0 +1,50 @@
+// Package credentials scans logs for secrets, so the e2e suites and the unit tests of the
+// components that redact URLs share one definition of what must never be logged.
+package credentials
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// redactedValue is the placeholder net/url's URL.Redacted and the ICAP server and store-id
+// helper redactors substitute for secrets
+const redactedValue = "xxxxx"
+
+// patterns match secrets that must never reach the logs. Squid strips query terms from
+// logged URLs and the ICAP server redacts the URLs it logs, so any match is a regression.
+// Matches whose "value" group is redactedValue are redacted output rather than credentials.
+var patterns = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{"Authorization header", regexp.MustCompile(`(?i)\b(proxy-)?authorization:`)},
+	{"token query parameter", regexp.MustCompile(`(?i)[?&;]([a-z_-]*token)=(?P<value>[^&;\s"']*)`)},
+	{"AWS signature", regexp.MustCompile(`(?i)X-Amz-(Signature|Credential|Security-Token)=(?P<value>[^&;\s"']*)`)},
+	{"GCS signature", regexp.MustCompile(`(?i)X-Goog-(Signature|Credential)=(?P<value>[^&;\s"']*)`)},
+	{"URL userinfo", regexp.MustCompile(`://[^/\s:@]+:(?P<value>[^/\s@]+)@`)},
+	{"bearer credentials", regexp.MustCompile(`(?i)\b(Bearer|Basic)\s+[A-Za-z0-9._~+/-]{8,}=*`)},
+	{"JSON web token", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}\.`)},
+}
+
+// Find returns a description of each credential found in logs, as "line <n>: <kind>".
+// The credentials themselves are left out so failures don't leak them.
+// Values redacted to redactedValue are not reported.
+func Find(logs string) []string {
+	var findings []string
+	lineNumber := 0
+	for line := range strings.Lines(logs) {
+		lineNumber++
+		for _, credential := range patterns {
+			value := credential.pattern.SubexpIndex("value")
+			for _, match := range credential.pattern.FindAllStringSubmatch(line, -1) {
+				if value < 0 || match[value] != redactedValue {
+					findings = append(findings, fmt.Sprintf("line %d: %s", lineNumber, credential.name))
+					break
+				}
+			}
+		}
+	}
+	return findings
+}
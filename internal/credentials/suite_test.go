@@ -0,0 +1,13 @@
+package credentials
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestCredentialsUnit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Credentials Unit Suite (package credentials)")
+}
@@ -0,0 +1,134 @@
+// Package sitemetrics checks invariants of the per-site exporter's metrics in Prometheus text
+// exposition content. It has no Kubernetes dependencies, so the exporter's unit tests can use it.
+package sitemetrics
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// hitRatioEpsilon is the float tolerance of AssertHitRatioConsistent
+const hitRatioEpsilon = 1e-9
+
+// AssertHitRatioConsistent fails the current test unless the squid_site_hit_ratio gauge for hostname
+// equals squid_site_hits_total / (squid_site_hits_total + squid_site_misses_total) within
+// hitRatioEpsilon, so the exported ratio never drifts from the exported counters. Denied requests
+// are neither hits nor misses, so they never affect the ratio, whether or not the exporter counts
+// them in squid_site_requests_total.
+//
+// Example usage:
+//
+//	AssertHitRatioConsistent(metricsContent, "example.com")
+func AssertHitRatioConsistent(metricsContent, hostname string) {
+	metricFamilies, err := parse(metricsContent)
+	Expect(err).NotTo(HaveOccurred(), "Failed to parse metrics")
+
+	hits, err := hostnameValue(metricFamilies, "squid_site_hits_total", hostname)
+	Expect(err).NotTo(HaveOccurred(), "Failed to read squid_site_hits_total for %s", hostname)
+	misses, err := hostnameValue(metricFamilies, "squid_site_misses_total", hostname)
+	Expect(err).NotTo(HaveOccurred(), "Failed to read squid_site_misses_total for %s", hostname)
+	ratio, err := hostnameValue(metricFamilies, "squid_site_hit_ratio", hostname)
+	Expect(err).NotTo(HaveOccurred(), "Failed to read squid_site_hit_ratio for %s", hostname)
+
+	expected := 0.0
+	if hits+misses > 0 {
+		expected = hits / (hits + misses)
+	}
+	Expect(ratio).To(BeNumerically("~", expected, hitRatioEpsilon),
+		"squid_site_hit_ratio for %s should equal hits/(hits+misses) (%g/(%g+%g))", hostname, hits, hits, misses)
+}
+
+// hostnameLabelPattern matches lowercase DNS names, including single labels such as "localhost"
+var hostnameLabelPattern = regexp.MustCompile(`^[a-z0-9_]([a-z0-9_-]{0,61}[a-z0-9_])?(\.[a-z0-9_]([a-z0-9_-]{0,61}[a-z0-9_])?)*$`)
+
+// AssertHostnameLabelsSane returns an error listing every hostname label value of the per-site
+// (squid_site_*) metrics in metricsContent that is neither a lowercase DNS name nor a canonical
+// IP address, e.g. a value with a port, a path fragment or IPv6 brackets. Such values break the
+// per-site dashboards, so this guards the exporter's hostname extraction.
+//
+// Example usage:
+//
+//	Expect(AssertHostnameLabelsSane(metricsContent)).To(Succeed())
+func AssertHostnameLabelsSane(metricsContent string) error {
+	metricFamilies, err := parse(metricsContent)
+	if err != nil {
+		return err
+	}
+
+	malformed := make(map[string][]string)
+	for name, family := range metricFamilies {
+		if !strings.HasPrefix(name, "squid_site_") {
+			continue
+		}
+		for _, metric := range family.Metric {
+			for _, label := range metric.Label {
+				if label.GetName() == "hostname" && !isSaneHostnameLabel(label.GetValue()) &&
+					!slices.Contains(malformed[label.GetValue()], name) {
+					malformed[label.GetValue()] = append(malformed[label.GetValue()], name)
+				}
+			}
+		}
+	}
+	if len(malformed) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0, len(malformed))
+	for value, names := range malformed {
+		slices.Sort(names)
+		values = append(values, fmt.Sprintf("%q (%s)", value, strings.Join(names, ", ")))
+	}
+	slices.Sort(values)
+	return fmt.Errorf("malformed hostname label values: %s", strings.Join(values, "; "))
+}
+
+// isSaneHostnameLabel returns true if value is a lowercase DNS name or a canonical IP address
+// without zone, as normalized by the per-site exporter
+func isSaneHostnameLabel(value string) bool {
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return addr.Zone() == "" && addr.String() == value
+	}
+	return len(value) <= 253 && hostnameLabelPattern.MatchString(value)
+}
+
+// parse parses Prometheus text exposition content into metric families keyed by name
+func parse(metricsContent string) (map[string]*dto.MetricFamily, error) {
+	parser := expfmt.NewTextParser(model.LegacyValidation)
+	metricFamilies, err := parser.TextToMetricFamilies(strings.NewReader(metricsContent))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse metrics: %w", err)
+	}
+	return metricFamilies, nil
+}
+
+// hostnameValue returns the value of the counter or gauge metricName for hostname
+func hostnameValue(metricFamilies map[string]*dto.MetricFamily, metricName, hostname string) (float64, error) {
+	metricFamily, found := metricFamilies[metricName]
+	if !found {
+		return 0, fmt.Errorf("metric %s not found", metricName)
+	}
+	for _, metric := range metricFamily.Metric {
+		for _, label := range metric.Label {
+			if label.GetName() != "hostname" || label.GetValue() != hostname {
+				continue
+			}
+			switch metricFamily.GetType() {
+			case dto.MetricType_COUNTER:
+				return metric.Counter.GetValue(), nil
+			case dto.MetricType_GAUGE:
+				return metric.Gauge.GetValue(), nil
+			default:
+				return 0, fmt.Errorf("metric %s has unsupported type %s", metricName, metricFamily.GetType())
+			}
+		}
+	}
+	return 0, fmt.Errorf("metric %s with hostname %q not found", metricName, hostname)
+}
@@ -0,0 +1,132 @@
+package sitemetrics
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AssertHitRatioConsistent", func() {
+	const metricsContent = `# TYPE squid_site_requests_total counter
+squid_site_requests_total{hostname="example.com"} 3
+squid_site_requests_total{hostname="denied.example.com"} 5
+squid_site_requests_total{hostname="uncounted-denied.example.com"} 4
+squid_site_requests_total{hostname="drift.example.com"} 3
+squid_site_requests_total{hostname="idle.example.com"} 0
+# TYPE squid_site_hits_total counter
+squid_site_hits_total{hostname="example.com"} 1
+squid_site_hits_total{hostname="denied.example.com"} 1
+squid_site_hits_total{hostname="uncounted-denied.example.com"} 1
+squid_site_hits_total{hostname="drift.example.com"} 1
+squid_site_hits_total{hostname="idle.example.com"} 0
+# TYPE squid_site_misses_total counter
+squid_site_misses_total{hostname="example.com"} 2
+squid_site_misses_total{hostname="denied.example.com"} 3
+squid_site_misses_total{hostname="uncounted-denied.example.com"} 3
+squid_site_misses_total{hostname="drift.example.com"} 2
+squid_site_misses_total{hostname="idle.example.com"} 0
+# TYPE squid_site_denied_total counter
+squid_site_denied_total{hostname="denied.example.com"} 1
+squid_site_denied_total{hostname="uncounted-denied.example.com"} 2
+# TYPE squid_site_hit_ratio gauge
+squid_site_hit_ratio{hostname="example.com"} 0.3333333333333333
+squid_site_hit_ratio{hostname="denied.example.com"} 0.25
+squid_site_hit_ratio{hostname="uncounted-denied.example.com"} 0.25
+squid_site_hit_ratio{hostname="drift.example.com"} 0.33
+squid_site_hit_ratio{hostname="idle.example.com"} 0
+`
+
+	DescribeTable("should pass when the gauge matches the counters",
+		func(hostname string) {
+			Expect(InterceptGomegaFailures(func() {
+				AssertHitRatioConsistent(metricsContent, hostname)
+			})).To(BeEmpty())
+		},
+		Entry("repeating decimal ratio", "example.com"),
+		Entry("denied requests excluded from the ratio", "denied.example.com"),
+		Entry("denied requests not counted in the requests", "uncounted-denied.example.com"),
+		Entry("no requests", "idle.example.com"),
+	)
+
+	It("should fail when the gauge drifts from the counters", func() {
+		Expect(InterceptGomegaFailures(func() {
+			AssertHitRatioConsistent(metricsContent, "drift.example.com")
+		})).NotTo(BeEmpty())
+	})
+
+	It("should fail when a metric is missing for the hostname", func() {
+		Expect(InterceptGomegaFailures(func() {
+			AssertHitRatioConsistent(metricsContent, "unknown.example.com")
+		})).NotTo(BeEmpty())
+	})
+})
+
+var _ = Describe("AssertHostnameLabelsSane", func() {
+	metricsWithHostname := func(hostname string) string {
+		return fmt.Sprintf("# TYPE squid_site_requests_total counter\nsquid_site_requests_total{hostname=%q} 1\n", hostname)
+	}
+
+	DescribeTable("should accept well-formed hostnames",
+		func(hostname string) {
+			Expect(AssertHostnameLabelsSane(metricsWithHostname(hostname))).To(Succeed())
+		},
+		Entry("DNS name", "cdn01.quay.io"),
+		Entry("single label", "localhost"),
+		Entry("hyphens and underscores", "my-registry_1.example.com"),
+		Entry("IPv4 address", "10.96.0.1"),
+		Entry("IPv6 address", "2606:4700::abcd"),
+	)
+
+	DescribeTable("should reject malformed hostnames",
+		func(hostname string) {
+			Expect(AssertHostnameLabelsSane(metricsWithHostname(hostname))).To(MatchError(ContainSubstring(fmt.Sprintf("%q (squid_site_requests_total)", hostname))))
+		},
+		Entry("port", "example.com:8080"),
+		Entry("path fragment", "example.com/v2"),
+		Entry("upper case", "Example.com"),
+		Entry("bracketed IPv6 address", "[2606:4700::abcd]"),
+		Entry("non-canonical IPv6 address", "2606:4700:0:0:0:0:0:ABCD"),
+		Entry("IPv6 zone", "fe80::1%eth0"),
+		Entry("empty", ""),
+		Entry("trailing dot", "example.com."),
+		Entry("leading hyphen", "-example.com"),
+		Entry("empty DNS label", "example..com"),
+	)
+
+	It("should only check the per-site metrics", func() {
+		metricsContent := metricsWithHostname("example.com") +
+			"# TYPE probe_requests_total counter\nprobe_requests_total{hostname=\"example.com:443\"} 1\n"
+		Expect(AssertHostnameLabelsSane(metricsContent)).To(Succeed())
+	})
+
+	It("should list every malformed value once with the metrics it appears in", func() {
+		metricsContent := `# TYPE squid_site_requests_total counter
+squid_site_requests_total{hostname="b.example.com:80",port="80"} 1
+squid_site_requests_total{hostname="b.example.com:80",port="443"} 1
+squid_site_requests_total{hostname="a.example.com/x"} 1
+# TYPE squid_site_hits_total counter
+squid_site_hits_total{hostname="b.example.com:80"} 1
+`
+		Expect(AssertHostnameLabelsSane(metricsContent)).To(MatchError(
+			`malformed hostname label values: "a.example.com/x" (squid_site_requests_total); ` +
+				`"b.example.com:80" (squid_site_hits_total, squid_site_requests_total)`))
+	})
+
+	It("should return an error on unparsable metrics", func() {
+		Expect(AssertHostnameLabelsSane("squid_site_requests_total{hostname=")).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("hostnameValue", func() {
+	It("should return an error for unsupported metric types", func() {
+		metricFamilies, err := parse("# TYPE squid_site_response_time_seconds histogram\n" +
+			"squid_site_response_time_seconds_bucket{hostname=\"example.com\",le=\"+Inf\"} 1\n" +
+			"squid_site_response_time_seconds_sum{hostname=\"example.com\"} 0.5\n" +
+			"squid_site_response_time_seconds_count{hostname=\"example.com\"} 1\n")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = hostnameValue(metricFamilies, "squid_site_response_time_seconds", "example.com")
+		Expect(err).To(MatchError(ContainSubstring("unsupported type HISTOGRAM")))
+	})
+})
@@ -0,0 +1,13 @@
+package sitemetrics
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestSiteMetricsUnit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Site Metrics Unit Suite (package sitemetrics)")
+}
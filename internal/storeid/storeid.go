@@ -0,0 +1,91 @@
+// Package storeid computes the store-ids of the squid-store-id helper: which URLs identify
+// immutable content and the cache key they share once the origin has authorized them.
+// The functions only look at the URL, so store-ids can be compared without a live CDN.
+package storeid
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/konflux-ci/caching/internal/cdnpatterns"
+)
+
+// packageRegistryPatterns match immutable artifact URLs from language package registries.
+// These URLs don't contain SHA256 hashes in the path but are content-addressable by
+// construction, so their query parameters can be dropped from the store-id as well.
+var packageRegistryPatterns = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	// PyPI: /packages/<2 hex>/<2 hex>/<60 hex>/<file> where the path is the blake2b-256 digest of the file
+	{"pypi", regexp.MustCompile(`^https://files\.pythonhosted\.org/packages/[a-f0-9]{2}/[a-f0-9]{2}/[a-f0-9]{60}/[^/?]+(\?|$)`)},
+	// npm: /<package>/-/<package>-<version>.tgz, optionally scoped (/@<scope>/<package>/-/...)
+	{"npm", regexp.MustCompile(`^https://registry\.npmjs\.org/(@[^/?]+/)?[^/?]+/-/[^/?]+\.tgz(\?|$)`)},
+	// crates.io: /crates/<name>/<name>-<version>.crate
+	{"crates", regexp.MustCompile(`^https://static\.crates\.io/crates/[^/?]+/[^/?]+-[^/?]+\.crate(\?|$)`)},
+}
+
+// Options configures which URLs are content-addressable
+type Options struct {
+	// PackageRegistries also matches artifact URLs from PyPI, npm and crates.io.
+	// Disabled by default so container-only deployments are unaffected.
+	PackageRegistries bool
+}
+
+// NoPattern is the Pattern of URLs that are not content-addressable
+const NoPattern = "none"
+
+// Pattern returns the name of the pattern that makes requestURL content-addressable:
+// a CDN pattern name (see internal/cdnpatterns), "sha256" for other URLs with a SHA256 hash in
+// the path, or a package registry name ("pypi", "npm" or "crates"). It returns NoPattern for
+// URLs that are not content-addressable.
+func Pattern(requestURL string, options Options) string {
+	if name, ok := cdnpatterns.Match(requestURL); ok {
+		return name
+	}
+	if strings.Contains(requestURL, "/sha256/") {
+		return "sha256"
+	}
+	if options.PackageRegistries {
+		for _, registry := range packageRegistryPatterns {
+			if registry.pattern.MatchString(requestURL) {
+				return registry.name
+			}
+		}
+	}
+	return NoPattern
+}
+
+// IsContentAddressable returns true if requestURL identifies immutable content,
+// either by a SHA256 hash in the path, by a known registry CDN pattern or, when enabled,
+// by a package registry pattern
+func IsContentAddressable(requestURL string, options Options) bool {
+	return Pattern(requestURL, options) != NoPattern
+}
+
+// Key returns the cache key of an authorized content-addressable URL. URLs matching a
+// registry CDN pattern are keyed by registry and path ("quay:/quayio-production-s3/sha256/..."),
+// so registries whose buckets share a generic storage host and blob layout never collide, while
+// the CDN hosts of one registry share entries. Other URLs are keyed without query parameters.
+func Key(requestURL string) string {
+	storeID := strings.SplitN(requestURL, "?", 2)[0]
+	registry, ok := cdnpatterns.MatchRegistry(requestURL)
+	if !ok {
+		return storeID
+	}
+	u, err := url.Parse(storeID)
+	if err != nil {
+		return storeID
+	}
+	return registry + ":" + u.EscapedPath()
+}
+
+// Compute returns the store-id of requestURL assuming the origin authorizes it: the Key of
+// content-addressable URLs, and requestURL itself for all others
+func Compute(requestURL string, options Options) string {
+	if !IsContentAddressable(requestURL, options) {
+		return requestURL
+	}
+	return Key(requestURL)
+}
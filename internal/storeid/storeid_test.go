@@ -0,0 +1,59 @@
+package storeid
+
+import (
+	"github.com/konflux-ci/caching/internal/cdnpatterns"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pattern", func() {
+	DescribeTable("should name the pattern that makes a URL content-addressable",
+		func(requestURL string, options Options, expected string) {
+			Expect(Pattern(requestURL, options)).To(Equal(expected))
+			Expect(IsContentAddressable(requestURL, options)).To(Equal(expected != NoPattern))
+		},
+		Entry("CDN pattern", cdnpatterns.Patterns[0].Example, Options{}, cdnpatterns.Patterns[0].Name),
+		Entry("SHA256 path", "https://cdn.example.com/blobs/sha256/ab/abcdef", Options{}, "sha256"),
+		Entry("package registry", "https://registry.npmjs.org/left-pad/-/left-pad-1.3.0.tgz", Options{PackageRegistries: true}, "npm"),
+		Entry("disabled package registry", "https://registry.npmjs.org/left-pad/-/left-pad-1.3.0.tgz", Options{}, NoPattern),
+		Entry("other URL", "https://example.com/api/v1/data?version=2", Options{}, NoPattern),
+	)
+})
+
+var _ = Describe("Key", func() {
+	It("should key registry CDN URLs by registry and path", func() {
+		Expect(Key(cdnpatterns.Patterns[0].Example)).To(Equal("quay:/quayio-production-s3/sha256/ab/abababababababababababababababababababababababababababababababab"))
+	})
+
+	It("should drop the query of other URLs", func() {
+		Expect(Key("https://cdn.example.com/blobs/sha256/ab/abcdef?token=abc123")).To(Equal("https://cdn.example.com/blobs/sha256/ab/abcdef"))
+	})
+})
+
+var _ = Describe("Compute", func() {
+	const (
+		digestA = "3fa1c9e6b0d24d0c6a3c5e8f1b2d4a6c8e0f2a4b6c8d0e2f4a6b8c0d2e4f6a8b"
+		digestB = "9c2e4f6a8b0d2e4f6a8b3fa1c9e6b0d24d0c6a3c5e8f1b2d4a6c8e0f2a4b6c8d"
+	)
+
+	It("should collapse signed URLs for the same digest to one store-id", func() {
+		Expect(Compute("https://cdn01.quay.io/quayio-production-s3/sha256/3f/"+digestA+"?X-Amz-Signature=abc&X-Amz-Expires=600", Options{})).
+			To(Equal(Compute("https://cdn02.quay.io/quayio-production-s3/sha256/3f/"+digestA+"?X-Amz-Signature=def&X-Amz-Expires=300", Options{})))
+	})
+
+	It("should keep signed URLs for different digests apart", func() {
+		Expect(Compute("https://cdn01.quay.io/quayio-production-s3/sha256/3f/"+digestA+"?X-Amz-Signature=abc", Options{})).
+			NotTo(Equal(Compute("https://cdn01.quay.io/quayio-production-s3/sha256/9c/"+digestB+"?X-Amz-Signature=abc", Options{})))
+	})
+
+	It("should return URLs that are not content-addressable unchanged", func() {
+		const manifestURL = "https://quay.io/v2/konflux-ci/caching/manifests/latest?ns=quay.io"
+		Expect(Compute(manifestURL, Options{})).To(Equal(manifestURL))
+	})
+
+	It("should only normalize package registry URLs when enabled", func() {
+		const tarballURL = "https://registry.npmjs.org/left-pad/-/left-pad-1.3.0.tgz?cache=1"
+		Expect(Compute(tarballURL, Options{})).To(Equal(tarballURL))
+		Expect(Compute(tarballURL, Options{PackageRegistries: true})).To(Equal("https://registry.npmjs.org/left-pad/-/left-pad-1.3.0.tgz"))
+	})
+})
@@ -0,0 +1,13 @@
+package storeid
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestStoreIDUnit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Store-ID Unit Suite (package storeid)")
+}
@@ -92,6 +92,9 @@ func (Test) Unit() error {
 		"./cmd/squid-per-site-exporter",
 		"./cmd/squid-store-id",
 		"./cmd/icap-server",
+		"./internal/buildinfo",
+		"./internal/cdnpatterns",
+		"./tests/testhelpers/",
 		"./tests/helm/",
 	); err != nil {
 		return fmt.Errorf("unit tests failed: %w", err)
@@ -296,6 +299,10 @@ func (Build) Squid() error {
 		fmt.Println("📊 Coverage instrumentation enabled")
 		args = append(args, "--build-arg", "ENABLE_COVERAGE=true")
 	}
+	// Best effort: the build info defaults to "unknown" outside a git checkout
+	if commit, err := sh.Output("git", "rev-parse", "--short", "HEAD"); err == nil {
+		args = append(args, "--build-arg", "COMMIT="+commit)
+	}
 	args = append(args, "-f", squidContainerfile, ".")
 	err := sh.Run("podman", args...)
 	if err != nil {
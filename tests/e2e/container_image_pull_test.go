@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"regexp"
-	"time"
+	"strings"
 
 	"github.com/konflux-ci/caching/tests/testhelpers"
 	. "github.com/onsi/ginkgo/v2"
@@ -47,6 +47,57 @@ var _ = Describe("Container image pulls", Ordered, Serial, Label("external-deps"
 		Expect(err).NotTo(HaveOccurred(), "Failed to restore squid cache defaults")
 	})
 
+	It("should grow the on-disk cache by the bytes of the layers it fetched", func() {
+		const imageRef = "docker.io/library/alpine:3.19@sha256:13b7e62e8df80264dbb747995705a986aa530415763a6c58f84a3ca8af9a5bcd"
+
+		restConfig, err := testhelpers.GetRESTConfig()
+		Expect(err).NotTo(HaveOccurred(), "Failed to get REST config")
+		pods, err := testhelpers.GetPods(ctx, clientset, namespace, deploymentName)
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid pods")
+
+		// Any pod may serve the pull, so the usage is summed over all of them
+		totalUsage := func() (int64, error) {
+			var total int64
+			for _, pod := range pods {
+				usage, err := testhelpers.GetSquidCacheDiskUsage(ctx, clientset, restConfig, namespace, pod.Name)
+				if err != nil {
+					return 0, err
+				}
+				total += usage
+			}
+			return total, nil
+		}
+		before, err := totalUsage()
+		Expect(err).NotTo(HaveOccurred(), "Failed to measure the cache disk usage")
+
+		By("Pulling the image through the proxy")
+		transport, err := testhelpers.NewSquidPullTransport(ctx, clientset, namespace)
+		Expect(err).NotTo(HaveOccurred(), "Failed to create squid pull transport")
+		stats, err := testhelpers.PullContainerImageWithStats(&transport, imageRef)
+		Expect(err).NotTo(HaveOccurred(), "Failed to pull container image")
+
+		var fetchedBytes int64
+		for _, layer := range stats.Layers {
+			if !layer.CacheHit {
+				fetchedBytes += layer.Bytes
+			}
+		}
+		if fetchedBytes == 0 {
+			Skip("All layers were already cached, so the cache has nothing new to store")
+		}
+		fmt.Printf("DEBUG: Cache disk usage before the pull: %d bytes, fetched layer bytes: %d\n", before, fetchedBytes)
+
+		By("Waiting for the fetched layers to be written to the cache_dir")
+		// Squid swaps objects out to disk asynchronously, so the usage can lag behind the pull.
+		// Stored objects also carry their headers, so only most of the layer bytes are required.
+		Eventually(func(g Gomega) {
+			after, err := totalUsage()
+			g.Expect(err).NotTo(HaveOccurred(), "Failed to measure the cache disk usage")
+			g.Expect(after-before).To(BeNumerically(">=", fetchedBytes*9/10),
+				"Cache disk usage should grow by the fetched layer bytes (before %d, after %d)", before, after)
+		}, timeout, interval).Should(Succeed())
+	})
+
 	DescribeTable("should cache layers from quay CDNs",
 		pullAndVerifyQuayCDN,
 		Entry("quay.io", "quay.io/konflux-ci/caching/squid@sha256:497644fae8de47ed449126735b02d54fdc152ef22634e32f175186094c2d638e"),
@@ -80,47 +131,41 @@ var _ = Describe("Container image pulls", Ordered, Serial, Label("external-deps"
 func pullAndVerifyQuayCDN(imageRef string) {
 	pullAndVerifyContainerImageCDN(imageRef,
 		`(cdn(?:[0-9]{2})?\.quay\.io|quayio-production-s3\.s3[a-z0-9.-]*\.amazonaws\.com|s3\.[a-z0-9-]+\.amazonaws\.com/quayio-production-s3)`,
-		"Quay CDN")
+		"Quay CDN", "")
 }
 
 func pullAndVerifyDockerHubCDN(imageRef string) {
 	pullAndVerifyContainerImageCDN(imageRef,
 		`(docker-images-prod\.[a-f0-9]{32}\.r2\.cloudflarestorage\.com|production\.cloudflare\.docker\.com|production\.cloudfront\.docker\.com|docker-images-prod\.s3[a-z0-9.-]*\.amazonaws\.com)`,
-		"Docker Hub CDN")
+		"Docker Hub CDN", "")
 }
 
 func pullAndVerifyFedoraRegistry(imageRef string) {
 	pullAndVerifyContainerImageCDN(imageRef,
 		`cdn\.registry\.fedoraproject\.org`,
-		"Fedora Registry CDN")
+		"Fedora Registry CDN", `cdn\.registry\.fedoraproject\.org`)
 }
 
 func pullAndVerifyOpenShiftCICDN(imageRef string) {
 	pullAndVerifyContainerImageCDN(imageRef,
 		`[a-f0-9]{32}\.r2\.cloudflarestorage\.com/app-ci-image-registry`,
-		"OpenShift CI Registry CDN")
+		"OpenShift CI Registry CDN", `[a-f0-9]{32}\.r2\.cloudflarestorage\.com/app-ci-image-registry/`)
 }
 
 func pullAndVerifyNvcrCDN(imageRef string) {
 	pullAndVerifyContainerImageCDN(imageRef,
 		`layers\.nvcr\.io`,
-		"NVIDIA Container Registry CDN")
+		"NVIDIA Container Registry CDN", `layers\.nvcr\.io`)
 }
 
 // pullAndVerifyContainerImageCDN verifies that container image layers are cached from CDN hosts.
 // cdnRegexPattern should contain ONLY the CDN host pattern (e.g., "(cdn\.quay\.io|s3\.amazonaws\.com)").
 // The function will automatically build the full patterns with TCP_MISS and TCP_HIT prefixes.
-func pullAndVerifyContainerImageCDN(imageRef, cdnRegexPattern, cdnName string) {
-	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), namespace+"-ca-bundle", metav1.GetOptions{})
-	Expect(err).NotTo(HaveOccurred(), "Failed to get "+namespace+"-ca-bundle ConfigMap")
-
-	client, err := testhelpers.NewTrustedSquidCachingClient(
-		serviceName,
-		namespace,
-		[]byte(cm.Data["ca-bundle.crt"]),
-		[]byte(nil),
-	)
-	Expect(err).NotTo(HaveOccurred(), "Failed to create trusted squid caching client")
+// When hitHostPattern is set, a cache hit must also be logged for a URL served from that host
+// specifically (see testhelpers.AssertCacheHitForHost), e.g. to pin one backend of a multi-host CDN.
+func pullAndVerifyContainerImageCDN(imageRef, cdnRegexPattern, cdnName, hitHostPattern string) {
+	transport, err := testhelpers.NewSquidPullTransport(ctx, clientset, namespace)
+	Expect(err).NotTo(HaveOccurred(), "Failed to create squid pull transport")
 
 	statefulSet, err := clientset.AppsV1().StatefulSets(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
 	Expect(err).NotTo(HaveOccurred(), "Failed to get statefulset")
@@ -137,21 +182,38 @@ func pullAndVerifyContainerImageCDN(imageRef, cdnRegexPattern, cdnName string) {
 	// Pull (replicas + 1) times - pigeonhole principle guarantees at least one pod gets hit twice
 	for i := range maxAttempts {
 		fmt.Printf("🔍 DEBUG: Pull attempt %d/%d\n", i+1, maxAttempts)
-		err = testhelpers.PullContainerImage(&client.Transport, imageRef)
+		err = testhelpers.PullContainerImage(&transport, imageRef)
 		Expect(err).NotTo(HaveOccurred(), "Failed to pull container image")
 	}
 
-	// Wait a moment to ensure all requests are logged
-	time.Sleep(1 * time.Second)
-
-	By("Verifying CDN requests in squid logs")
-	// Collect logs from all pods and check for MISS and HIT patterns
-	var foundMiss, foundHit bool
 	// Build full patterns from the CDN host pattern
 	missPattern := fmt.Sprintf(`(?m)^.*TCP_MISS.*%s.*$`, cdnRegexPattern)
 	// TCP_HIT = direct cache hit, TCP_REFRESH_UNMODIFIED = cache hit after revalidation (304 Not Modified)
 	hitPattern := fmt.Sprintf(`(?m)^.*TCP_(HIT|REFRESH_UNMODIFIED).*%s.*$`, cdnRegexPattern)
 
+	By("Waiting for a CDN cache hit to be logged by any pod")
+	// The hit may be served by any pod, so follow the logs of all of them since the first pull,
+	// and stop following the other pods once one has logged the hit
+	hitRegexp := regexp.MustCompile(hitPattern)
+	hitLogged := make(chan string, len(pods))
+	streamCtx, stopStreams := context.WithCancel(ctx)
+	defer stopStreams()
+	for _, pod := range pods {
+		go func(podName string) {
+			defer GinkgoRecover()
+			if _, err := testhelpers.StreamPodLogsUntil(streamCtx, clientset, namespace, podName, squidContainerName, &beforeSequence, hitRegexp.MatchString, timeout); err == nil {
+				hitLogged <- podName
+			}
+		}(pod.Name)
+	}
+	Eventually(hitLogged, timeout).Should(Receive(), "A %s cache hit should be logged by a squid pod", cdnName)
+	stopStreams()
+
+	By("Verifying CDN requests in squid logs")
+	// Collect logs from all pods and check for MISS and HIT patterns
+	var foundMiss, foundHit bool
+	var testLogs strings.Builder
+
 	// First, check logs from our test sequence
 	for _, pod := range pods {
 		logs, err := testhelpers.GetPodLogsSince(ctx, clientset, namespace, pod.Name, squidContainerName, &beforeSequence)
@@ -166,6 +228,11 @@ func pullAndVerifyContainerImageCDN(imageRef, cdnRegexPattern, cdnName string) {
 
 		fmt.Printf("DEBUG: === Logs from pod %s (since test start) ===\n", pod.Name)
 		fmt.Printf("%s\n", logStr)
+		testLogs.WriteString(logStr)
+		testLogs.WriteString("\n")
+
+		// Registry token requests and signed CDN redirects carry credentials that must not be logged
+		testhelpers.AssertNoCredentialsInLogs(logStr)
 
 		// Check for MISS pattern
 		matched, err := regexp.MatchString(missPattern, logStr)
@@ -223,6 +290,10 @@ func pullAndVerifyContainerImageCDN(imageRef, cdnRegexPattern, cdnName string) {
 	//
 	// Expect(foundMiss).To(BeTrue(), "Should find TCP_MISS for %s in pod logs (proves content was fetched and cached, either in current test or earlier)", cdnName)
 	Expect(foundHit).To(BeTrue(), "Should find TCP_HIT for %s in pod logs (proves content was served from cache)", cdnName)
+	if hitHostPattern != "" {
+		Expect(testhelpers.AssertCacheHitForHost(testLogs.String(), hitHostPattern)).To(Succeed(),
+			"%s layers should be served from cache for host %s", cdnName, hitHostPattern)
+	}
 
 	fmt.Printf("DEBUG: Caching verification successful - found both TCP_MISS and TCP_HIT for %s!\n", cdnName)
 }
@@ -49,6 +49,12 @@ func getPodIP() (string, error) {
 // setupHTTPTestServerAndClient sets up an HTTP test server
 // Registers a cleanup function to automatically close the test server
 func setupHTTPTestServer(msg string) *testhelpers.CachingTestServer {
+	return setupHTTPTestServerWithOptions(msg, testhelpers.CachingTestServerOptions{})
+}
+
+// setupHTTPTestServerWithOptions sets up an HTTP test server whose responses are configured by options
+// Registers a cleanup function to automatically close the test server
+func setupHTTPTestServerWithOptions(msg string, options testhelpers.CachingTestServerOptions) *testhelpers.CachingTestServer {
 	// Get pod IP for test server
 	podIP, err := getPodIP()
 	Expect(err).NotTo(HaveOccurred(), "Failed to get pod IP")
@@ -62,7 +68,7 @@ func setupHTTPTestServer(msg string) *testhelpers.CachingTestServer {
 	}
 
 	// Create test server
-	server, err := testhelpers.NewCachingTestServer(msg, podIP, testPort)
+	server, err := testhelpers.NewCachingTestServerWithOptions(msg, podIP, testPort, options)
 	Expect(err).NotTo(HaveOccurred(), "Failed to create test server")
 	Expect(server).NotTo(BeNil())
 
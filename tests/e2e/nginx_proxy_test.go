@@ -31,7 +31,7 @@ var _ = Describe("Nginx Proxy Caching Tests", Label("nginx"), Ordered, Serial, f
 					SecretName: authSecretName,
 				},
 				Cache: &testhelpers.NginxCacheValues{
-					AllowList: []string{"^/content/"},
+					AllowList: testhelpers.NginxAllowListPatterns("^/content/"),
 				},
 			},
 		})
@@ -93,6 +93,29 @@ var _ = Describe("Nginx Proxy Caching Tests", Label("nginx"), Ordered, Serial, f
 			"Responses should differ because each request contacts the upstream")
 	})
 
+	It("should substitute the auth secret into the rendered nginx configuration", func() {
+		secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, authSecretName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred(), "Should get the auth secret")
+		authValue := string(secret.Data["authorization"])
+		Expect(authValue).NotTo(BeEmpty(), "Auth secret should have an authorization value")
+
+		restConfig, err := testhelpers.GetRESTConfig()
+		Expect(err).NotTo(HaveOccurred(), "Should get the REST config")
+		pods, err := testhelpers.GetNginxPods(ctx, clientset, namespace)
+		Expect(err).NotTo(HaveOccurred(), "Should get nginx pods")
+		Expect(pods).NotTo(BeEmpty())
+
+		for _, pod := range pods {
+			By(fmt.Sprintf("Reading the rendered nginx configuration of pod %s", pod.Name))
+			config, err := testhelpers.GetNginxRenderedConfig(ctx, clientset, restConfig, namespace, pod.Name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config).NotTo(ContainSubstring(testhelpers.NginxAuthHeaderPlaceholder),
+				"The auth header placeholder should be substituted in pod %s", pod.Name)
+			Expect(config).To(ContainSubstring(fmt.Sprintf("proxy_set_header Authorization %q;", authValue)),
+				"The auth secret value should be set as the Authorization header in pod %s", pod.Name)
+		}
+	})
+
 	It("should NOT cache requests to non-matching paths", func() {
 		reqURL := testhelpers.GetNginxURL() + "/not-cached"
 		resp, err := client.Get(reqURL)
@@ -4,6 +4,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/konflux-ci/caching/tests/testhelpers"
 	. "github.com/onsi/ginkgo/v2"
@@ -26,7 +27,8 @@ var _ = Describe("Nginx Redirect Interception Tests", Label("nginx"), Ordered, S
 					URL: backendURL,
 				},
 				Cache: &testhelpers.NginxCacheValues{
-					AllowList: []string{"^/redirect"},
+					AllowList:    testhelpers.NginxAllowListPatterns("^/redirect"),
+					BypassHeader: "X-Bypass-Cache",
 				},
 			},
 		})
@@ -76,8 +78,32 @@ var _ = Describe("Nginx Redirect Interception Tests", Label("nginx"), Ordered, S
 		Expect(resp2.Header.Get("X-Cache-Status")).To(Equal("HIT"),
 			"Second request should be a cache HIT")
 
-		Expect(body2).To(Equal(body1),
-			"Cached response should match original response")
+		testhelpers.ValidateCachedBodyMatches(resp1, resp2, body1, body2)
+	})
+
+	It("should bypass the cache when the bypass header is set", func() {
+		targetURL := backendURL + "/content/bypass-test"
+		reqURL := testhelpers.GetNginxURL() + "/redirect?url=" + url.QueryEscape(targetURL) + "&" + generateCacheBuster("bypass-test")
+
+		getCacheStatus := func(bypass bool) string {
+			req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+			Expect(err).NotTo(HaveOccurred())
+			if bypass {
+				req.Header.Set("X-Bypass-Cache", "1")
+			}
+			resp, err := client.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = io.Copy(io.Discard, resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			return resp.Header.Get("X-Cache-Status")
+		}
+
+		Expect(getCacheStatus(false)).To(Equal("MISS"), "First request should be a cache MISS")
+		Expect(getCacheStatus(false)).To(Equal("HIT"), "Second request should be a cache HIT")
+		Expect(getCacheStatus(true)).To(Equal("BYPASS"), "Request with the bypass header should skip the cache")
+		Expect(getCacheStatus(false)).To(Equal("HIT"), "Requests without the bypass header should still hit the cache")
 	})
 
 	It("should pass through 403 from upstream even when content is cached", func() {
@@ -110,4 +136,39 @@ var _ = Describe("Nginx Redirect Interception Tests", Label("nginx"), Ordered, S
 		Expect(resp3.StatusCode).To(Equal(http.StatusForbidden),
 			"Banned request should return 403, not cached 200")
 	})
+	It("should serve stale content when the redirect target fails", func() {
+		// The backend marks the content fresh for 1 second only
+		targetURL := backendURL + "/content/stale-test?max_age=1"
+		reqURL := testhelpers.GetNginxURL() + "/redirect?url=" + url.QueryEscape(targetURL) + "&" + generateCacheBuster("stale-test")
+
+		// First request - should succeed and cache
+		resp1, err := client.Get(reqURL)
+		Expect(err).NotTo(HaveOccurred())
+		body1, err := io.ReadAll(resp1.Body)
+		Expect(err).NotTo(HaveOccurred())
+		resp1.Body.Close()
+		Expect(resp1.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp1.Header.Get("X-Cache-Status")).To(Equal("MISS"),
+			"First request should be a cache MISS")
+
+		// Let the cached entry expire
+		time.Sleep(2 * time.Second)
+
+		// Second request - the redirect still succeeds but the redirect target returns 503
+		req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("X-Content-Response-Status", "503")
+
+		resp2, err := client.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		body2, err := io.ReadAll(resp2.Body)
+		Expect(err).NotTo(HaveOccurred())
+		resp2.Body.Close()
+		Expect(resp2.StatusCode).To(Equal(http.StatusOK),
+			"Failed redirect target should be masked by the stale cached response")
+		Expect(resp2.Header.Get("X-Cache-Status")).To(Equal("STALE"),
+			"Response should be served stale from cache")
+		Expect(body2).To(Equal(body1),
+			"Stale response should match the originally cached response")
+	})
 })
@@ -2,6 +2,7 @@ package e2e_test
 
 import (
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/konflux-ci/caching/tests/testhelpers"
@@ -38,3 +39,64 @@ var _ = Describe("Squid Access Logs", Ordered, func() {
 		})
 	})
 })
+
+var _ = Describe("Squid Access Log Filters", Ordered, Serial, func() {
+	accessLogFilters := []string{"/access-log-filtered/", "-i /FILTERED-PROBE$"}
+
+	var (
+		testServer *testhelpers.CachingTestServer
+		client     *http.Client
+	)
+
+	BeforeAll(func() {
+		err := testhelpers.ConfigureSquidWithHelm(ctx, clientset, testhelpers.SquidHelmValues{
+			AccessLogFilters: accessLogFilters,
+			ReplicaCount:     int(suiteReplicaCount),
+		})
+		Expect(err).NotTo(HaveOccurred(), "Failed to configure squid with access log filters")
+
+		DeferCleanup(func() {
+			err := testhelpers.ConfigureSquidWithHelm(ctx, clientset, testhelpers.SquidHelmValues{
+				ReplicaCount: int(suiteReplicaCount),
+			})
+			Expect(err).NotTo(HaveOccurred(), "Failed to restore squid access log defaults")
+		})
+	})
+
+	BeforeEach(func() {
+		testServer = setupHTTPTestServer("Access log filter test server")
+		client = setupHTTPTestClient()
+	})
+
+	It("should omit requests matching the configured filters from the access log", func() {
+		start := metav1.Now()
+		filteredURLs := []string{
+			testServer.URL + "/access-log-filtered/data?" + generateCacheBuster("access-log-filtered"),
+			testServer.URL + "/filtered-probe",
+		}
+		loggedURL := testServer.URL + "/access-log-kept?" + generateCacheBuster("access-log-kept")
+
+		for _, requestURL := range append(filteredURLs, loggedURL) {
+			By(fmt.Sprintf("Requesting %s", requestURL))
+			resp, _, err := testhelpers.MakeCachingRequest(client, requestURL)
+			Expect(err).NotTo(HaveOccurred(), "Request through squid should succeed")
+			resp.Body.Close()
+		}
+
+		pods, err := testhelpers.GetPods(ctx, clientset, namespace, deploymentName)
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid pods")
+
+		// Squid logs a transaction after it completes, so wait for the unfiltered request
+		var entries []testhelpers.SquidAccessLogEntry
+		Eventually(func(g Gomega) {
+			entries, err = testhelpers.CollectSquidAccessLogs(ctx, clientset, namespace, pods, &start)
+			g.Expect(err).NotTo(HaveOccurred(), "Failed to collect access logs")
+			// Squid strips the query from logged URLs
+			g.Expect(entries).To(ContainElement(HaveField("URL", HavePrefix(testServer.URL+"/access-log-kept"))),
+				"Unfiltered request should be logged")
+		}, timeout, interval).Should(Succeed())
+
+		By("Verifying that requests matching the filters were not logged")
+		testhelpers.AssertAccessLogFiltered(entries, accessLogFilters)
+	})
+})
@@ -137,4 +137,47 @@ var _ = Describe("Cache allow list tests", Ordered, Serial, func() {
 			Expect(cacheHitResult).To(BeNil(), "Should not find a cache hit from any pod")
 		})
 	})
+
+	Context("When cache.denyList excludes URLs under an allowed prefix", func() {
+		BeforeAll(func() {
+			err := testhelpers.ConfigureSquidWithHelm(ctx, clientset, testhelpers.SquidHelmValues{
+				Cache: &testhelpers.CacheValues{
+					AllowList: []string{"^http://.*/do-cache.*"},
+					DenyList:  []string{"^http://.*/do-cache/latest.*"},
+				},
+				ReplicaCount: int(suiteReplicaCount),
+			})
+			Expect(err).NotTo(HaveOccurred(), "Failed to configure squid with cache allow and deny lists")
+
+			DeferCleanup(func() {
+				err := testhelpers.ConfigureSquidWithHelm(ctx, clientset, testhelpers.SquidHelmValues{
+					ReplicaCount: int(suiteReplicaCount),
+				})
+				Expect(err).NotTo(HaveOccurred(), "Failed to restore squid cache defaults")
+			})
+		})
+
+		It("should still cache allowed URLs outside the denyList", func() {
+			allowedURL := testServer.URL + "/do-cache/v1?" + generateCacheBuster("allowed-outside-deny-list")
+
+			cacheHitResult, err := testhelpers.FindCacheHitFromAnyPod(client, allowedURL, *statefulSet.Spec.Replicas)
+			Expect(err).NotTo(HaveOccurred(), "Should find a cache hit from any pod")
+			Expect(cacheHitResult.CacheHitFound).To(BeTrue(), "Should find a cache hit from any pod")
+		})
+
+		It("should NOT cache denylisted URLs under an allowed prefix", func() {
+			deniedURL := testServer.URL + "/do-cache/latest?" + generateCacheBuster("deny-list")
+			By(fmt.Sprintf("Testing URL: %s", deniedURL))
+
+			initialServerHits := testServer.GetRequestCount()
+			cacheHitResult, err := testhelpers.FindCacheHitFromAnyPod(client, deniedURL, *statefulSet.Spec.Replicas)
+			Expect(err).To(HaveOccurred(), "Denylisted URL should never be served from cache")
+			Expect(err.Error()).To(ContainSubstring(fmt.Sprintf("no cache hit found from any pod within %d attempts", *statefulSet.Spec.Replicas+1)), "Should not find a cache hit from any pod")
+			Expect(cacheHitResult).To(BeNil(), "Should not find a cache hit from any pod")
+
+			By("Verifying every request reached the server")
+			Expect(testServer.GetRequestCount()-initialServerHits).To(Equal(*statefulSet.Spec.Replicas+1),
+				"Server should have received every request for the denylisted URL")
+		})
+	})
 })
@@ -0,0 +1,44 @@
+package e2e_test
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Squid Cacheability", func() {
+	DescribeTable("should follow the response's caching directives",
+		func(responseHeaders, requestHeaders http.Header, expectCached bool) {
+			testServer := setupHTTPTestServerWithOptions("Cacheability test server",
+				testhelpers.CachingTestServerOptions{Headers: responseHeaders})
+			client := setupHTTPTestClient()
+			requestURL := testServer.URL + "/cacheability?" + generateCacheBuster("cacheability")
+
+			By(fmt.Sprintf("Requesting %s repeatedly with response headers %v", requestURL, responseHeaders))
+			result, err := testhelpers.CheckCacheability(client, testServer, requestURL, requestHeaders, suiteReplicaCount)
+			Expect(err).NotTo(HaveOccurred(), "Requests through squid should succeed")
+			fmt.Printf("🔍 DEBUG: Cacheability result: %+v\n", *result)
+
+			Expect(result.Cached).To(Equal(expectCached), "Unexpected caching decision")
+			if expectCached {
+				Expect(result.OriginRequests).To(BeNumerically("<", result.Requests), "Cache hits should not reach the origin")
+			} else {
+				Expect(result.OriginRequests).To(BeEquivalentTo(result.Requests), "Every request should reach the origin")
+			}
+		},
+		Entry("public max-age", nil, nil, true),
+		Entry("no-store", http.Header{"Cache-Control": {"no-store"}}, nil, false),
+		Entry("private", http.Header{"Cache-Control": {"private, max-age=300"}}, nil, false),
+		Entry("Vary on a request header that does not change",
+			http.Header{"Vary": {"Accept-Encoding"}}, nil, true),
+		Entry("Vary: *", http.Header{"Vary": {"*"}}, nil, false),
+		Entry("Set-Cookie", http.Header{"Set-Cookie": {"session=abc; Path=/"}}, nil, true),
+		Entry("Authorization-bearing request without public",
+			http.Header{"Cache-Control": {"max-age=300"}}, http.Header{"Authorization": {"Bearer e2e-token"}}, false),
+		Entry("Authorization-bearing request with public",
+			nil, http.Header{"Authorization": {"Bearer e2e-token"}}, true),
+	)
+})
@@ -0,0 +1,46 @@
+package e2e_test
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Squid Collapsed Forwarding", Ordered, Serial, func() {
+	var (
+		testServer *testhelpers.CachingTestServer
+		client     *http.Client
+	)
+
+	BeforeAll(func() {
+		err := testhelpers.ConfigureSquidWithHelm(ctx, clientset, testhelpers.SquidHelmValues{
+			CollapsedForwarding: true,
+			ReplicaCount:        int(suiteReplicaCount),
+		})
+		Expect(err).NotTo(HaveOccurred(), "Failed to configure squid with collapsed forwarding")
+
+		DeferCleanup(func() {
+			err := testhelpers.ConfigureSquidWithHelm(ctx, clientset, testhelpers.SquidHelmValues{
+				ReplicaCount: int(suiteReplicaCount),
+			})
+			Expect(err).NotTo(HaveOccurred(), "Failed to restore squid defaults")
+		})
+	})
+
+	BeforeEach(func() {
+		testServer = setupHTTPTestServer("Collapsed forwarding test server")
+		client = setupHTTPTestClient()
+	})
+
+	It("should fetch a URL requested concurrently from the origin at most once per pod", func() {
+		const concurrentRequests = 20
+		requestURL := testServer.URL + "/collapsed?" + generateCacheBuster("collapsed-forwarding")
+
+		By(fmt.Sprintf("Requesting %s from %d concurrent clients", requestURL, concurrentRequests))
+		testhelpers.AssertCollapsedForwarding(client, testServer, requestURL, concurrentRequests, suiteReplicaCount, 3*time.Second)
+	})
+})
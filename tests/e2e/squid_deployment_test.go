@@ -79,7 +79,9 @@ var _ = Describe("Squid Helm Chart StatefulSet", func() {
 			Expect(*statefulSet.Spec.Replicas).To(BeNumerically(">=", 1))
 
 			// Check selector and labels
-			Expect(statefulSet.Spec.Selector.MatchLabels).To(HaveKeyWithValue("app.kubernetes.io/name", deploymentName))
+			testhelpers.AssertStandardLabels(statefulSet, deploymentName, testhelpers.SquidComponentLabel)
+			testhelpers.AssertSelectorLabels(statefulSet.Spec.Selector.MatchLabels, deploymentName, testhelpers.SquidComponentLabel, "statefulset selector")
+			testhelpers.AssertSelectorLabels(statefulSet.Spec.Template.Labels, deploymentName, testhelpers.SquidComponentLabel, "pod template")
 		})
 
 		It("should be ready and available", func() {
@@ -161,11 +163,8 @@ var _ = Describe("Squid Helm Chart StatefulSet", func() {
 			Expect(rule.PodAffinityTerm.TopologyKey).To(Equal("kubernetes.io/hostname"), "Should spread by hostname")
 
 			// Verify label selector targets correct pods
-			labels := rule.PodAffinityTerm.LabelSelector.MatchLabels
-			Expect(labels).To(HaveKeyWithValue("app.kubernetes.io/name", deploymentName))
-			Expect(labels).To(HaveKeyWithValue("app.kubernetes.io/component", testhelpers.SquidComponentLabel))
-			// Note: instance label will be "squid" in actual statefulset vs "test-release" in template tests
-			Expect(labels).To(HaveKey("app.kubernetes.io/instance"))
+			testhelpers.AssertSelectorLabels(rule.PodAffinityTerm.LabelSelector.MatchLabels,
+				deploymentName, testhelpers.SquidComponentLabel, "anti-affinity selector")
 		})
 
 		It("should successfully schedule multiple replicas despite anti-affinity on single node", func() {
@@ -217,7 +216,8 @@ var _ = Describe("Squid Helm Chart StatefulSet", func() {
 
 			// Check service type and selector
 			Expect(service.Spec.Type).To(Equal(corev1.ServiceTypeClusterIP))
-			Expect(service.Spec.Selector).To(HaveKeyWithValue("app.kubernetes.io/name", deploymentName))
+			testhelpers.AssertStandardLabels(service, deploymentName, testhelpers.SquidComponentLabel)
+			testhelpers.AssertSelectorLabels(service.Spec.Selector, deploymentName, testhelpers.SquidComponentLabel, "service selector")
 		})
 
 		It("should have the correct port configuration", func() {
@@ -406,6 +406,60 @@ var _ = Describe("Squid Helm Chart StatefulSet", func() {
 				"Server should have received one request per unique pod")
 		})
 
+		It("should cache HTTP responses independently on every pod", func() {
+			testURL := testServer.URL + "?" + generateCacheBuster("cache-all-pods")
+
+			statefulSet, err := clientset.AppsV1().StatefulSets(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred(), "Should get squid statefulset")
+
+			initialServerHits := testServer.GetRequestCount()
+			testhelpers.AssertAllPodsCache(ctx, clientset, namespace, testURL, *statefulSet.Spec.Replicas)
+
+			Expect(testServer.GetRequestCount()).To(Equal(initialServerHits+*statefulSet.Spec.Replicas),
+				"Server should have received exactly one request per pod")
+		})
+
+		It("should serve concurrent requests for cached URLs without errors or origin requests", func() {
+			statefulSet, err := clientset.AppsV1().StatefulSets(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred(), "Should get squid statefulset")
+
+			urls := []string{
+				testServer.URL + "?" + generateCacheBuster("concurrent-load-a"),
+				testServer.URL + "?" + generateCacheBuster("concurrent-load-b"),
+			}
+			By("Caching the URLs on every pod")
+			for _, testURL := range urls {
+				testhelpers.AssertAllPodsCache(ctx, clientset, namespace, testURL, *statefulSet.Spec.Replicas)
+			}
+
+			const workers, iterations = 10, 5
+			serverHits := testServer.GetRequestCount()
+			By(fmt.Sprintf("Requesting the URLs from %d concurrent workers", workers))
+			result, err := testhelpers.RunConcurrentProxyLoad(client, urls, workers, iterations)
+			Expect(err).NotTo(HaveOccurred())
+			fmt.Printf("🔍 DEBUG: Statuses: %v, requests served per pod: %v\n", result.Statuses, result.Pods)
+
+			Expect(result.Errors).To(BeEmpty(), "No request should fail under contention")
+			Expect(result.Statuses).To(Equal(map[int]int{http.StatusOK: workers * iterations}))
+			Expect(testServer.GetRequestCount()).To(Equal(serverHits),
+				"Every concurrent request should be served from the cache")
+		})
+
+		It("should spread new connections across replicas", func() {
+			statefulSet, err := clientset.AppsV1().StatefulSets(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred(), "Should get squid statefulset")
+			replicaCount := *statefulSet.Spec.Replicas
+			if replicaCount < 2 {
+				Skip(fmt.Sprintf("Skipping test: load distribution needs at least 2 replicas, got %d", replicaCount))
+			}
+
+			testURL := testServer.URL + "?" + generateCacheBuster("load-distribution")
+			servedBy, err := testhelpers.CountDistinctServingPods(client, testURL, 10*int(replicaCount))
+			Expect(err).NotTo(HaveOccurred(), "Requests through squid should succeed")
+			fmt.Printf("🔍 DEBUG: Requests served per pod: %v\n", servedBy)
+			Expect(len(servedBy)).To(BeNumerically(">", 1), "More than one squid pod should serve traffic")
+		})
+
 		Describe("Caching Verification", func() {
 			It("should verify configuration is set for disk caching", func() {
 				configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, deploymentName+"-config", metav1.GetOptions{})
@@ -417,7 +471,12 @@ var _ = Describe("Squid Helm Chart StatefulSet", func() {
 				Expect(squidConf).To(ContainSubstring("cache_mem 0"), "Should set cache_mem to 0")
 				// Verify disk cache directory is configured
 				// Cache size is 1024MB (1GB), cache_dir is 80% = 819MB
-				Expect(squidConf).To(ContainSubstring("cache_dir aufs /var/spool/squid/cache 819 16 256"), "Should have disk cache configured")
+				cacheDir, err := testhelpers.ParseSquidDirective(squidConf, "cache_dir")
+				Expect(err).NotTo(HaveOccurred(), "Should have disk cache configured")
+				Expect(cacheDir).To(HaveLen(5))
+				Expect(cacheDir[0]).To(Equal("aufs"), "Should use the aufs store")
+				Expect(cacheDir[1]).To(Equal("/var/spool/squid/cache"))
+				Expect(cacheDir[2]).To(Equal("819"), "cache_dir should use 80% of the 1024MB volume")
 				// Verify maximum object size is configured
 				Expect(squidConf).To(ContainSubstring("maximum_object_size 192 MB"), "Should have maximum object size configured")
 				// Verify cache replacement policy
@@ -0,0 +1,86 @@
+package e2e_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Squid Header Access", Ordered, Serial, func() {
+	var (
+		testServer *testhelpers.CachingTestServer
+		client     *http.Client
+	)
+
+	BeforeAll(func() {
+		err := testhelpers.ConfigureSquidWithHelm(ctx, clientset, testhelpers.SquidHelmValues{
+			HeaderAccess: &testhelpers.HeaderAccessValues{
+				Request: []string{"Cookie"},
+				Reply:   []string{"Set-Cookie"},
+			},
+			ReplicaCount: int(suiteReplicaCount),
+		})
+		Expect(err).NotTo(HaveOccurred(), "Failed to configure squid with header access rules")
+
+		DeferCleanup(func() {
+			err := testhelpers.ConfigureSquidWithHelm(ctx, clientset, testhelpers.SquidHelmValues{
+				ReplicaCount: int(suiteReplicaCount),
+			})
+			Expect(err).NotTo(HaveOccurred(), "Failed to restore squid defaults")
+		})
+	})
+
+	BeforeEach(func() {
+		testServer = setupHTTPTestServerWithOptions("Header access test server", testhelpers.CachingTestServerOptions{
+			Headers: http.Header{"Set-Cookie": {"session=e2e; Path=/"}},
+		})
+		client = setupHTTPTestClient()
+	})
+
+	It("should remove the configured request and reply headers", func() {
+		requestURL := testServer.URL + "/header-access?" + generateCacheBuster("header-access")
+		req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("Cookie", "session=client")
+		req.Header.Set("X-Kept", "kept")
+
+		By(fmt.Sprintf("Requesting %s with a Cookie header", requestURL))
+		resp, err := client.Do(req)
+		Expect(err).NotTo(HaveOccurred(), "Request through squid should succeed")
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		Expect(resp.Header.Values("Set-Cookie")).To(BeEmpty(), "Squid should remove Set-Cookie from the reply")
+		response, err := testhelpers.ParseTestServerResponse(body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.Headers.Values("Cookie")).To(BeEmpty(), "Squid should not forward the Cookie header to the origin")
+		Expect(response.Headers.Get("X-Kept")).To(Equal("kept"), "Other request headers should be forwarded")
+	})
+
+	It("should not serve Set-Cookie on cache hits", func() {
+		requestURL := testServer.URL + "/header-access-cached?" + generateCacheBuster("header-access-cached")
+
+		// With N pods, N+1 requests reach at least one pod twice
+		var hit *http.Response
+		for i := range int(suiteReplicaCount) + 1 {
+			By(fmt.Sprintf("Request %d for %s", i+1, requestURL))
+			resp, _, err := testhelpers.MakeCachingRequest(client, requestURL)
+			Expect(err).NotTo(HaveOccurred(), "Request through squid should succeed")
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			if testhelpers.IsSquidCacheHit(resp) {
+				hit = resp
+				break
+			}
+		}
+
+		Expect(hit).NotTo(BeNil(), "One of the requests should be served from the cache")
+		Expect(hit.Header.Values("Set-Cookie")).To(BeEmpty(), "Squid should remove Set-Cookie from cache hits")
+	})
+})
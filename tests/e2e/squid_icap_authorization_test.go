@@ -0,0 +1,93 @@
+package e2e_test
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ICAP Authorization header removal", Ordered, Serial, func() {
+	const token = "e2e-icap-secret-token"
+
+	var (
+		testServer *testhelpers.CachingTestServer
+		client     *http.Client
+	)
+
+	BeforeAll(func() {
+		// ICAP is only invoked for URLs matching cache.allowList
+		err := testhelpers.ConfigureSquidWithHelm(ctx, clientset, testhelpers.SquidHelmValues{
+			Cache: &testhelpers.CacheValues{
+				AllowList: []string{"^http://.*/icap-auth/.*"},
+			},
+			ReplicaCount: int(suiteReplicaCount),
+		})
+		Expect(err).NotTo(HaveOccurred(), "Failed to configure squid with cache allow list")
+
+		DeferCleanup(func() {
+			err := testhelpers.ConfigureSquidWithHelm(ctx, clientset, testhelpers.SquidHelmValues{
+				ReplicaCount: int(suiteReplicaCount),
+			})
+			Expect(err).NotTo(HaveOccurred(), "Failed to restore squid cache defaults")
+		})
+	})
+
+	BeforeEach(func() {
+		testServer = setupHTTPTestServer("ICAP authorization test server")
+		client = setupHTTPTestClient()
+	})
+
+	It("should not forward the Authorization header for content-addressable URLs", func() {
+		blobURL := fmt.Sprintf("%s/icap-auth/v2/test/blobs/sha256/ab/%s?%s",
+			testServer.URL, strings.Repeat("ab", 32), generateCacheBuster("icap-auth-blob"))
+
+		By(fmt.Sprintf("Requesting %s with a bearer token", blobURL))
+		response, err := testhelpers.MakeAuthorizedCachingRequest(client, blobURL, token)
+		Expect(err).NotTo(HaveOccurred(), "Request through squid should succeed")
+
+		By("Verifying the origin did not receive the Authorization header")
+		testhelpers.ValidateAuthorizationStripped(response)
+	})
+
+	It("should forward the Authorization header for other allowed URLs", func() {
+		manifestURL := fmt.Sprintf("%s/icap-auth/v2/test/manifests/latest?%s",
+			testServer.URL, generateCacheBuster("icap-auth-manifest"))
+
+		By(fmt.Sprintf("Requesting %s with a bearer token", manifestURL))
+		response, err := testhelpers.MakeAuthorizedCachingRequest(client, manifestURL, token)
+		Expect(err).NotTo(HaveOccurred(), "Request through squid should succeed")
+
+		By("Verifying the origin received the Authorization header")
+		testhelpers.ValidateAuthorizationForwarded(response, token)
+	})
+
+	It("should advertise REQMOD with 204 support and a stable ISTag in OPTIONS", func() {
+		pods, err := testhelpers.GetPods(ctx, clientset, namespace, deploymentName)
+		Expect(err).NotTo(HaveOccurred(), "Should get squid pods")
+		Expect(pods).NotTo(BeEmpty())
+
+		var istag string
+		for _, pod := range pods {
+			addr := net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(testhelpers.ICAPPort))
+			for i := range 2 {
+				By(fmt.Sprintf("Probing ICAP OPTIONS on pod %s (probe %d)", pod.Name, i+1))
+				options, err := testhelpers.ProbeICAPOptions(addr, testhelpers.ICAPServiceName)
+				Expect(err).NotTo(HaveOccurred(), "ICAP OPTIONS on pod %s should succeed", pod.Name)
+				Expect(options.StatusCode).To(Equal(http.StatusOK), "ICAP OPTIONS status: %s", options.Status)
+				Expect(options.SupportsMethod("REQMOD")).To(BeTrue(), "Methods should include REQMOD, got %v", options.Methods)
+				Expect(options.Allows("204")).To(BeTrue(), "Allow should include 204, got %v", options.Allow)
+				Expect(options.ISTag).NotTo(BeEmpty(), "ISTag should be set")
+				if istag == "" {
+					istag = options.ISTag
+				}
+				Expect(options.ISTag).To(Equal(istag), "ISTag should be stable across probes and pods")
+			}
+		}
+	})
+})
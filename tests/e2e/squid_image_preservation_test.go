@@ -0,0 +1,27 @@
+package e2e_test
+
+import (
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+)
+
+var _ = Describe("Squid image preservation", Ordered, Serial, func() {
+	It("should keep the deployed squid image across consecutive helm upgrades", func() {
+		By("Reconfiguring squid with a cache allow list")
+		testhelpers.AssertImagePreservedAcrossUpgrade(ctx, clientset, namespace, func() error {
+			return testhelpers.ConfigureSquidWithHelm(ctx, clientset, testhelpers.SquidHelmValues{
+				Cache: &testhelpers.CacheValues{
+					AllowList: []string{"^http://.*/image-preservation.*"},
+				},
+				ReplicaCount: int(suiteReplicaCount),
+			})
+		})
+
+		By("Restoring the squid defaults")
+		testhelpers.AssertImagePreservedAcrossUpgrade(ctx, clientset, namespace, func() error {
+			return testhelpers.ConfigureSquidWithHelm(ctx, clientset, testhelpers.SquidHelmValues{
+				ReplicaCount: int(suiteReplicaCount),
+			})
+		})
+	})
+})
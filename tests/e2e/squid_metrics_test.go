@@ -334,60 +334,9 @@ var _ = Describe("Squid Caching Metrics Integration", func() {
 			body, err := io.ReadAll(resp.Body)
 			Expect(err).NotTo(HaveOccurred(), "Should read metrics body")
 
-			metricsContent := string(body)
-
-			// Validate basic Prometheus format requirements
-			lines := strings.Split(metricsContent, "\n")
-
-			for _, line := range lines {
-				line = strings.TrimSpace(line)
-				if line == "" {
-					continue
-				}
-
-				// Check HELP comments
-				if strings.HasPrefix(line, "# HELP ") {
-					parts := strings.SplitN(line, " ", 4)
-					Expect(len(parts)).To(BeNumerically(">=", 4), "HELP comments should have correct format")
-					continue
-				}
-
-				// Check TYPE comments
-				if strings.HasPrefix(line, "# TYPE ") {
-					parts := strings.SplitN(line, " ", 4)
-					Expect(len(parts)).To(Equal(4), "TYPE comments should have correct format")
-					validTypes := []string{"counter", "gauge", "histogram", "summary", "untyped"}
-					Expect(validTypes).To(ContainElement(parts[3]), "TYPE should be valid Prometheus type")
-					continue
-				}
-
-				// Skip other comments
-				if strings.HasPrefix(line, "#") {
-					continue
-				}
-
-				// Validate metric lines
-				if strings.Contains(line, " ") {
-					// Find the last space to correctly handle labels with spaces
-					lastSpaceIndex := strings.LastIndex(line, " ")
-					metricNameWithLabels := line[:lastSpaceIndex]
-					valueStr := strings.TrimSpace(line[lastSpaceIndex+1:])
-
-					// Extract just the metric name (before any labels)
-					metricName := metricNameWithLabels
-					if bracketIndex := strings.Index(metricNameWithLabels, "{"); bracketIndex > 0 {
-						metricName = metricNameWithLabels[:bracketIndex]
-					}
-
-					// Basic metric name validation (should start with letter, contain only valid chars)
-					Expect(metricName).To(MatchRegexp(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`),
-						fmt.Sprintf("Metric name '%s' should follow Prometheus naming conventions", metricName))
-
-					// Value should be a valid number
-					_, err := strconv.ParseFloat(valueStr, 64)
-					Expect(err).NotTo(HaveOccurred(), "Metric value should be a valid number")
-				}
-			}
+			Expect(testhelpers.ValidatePrometheusExposition(string(body),
+				testhelpers.CheckDuplicateNames, testhelpers.CheckConsistentTypes)).To(Succeed(),
+				"Metrics should be valid Prometheus text exposition")
 		})
 
 		It("should include required metric metadata", func() {
@@ -0,0 +1,38 @@
+package e2e_test
+
+import (
+	"fmt"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Squid Maximum Object Size", func() {
+	// Default cache.maxObjectSize. Squid's MB are MiB, so a body of 200,000,000 bytes is
+	// still under the limit.
+	const maxObjectSize = 192 << 20
+
+	DescribeTable("should only cache objects up to cache.maxObjectSize",
+		func(size int64, expectCached bool) {
+			testServer := setupHTTPTestServerWithOptions("Object size test server",
+				testhelpers.CachingTestServerOptions{MaxResponseSize: 2 * maxObjectSize})
+			client := setupHTTPTestClient()
+			requestURL := fmt.Sprintf("%s/object-size?size=%d&%s", testServer.URL, size, generateCacheBuster("object-size"))
+
+			By(fmt.Sprintf("Requesting a %d byte object repeatedly", size))
+			result, err := testhelpers.CheckCacheability(client, testServer, requestURL, nil, suiteReplicaCount)
+			Expect(err).NotTo(HaveOccurred(), "Requests through squid should succeed")
+			fmt.Printf("🔍 DEBUG: Object size result: %+v\n", *result)
+
+			Expect(result.Cached).To(Equal(expectCached), "Unexpected caching decision")
+			if expectCached {
+				Expect(result.OriginRequests).To(BeNumerically("<", result.Requests), "Cache hits should not reach the origin")
+			} else {
+				Expect(result.OriginRequests).To(BeEquivalentTo(result.Requests), "Every request should reach the origin")
+			}
+		},
+		Entry("small object", int64(100<<10), true),
+		Entry("object over the limit", int64(maxObjectSize+8<<20), false),
+	)
+})
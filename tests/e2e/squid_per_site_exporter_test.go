@@ -221,10 +221,9 @@ var _ = Describe("Squid Per-Site Exporter Integration", func() {
 			testHostname := strings.Split(strings.TrimPrefix(testServer.URL, "http://"), ":")[0]
 			testURL := testServer.URL + "?" + generateCacheBuster("per-site-metrics-test")
 
-			// Get baseline aggregated metrics from all pods
-			baselineRequests, err := testhelpers.GetAggregatedMetrics(ctx, clientset, metricsClient, namespace, *statefulSet.Spec.Replicas, "squid_site_requests_total", testHostname)
-			Expect(err).NotTo(HaveOccurred(), "Failed to get aggregated metrics")
-			fmt.Printf("DEBUG: Baseline aggregated requests: %.0f\n", baselineRequests)
+			// Snapshot metrics from all pods before generating traffic
+			before, err := testhelpers.ScrapeAllPodMetrics(ctx, clientset, metricsClient, namespace)
+			Expect(err).NotTo(HaveOccurred(), "Failed to scrape baseline metrics")
 
 			By("Making HTTP requests through the proxy")
 			for i := 0; i < 3; i++ {
@@ -238,16 +237,65 @@ var _ = Describe("Squid Per-Site Exporter Integration", func() {
 			}
 			time.Sleep(5 * time.Second)
 
-			Eventually(func() bool {
-				currentRequests, err := testhelpers.GetAggregatedMetrics(ctx, clientset, metricsClient, namespace, *statefulSet.Spec.Replicas, "squid_site_requests_total", testHostname)
+			hostnameKey := fmt.Sprintf("hostname=%q", testHostname)
+			Eventually(func(g Gomega) {
+				after, stats, err := testhelpers.ScrapeAllPodMetricsWithStats(ctx, clientset, metricsClient, namespace)
+				g.Expect(err).NotTo(HaveOccurred(), "Failed to scrape metrics; scrape durations: %s", stats)
+				deltas := testhelpers.DiffMetricSnapshots(before, after, "squid_site_requests_total")
+				fmt.Printf("DEBUG: squid_site_requests_total deltas: %v\n", deltas)
+				g.Expect(deltas[hostnameKey]).To(BeNumerically(">=", 3),
+					"Per-site request metrics delta should reflect generated proxy traffic; scrape durations: %s", stats)
+			}, timeout*2, interval).Should(Succeed())
+		})
+
+		It("should count each proxied request exactly once", func() {
+			testHostname := strings.Split(strings.TrimPrefix(testServer.URL, "http://"), ":")[0]
+			testURL := testServer.URL + "?" + generateCacheBuster("per-site-rate-test")
+			hostnameKey := fmt.Sprintf("hostname=%q", testHostname)
+			const requests = 5
+			const sampleInterval = 2 * time.Second
+
+			scrape := func() (float64, error) {
+				snapshot, err := testhelpers.ScrapeAllPodMetrics(ctx, clientset, metricsClient, namespace)
 				if err != nil {
-					fmt.Printf("DEBUG: Error getting aggregated metrics: %v\n", err)
-					return false
+					return 0, err
 				}
-				delta := currentRequests - baselineRequests
-				fmt.Printf("DEBUG: Current aggregated requests: %.0f, Baseline: %.0f, Delta: %.0f\n", currentRequests, baselineRequests, delta)
-				return delta >= 3
-			}, timeout*2, interval).Should(BeTrue(), "Per-site request metrics delta should reflect generated proxy traffic (>= 3)")
+				return testhelpers.DiffMetricSnapshots(nil, snapshot, "squid_site_requests_total")[hostnameKey], nil
+			}
+
+			baseline, err := scrape()
+			Expect(err).NotTo(HaveOccurred(), "Failed to scrape baseline metrics")
+
+			By(fmt.Sprintf("Sending one request per %s while sampling the request counter", sampleInterval))
+			done := make(chan error, 1)
+			go func() {
+				defer GinkgoRecover()
+				for i := range requests {
+					resp, _, err := testhelpers.MakeCachingRequest(client, testURL+fmt.Sprintf("&req=%d", i))
+					if err != nil {
+						done <- fmt.Errorf("request %d failed: %w", i, err)
+						return
+					}
+					resp.Body.Close()
+					time.Sleep(sampleInterval)
+				}
+				done <- nil
+			}()
+
+			// Keep sampling after the last request so the access log of every request is parsed
+			samples, err := testhelpers.SampleCounterOverTime(scrape, requests+4, sampleInterval)
+			Expect(err).NotTo(HaveOccurred(), "Failed to sample the request counter")
+			Expect(<-done).To(Succeed(), "All requests should succeed")
+			fmt.Printf("DEBUG: squid_site_requests_total baseline: %v, samples: %v\n", baseline, samples)
+
+			deltas, err := testhelpers.CounterDeltas(append([]float64{baseline}, samples...))
+			Expect(err).NotTo(HaveOccurred(), "Request counter should not be reset while sampling")
+			var total float64
+			for _, delta := range deltas {
+				Expect(delta).To(BeNumerically("<=", 2), "At most one request per interval (plus one delayed log line) should be counted: %v", deltas)
+				total += delta
+			}
+			Expect(total).To(Equal(float64(requests)), "Each request should be counted exactly once: %v", deltas)
 		})
 
 		It("should expose bandwidth metrics per site", func() {
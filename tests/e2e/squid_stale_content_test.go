@@ -0,0 +1,29 @@
+package e2e_test
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Squid Stale Content", func() {
+	It("should serve an expired cached object after the origin went away", func() {
+		testServer := setupHTTPTestServerWithOptions("Stale content test server", testhelpers.CachingTestServerOptions{
+			Headers: http.Header{"Cache-Control": {"public, max-age=2"}},
+		})
+
+		// Keep the connection so that both requests reach the same Squid pod
+		options := testhelpers.DefaultClientOptions
+		options.KeepAlive = true
+		client, err := testhelpers.NewSquidCachingClientWithOptions(serviceName, namespace, options)
+		Expect(err).NotTo(HaveOccurred(), "Failed to create caching client")
+		DeferCleanup(client.CloseIdleConnections)
+
+		requestURL := testServer.URL + "/stale?" + generateCacheBuster("stale-content")
+		By(fmt.Sprintf("Caching %s, then shutting down the origin", requestURL))
+		testhelpers.ShutdownAndAssertStaleHit(testServer, client, requestURL)
+	})
+})
@@ -0,0 +1,68 @@
+package e2e_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Squid Store Log", Ordered, Serial, func() {
+	var (
+		testServer *testhelpers.CachingTestServer
+		client     *http.Client
+	)
+
+	BeforeAll(func() {
+		err := testhelpers.ConfigureSquidWithHelm(ctx, clientset, testhelpers.SquidHelmValues{
+			Cache:        &testhelpers.CacheValues{StoreLog: true},
+			ReplicaCount: int(suiteReplicaCount),
+		})
+		Expect(err).NotTo(HaveOccurred(), "Failed to configure squid with the store log")
+
+		DeferCleanup(func() {
+			err := testhelpers.ConfigureSquidWithHelm(ctx, clientset, testhelpers.SquidHelmValues{
+				ReplicaCount: int(suiteReplicaCount),
+			})
+			Expect(err).NotTo(HaveOccurred(), "Failed to restore squid cache defaults")
+		})
+	})
+
+	BeforeEach(func() {
+		testServer = setupHTTPTestServer("Store log test server")
+		client = setupHTTPTestClient()
+	})
+
+	It("should log a SWAPOUT entry for a cached response", func() {
+		start := metav1.Now()
+		requestURL := testServer.URL + "/store-log?" + generateCacheBuster("store-log")
+
+		By(fmt.Sprintf("Requesting %s", requestURL))
+		resp, body, err := testhelpers.MakeCachingRequest(client, requestURL)
+		Expect(err).NotTo(HaveOccurred(), "Request through squid should succeed")
+		resp.Body.Close()
+
+		pods, err := testhelpers.GetPods(ctx, clientset, namespace, deploymentName)
+		Expect(err).NotTo(HaveOccurred(), "Failed to get squid pods")
+
+		By("Waiting for the response to be written to the cache")
+		Eventually(func(g Gomega) {
+			entries, err := testhelpers.CollectStoreLogs(ctx, clientset, namespace, pods, &start)
+			g.Expect(err).NotTo(HaveOccurred(), "Failed to collect store logs")
+
+			var swapOuts []testhelpers.StoreLogEntry
+			for _, entry := range entries {
+				if entry.Action == testhelpers.StoreLogSwapOut && strings.HasPrefix(entry.URL, testServer.URL+"/store-log") {
+					swapOuts = append(swapOuts, entry)
+				}
+			}
+			g.Expect(swapOuts).To(HaveLen(1), "The response should be written to the cache once")
+			g.Expect(swapOuts[0].Status).To(Equal(http.StatusOK))
+			g.Expect(swapOuts[0].Size).To(BeNumerically(">=", len(body)), "The stored object should include the response body")
+		}, timeout, interval).Should(Succeed())
+	})
+})
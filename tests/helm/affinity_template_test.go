@@ -5,6 +5,7 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 
 	"github.com/konflux-ci/caching/tests/testhelpers"
@@ -28,9 +29,14 @@ var _ = Describe("Helm Template Affinity Configuration", func() {
 			Expect(output).To(ContainSubstring("kubernetes.io/hostname"), "Should use hostname topology key")
 			Expect(output).To(ContainSubstring("weight: 100"), "Should have weight 100")
 
-			// Verify label selector
-			Expect(output).To(ContainSubstring("app.kubernetes.io/name: "+testhelpers.SquidStatefulSetName), "Should target squid pods")
-			Expect(output).To(ContainSubstring("app.kubernetes.io/component: "+testhelpers.SquidComponentLabel), "Should target squid component")
+			// Verify labels and the label selector
+			var statefulSet appsv1.StatefulSet
+			Expect(decodeRenderedResource(output, "StatefulSet", testhelpers.SquidStatefulSetName, &statefulSet)).To(Succeed())
+			testhelpers.AssertStandardLabels(&statefulSet, testhelpers.SquidStatefulSetName, testhelpers.SquidComponentLabel)
+			preferred := statefulSet.Spec.Template.Spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+			Expect(preferred).To(HaveLen(1))
+			testhelpers.AssertSelectorLabels(preferred[0].PodAffinityTerm.LabelSelector.MatchLabels,
+				testhelpers.SquidStatefulSetName, testhelpers.SquidComponentLabel, "squid anti-affinity selector")
 		})
 
 		It("should not include required anti-affinity (only preferred)", func() {
@@ -137,8 +143,14 @@ var _ = Describe("Helm Template Affinity Configuration", func() {
 			Expect(nginxSection).To(ContainSubstring("preferredDuringSchedulingIgnoredDuringExecution"), "Should use preferred anti-affinity")
 			Expect(nginxSection).To(ContainSubstring("kubernetes.io/hostname"), "Should use hostname topology key")
 			Expect(nginxSection).To(ContainSubstring("weight: 100"), "Should have weight 100")
-			Expect(nginxSection).To(ContainSubstring("app.kubernetes.io/name: "+testhelpers.NginxStatefulSetName), "Should target nginx pods")
-			Expect(nginxSection).To(ContainSubstring("app.kubernetes.io/component: "+testhelpers.NginxComponentLabel), "Should target nginx component")
+
+			var statefulSet appsv1.StatefulSet
+			Expect(decodeRenderedResource(output, "StatefulSet", testhelpers.NginxStatefulSetName, &statefulSet)).To(Succeed())
+			testhelpers.AssertStandardLabels(&statefulSet, testhelpers.NginxStatefulSetName, testhelpers.NginxComponentLabel)
+			preferred := statefulSet.Spec.Template.Spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+			Expect(preferred).To(HaveLen(1))
+			testhelpers.AssertSelectorLabels(preferred[0].PodAffinityTerm.LabelSelector.MatchLabels,
+				testhelpers.NginxStatefulSetName, testhelpers.NginxComponentLabel, "nginx anti-affinity selector")
 		})
 
 		It("should not include required anti-affinity (only preferred)", func() {
@@ -1,6 +1,11 @@
 package helm_test
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
 
 // chartPath is the path to the Helm chart under test
 const chartPath = "./caching"
@@ -32,6 +37,25 @@ func extractSection(helmOutput, sourceMarker string) string {
 	return strings.Join(sectionLines, "\n")
 }
 
+// decodeRenderedResource decodes the rendered manifest with the given kind and name into obj
+func decodeRenderedResource(helmOutput, kind, name string, obj any) error {
+	for _, doc := range strings.Split(helmOutput, "\n---") {
+		var header struct {
+			Kind     string `json:"kind"`
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		}
+		if err := yaml.Unmarshal([]byte(doc), &header); err != nil {
+			return fmt.Errorf("failed to parse rendered manifest: %w", err)
+		}
+		if header.Kind == kind && header.Metadata.Name == name {
+			return yaml.Unmarshal([]byte(doc), obj)
+		}
+	}
+	return fmt.Errorf("%s %s not found in rendered output", kind, name)
+}
+
 // extractSquidDeploymentSection extracts just the squid statefulset YAML for precise testing
 func extractSquidDeploymentSection(helmOutput string) string {
 	return extractSection(helmOutput, "# Source: caching/templates/deployment.yaml")
@@ -240,7 +240,7 @@ var _ = Describe("Helm Template Nginx Configuration", func() {
 						URL: "http://backend:8080",
 					},
 					Cache: &testhelpers.NginxCacheValues{
-						AllowList: []string{},
+						AllowList: []testhelpers.NginxCacheAllowListEntry{},
 					},
 				},
 			})
@@ -262,11 +262,11 @@ var _ = Describe("Helm Template Nginx Configuration", func() {
 						URL: "http://backend:8080",
 					},
 					Cache: &testhelpers.NginxCacheValues{
-						AllowList: []string{
+						AllowList: testhelpers.NginxAllowListPatterns(
 							`^/repository/maven-.*`,
 							`^/repository/npm-.*`,
 							`\.tar\.gz$`,
-						},
+						),
 					},
 				},
 			})
@@ -299,7 +299,7 @@ var _ = Describe("Helm Template Nginx Configuration", func() {
 						SecretName: "my-secret",
 					},
 					Cache: &testhelpers.NginxCacheValues{
-						AllowList: []string{`^/api/.*`},
+						AllowList: testhelpers.NginxAllowListPatterns(`^/api/.*`),
 					},
 				},
 			})
@@ -319,7 +319,7 @@ var _ = Describe("Helm Template Nginx Configuration", func() {
 						URL: "http://backend:8080",
 					},
 					Cache: &testhelpers.NginxCacheValues{
-						AllowList: []string{`^/content/`},
+						AllowList: testhelpers.NginxAllowListPatterns(`^/content/`),
 					},
 				},
 			})
@@ -341,7 +341,7 @@ var _ = Describe("Helm Template Nginx Configuration", func() {
 						URL: "http://backend:8080",
 					},
 					Cache: &testhelpers.NginxCacheValues{
-						AllowList: []string{},
+						AllowList: []testhelpers.NginxCacheAllowListEntry{},
 					},
 				},
 			})
@@ -361,7 +361,7 @@ var _ = Describe("Helm Template Nginx Configuration", func() {
 						URL: "http://backend:8080",
 					},
 					Cache: &testhelpers.NginxCacheValues{
-						AllowList: []string{`^/content/`},
+						AllowList: testhelpers.NginxAllowListPatterns(`^/content/`),
 					},
 				},
 			})
@@ -381,7 +381,7 @@ var _ = Describe("Helm Template Nginx Configuration", func() {
 						URL: "http://backend:8080",
 					},
 					Cache: &testhelpers.NginxCacheValues{
-						AllowList: []string{`^/content/`},
+						AllowList: testhelpers.NginxAllowListPatterns(`^/content/`),
 						TTL:       "30d",
 					},
 				},
@@ -394,6 +394,251 @@ var _ = Describe("Helm Template Nginx Configuration", func() {
 			Expect(configMap).NotTo(ContainSubstring("proxy_cache_valid 200 1d"), "Should not have default TTL")
 		})
 
+		It("should render a dedicated redirect handler for allowList entries with their own TTL", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Nginx: &testhelpers.NginxValues{
+					Enabled: true,
+					Upstream: &testhelpers.NginxUpstreamValues{
+						URL: "http://backend:8080",
+					},
+					Cache: &testhelpers.NginxCacheValues{
+						AllowList: []testhelpers.NginxCacheAllowListEntry{
+							{Pattern: `^/releases/`, TTL: "30d"},
+							{Pattern: `^/content/`},
+							{Pattern: `/index\.json$`, TTL: "5m"},
+						},
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractNginxConfigMapSection(output)
+
+			Expect(configMap).To(ContainSubstring("location ~ ^/releases/ {"), "Should have a location for the TTL entry")
+			Expect(configMap).To(ContainSubstring("location ~ ^/content/ {"), "Should have a location for the plain entry")
+			Expect(configMap).To(ContainSubstring("location ~ /index\\.json$ {"), "Should have a location for the second TTL entry")
+
+			Expect(configMap).To(ContainSubstring("error_page 301 302 307 308 = @handle_redirect_0;"), "TTL entry should use its own redirect handler")
+			Expect(configMap).To(ContainSubstring("error_page 301 302 307 308 = @handle_redirect;"), "Plain entry should use the shared redirect handler")
+			Expect(configMap).To(ContainSubstring("error_page 301 302 307 308 = @handle_redirect_2;"), "Second TTL entry should use its own redirect handler")
+
+			Expect(strings.Count(configMap, "proxy_cache backend_cache")).To(Equal(3), "Each redirect handler should use the cache")
+			Expect(configMap).To(MatchRegexp(`location @handle_redirect_0 \{[^}]*proxy_cache_valid 200 30d;`), "TTL entry should be cached for 30d")
+			Expect(configMap).To(MatchRegexp(`location @handle_redirect \{[^}]*proxy_cache_valid 200 1d;`), "Plain entry should use the default TTL")
+			Expect(configMap).To(MatchRegexp(`location @handle_redirect_2 \{[^}]*proxy_cache_valid 200 5m;`), "Second TTL entry should be cached for 5m")
+		})
+
+		It("should not render the shared redirect handler when every entry has its own TTL", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Nginx: &testhelpers.NginxValues{
+					Enabled: true,
+					Upstream: &testhelpers.NginxUpstreamValues{
+						URL: "http://backend:8080",
+					},
+					Cache: &testhelpers.NginxCacheValues{
+						AllowList: []testhelpers.NginxCacheAllowListEntry{
+							{Pattern: `^/releases/`, TTL: "30d"},
+						},
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractNginxConfigMapSection(output)
+
+			Expect(configMap).To(ContainSubstring("location @handle_redirect_0 {"), "Should render the dedicated redirect handler")
+			Expect(configMap).NotTo(ContainSubstring("location @handle_redirect {"), "Should not render the unused shared redirect handler")
+			Expect(configMap).NotTo(ContainSubstring("proxy_cache_valid 200 1d"), "Should not have default TTL")
+		})
+
+		It("should serve stale content with the default conditions", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Nginx: &testhelpers.NginxValues{
+					Enabled: true,
+					Upstream: &testhelpers.NginxUpstreamValues{
+						URL: "http://backend:8080",
+					},
+					Cache: &testhelpers.NginxCacheValues{
+						AllowList: testhelpers.NginxAllowListPatterns(`^/content/`),
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractNginxConfigMapSection(output)
+
+			Expect(configMap).To(ContainSubstring("proxy_cache_use_stale error timeout updating http_500 http_502 http_503 http_504;"), "Should serve stale content by default")
+			Expect(configMap).NotTo(ContainSubstring("proxy_cache_background_update"), "Background update should be disabled by default")
+		})
+
+		It("should render configured stale serving in each cached location", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Nginx: &testhelpers.NginxValues{
+					Enabled: true,
+					Upstream: &testhelpers.NginxUpstreamValues{
+						URL: "http://backend:8080",
+					},
+					Cache: &testhelpers.NginxCacheValues{
+						AllowList: []testhelpers.NginxCacheAllowListEntry{
+							{Pattern: `^/content/`},
+							{Pattern: `^/releases/`, TTL: "30d"},
+						},
+						ServeStale: &testhelpers.NginxServeStaleValues{
+							UseStale:         []string{"error", "timeout", "updating"},
+							BackgroundUpdate: true,
+						},
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractNginxConfigMapSection(output)
+
+			Expect(strings.Count(configMap, "proxy_cache_use_stale error timeout updating;")).To(Equal(2), "Each redirect handler should serve stale content")
+			Expect(strings.Count(configMap, "proxy_cache_background_update on;")).To(Equal(2), "Each redirect handler should update in the background")
+			Expect(configMap).NotTo(ContainSubstring("http_500"), "Should not include default conditions")
+		})
+
+		It("should not render a cache bypass header by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Nginx: &testhelpers.NginxValues{
+					Enabled: true,
+					Upstream: &testhelpers.NginxUpstreamValues{
+						URL: "http://backend:8080",
+					},
+					Cache: &testhelpers.NginxCacheValues{
+						AllowList: testhelpers.NginxAllowListPatterns(`^/content/`),
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractNginxConfigMapSection(output)
+
+			Expect(configMap).NotTo(ContainSubstring("proxy_cache_bypass $http_"), "Cached locations should not honour a bypass header by default")
+		})
+
+		It("should render the configured cache bypass header in each cached location", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Nginx: &testhelpers.NginxValues{
+					Enabled: true,
+					Upstream: &testhelpers.NginxUpstreamValues{
+						URL: "http://backend:8080",
+					},
+					Cache: &testhelpers.NginxCacheValues{
+						AllowList: []testhelpers.NginxCacheAllowListEntry{
+							{Pattern: `^/content/`},
+							{Pattern: `^/releases/`, TTL: "30d"},
+						},
+						BypassHeader: "X-Bypass-Cache",
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractNginxConfigMapSection(output)
+
+			Expect(strings.Count(configMap, "proxy_cache_bypass $http_x_bypass_cache;")).To(Equal(2), "Each redirect handler should honour the bypass header")
+		})
+
+		It("should reject an invalid cache bypass header name", func() {
+			_, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Nginx: &testhelpers.NginxValues{
+					Enabled: true,
+					Upstream: &testhelpers.NginxUpstreamValues{
+						URL: "http://backend:8080",
+					},
+					Cache: &testhelpers.NginxCacheValues{
+						AllowList:    testhelpers.NginxAllowListPatterns(`^/content/`),
+						BypassHeader: "X-Bypass; return 200",
+					},
+				},
+			})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should not render the cache preload sidecar by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Nginx: &testhelpers.NginxValues{
+					Enabled: true,
+					Upstream: &testhelpers.NginxUpstreamValues{
+						URL: "http://backend:8080",
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := extractNginxStatefulSetSection(output)
+			Expect(statefulSet).NotTo(ContainSubstring("cache-preload"), "Preload sidecar should be off by default")
+		})
+
+		It("should render a cache preload sidecar requesting the preload paths", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Nginx: &testhelpers.NginxValues{
+					Enabled: true,
+					Upstream: &testhelpers.NginxUpstreamValues{
+						URL: "http://backend:8080",
+					},
+					Auth: &testhelpers.NginxAuthValues{
+						Enabled:    true,
+						SecretName: "my-auth-secret",
+					},
+					Cache: &testhelpers.NginxCacheValues{
+						AllowList: testhelpers.NginxAllowListPatterns(`^/releases/`),
+						Preload:   []string{"/releases/lib-1.0.jar", "/releases/lib-2.0.jar"},
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := extractNginxStatefulSetSection(output)
+			Expect(statefulSet).To(ContainSubstring("- name: cache-preload"), "Should render the preload sidecar")
+			Expect(statefulSet).To(ContainSubstring("BASE_URL=http://127.0.0.1:8080"), "Should preload through the local HTTP listener")
+			Expect(statefulSet).To(ContainSubstring(`until curl -ksf -o /dev/null "${BASE_URL}/health"`), "Should wait for nginx to be ready")
+			Expect(statefulSet).To(ContainSubstring("for path in '/releases/lib-1.0.jar' '/releases/lib-2.0.jar'; do"), "Should request each preload path")
+
+			// nginx injects the Authorization header, so only init-config reads the secret
+			preload := statefulSet[strings.Index(statefulSet, "- name: cache-preload"):strings.Index(statefulSet, "- name: access-log-exporter")]
+			Expect(preload).NotTo(ContainSubstring("auth-secret"), "Preload sidecar should not mount the auth secret")
+			Expect(preload).NotTo(ContainSubstring("Authorization"), "Preload sidecar should not send its own Authorization header")
+		})
+
+		It("should preload through the HTTPS listener when TLS is enabled", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Nginx: &testhelpers.NginxValues{
+					Enabled: true,
+					Upstream: &testhelpers.NginxUpstreamValues{
+						URL: "http://backend:8080",
+					},
+					TLS: &testhelpers.NginxTLSValues{
+						Enabled: true,
+					},
+					Cache: &testhelpers.NginxCacheValues{
+						Preload: []string{"/releases/lib-1.0.jar"},
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := extractNginxStatefulSetSection(output)
+			Expect(statefulSet).To(ContainSubstring("BASE_URL=https://127.0.0.1:8443"), "Should preload through the local HTTPS listener")
+		})
+
+		It("should reject preload paths that could break out of the preload script", func() {
+			_, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Nginx: &testhelpers.NginxValues{
+					Enabled: true,
+					Upstream: &testhelpers.NginxUpstreamValues{
+						URL: "http://backend:8080",
+					},
+					Cache: &testhelpers.NginxCacheValues{
+						Preload: []string{"/releases/'; rm -rf /tmp; '"},
+					},
+				},
+			})
+			Expect(err).To(HaveOccurred())
+		})
+
 		It("should not have redirect interception in default location", func() {
 			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
 				Nginx: &testhelpers.NginxValues{
@@ -402,7 +647,7 @@ var _ = Describe("Helm Template Nginx Configuration", func() {
 						URL: "http://backend:8080",
 					},
 					Cache: &testhelpers.NginxCacheValues{
-						AllowList: []string{`^/content/`},
+						AllowList: testhelpers.NginxAllowListPatterns(`^/content/`),
 					},
 				},
 			})
@@ -478,7 +723,7 @@ var _ = Describe("Helm Template Nginx Configuration", func() {
 						URL: "http://nexus.example.com:8081",
 					},
 					Cache: &testhelpers.NginxCacheValues{
-						AllowList: []string{`^/api/.*`},
+						AllowList: testhelpers.NginxAllowListPatterns(`^/api/.*`),
 					},
 				},
 			})
@@ -494,6 +739,130 @@ var _ = Describe("Helm Template Nginx Configuration", func() {
 			Expect(strings.Count(configMap, "proxy_set_header Host nexus.example.com:8081")).To(Equal(2), "Should derive Host header from upstream URL in both locations")
 		})
 
+		It("should load balance several servers through a weighted upstream group", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Nginx: &testhelpers.NginxValues{
+					Enabled: true,
+					Upstream: &testhelpers.NginxUpstreamValues{
+						Servers: []testhelpers.NginxUpstreamServer{
+							{URL: "http://nexus-0.example.com:8081", Weight: 2},
+							{URL: "http://nexus-1.example.com:8081"},
+							{URL: "http://nexus-2.example.com"},
+						},
+						HostHeader: "nexus.example.com",
+					},
+					Cache: &testhelpers.NginxCacheValues{
+						AllowList: testhelpers.NginxAllowListPatterns(`^/api/.*`),
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractNginxConfigMapSection(output)
+			Expect(configMap).To(ContainSubstring("upstream backend {"), "Should render the upstream group")
+			Expect(configMap).To(ContainSubstring("server nexus-0.example.com:8081 weight=2;"), "Should render the server weight")
+			Expect(configMap).To(ContainSubstring("server nexus-1.example.com:8081;"), "Should omit the weight when not set")
+			Expect(configMap).To(ContainSubstring("server nexus-2.example.com:80;"), "Should default to the scheme's port")
+
+			Expect(strings.Count(configMap, "proxy_pass http://backend;")).To(Equal(2), "Should proxy both locations to the upstream group")
+			Expect(configMap).NotTo(ContainSubstring("$upstream_url"), "Should not use the upstream_url variable")
+			Expect(strings.Count(configMap, "proxy_set_header Host nexus.example.com;")).To(Equal(2), "Should send hostHeader to all servers")
+		})
+
+		It("should derive the Host header from servers sharing a host", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Nginx: &testhelpers.NginxValues{
+					Enabled: true,
+					Upstream: &testhelpers.NginxUpstreamValues{
+						Servers: []testhelpers.NginxUpstreamServer{
+							{URL: "http://nexus.example.com:8081", Weight: 2},
+							{URL: "http://nexus.example.com:8081/"},
+						},
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractNginxConfigMapSection(output)
+			Expect(strings.Count(configMap, "proxy_set_header Host nexus.example.com:8081;")).To(Equal(1), "Should derive Host header from the shared host")
+		})
+
+		DescribeTable("should reject servers that cannot share one upstream group",
+			func(servers []testhelpers.NginxUpstreamServer, expectedError string) {
+				_, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+					Nginx: &testhelpers.NginxValues{
+						Enabled: true,
+						Upstream: &testhelpers.NginxUpstreamValues{
+							Servers: servers,
+						},
+					},
+				})
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring(expectedError))
+			},
+			Entry("different hosts without hostHeader", []testhelpers.NginxUpstreamServer{
+				{URL: "http://nexus-0.example.com:8081"},
+				{URL: "http://nexus-1.example.com:8081"},
+			}, "set nginx.upstream.hostHeader"),
+			Entry("a server with a path", []testhelpers.NginxUpstreamServer{
+				{URL: "http://nexus.example.com:8081"},
+				{URL: "http://nexus.example.com:8081/repository/npm"},
+			}, "has a path"),
+			Entry("different schemes", []testhelpers.NginxUpstreamServer{
+				{URL: "http://nexus.example.com:8081"},
+				{URL: "https://nexus.example.com:8443"},
+			}, "does not share the scheme"),
+		)
+
+		It("should allow overriding the Host header of a single upstream", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Nginx: &testhelpers.NginxValues{
+					Enabled: true,
+					Upstream: &testhelpers.NginxUpstreamValues{
+						URL:        "http://10.0.0.5:8081",
+						HostHeader: "nexus.example.com",
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractNginxConfigMapSection(output)
+			Expect(strings.Count(configMap, "proxy_set_header Host nexus.example.com;")).To(Equal(1), "Should send hostHeader")
+		})
+
+		It("should proxy a single server via the upstream_url variable", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Nginx: &testhelpers.NginxValues{
+					Enabled: true,
+					Upstream: &testhelpers.NginxUpstreamValues{
+						Servers: []testhelpers.NginxUpstreamServer{
+							{URL: "https://nexus.example.com", Weight: 3},
+						},
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractNginxConfigMapSection(output)
+			Expect(configMap).NotTo(ContainSubstring("upstream backend"), "Should not render an upstream group")
+			Expect(configMap).To(ContainSubstring(`set $upstream_url "https://nexus.example.com";`), "Should override the upstream URL")
+			Expect(strings.Count(configMap, "proxy_pass $upstream_url")).To(Equal(1), "Should use upstream_url variable in proxy_pass")
+		})
+
+		It("should reject upstream server URLs without a scheme", func() {
+			_, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Nginx: &testhelpers.NginxValues{
+					Enabled: true,
+					Upstream: &testhelpers.NginxUpstreamValues{
+						Servers: []testhelpers.NginxUpstreamServer{
+							{URL: "nexus.example.com:8081"},
+						},
+					},
+				},
+			})
+			Expect(err).To(HaveOccurred(), "Should reject server URLs without a scheme")
+		})
+
 		It("should use default proxy_read_timeout when not specified", func() {
 			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
 				Nginx: &testhelpers.NginxValues{
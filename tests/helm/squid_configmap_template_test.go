@@ -0,0 +1,585 @@
+package helm_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+)
+
+var _ = Describe("Helm Template Squid ConfigMap Configuration", func() {
+	Describe("Store-ID Helper Configuration", func() {
+		It("should run the store-id helper without flags by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).To(ContainSubstring("store_id_program /usr/local/bin/squid-store-id\n"), "store-id helper should be invoked without flags")
+			Expect(configMap).NotTo(ContainSubstring("-package-registries"), "Package registry normalization should be disabled by default")
+		})
+
+		It("should pass -package-registries when package registry normalization is enabled", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				StoreID: &testhelpers.StoreIDValues{
+					PackageRegistries: true,
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).To(ContainSubstring("store_id_program /usr/local/bin/squid-store-id -package-registries\n"), "store-id helper should be invoked with -package-registries")
+		})
+
+		It("should pass -min-size-bytes when a minimum size is configured", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				StoreID: &testhelpers.StoreIDValues{
+					MinSizeBytes: 10485760,
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).To(ContainSubstring("store_id_program /usr/local/bin/squid-store-id -min-size-bytes 10485760\n"), "store-id helper should be invoked with -min-size-bytes")
+		})
+
+		It("should pass -soft-deadline and -background-auth when a soft deadline is configured", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				StoreID: &testhelpers.StoreIDValues{
+					SoftDeadline:   "2s",
+					BackgroundAuth: true,
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).To(ContainSubstring("store_id_program /usr/local/bin/squid-store-id -soft-deadline 2s -background-auth\n"), "store-id helper should be invoked with -soft-deadline and -background-auth")
+		})
+
+		It("should pass -skip-auth-check when the authorization check is disabled", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				StoreID: &testhelpers.StoreIDValues{
+					SkipAuthCheck: true,
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).To(ContainSubstring("store_id_program /usr/local/bin/squid-store-id -skip-auth-check\n"), "store-id helper should be invoked with -skip-auth-check")
+		})
+
+		It("should pass -audit when audit logging is enabled", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				StoreID: &testhelpers.StoreIDValues{
+					Audit: true,
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).To(ContainSubstring("store_id_program /usr/local/bin/squid-store-id -audit\n"), "store-id helper should be invoked with -audit")
+		})
+
+		It("should reject an invalid soft deadline", func() {
+			_, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				StoreID: &testhelpers.StoreIDValues{
+					SoftDeadline: "2 seconds",
+				},
+			})
+			Expect(err).To(HaveOccurred(), "schema should reject a soft deadline that is not a duration")
+		})
+	})
+	Describe("Cache Sizing Configuration", func() {
+		It("should render the default object size and cache_dir sizing", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).To(ContainSubstring("maximum_object_size 192 MB"), "Default maximum object size should be 192 MB")
+			cacheDir, err := testhelpers.ParseSquidDirective(configMap, "cache_dir")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cacheDir).To(HaveLen(5))
+			Expect(cacheDir[0]).To(Equal("aufs"), "cache_dir should use the aufs store")
+			Expect(cacheDir[1]).To(Equal("/var/spool/squid/cache"))
+			Expect(cacheDir[2]).To(Equal("819"), "cache_dir should use 80% of the default 1024 MiB volume")
+			Expect(cacheDir[3:]).To(Equal([]string{"16", "256"}), "cache_dir should use 16 L1 and 256 L2 directories")
+		})
+
+		It("should render the configured object size and cache_dir sizing", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Cache: &testhelpers.CacheValues{
+					DiskSizeMB:          10240,
+					MaximumObjectSizeMB: 2048,
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).To(ContainSubstring("maximum_object_size 2048 MB"), "maximum_object_size should reflect cache.maxObjectSize")
+			cacheDir, err := testhelpers.ParseSquidDirective(configMap, "cache_dir")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cacheDir).To(HaveLen(5))
+			Expect(cacheDir[0]).To(Equal("aufs"), "cache_dir should use the aufs store")
+			Expect(cacheDir[1]).To(Equal("/var/spool/squid/cache"))
+			Expect(cacheDir[2]).To(Equal("8192"), "cache_dir should use 80% of cache.size")
+			Expect(cacheDir[3:]).To(Equal([]string{"16", "256"}), "cache_dir should use 16 L1 and 256 L2 directories")
+		})
+
+		It("should disable the memory cache by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			cacheMem, err := testhelpers.ParseSquidDirective(configMap, "cache_mem")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cacheMem).To(Equal([]string{"0"}), "cache_mem should be 0 by default")
+		})
+
+		It("should render the configured memory cache size", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Cache: &testhelpers.CacheValues{MemMB: 256},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			cacheMem, err := testhelpers.ParseSquidDirective(configMap, "cache_mem")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cacheMem).To(Equal([]string{"256", "MB"}), "cache_mem should reflect cache.memSize")
+		})
+
+		It("should reject a negative memory cache size", func() {
+			_, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Cache: &testhelpers.CacheValues{MemMB: -1},
+			})
+			Expect(err).To(HaveOccurred(), "schema should reject a negative cache.memSize")
+		})
+
+		It("should serve stale objects for Squid's default of 1 week by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			maxStale, err := testhelpers.ParseSquidDirective(configMap, "max_stale")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(maxStale).To(Equal([]string{"604800", "seconds"}), "max_stale should default to 1 week")
+		})
+
+		DescribeTable("should render the configured max_stale",
+			func(seconds int, expected string) {
+				output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+					Cache: &testhelpers.CacheValues{MaxStaleSeconds: testhelpers.IntPtr(seconds)},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				configMap := extractSquidConfigMapSection(output)
+				maxStale, err := testhelpers.ParseSquidDirective(configMap, "max_stale")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(maxStale).To(Equal([]string{expected, "seconds"}), "max_stale should reflect cache.maxStaleSeconds")
+			},
+			Entry("one day", 86400, "86400"),
+			Entry("never serve stale objects", 0, "0"),
+		)
+
+		It("should reject a negative max_stale", func() {
+			_, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Cache: &testhelpers.CacheValues{MaxStaleSeconds: testhelpers.IntPtr(-1)},
+			})
+			Expect(err).To(HaveOccurred(), "schema should reject a negative cache.maxStaleSeconds")
+		})
+	})
+	Describe("Process Tuning Configuration", func() {
+		It("should keep Squid's defaults and a single cache_dir by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).NotTo(ContainSubstring("max_filedescriptors"), "max_filedescriptors should not be rendered by default")
+			Expect(configMap).NotTo(MatchRegexp(`(?m)^\s*workers `), "workers should not be rendered by default")
+			Expect(configMap).NotTo(ContainSubstring("${process_number}"), "A single worker should use the shared cache_dir")
+		})
+
+		It("should render max_filedescriptors", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Tuning: &testhelpers.TuningValues{MaxFileDescriptors: 65536},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			maxFDs, err := testhelpers.ParseSquidDirective(configMap, "max_filedescriptors")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(maxFDs).To(Equal([]string{"65536"}))
+			Expect(configMap).NotTo(MatchRegexp(`(?m)^\s*workers `), "workers should only be rendered for more than one worker")
+		})
+
+		It("should render workers with a cache_dir per worker", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Cache:  &testhelpers.CacheValues{DiskSizeMB: 10240},
+				Tuning: &testhelpers.TuningValues{WorkersSMP: 4},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			workers, err := testhelpers.ParseSquidDirective(configMap, "workers")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(workers).To(Equal([]string{"4"}))
+			cacheDir, err := testhelpers.ParseSquidDirective(configMap, "cache_dir")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cacheDir).To(Equal([]string{"aufs", "/var/spool/squid/cache/${process_number}", "2048", "16", "256"}),
+				"Each worker should get its own cache_dir with an equal share of 80% of cache.size")
+		})
+
+		It("should reject fewer than one worker", func() {
+			_, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Tuning: &testhelpers.TuningValues{WorkersSMP: -1},
+			})
+			Expect(err).To(HaveOccurred(), "schema should reject fewer than one worker")
+		})
+	})
+	Describe("Forwarding Headers Configuration", func() {
+		It("should enable via and forwarded_for by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			via, err := testhelpers.ParseSquidDirective(configMap, "via")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(via).To(Equal([]string{"on"}), "Via header should be enabled by default")
+			forwardedFor, err := testhelpers.ParseSquidDirective(configMap, "forwarded_for")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(forwardedFor).To(Equal([]string{"on"}), "X-Forwarded-For should be enabled by default")
+			Expect(configMap).NotTo(ContainSubstring("squid-pod-identity.conf"), "Pod header should be disabled by default")
+		})
+
+		It("should render the configured via and forwarded_for directives", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Forwarding: &testhelpers.ForwardingValues{
+					Via:          "off",
+					ForwardedFor: "delete",
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			via, err := testhelpers.ParseSquidDirective(configMap, "via")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(via).To(Equal([]string{"off"}))
+			forwardedFor, err := testhelpers.ParseSquidDirective(configMap, "forwarded_for")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(forwardedFor).To(Equal([]string{"delete"}))
+		})
+
+		It("should include the pod identity file when the pod header is enabled", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Forwarding: &testhelpers.ForwardingValues{
+					Via:       "off",
+					PodHeader: true,
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			include, err := testhelpers.ParseSquidDirective(configMap, "include")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(include).To(Equal([]string{"/tmp/squid-pod-identity.conf"}), "Pod identity file written by the entrypoint should be included")
+		})
+
+		It("should reject unsupported forwarded_for modes", func() {
+			_, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Forwarding: &testhelpers.ForwardingValues{ForwardedFor: "truncate"},
+			})
+			Expect(err).To(HaveOccurred(), "Schema should reject unknown forwarded_for values")
+		})
+	})
+	Describe("Header Access Configuration", func() {
+		It("should not remove headers by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).NotTo(ContainSubstring("request_header_access"), "No request headers should be removed by default")
+			Expect(configMap).NotTo(ContainSubstring("reply_header_access"), "No reply headers should be removed by default")
+		})
+
+		It("should render a deny rule for every configured header", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				HeaderAccess: &testhelpers.HeaderAccessValues{
+					Request: []string{"Cookie", "X-Debug"},
+					Reply:   []string{"Set-Cookie"},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).To(ContainSubstring("request_header_access Cookie deny all\n    request_header_access X-Debug deny all\n"), "Request headers should be removed in order")
+			replyHeaderAccess, err := testhelpers.ParseSquidDirective(configMap, "reply_header_access")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(replyHeaderAccess).To(Equal([]string{"Set-Cookie", "deny", "all"}))
+		})
+
+		It("should reject invalid header names", func() {
+			_, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				HeaderAccess: &testhelpers.HeaderAccessValues{
+					Reply: []string{"Set-Cookie deny all\nhttp_access allow all"},
+				},
+			})
+			Expect(err).To(HaveOccurred(), "schema should reject header names that are not HTTP tokens")
+		})
+	})
+
+	Describe("Parent Proxy Configuration", func() {
+		It("should connect to origins directly by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).NotTo(ContainSubstring("cache_peer"), "No parent proxy should be configured by default")
+			Expect(configMap).NotTo(ContainSubstring("never_direct"), "Direct connections should be allowed by default")
+		})
+
+		It("should forward every request through the parent proxy", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				ParentProxy: &testhelpers.ParentProxyValues{
+					Host: "proxy.corp.example.com",
+					Port: 8080,
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			cachePeer, err := testhelpers.ParseSquidDirective(configMap, "cache_peer")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cachePeer).To(Equal([]string{"proxy.corp.example.com", "parent", "8080", "0", "no-query", "default"}),
+				"cache_peer should use the default type and options with ICP disabled")
+			neverDirect, err := testhelpers.ParseSquidDirective(configMap, "never_direct")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(neverDirect).To(Equal([]string{"allow", "all"}))
+			Expect(configMap).To(ContainSubstring("store_id_program /usr/local/bin/squid-store-id -parent-proxy http://proxy.corp.example.com:8080\n"),
+				"store-id helper should check origin authorization through the parent proxy")
+		})
+
+		It("should render custom options and allow direct connections for siblings", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				ParentProxy: &testhelpers.ParentProxyValues{
+					Host:    "10.0.0.5",
+					Port:    3128,
+					Type:    "sibling",
+					Options: "proxy-only name=peer",
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			cachePeer, err := testhelpers.ParseSquidDirective(configMap, "cache_peer")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cachePeer).To(Equal([]string{"10.0.0.5", "sibling", "3128", "0", "proxy-only", "name=peer"}))
+			Expect(configMap).NotTo(ContainSubstring("never_direct"), "Siblings should not prevent direct connections")
+			Expect(configMap).NotTo(ContainSubstring("-parent-proxy"), "store-id helper should connect to origins directly")
+		})
+
+		It("should reject unsupported peer types", func() {
+			_, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				ParentProxy: &testhelpers.ParentProxyValues{Host: "proxy.corp.example.com", Type: "multicast"},
+			})
+			Expect(err).To(HaveOccurred(), "Schema should reject unknown cache_peer types")
+		})
+	})
+	Describe("DNS Configuration", func() {
+		It("should use the pod resolver by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).NotTo(ContainSubstring("dns_"), "No DNS directives should be rendered by default")
+		})
+
+		It("should render nameservers and DNS timeouts", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				DNS: &testhelpers.DNSValues{
+					Nameservers:               []string{"10.96.0.10", "fd00::10"},
+					TimeoutSeconds:            10,
+					RetransmitIntervalSeconds: 2,
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			nameservers, err := testhelpers.ParseSquidDirective(configMap, "dns_nameservers")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nameservers).To(Equal([]string{"10.96.0.10", "fd00::10"}))
+			timeout, err := testhelpers.ParseSquidDirective(configMap, "dns_timeout")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(timeout).To(Equal([]string{"10", "seconds"}))
+			retransmit, err := testhelpers.ParseSquidDirective(configMap, "dns_retransmit_interval")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(retransmit).To(Equal([]string{"2", "seconds"}))
+		})
+
+		It("should render only the configured DNS directives", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				DNS: &testhelpers.DNSValues{TimeoutSeconds: 5},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).To(ContainSubstring("dns_timeout 5 seconds"))
+			Expect(configMap).NotTo(ContainSubstring("dns_nameservers"))
+			Expect(configMap).NotTo(ContainSubstring("dns_retransmit_interval"))
+		})
+
+		It("should reject negative timeouts", func() {
+			_, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				DNS: &testhelpers.DNSValues{TimeoutSeconds: -1},
+			})
+			Expect(err).To(HaveOccurred(), "Schema should reject negative DNS timeouts")
+		})
+	})
+	Describe("Deny List Configuration", func() {
+		It("should not render deny rules by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).NotTo(ContainSubstring("never_cached_urls"), "Deny list should be empty by default")
+		})
+
+		It("should render deny rules before the allow rules", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Cache: &testhelpers.CacheValues{
+					AllowList: []string{"^https://quay\\.io/"},
+					DenyList:  []string{"/manifests/latest$", "/tags/list"},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).To(ContainSubstring("acl never_cached_urls url_regex /manifests/latest$\n    acl never_cached_urls url_regex /tags/list\n    cache deny never_cached_urls"), "Deny list patterns should be rendered as cache deny ACLs")
+			denyIndex := strings.Index(configMap, "cache deny never_cached_urls")
+			allowIndex := strings.Index(configMap, "cache allow cached_urls")
+			Expect(allowIndex).To(BeNumerically(">", 0), "Allow list should be rendered")
+			Expect(denyIndex).To(BeNumerically("<", allowIndex), "Deny rules should take precedence over allow rules")
+		})
+
+		It("should render deny rules before caching everything when the allow list is empty", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Cache: &testhelpers.CacheValues{DenyList: []string{"/latest$"}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).To(ContainSubstring("cache deny never_cached_urls\n    cache allow all"), "Deny rules should precede cache allow all")
+		})
+	})
+
+	Describe("Access Log Filter Configuration", func() {
+		It("should only omit the built-in requests from the access log by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).To(ContainSubstring("access_log none localhost squid_internal"), "Internal manager requests should be omitted")
+			Expect(configMap).To(ContainSubstring("access_log none !has_request"), "TCP health checks should be omitted")
+			Expect(configMap).NotTo(ContainSubstring("access_log_filtered"), "No access log filters should be rendered by default")
+		})
+
+		It("should omit requests matching the filters before logging to stdout", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				AccessLogFilters: []string{"/healthz$", "-i ^http://probe\\.example\\.com/"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).To(ContainSubstring("acl access_log_filtered url_regex /healthz$\n    acl access_log_filtered url_regex -i ^http://probe\\.example\\.com/\n    access_log none access_log_filtered\n"),
+				"Access log filters should be rendered as an access_log none ACL")
+			filterIndex := strings.Index(configMap, "access_log none access_log_filtered")
+			stdoutIndex := strings.Index(configMap, "access_log stdio:/dev/stdout")
+			Expect(stdoutIndex).To(BeNumerically(">", 0), "The stdout access log should be rendered")
+			Expect(filterIndex).To(BeNumerically("<", stdoutIndex), "Filters should precede the stdout access log")
+		})
+	})
+
+	Describe("Store Log Configuration", func() {
+		It("should not write a store log by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).NotTo(ContainSubstring("cache_store_log"), "Store log should be disabled by default")
+		})
+
+		It("should write the store log to stderr when enabled", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Cache: &testhelpers.CacheValues{StoreLog: true},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).To(ContainSubstring("cache_store_log stdio:/dev/stderr\n"), "Store log should be written to stderr")
+			Expect(configMap).NotTo(ContainSubstring("cache_store_log stdio:/dev/stdout"), "Store log must not mix with the access log read by the per-site exporter")
+		})
+	})
+
+	Describe("Allow List Dry-Run Configuration", func() {
+		allowList := []string{"^https://cdn\\.example\\.com/"}
+
+		It("should enforce the allow list by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Cache: &testhelpers.CacheValues{AllowList: allowList},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).To(ContainSubstring("cache allow cached_urls\n    cache deny all"), "Allow list should be enforced")
+			Expect(configMap).NotTo(ContainSubstring("allowlist_decision"), "Dry-run annotations should not be rendered")
+			Expect(configMap).To(ContainSubstring("access_log stdio:/dev/stdout squid\n"), "Native access log format should be used")
+		})
+
+		It("should log allow list decisions without enforcing them in dry-run mode", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Cache: &testhelpers.CacheValues{AllowList: allowList, DryRun: true},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).To(ContainSubstring("note allowlist_decision allow cached_urls"), "Allowed requests should be annotated")
+			Expect(configMap).To(ContainSubstring("note allowlist_decision deny !cached_urls"), "Denied requests should be annotated")
+			Expect(configMap).To(ContainSubstring("cache allow all"), "All URLs should be cached in dry-run mode")
+			Expect(configMap).NotTo(ContainSubstring("cache deny all"), "Allow list should not be enforced in dry-run mode")
+			Expect(configMap).To(ContainSubstring("allowlist=%{allowlist_decision}note"), "Access log format should include the decision")
+			Expect(configMap).To(ContainSubstring("access_log stdio:/dev/stdout squid_dryrun"), "Dry-run access log format should be used")
+		})
+
+		It("should ignore dry-run mode when the allow list is empty", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Cache: &testhelpers.CacheValues{DryRun: true},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).NotTo(ContainSubstring("allowlist_decision"), "Dry-run annotations should not be rendered")
+			Expect(configMap).To(ContainSubstring("access_log stdio:/dev/stdout squid\n"), "Native access log format should be used")
+		})
+	})
+
+	Describe("Collapsed Forwarding Configuration", func() {
+		It("should not collapse forwarding by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			Expect(configMap).NotTo(ContainSubstring("collapsed_forwarding"), "Collapsed forwarding should be disabled by default")
+		})
+
+		It("should render collapsed_forwarding when enabled", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				CollapsedForwarding: true,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			configMap := extractSquidConfigMapSection(output)
+			collapsedForwarding, err := testhelpers.ParseSquidDirective(configMap, "collapsed_forwarding")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(collapsedForwarding).To(Equal([]string{"on"}))
+		})
+	})
+})
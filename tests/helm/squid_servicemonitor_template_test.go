@@ -92,4 +92,31 @@ var _ = Describe("Helm Template Squid ServiceMonitor Configuration", func() {
 			Expect(serviceMonitor).To(ContainSubstring("kind: ServiceMonitor"))
 		})
 	})
+	Describe("ICAP Server Metrics Endpoint", func() {
+		It("should not scrape the ICAP server by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(extractSquidServiceMonitorSection(output)).NotTo(ContainSubstring("icap-metrics"))
+			Expect(extractSquidServiceSection(output)).NotTo(ContainSubstring("icap-metrics"))
+		})
+
+		It("should expose and scrape the ICAP metrics port when enabled", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				ICAPServer: &testhelpers.ICAPServerValues{
+					Metrics: &testhelpers.ICAPMetricsValues{Enabled: true, Port: 9303},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			service := extractSquidServiceSection(output)
+			Expect(service).To(ContainSubstring("- port: 9303\n      targetPort: icap-metrics\n      protocol: TCP\n      name: icap-metrics"),
+				"Service should expose the ICAP metrics port")
+
+			serviceMonitor := extractSquidServiceMonitorSection(output)
+			Expect(serviceMonitor).To(ContainSubstring("- port: icap-metrics\n      path: /metrics\n"),
+				"ServiceMonitor should scrape the ICAP metrics port")
+			Expect(serviceMonitor).To(ContainSubstring("regex: icap_.*"), "only the icap_* metrics should be kept")
+		})
+	})
 })
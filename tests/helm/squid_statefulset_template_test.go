@@ -0,0 +1,216 @@
+package helm_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/konflux-ci/caching/tests/testhelpers"
+)
+
+var _ = Describe("Helm Template Squid StatefulSet Configuration", func() {
+	Describe("ICAP Server Rate Limit Configuration", func() {
+		It("should not set rate limit env vars by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := extractSquidDeploymentSection(output)
+			Expect(statefulSet).NotTo(ContainSubstring("ICAP_RATE_LIMIT"), "Rate limiting should be disabled by default")
+			Expect(statefulSet).NotTo(ContainSubstring("ICAP_RATE_BURST"), "Rate limit burst should not be set by default")
+		})
+
+		It("should set rate limit env vars on the icap-server container when enabled", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				ICAPServer: &testhelpers.ICAPServerValues{
+					RateLimit: &testhelpers.ICAPRateLimitValues{
+						RequestsPerSecond: 2.5,
+						Burst:             10,
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := extractSquidDeploymentSection(output)
+			Expect(statefulSet).To(ContainSubstring("- icap-server\n          env:\n            - name: ICAP_RATE_LIMIT\n              value: \"2.5\""), "icap-server should receive ICAP_RATE_LIMIT")
+			Expect(statefulSet).To(ContainSubstring("- name: ICAP_RATE_BURST\n              value: \"10\""), "icap-server should receive ICAP_RATE_BURST")
+		})
+
+		It("should omit the burst env var when only the rate is set", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				ICAPServer: &testhelpers.ICAPServerValues{
+					RateLimit: &testhelpers.ICAPRateLimitValues{
+						RequestsPerSecond: 5,
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := extractSquidDeploymentSection(output)
+			Expect(statefulSet).To(ContainSubstring("- name: ICAP_RATE_LIMIT\n              value: \"5\""), "icap-server should receive ICAP_RATE_LIMIT")
+			Expect(statefulSet).NotTo(ContainSubstring("ICAP_RATE_BURST"), "ICAP_RATE_BURST should not be set")
+		})
+	})
+	Describe("ICAP Server Metrics Configuration", func() {
+		It("should not serve ICAP metrics by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := extractSquidDeploymentSection(output)
+			Expect(statefulSet).NotTo(ContainSubstring("ICAP_METRICS_ADDR"), "ICAP metrics should be disabled by default")
+			Expect(statefulSet).NotTo(ContainSubstring("icap-metrics"), "no ICAP metrics port should be exposed by default")
+		})
+
+		It("should set the metrics address and port on the icap-server container when enabled", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				ICAPServer: &testhelpers.ICAPServerValues{
+					RateLimit: &testhelpers.ICAPRateLimitValues{RequestsPerSecond: 5},
+					Metrics:   &testhelpers.ICAPMetricsValues{Enabled: true, Port: 9310},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := extractSquidDeploymentSection(output)
+			Expect(statefulSet).To(ContainSubstring("- name: icap-metrics\n              containerPort: 9310\n              protocol: TCP"),
+				"icap-server should expose the metrics port")
+			Expect(statefulSet).To(ContainSubstring("- name: ICAP_RATE_LIMIT\n              value: \"5\"\n            - name: ICAP_METRICS_ADDR\n              value: \":9310\""),
+				"icap-server should receive ICAP_METRICS_ADDR next to the rate limit")
+		})
+	})
+	Describe("Per-site Exporter Readiness Configuration", func() {
+		It("should use /health for readiness by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := extractSquidDeploymentSection(output)
+			Expect(statefulSet).To(ContainSubstring("readinessProbe:\n            httpGet:\n              path: /health"), "readiness should use /health by default")
+			Expect(statefulSet).NotTo(ContainSubstring("-web.readiness-enabled"), "readiness gate should be disabled by default")
+		})
+
+		It("should use /ready and pass the warm-up when enabled", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				PerSiteExporter: &testhelpers.PerSiteExporterValues{
+					Readiness: &testhelpers.PerSiteExporterReadiness{
+						Enabled: true,
+						Warmup:  "2m",
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := extractSquidDeploymentSection(output)
+			Expect(statefulSet).To(ContainSubstring("readinessProbe:\n            httpGet:\n              path: /ready"), "readiness should use /ready")
+			Expect(statefulSet).To(ContainSubstring("livenessProbe:\n            httpGet:\n              path: /health"), "liveness should keep using /health")
+			Expect(statefulSet).To(ContainSubstring("- -web.readiness-enabled\n            - -web.readiness-warmup\n            - \"2m\""), "exporter should receive the readiness flags")
+		})
+
+		It("should reject an invalid warm-up duration", func() {
+			_, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				PerSiteExporter: &testhelpers.PerSiteExporterValues{
+					Readiness: &testhelpers.PerSiteExporterReadiness{
+						Enabled: true,
+						Warmup:  "one minute",
+					},
+				},
+			})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Describe("Per-site Exporter Pod Label Configuration", func() {
+		It("should not label metrics with the pod by default", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := extractSquidDeploymentSection(output)
+			Expect(statefulSet).NotTo(ContainSubstring("-pod-label"))
+			Expect(statefulSet).NotTo(ContainSubstring("POD_NAME"))
+		})
+
+		It("should pass the pod name from the downward API when enabled", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				PerSiteExporter: &testhelpers.PerSiteExporterValues{PodLabel: true},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := extractSquidDeploymentSection(output)
+			Expect(statefulSet).To(ContainSubstring("            - -pod-label\n"), "exporter should receive the pod label flag")
+			Expect(statefulSet).To(ContainSubstring("- name: POD_NAME\n              valueFrom:\n                fieldRef:\n                  fieldPath: metadata.name"),
+				"POD_NAME should be set from the pod name")
+		})
+	})
+	Describe("Store-ID Helper Metrics Configuration", func() {
+		It("should point the store-id helpers and the exporter at a shared metrics directory", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := extractSquidDeploymentSection(output)
+			Expect(statefulSet).To(ContainSubstring("- name: STORE_ID_METRICS_DIR\n              value: /tmp/store-id-metrics\n"),
+				"the helpers inherit the directory from the squid container environment")
+		})
+
+		It("should not set the metrics directory without the per-site exporter", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				PerSiteExporter: &testhelpers.PerSiteExporterValues{Enabled: testhelpers.BoolPtr(false)},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := extractSquidDeploymentSection(output)
+			Expect(statefulSet).NotTo(ContainSubstring("STORE_ID_METRICS_DIR"))
+		})
+	})
+	Describe("Cache Volume Configuration", func() {
+		It("should size the cache volume claim from cache.size", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Cache: &testhelpers.CacheValues{
+					DiskSizeMB: 10240,
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := extractSquidDeploymentSection(output)
+			Expect(statefulSet).To(ContainSubstring("storage: 10240Mi"), "Cache volume claim should request cache.size MiB")
+		})
+	})
+	Describe("SMP Workers Configuration", func() {
+		It("should not mount a run directory for a single worker", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := extractSquidDeploymentSection(output)
+			Expect(statefulSet).NotTo(ContainSubstring("mountPath: /run/squid"))
+		})
+
+		It("should mount a writable run directory for the worker sockets", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Tuning: &testhelpers.TuningValues{WorkersSMP: 2},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := extractSquidDeploymentSection(output)
+			Expect(statefulSet).To(ContainSubstring("- name: squid-run\n              mountPath: /run/squid"),
+				"SMP workers need a writable /run/squid on the read-only root filesystem")
+			Expect(statefulSet).To(ContainSubstring("- name: squid-run\n          emptyDir: {}"))
+		})
+
+		It("should mount a memory-backed /dev/shm sized from the memory cache", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Tuning: &testhelpers.TuningValues{WorkersSMP: 2},
+				Cache:  &testhelpers.CacheValues{MemMB: 256},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			statefulSet := extractSquidDeploymentSection(output)
+			Expect(statefulSet).To(ContainSubstring("- name: squid-shm\n              mountPath: /dev/shm"),
+				"SMP workers keep the shared memory cache in /dev/shm")
+			Expect(statefulSet).To(ContainSubstring("- name: squid-shm\n          emptyDir:\n            medium: Memory\n            sizeLimit: 320Mi"),
+				"/dev/shm should hold cache.memSize plus the workers' other segments")
+		})
+
+		It("should keep the default /dev/shm for a single worker", func() {
+			output, err := testhelpers.RenderHelmTemplate(chartPath, testhelpers.SquidHelmValues{
+				Cache: &testhelpers.CacheValues{MemMB: 256},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(extractSquidDeploymentSection(output)).NotTo(ContainSubstring("/dev/shm"))
+		})
+	})
+})
@@ -21,9 +21,9 @@ func main() {
 	var requestCount int32
 
 	// checkOverrideStatus returns true (and writes the response) if the client
-	// requested a specific status code via X-Response-Status header.
-	checkOverrideStatus := func(w http.ResponseWriter, r *http.Request) bool {
-		val := r.Header.Get("X-Response-Status")
+	// requested a specific status code via the given header.
+	checkOverrideStatus := func(w http.ResponseWriter, r *http.Request, header string) bool {
+		val := r.Header.Get(header)
 		if val == "" {
 			return false
 		}
@@ -63,7 +63,7 @@ func main() {
 		if !checkAuth(w, r) {
 			return
 		}
-		if checkOverrideStatus(w, r) {
+		if checkOverrideStatus(w, r, "X-Response-Status") {
 			return
 		}
 		target := r.URL.Query().Get("url")
@@ -79,12 +79,24 @@ func main() {
 		if !checkAuth(w, r) {
 			return
 		}
-		if checkOverrideStatus(w, r) {
+		if checkOverrideStatus(w, r, "X-Response-Status") {
 			return
 		}
+		// X-Content-Response-Status only applies to content, so redirects to this
+		// endpoint still succeed while the redirect target fails
+		if checkOverrideStatus(w, r, "X-Content-Response-Status") {
+			return
+		}
+
+		// The max_age query parameter shortens the freshness lifetime so tests can
+		// observe expired cache entries
+		maxAge := 300
+		if v, err := strconv.Atoi(r.URL.Query().Get("max_age")); err == nil && v >= 0 {
+			maxAge = v
+		}
 
 		count := atomic.AddInt32(&requestCount, 1)
-		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
 		w.Header().Set("Content-Type", "application/json")
 
 		response := map[string]interface{}{
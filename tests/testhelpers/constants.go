@@ -8,6 +8,8 @@ const (
 	Namespace = "caching"
 	Timeout   = 60 * time.Second
 	Interval  = 2 * time.Second
+	// ExecTimeout bounds pod exec commands whose context has no deadline
+	ExecTimeout = 5 * time.Minute
 
 	// Squid constants
 	SquidServiceName     = "squid"
@@ -15,6 +17,17 @@ const (
 	SquidContainerName   = "squid"
 	SquidComponentLabel  = "squid-caching"
 	SquidTLSSecretName   = Namespace + "-tls"
+	// SquidCacheDir is the cache_dir on the cache volume of the squid container
+	SquidCacheDir = "/var/spool/squid/cache"
+	// The trust-manager bundle ConfigMap is named "<namespace>" + SquidCABundleConfigMapSuffix
+	SquidCABundleConfigMapSuffix = "-ca-bundle"
+	SquidCABundleKey             = "ca-bundle.crt"
+	// SquidTestServerCAPath is the test-server CA bundle mounted in the squid container when test.enabled
+	SquidTestServerCAPath = "/etc/squid/trust/test-server/ca.crt"
+
+	// ICAP server constants (sidecar of the squid pods)
+	ICAPPort        = 1344
+	ICAPServiceName = "reqmod"
 
 	// Nginx constants
 	NginxServiceName     = "nginx"
@@ -22,6 +35,11 @@ const (
 	NginxPort            = 80
 	NginxHTTPSPort       = 443
 	NginxComponentLabel  = "nginx-caching"
+	NginxContainerName   = "nginx"
+	// NginxConfigPath is the nginx.conf rendered by the init-config init container
+	NginxConfigPath = "/etc/nginx/nginx.conf"
+	// NginxAuthHeaderPlaceholder is replaced with the auth secret value in the rendered nginx.conf
+	NginxAuthHeaderPlaceholder = "__AUTH_HEADER__"
 
 	// Nginx test backend constants
 	NginxTestBackendServiceName = "nginx-test-backend"
@@ -0,0 +1,125 @@
+package testhelpers
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// icapProbeTimeout bounds the connection and exchange of ProbeICAPOptions
+const icapProbeTimeout = 10 * time.Second
+
+// ICAPOptions holds the parsed response to an ICAP OPTIONS request (RFC 3507, section 4.10)
+type ICAPOptions struct {
+	StatusCode int
+	Status     string
+	// Methods lists the ICAP methods the service supports, e.g. REQMOD
+	Methods []string
+	// Allow lists the optional features the service supports, e.g. 204
+	Allow   []string
+	ISTag   string
+	Service string
+	// Preview is the number of body bytes the service wants as preview, or -1 if not advertised
+	Preview int
+	Header  textproto.MIMEHeader
+}
+
+// SupportsMethod reports whether the service advertises the ICAP method
+func (o *ICAPOptions) SupportsMethod(method string) bool {
+	for _, m := range o.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the service advertises the optional feature in its Allow header
+func (o *ICAPOptions) Allows(feature string) bool {
+	for _, a := range o.Allow {
+		if a == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// ProbeICAPOptions sends a raw ICAP OPTIONS request for service (e.g. "reqmod") to the ICAP
+// server at addr (host:port) the way Squid does when it starts, and parses the response headers.
+// A non-200 response is returned with its status rather than as an error, so callers can assert on it.
+//
+// Example usage:
+//
+//	options, err := ProbeICAPOptions(net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(ICAPPort)), ICAPServiceName)
+func ProbeICAPOptions(addr, service string) (*ICAPOptions, error) {
+	conn, err := net.DialTimeout("tcp", addr, icapProbeTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ICAP server %s: %w", addr, err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(icapProbeTimeout)); err != nil {
+		return nil, fmt.Errorf("failed to set deadline: %w", err)
+	}
+
+	request := fmt.Sprintf("OPTIONS icap://%s/%s ICAP/1.0\r\nHost: %s\r\nEncapsulated: null-body=0\r\n\r\n",
+		addr, strings.TrimPrefix(service, "/"), addr)
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return nil, fmt.Errorf("failed to send ICAP OPTIONS request: %w", err)
+	}
+
+	return readICAPOptions(textproto.NewReader(bufio.NewReader(conn)))
+}
+
+// readICAPOptions parses the status line and headers of an ICAP OPTIONS response
+func readICAPOptions(r *textproto.Reader) (*ICAPOptions, error) {
+	statusLine, err := r.ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ICAP status line: %w", err)
+	}
+	proto, status, found := strings.Cut(statusLine, " ")
+	if !found || !strings.HasPrefix(proto, "ICAP/") {
+		return nil, fmt.Errorf("malformed ICAP status line %q", statusLine)
+	}
+	codeStr, _, _ := strings.Cut(status, " ")
+	code, err := strconv.Atoi(codeStr)
+	if err != nil {
+		return nil, fmt.Errorf("malformed ICAP status code in %q: %w", statusLine, err)
+	}
+
+	header, err := r.ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ICAP response headers: %w", err)
+	}
+
+	options := &ICAPOptions{
+		StatusCode: code,
+		Status:     status,
+		Methods:    splitICAPList(header.Get("Methods")),
+		Allow:      splitICAPList(header.Get("Allow")),
+		ISTag:      header.Get("ISTag"),
+		Service:    header.Get("Service"),
+		Preview:    -1,
+		Header:     header,
+	}
+	if preview := header.Get("Preview"); preview != "" {
+		if options.Preview, err = strconv.Atoi(preview); err != nil {
+			return nil, fmt.Errorf("malformed ICAP Preview header %q: %w", preview, err)
+		}
+	}
+	return options, nil
+}
+
+// splitICAPList splits a comma-separated ICAP header value, trimming spaces around the elements
+func splitICAPList(value string) []string {
+	var elements []string
+	for _, element := range strings.Split(value, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
@@ -0,0 +1,104 @@
+package testhelpers
+
+import (
+	"bufio"
+	"net"
+	"net/textproto"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// serveICAPResponse accepts one connection on a local listener, reads an ICAP request and replies
+// with response. It returns the listener address and a channel receiving the request line.
+func serveICAPResponse(response string) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	DeferCleanup(listener.Close)
+
+	requestLines := make(chan string, 1)
+	go func() {
+		defer GinkgoRecover()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := textproto.NewReader(bufio.NewReader(conn))
+		line, err := r.ReadLine()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = r.ReadMIMEHeader()
+		Expect(err).NotTo(HaveOccurred())
+		requestLines <- line
+		_, _ = conn.Write([]byte(response))
+	}()
+	return listener.Addr().String(), requestLines
+}
+
+var _ = Describe("ProbeICAPOptions", func() {
+	It("parses the advertised methods, features and ISTag", func() {
+		addr, requestLines := serveICAPResponse("ICAP/1.0 200 OK\r\n" +
+			"Methods: REQMOD\r\n" +
+			"Allow: 204\r\n" +
+			"ISTag: \"SQUID-ICAP-REQMOD\"\r\n" +
+			"Service: Squid ICAP REQMOD\r\n" +
+			"Preview: 0\r\n" +
+			"Encapsulated: null-body=0\r\n\r\n")
+
+		options, err := ProbeICAPOptions(addr, "/"+ICAPServiceName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(<-requestLines).To(Equal("OPTIONS icap://" + addr + "/reqmod ICAP/1.0"))
+
+		Expect(options.StatusCode).To(Equal(200))
+		Expect(options.Methods).To(Equal([]string{"REQMOD"}))
+		Expect(options.SupportsMethod("REQMOD")).To(BeTrue())
+		Expect(options.SupportsMethod("RESPMOD")).To(BeFalse())
+		Expect(options.Allows("204")).To(BeTrue())
+		Expect(options.ISTag).To(Equal(`"SQUID-ICAP-REQMOD"`))
+		Expect(options.Service).To(Equal("Squid ICAP REQMOD"))
+		Expect(options.Preview).To(Equal(0))
+	})
+
+	It("splits header lists and defaults the preview when not advertised", func() {
+		addr, _ := serveICAPResponse("ICAP/1.0 200 OK\r\nMethods: REQMOD, RESPMOD\r\nAllow: 204, trailers\r\n\r\n")
+
+		options, err := ProbeICAPOptions(addr, ICAPServiceName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(options.Methods).To(Equal([]string{"REQMOD", "RESPMOD"}))
+		Expect(options.Allow).To(Equal([]string{"204", "trailers"}))
+		Expect(options.Preview).To(Equal(-1))
+		Expect(options.ISTag).To(BeEmpty())
+	})
+
+	It("returns non-200 responses with their status", func() {
+		addr, _ := serveICAPResponse("ICAP/1.0 404 ICAP Service Not Found\r\nISTag: \"x\"\r\n\r\n")
+
+		options, err := ProbeICAPOptions(addr, "unknown")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(options.StatusCode).To(Equal(404))
+		Expect(options.Status).To(Equal("404 ICAP Service Not Found"))
+	})
+
+	DescribeTable("rejects malformed responses",
+		func(response, message string) {
+			addr, _ := serveICAPResponse(response)
+
+			_, err := ProbeICAPOptions(addr, ICAPServiceName)
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("HTTP status line", "HTTP/1.1 200 OK\r\n\r\n", "malformed ICAP status line"),
+		Entry("non-numeric status", "ICAP/1.0 OK\r\n\r\n", "malformed ICAP status code"),
+		Entry("non-numeric preview", "ICAP/1.0 200 OK\r\nPreview: all\r\n\r\n", "malformed ICAP Preview header"),
+		Entry("closed connection", "", "failed to read ICAP status line"),
+	)
+
+	It("fails when the server is unreachable", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		addr := listener.Addr().String()
+		Expect(listener.Close()).To(Succeed())
+
+		_, err = ProbeICAPOptions(addr, ICAPServiceName)
+		Expect(err).To(MatchError(ContainSubstring("failed to connect to ICAP server " + addr)))
+	})
+})
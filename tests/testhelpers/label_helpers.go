@@ -0,0 +1,45 @@
+package testhelpers
+
+import (
+	"strings"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Recommended Kubernetes labels set by the chart's label helpers
+const (
+	labelName      = "app.kubernetes.io/name"
+	labelInstance  = "app.kubernetes.io/instance"
+	labelComponent = "app.kubernetes.io/component"
+	labelVersion   = "app.kubernetes.io/version"
+	labelManagedBy = "app.kubernetes.io/managed-by"
+	labelHelmChart = "helm.sh/chart"
+)
+
+// AssertStandardLabels asserts that obj carries the full label set of the chart's
+// caching.<component>.labels helpers: the selector labels for name and component plus the
+// chart, version and managed-by labels. obj can be a typed object or *unstructured.Unstructured.
+//
+// Example usage:
+//
+//	AssertStandardLabels(statefulSet, SquidStatefulSetName, SquidComponentLabel)
+func AssertStandardLabels(obj metav1.Object, name, component string) {
+	labels := obj.GetLabels()
+	description := obj.GetName()
+
+	AssertSelectorLabels(labels, name, component, description)
+	Expect(labels).To(HaveKeyWithValue(labelManagedBy, "Helm"), "%s should be managed by Helm", description)
+	Expect(labels).To(HaveKey(labelVersion), "%s should have the %s label", description, labelVersion)
+	Expect(labels[labelHelmChart]).To(HavePrefix("caching-"), "%s should have the %s label", description, labelHelmChart)
+}
+
+// AssertSelectorLabels asserts that labels contain the selector labels of the chart's
+// caching.<component>.selectorLabels helpers. It applies to pod templates, service selectors and
+// affinity terms, which only carry the selector labels. description identifies the labels in
+// failure messages.
+func AssertSelectorLabels(labels map[string]string, name, component, description string) {
+	Expect(labels).To(HaveKeyWithValue(labelName, name), "%s should have %s=%s", description, labelName, name)
+	Expect(labels).To(HaveKeyWithValue(labelComponent, component), "%s should have %s=%s", description, labelComponent, component)
+	Expect(strings.TrimSpace(labels[labelInstance])).NotTo(BeEmpty(), "%s should have the %s label", description, labelInstance)
+}
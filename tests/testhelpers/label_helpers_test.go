@@ -0,0 +1,93 @@
+package testhelpers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var _ = Describe("AssertStandardLabels", func() {
+	standardLabels := func() map[string]string {
+		return map[string]string{
+			"app.kubernetes.io/name":       SquidStatefulSetName,
+			"app.kubernetes.io/instance":   "test-release",
+			"app.kubernetes.io/component":  SquidComponentLabel,
+			"app.kubernetes.io/version":    "6.10",
+			"app.kubernetes.io/managed-by": "Helm",
+			"helm.sh/chart":                "caching-0.1.0",
+		}
+	}
+
+	It("should accept typed objects with the full label set", func() {
+		statefulSet := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "squid", Labels: standardLabels()}}
+		Expect(InterceptGomegaFailures(func() {
+			AssertStandardLabels(statefulSet, SquidStatefulSetName, SquidComponentLabel)
+		})).To(BeEmpty())
+	})
+
+	It("should accept unstructured objects with the full label set", func() {
+		obj := &unstructured.Unstructured{}
+		obj.SetName("squid")
+		obj.SetLabels(standardLabels())
+		Expect(InterceptGomegaFailures(func() {
+			AssertStandardLabels(obj, SquidStatefulSetName, SquidComponentLabel)
+		})).To(BeEmpty())
+	})
+
+	DescribeTable("should fail when a label is missing or wrong",
+		func(key, value string) {
+			labels := standardLabels()
+			if value == "" {
+				delete(labels, key)
+			} else {
+				labels[key] = value
+			}
+			statefulSet := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "squid", Labels: labels}}
+
+			failures := InterceptGomegaFailures(func() {
+				AssertStandardLabels(statefulSet, SquidStatefulSetName, SquidComponentLabel)
+			})
+			Expect(failures).To(ContainElement(ContainSubstring(key)))
+		},
+		Entry("wrong name", "app.kubernetes.io/name", "nginx"),
+		Entry("wrong component", "app.kubernetes.io/component", NginxComponentLabel),
+		Entry("missing instance", "app.kubernetes.io/instance", ""),
+		Entry("missing version", "app.kubernetes.io/version", ""),
+		Entry("missing chart", "helm.sh/chart", ""),
+		Entry("other chart", "helm.sh/chart", "other-0.1.0"),
+	)
+
+	It("should fail when the object is not managed by Helm", func() {
+		labels := standardLabels()
+		labels["app.kubernetes.io/managed-by"] = "kubectl"
+		statefulSet := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "squid", Labels: labels}}
+
+		failures := InterceptGomegaFailures(func() {
+			AssertStandardLabels(statefulSet, SquidStatefulSetName, SquidComponentLabel)
+		})
+		Expect(failures).To(ConsistOf(ContainSubstring("squid should be managed by Helm")))
+	})
+})
+
+var _ = Describe("AssertSelectorLabels", func() {
+	It("should only require the selector labels", func() {
+		selector := map[string]string{
+			"app.kubernetes.io/name":      NginxStatefulSetName,
+			"app.kubernetes.io/instance":  "test-release",
+			"app.kubernetes.io/component": NginxComponentLabel,
+		}
+		Expect(InterceptGomegaFailures(func() {
+			AssertSelectorLabels(selector, NginxStatefulSetName, NginxComponentLabel, "nginx selector")
+		})).To(BeEmpty())
+	})
+
+	It("should name the labels in failure messages", func() {
+		failures := InterceptGomegaFailures(func() {
+			AssertSelectorLabels(map[string]string{}, NginxStatefulSetName, NginxComponentLabel, "nginx selector")
+		})
+		Expect(failures).To(HaveLen(3))
+		Expect(failures[0]).To(ContainSubstring("nginx selector should have app.kubernetes.io/name=nginx"))
+	})
+})
@@ -12,7 +12,11 @@ import (
 	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
 	certmanagermeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
 	certmanagerclient "github.com/cert-manager/cert-manager/pkg/client/clientset/versioned"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 // NginxValues holds Helm values for nginx configuration
@@ -45,8 +49,16 @@ type NginxTLSValues struct {
 
 // NginxUpstreamValues holds upstream server configuration
 type NginxUpstreamValues struct {
-	URL         string `json:"url,omitempty"`
-	ReadTimeout string `json:"readTimeout,omitempty"`
+	URL         string                `json:"url,omitempty"`
+	Servers     []NginxUpstreamServer `json:"servers,omitempty"`
+	HostHeader  string                `json:"hostHeader,omitempty"`
+	ReadTimeout string                `json:"readTimeout,omitempty"`
+}
+
+// NginxUpstreamServer is a member of the load balanced upstream group
+type NginxUpstreamServer struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight,omitempty"`
 }
 
 // NginxAuthValues holds authorization header injection configuration
@@ -57,9 +69,58 @@ type NginxAuthValues struct {
 
 // NginxCacheValues holds cache configuration
 type NginxCacheValues struct {
-	AllowList []string `json:"allowList,omitempty"`
-	Size      int      `json:"size,omitempty"`
-	TTL       string   `json:"ttl,omitempty"`
+	AllowList  []NginxCacheAllowListEntry `json:"allowList,omitempty"`
+	Size       int                        `json:"size,omitempty"`
+	TTL        string                     `json:"ttl,omitempty"`
+	ServeStale *NginxServeStaleValues     `json:"serveStale,omitempty"`
+	// BypassHeader is a request header that, when set to a non-empty value other than "0",
+	// forces cached locations to fetch from the redirect target
+	BypassHeader string `json:"bypassHeader,omitempty"`
+	// Preload lists paths a sidecar requests through nginx on startup to warm the cache
+	Preload []string `json:"preload,omitempty"`
+}
+
+// NginxServeStaleValues holds stale content serving configuration.
+// An unset UseStale keeps the chart default conditions.
+type NginxServeStaleValues struct {
+	UseStale         []string `json:"useStale,omitempty"`
+	BackgroundUpdate bool     `json:"backgroundUpdate,omitempty"`
+}
+
+// NginxCacheAllowListEntry is a cached path pattern with an optional TTL overriding NginxCacheValues.TTL.
+// Entries without a TTL are rendered as plain pattern strings.
+type NginxCacheAllowListEntry struct {
+	Pattern string `json:"pattern"`
+	TTL     string `json:"ttl,omitempty"`
+}
+
+// MarshalJSON renders entries without a TTL as plain pattern strings
+func (e NginxCacheAllowListEntry) MarshalJSON() ([]byte, error) {
+	if e.TTL == "" {
+		return json.Marshal(e.Pattern)
+	}
+	type entry NginxCacheAllowListEntry
+	return json.Marshal(entry(e))
+}
+
+// UnmarshalJSON accepts both plain pattern strings and {pattern, ttl} objects
+func (e *NginxCacheAllowListEntry) UnmarshalJSON(data []byte) error {
+	var pattern string
+	if err := json.Unmarshal(data, &pattern); err == nil {
+		*e = NginxCacheAllowListEntry{Pattern: pattern}
+		return nil
+	}
+	type entry NginxCacheAllowListEntry
+	return json.Unmarshal(data, (*entry)(e))
+}
+
+// NginxAllowListPatterns builds allowList entries that use the default cache TTL
+func NginxAllowListPatterns(patterns ...string) []NginxCacheAllowListEntry {
+	entries := make([]NginxCacheAllowListEntry, 0, len(patterns))
+	for _, pattern := range patterns {
+		entries = append(entries, NginxCacheAllowListEntry{Pattern: pattern})
+	}
+	return entries
 }
 
 // NginxServiceValues holds service configuration
@@ -70,6 +131,28 @@ type NginxServiceValues struct {
 	Annotations         map[string]string `json:"annotations,omitempty"`
 }
 
+// WaitForNginxStatefulSetReady waits for the nginx statefulset to have all replicas ready,
+// ignoring pods that are still terminating from a rolling update
+func WaitForNginxStatefulSetReady(ctx context.Context, client kubernetes.Interface, namespace string) (*v1.StatefulSet, error) {
+	return WaitForStatefulSetReadyInNamespace(ctx, client, namespace, NginxStatefulSetName)
+}
+
+// GetNginxPods returns the running and ready nginx pods once their count matches the
+// statefulset replicas, excluding pods that are terminating during rolling updates
+func GetNginxPods(ctx context.Context, client kubernetes.Interface, namespace string) ([]*corev1.Pod, error) {
+	pods, err := GetPods(ctx, client, namespace, NginxStatefulSetName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pod := range pods {
+		if component := pod.Labels["app.kubernetes.io/component"]; component != NginxComponentLabel {
+			return nil, fmt.Errorf("pod %s has component label %q, expected %q", pod.Name, component, NginxComponentLabel)
+		}
+	}
+	return pods, nil
+}
+
 // NewNginxClient creates an HTTP client for requests to nginx
 func NewNginxClient() *http.Client {
 	transport := &http.Transport{
@@ -150,3 +233,19 @@ func CreateNginxCertificate(ctx context.Context, client *certmanagerclient.Clien
 func DeleteNginxCertificate(ctx context.Context, client *certmanagerclient.Clientset) error {
 	return client.CertmanagerV1().Certificates(Namespace).Delete(ctx, "nginx-cert", metav1.DeleteOptions{})
 }
+
+// GetNginxRenderedConfig returns the nginx.conf of the nginx container in pod, as rendered at
+// startup by the init-config init container from the ConfigMap template. Unlike the template,
+// it holds the substituted resolver and Authorization header values.
+func GetNginxRenderedConfig(ctx context.Context, client kubernetes.Interface, restConfig *rest.Config,
+	namespace, pod string) (string, error) {
+	stdout, stderr, err := ExecCommandInPod(ctx, client, restConfig, namespace, pod, NginxContainerName,
+		[]string{"cat", NginxConfigPath})
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from pod %s: %w: %s", NginxConfigPath, pod, err, stderr)
+	}
+	if stdout == "" {
+		return "", fmt.Errorf("%s is empty in pod %s", NginxConfigPath, pod)
+	}
+	return stdout, nil
+}
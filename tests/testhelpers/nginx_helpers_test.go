@@ -0,0 +1,138 @@
+package testhelpers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// nginxTestObjects returns a ready nginx statefulset with the given replicas and matching pods
+func nginxTestObjects(namespace string, replicas int32) []runtime.Object {
+	labels := map[string]string{
+		"app.kubernetes.io/name":      "caching",
+		"app.kubernetes.io/component": NginxComponentLabel,
+	}
+	objects := []runtime.Object{&v1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: NginxStatefulSetName, Namespace: namespace, Labels: labels},
+		Spec:       v1.StatefulSetSpec{Replicas: &replicas},
+		Status:     v1.StatefulSetStatus{ReadyReplicas: replicas},
+	}}
+	for i := int32(0); i < replicas; i++ {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s-%d", NginxStatefulSetName, i),
+				Namespace: namespace,
+				Labels:    labels,
+			},
+			Status: corev1.PodStatus{
+				Phase:             corev1.PodRunning,
+				ContainerStatuses: []corev1.ContainerStatus{{Name: "nginx", Ready: true}},
+			},
+		})
+	}
+	return objects
+}
+
+var _ = Describe("GetNginxPods", func() {
+	It("should return the ready nginx pods in the given namespace", func() {
+		client := fake.NewClientset(nginxTestObjects("custom-ns", 2)...)
+
+		pods, err := GetNginxPods(context.Background(), client, "custom-ns")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pods).To(HaveLen(2))
+		for _, pod := range pods {
+			Expect(pod.Namespace).To(Equal("custom-ns"))
+		}
+	})
+})
+
+var _ = Describe("WaitForNginxStatefulSetReady", func() {
+	It("should return the nginx statefulset once all replicas are ready", func() {
+		client := fake.NewClientset(nginxTestObjects("custom-ns", 1)...)
+
+		statefulSet, err := WaitForNginxStatefulSetReady(context.Background(), client, "custom-ns")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(statefulSet.Name).To(Equal(NginxStatefulSetName))
+		Expect(statefulSet.Namespace).To(Equal("custom-ns"))
+	})
+})
+
+var _ = Describe("NginxCacheAllowListEntry", func() {
+	It("should marshal entries without a TTL as plain strings", func() {
+		data, err := json.Marshal([]NginxCacheAllowListEntry{
+			{Pattern: "^/content/"},
+			{Pattern: "^/releases/", TTL: "30d"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal(`["^/content/",{"pattern":"^/releases/","ttl":"30d"}]`))
+	})
+
+	It("should unmarshal both plain strings and objects", func() {
+		var entries []NginxCacheAllowListEntry
+		Expect(json.Unmarshal([]byte(`["^/content/",{"pattern":"^/releases/","ttl":"30d"}]`), &entries)).To(Succeed())
+		Expect(entries).To(Equal([]NginxCacheAllowListEntry{
+			{Pattern: "^/content/"},
+			{Pattern: "^/releases/", TTL: "30d"},
+		}))
+	})
+
+	It("should build default-TTL entries from patterns", func() {
+		Expect(NginxAllowListPatterns("^/a/", "^/b/")).To(Equal([]NginxCacheAllowListEntry{
+			{Pattern: "^/a/"},
+			{Pattern: "^/b/"},
+		}))
+	})
+})
+
+var _ = Describe("GetNginxRenderedConfig", func() {
+	var (
+		client   kubernetes.Interface
+		executor *fakeExecutor
+	)
+
+	BeforeEach(func() {
+		var err error
+		client, err = kubernetes.NewForConfig(&rest.Config{Host: "http://127.0.0.1:1"})
+		Expect(err).NotTo(HaveOccurred())
+
+		executor = &fakeExecutor{exit: true}
+		old := newExecutor
+		newExecutor = func(*rest.Config, string, *url.URL) (remotecommand.Executor, error) { return executor, nil }
+		DeferCleanup(func() { newExecutor = old })
+	})
+
+	It("should return the rendered configuration", func() {
+		executor.stdout = "proxy_set_header Authorization \"Basic dGVzdA==\";\n"
+
+		config, err := GetNginxRenderedConfig(context.Background(), client, &rest.Config{}, "caching", "nginx-0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config).To(Equal(executor.stdout))
+	})
+
+	It("should include stderr when the file cannot be read", func() {
+		executor.stderr = "cat: /etc/nginx/nginx.conf: No such file or directory"
+		executor.err = errors.New("command terminated with exit code 1")
+
+		_, err := GetNginxRenderedConfig(context.Background(), client, &rest.Config{}, "caching", "nginx-0")
+		Expect(err).To(MatchError(ContainSubstring("nginx-0")))
+		Expect(err).To(MatchError(ContainSubstring("No such file or directory")))
+	})
+
+	It("should reject an empty configuration", func() {
+		_, err := GetNginxRenderedConfig(context.Background(), client, &rest.Config{}, "caching", "nginx-0")
+		Expect(err).To(MatchError(ContainSubstring("is empty")))
+	})
+})
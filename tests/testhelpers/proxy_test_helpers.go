@@ -1,12 +1,15 @@
 package testhelpers
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -17,22 +20,35 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	certmanagermeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	certmanagerclient "github.com/cert-manager/cert-manager/pkg/client/clientset/versioned"
 	"github.com/google/go-containerregistry/pkg/name"
+	ggcrv1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	"sigs.k8s.io/yaml"
 
+	"github.com/konflux-ci/caching/internal/storeid"
+	"github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 	"github.com/prometheus/common/model"
+	"golang.org/x/net/http2"
 	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -47,6 +63,9 @@ type TestServerResponse struct {
 	Timestamp  float64 `json:"timestamp"`
 	ServerHits float64 `json:"server_hits"`
 	SquidPod   string  `json:"squid_pod"` // extracted from Via header
+	// Headers are the request headers received by the test server, used to verify what
+	// Squid and the ICAP server forwarded to the origin
+	Headers http.Header `json:"headers,omitempty"`
 }
 
 // CachingTestServer wraps an HTTP test server with request counting and caching-friendly configuration
@@ -55,14 +74,20 @@ type CachingTestServer struct {
 	RequestCount *int32
 	PodIP        string
 	URL          string
+	// responseDelay holds the nanoseconds each response is delayed by, see SetResponseDelay
+	responseDelay *atomic.Int64
 }
 
+// SquidPodHeader is the response header naming the serving Squid pod when forwarding.podHeader is enabled
+const SquidPodHeader = "X-Squid-Pod"
+
 // ExtractSquidPodFromViaHeader extracts the Squid pod name from the Via response header
 // Via header format: "1.1 squid-<pod-name> (squid/<version>)"
+// When Via is disabled, it falls back to the X-Squid-Pod response header.
 func ExtractSquidPodFromViaHeader(resp *http.Response) string {
 	viaHeader := resp.Header.Get("Via")
 	if viaHeader == "" {
-		return ""
+		return resp.Header.Get(SquidPodHeader)
 	}
 
 	// Parse "1.1 hostname (squid/version)" format and return the hostname (second field)
@@ -74,6 +99,303 @@ func ExtractSquidPodFromViaHeader(resp *http.Response) string {
 	return ""
 }
 
+// ViaHeaderInfo holds the fields parsed from a Squid Via header entry
+type ViaHeaderInfo struct {
+	Protocol string // e.g. "1.1"
+	Pod      string // e.g. "squid-0"
+	Version  string // e.g. "6.10"
+}
+
+// ParseViaHeader parses the Squid entry of the Via response header.
+// Via header format: "1.1 squid-<pod-name> (squid/<version>)"
+// When the header lists several hops, the first entry with a "(squid/<version>)" comment is used.
+func ParseViaHeader(resp *http.Response) (*ViaHeaderInfo, error) {
+	viaHeader := resp.Header.Get("Via")
+	if viaHeader == "" {
+		return nil, fmt.Errorf("via header not found")
+	}
+
+	for _, entry := range strings.Split(viaHeader, ",") {
+		parts := strings.Fields(entry)
+		if len(parts) != 3 {
+			continue
+		}
+
+		comment := parts[2]
+		if !strings.HasPrefix(comment, "(squid/") || !strings.HasSuffix(comment, ")") {
+			continue
+		}
+
+		version := strings.TrimSuffix(strings.TrimPrefix(comment, "(squid/"), ")")
+		if version == "" {
+			continue
+		}
+
+		return &ViaHeaderInfo{
+			Protocol: parts[0],
+			Pod:      parts[1],
+			Version:  version,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("malformed via header: %q", viaHeader)
+}
+
+// ExtractSquidVersionFromViaHeader extracts the Squid version from the Via response header
+func ExtractSquidVersionFromViaHeader(resp *http.Response) (string, error) {
+	via, err := ParseViaHeader(resp)
+	if err != nil {
+		return "", err
+	}
+	return via.Version, nil
+}
+
+// CountDistinctServingPods makes n GET requests to url through client and tallies the Squid pods
+// that served them, keyed by the pod name from the Via header (see ExtractSquidPodFromViaHeader).
+// Every request uses a new connection, since the Service balances connections rather than requests
+// and a reused connection would always reach the same pod.
+//
+// Example usage:
+//
+//	servedBy, err := CountDistinctServingPods(client, testURL, 20)
+//	Expect(len(servedBy)).To(BeNumerically(">", 1))
+func CountDistinctServingPods(client *http.Client, url string, n int) (map[string]int, error) {
+	servedBy := make(map[string]int)
+	for i := range n {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Close = true
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return servedBy, fmt.Errorf("request %d/%d failed: %w", i+1, n, err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		pod := ExtractSquidPodFromViaHeader(resp)
+		if pod == "" {
+			return servedBy, fmt.Errorf("request %d/%d: response does not name the serving squid pod", i+1, n)
+		}
+		servedBy[pod]++
+	}
+	return servedBy, nil
+}
+
+// LoadResult summarizes the requests made by RunConcurrentProxyLoad
+type LoadResult struct {
+	// Requests is the number of requests made, with or without a response
+	Requests int
+	// Successes is the number of 2xx responses
+	Successes int
+	// Statuses counts the responses by HTTP status code
+	Statuses map[int]int
+	// Errors holds the errors of requests that got no complete response
+	Errors []error
+	// Pods counts the responses by the squid pod named in their Via header
+	Pods map[string]int
+}
+
+// RunConcurrentProxyLoad makes workers*iterations GET requests through client from workers
+// goroutines, cycling through urls with each worker starting at a different URL so that the
+// same URL is requested concurrently. Failed requests are recorded in the result rather than
+// stopping the load; an error is only returned for invalid arguments.
+//
+// Example usage:
+//
+//	result, err := RunConcurrentProxyLoad(client, urls, 10, 5)
+//	Expect(result.Errors).To(BeEmpty())
+//	Expect(result.Successes).To(Equal(50))
+func RunConcurrentProxyLoad(client *http.Client, urls []string, workers, iterations int) (*LoadResult, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("no URLs to request")
+	}
+	if workers < 1 || iterations < 1 {
+		return nil, fmt.Errorf("workers and iterations must be positive, got %d and %d", workers, iterations)
+	}
+
+	result := &LoadResult{
+		Statuses: make(map[int]int),
+		Pods:     make(map[string]int),
+	}
+	var (
+		mutex sync.Mutex
+		wg    sync.WaitGroup
+	)
+	record := func(url string, resp *http.Response, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		result.Requests++
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("GET %s: %w", url, err))
+			return
+		}
+		result.Statuses[resp.StatusCode]++
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			result.Successes++
+		}
+		if pod := ExtractSquidPodFromViaHeader(resp); pod != "" {
+			result.Pods[pod]++
+		}
+	}
+
+	for worker := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range iterations {
+				url := urls[(worker+i)%len(urls)]
+				resp, err := client.Get(url)
+				if err == nil {
+					// Read the whole body so that truncated responses are reported as errors
+					_, err = io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}
+				record(url, resp, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return result, nil
+}
+
+// AssertCollapsedForwarding fails the current test unless n concurrent requests through client
+// for the uncached url all succeed while server receives at most maxOriginRequests of them.
+// The server delays its responses by delay so that the requests overlap at the proxy; with
+// several replicas, each pod may fetch once, so pass the replica count as maxOriginRequests.
+//
+// Example usage:
+//
+//	AssertCollapsedForwarding(client, testServer, testServer.URL+"?fresh", 20, replicas, 3*time.Second)
+func AssertCollapsedForwarding(client *http.Client, server *CachingTestServer, url string, n int, maxOriginRequests int32, delay time.Duration) {
+	server.SetResponseDelay(delay)
+	defer server.SetResponseDelay(0)
+
+	initialServerHits := server.GetRequestCount()
+	result, err := RunConcurrentProxyLoad(client, []string{url}, n, 1)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(result.Errors).To(BeEmpty(), "No concurrent request should fail")
+	Expect(result.Successes).To(Equal(n), "Every concurrent request should succeed, got statuses %v", result.Statuses)
+
+	originRequests := server.GetRequestCount() - initialServerHits
+	fmt.Printf("🔍 DEBUG: %d concurrent requests caused %d origin request(s), served per pod: %v\n", n, originRequests, result.Pods)
+	Expect(originRequests).To(BeNumerically("<=", maxOriginRequests),
+		"Concurrent requests for the same URL should be collapsed into at most %d origin request(s)", maxOriginRequests)
+}
+
+// maxStaleWait bounds how long ShutdownAndAssertStaleHit waits for a cached response to expire
+const maxStaleWait = time.Minute
+
+// ShutdownAndAssertStaleHit fails the current test unless client is still served the cached
+// response for url after server shuts down. It requests url so its response is cached, closes
+// server, waits for the response's max-age to expire and requests url again: Squid then fails to
+// revalidate the object and serves it stale, as allowed by max_stale (cache.maxStaleSeconds).
+// Give the response a max-age of at most maxStaleWait with CachingTestServerOptions. With several
+// replicas, client must keep connections alive (ClientOptions.KeepAlive) so that both requests
+// reach the same Squid pod.
+//
+// Example usage:
+//
+//	server := NewCachingTestServerWithOptions(message, podIP, 0, CachingTestServerOptions{
+//		Headers: http.Header{"Cache-Control": {"public, max-age=2"}},
+//	})
+//	ShutdownAndAssertStaleHit(server, client, server.URL+"/stale?"+cacheBuster)
+func ShutdownAndAssertStaleHit(server *CachingTestServer, client *http.Client, url string) {
+	resp, cachedBody, err := MakeCachingRequest(client, url)
+	if !Expect(err).NotTo(HaveOccurred(), "Request with the origin up should succeed") {
+		return
+	}
+	resp.Body.Close()
+	Expect(resp.StatusCode).To(Equal(http.StatusOK), "Request with the origin up should succeed")
+	pod := ExtractSquidPodFromViaHeader(resp)
+
+	freshness := responseFreshness(resp)
+	if !Expect(freshness).To(BeNumerically("<=", maxStaleWait),
+		"The response should expire within %s, use a shorter max-age", maxStaleWait) {
+		return
+	}
+
+	server.Close()
+	fmt.Printf("🔍 DEBUG: Origin closed, waiting %s for the cached response to expire\n", freshness)
+	time.Sleep(freshness)
+
+	resp, staleBody, err := MakeCachingRequest(client, url)
+	if !Expect(err).NotTo(HaveOccurred(), "Request with the origin down should be served from the cache") {
+		return
+	}
+	resp.Body.Close()
+	Expect(ExtractSquidPodFromViaHeader(resp)).To(Equal(pod), "Both requests should reach the same Squid pod")
+	Expect(resp.StatusCode).To(Equal(http.StatusOK), "The stale object should be served with the origin down")
+	Expect(staleBody).To(Equal(cachedBody), "The stale object should be the cached response")
+}
+
+// responseFreshness returns how long resp stays fresh according to its Cache-Control max-age
+// and Age headers, plus a second since both only have a resolution of seconds
+func responseFreshness(resp *http.Response) time.Duration {
+	var maxAge int
+	for _, directive := range strings.Split(strings.Join(resp.Header.Values("Cache-Control"), ","), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if strings.EqualFold(name, "max-age") {
+			maxAge, _ = strconv.Atoi(value)
+		}
+	}
+	age, _ := strconv.Atoi(resp.Header.Get("Age"))
+	return time.Duration(max(maxAge-age, 0)+1) * time.Second
+}
+
+// CacheabilityResult reports whether Squid cached a response, see CheckCacheability
+type CacheabilityResult struct {
+	// Cached is true when Squid served one of the requests from its cache
+	Cached bool
+	// Requests is the number of requests made through the proxy
+	Requests int
+	// OriginRequests is the number of those requests that reached the origin server
+	OriginRequests int32
+}
+
+// CheckCacheability requests url from server through client replicas+1 times, so that at least
+// one pod receives it twice, and reports whether Squid served any request from its cache.
+// header is sent with every request, e.g. an Authorization header. Configure the response
+// headers under test with NewCachingTestServerWithOptions; url must not have been requested before.
+//
+// Example usage:
+//
+//	result, err := CheckCacheability(client, server, server.URL+"?"+cacheBuster, nil, replicas)
+//	Expect(result.Cached).To(BeFalse(), "no-store responses must not be cached")
+func CheckCacheability(client *http.Client, server *CachingTestServer, url string, header http.Header, replicas int32) (*CacheabilityResult, error) {
+	result := &CacheabilityResult{}
+	initialServerHits := server.GetRequestCount()
+	for i := range int(replicas) + 1 {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("request %d failed: %w", i+1, err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("request %d: unexpected status %s", i+1, resp.Status)
+		}
+
+		result.Requests++
+		if IsSquidCacheHit(resp) {
+			result.Cached = true
+		}
+	}
+	result.OriginRequests = server.GetRequestCount() - initialServerHits
+	return result, nil
+}
+
 // CacheHitResult contains the results of finding a cache hit from a pod
 type CacheHitResult struct {
 	CacheHitFound    bool
@@ -147,6 +469,55 @@ func FindCacheHitFromAnyPod(client *http.Client, testURL string, replicaCount in
 
 }
 
+// AssertAllPodsCache asserts that every squid pod independently caches testURL. Unlike
+// FindCacheHitFromAnyPod, which stops at the first pod serving a hit, it requests testURL twice
+// through each pod (addressed by pod IP rather than the service) and fails listing every pod whose
+// second request was not served from its cache, so a single misconfigured pod cannot be masked.
+// replicas is the expected number of squid pods.
+func AssertAllPodsCache(ctx context.Context, client kubernetes.Interface, namespace, testURL string, replicas int32) {
+	pods, err := GetPods(ctx, client, namespace, SquidStatefulSetName)
+	Expect(err).NotTo(HaveOccurred(), "Should get squid pods")
+	Expect(pods).To(HaveLen(int(replicas)), "Should find one squid pod per replica")
+
+	var failures []string
+	for _, pod := range pods {
+		httpClient, err := newPodProxyClient(pod)
+		Expect(err).NotTo(HaveOccurred())
+
+		var responses [2]*TestServerResponse
+		for i := range responses {
+			resp, body, err := MakeCachingRequest(httpClient, testURL)
+			Expect(err).NotTo(HaveOccurred(), "Request %d through pod %s should succeed", i+1, pod.Name)
+			resp.Body.Close()
+			Expect(ExtractSquidPodFromViaHeader(resp)).To(Equal(pod.Name),
+				"Request %d should be handled by pod %s", i+1, pod.Name)
+			responses[i], err = ParseTestServerResponse(body)
+			Expect(err).NotTo(HaveOccurred(), "Should parse response JSON from pod %s", pod.Name)
+		}
+
+		fmt.Printf("🔍 DEBUG: Pod %s: first request_id=%v, second request_id=%v\n",
+			pod.Name, responses[0].RequestID, responses[1].RequestID)
+		if responses[1].RequestID != responses[0].RequestID {
+			failures = append(failures, fmt.Sprintf("%s (request_id %v, then %v)",
+				pod.Name, responses[0].RequestID, responses[1].RequestID))
+		}
+	}
+	Expect(failures).To(BeEmpty(), "Every squid pod should serve its second request for %s from cache", testURL)
+}
+
+// newPodProxyClient returns an HTTP client using the Squid proxy of pod directly, bypassing the
+// service so the request is handled (and logged) by that pod
+func newPodProxyClient(pod *corev1.Pod) (*http.Client, error) {
+	if pod.Status.PodIP == "" {
+		return nil, fmt.Errorf("pod %s has no IP", pod.Name)
+	}
+	proxyURL := &url.URL{Scheme: "http", Host: net.JoinHostPort(pod.Status.PodIP, "3128")}
+	return &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), DisableKeepAlives: true},
+		Timeout:   30 * time.Second,
+	}, nil
+}
+
 // ValidateCacheHitSamePod verifies that a cached response came from the same pod
 // and has the same request_id as the original
 func ValidateCacheHitSamePod(originalResponse, cachedResponse *TestServerResponse, originalPod, cachedPod string) {
@@ -163,17 +534,89 @@ func ValidateCacheHitSamePod(originalResponse, cachedResponse *TestServerRespons
 		"Cached response should preserve original timestamp")
 }
 
+// CachingTestServerOptions configures the responses of a CachingTestServer
+type CachingTestServerOptions struct {
+	// Headers are set on every response, replacing the default Cache-Control
+	// ("public, max-age=300") and Content-Type headers of the same name.
+	// A header with only an empty value removes the default.
+	Headers http.Header
+	// MaxResponseSize enables responses of an exact size: a request with a size=<bytes> query
+	// parameter of at most MaxResponseSize is answered with a body of that many bytes
+	// (Content-Type application/octet-stream) instead of the JSON response, e.g. to test
+	// cache.maxObjectSize. Larger or invalid sizes are rejected with 400. 0 disables it.
+	MaxResponseSize int64
+}
+
+// requestedResponseSize returns the body size requested by the size query parameter of r,
+// and false when it is absent or sized responses are disabled (maxSize 0)
+func requestedResponseSize(r *http.Request, maxSize int64) (int64, bool, error) {
+	value := r.URL.Query().Get("size")
+	if maxSize <= 0 || value == "" {
+		return 0, false, nil
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 || size > maxSize {
+		return 0, false, fmt.Errorf("invalid size %q: expected 0 to %d bytes", value, maxSize)
+	}
+	return size, true, nil
+}
+
+// writeSizedBody writes size bytes to w in chunks, so large bodies are never held in memory
+func writeSizedBody(w io.Writer, size int64) error {
+	chunk := bytes.Repeat([]byte("x"), 32*1024)
+	for size > 0 {
+		n := min(size, int64(len(chunk)))
+		if _, err := w.Write(chunk[:n]); err != nil {
+			return err
+		}
+		size -= n
+	}
+	return nil
+}
+
 // NewCachingTestServer creates a new test server configured for cross-pod communication
 func NewCachingTestServer(message string, podIP string, port int) (*CachingTestServer, error) {
+	return NewCachingTestServerWithOptions(message, podIP, port, CachingTestServerOptions{})
+}
+
+// NewCachingTestServerWithOptions creates a test server like NewCachingTestServer whose
+// responses are configured by options, e.g. to test cacheability edge cases
+func NewCachingTestServerWithOptions(message string, podIP string, port int, options CachingTestServerOptions) (*CachingTestServer, error) {
 	var requestCount int32
+	responseDelay := &atomic.Int64{}
 
 	// Create HTTP server with request tracking
 	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		count := atomic.AddInt32(&requestCount, 1)
+		time.Sleep(time.Duration(responseDelay.Load()))
+
+		size, sized, err := requestedResponseSize(r, options.MaxResponseSize)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		contentType := "application/json"
+		if sized {
+			contentType = "application/octet-stream"
+		}
 
 		// Add cache headers to make content cacheable
 		w.Header().Set("Cache-Control", "public, max-age=300")
-		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Type", contentType)
+		for name, values := range options.Headers {
+			w.Header().Del(name)
+			for _, value := range values {
+				if value != "" {
+					w.Header().Add(name, value)
+				}
+			}
+		}
+
+		if sized {
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+			_ = writeSizedBody(w, size)
+			return
+		}
 
 		// Return JSON response with request count
 		response := TestServerResponse{
@@ -181,6 +624,7 @@ func NewCachingTestServer(message string, podIP string, port int) (*CachingTestS
 			RequestID:  float64(count),
 			Timestamp:  float64(time.Now().Unix()),
 			ServerHits: float64(count),
+			Headers:    r.Header.Clone(),
 		}
 
 		jsonResponse, _ := json.Marshal(response)
@@ -203,13 +647,20 @@ func NewCachingTestServer(message string, podIP string, port int) (*CachingTestS
 	serverURL := fmt.Sprintf("http://%s:%s", podIP, actualPortStr)
 
 	return &CachingTestServer{
-		Server:       server,
-		RequestCount: &requestCount,
-		PodIP:        podIP,
-		URL:          serverURL,
+		Server:        server,
+		RequestCount:  &requestCount,
+		PodIP:         podIP,
+		URL:           serverURL,
+		responseDelay: responseDelay,
 	}, nil
 }
 
+// SetResponseDelay delays every following response by delay after counting the request,
+// e.g. to keep concurrent requests for the same URL in flight at the same time
+func (pts *CachingTestServer) SetResponseDelay(delay time.Duration) {
+	pts.responseDelay.Store(int64(delay))
+}
+
 // GetRequestCount returns the current request count
 func (pts *CachingTestServer) GetRequestCount() int32 {
 	return atomic.LoadInt32(pts.RequestCount)
@@ -220,8 +671,44 @@ func (pts *CachingTestServer) ResetRequestCount() {
 	atomic.StoreInt32(pts.RequestCount, 0)
 }
 
+// ClientOptions configures the HTTP client created by NewSquidCachingClientWithOptions
+type ClientOptions struct {
+	// DialTimeout bounds each attempt to connect to the proxy, 0 for no limit besides Timeout
+	DialTimeout time.Duration
+	// DialAttempts is how many times connecting to the proxy is tried, e.g. while it starts up.
+	// Only the connection is retried, so requests are never sent twice. 0 means a single attempt.
+	DialAttempts int
+	// DialRetryInterval is the delay between connection attempts
+	DialRetryInterval time.Duration
+	// ResponseHeaderTimeout bounds the wait for response headers once the request is sent, 0 for no limit
+	ResponseHeaderTimeout time.Duration
+	// Timeout bounds the whole request including reading the body, 0 for no limit
+	Timeout time.Duration
+	// KeepAlive reuses connections between requests. Disabled by default so every request opens a
+	// new connection and is balanced across Squid pods independently.
+	KeepAlive bool
+}
+
+// DefaultClientOptions are the options used by NewSquidCachingClient
+var DefaultClientOptions = ClientOptions{
+	Timeout: 30 * time.Second,
+}
+
 // NewSquidCachingClient creates an HTTP client configured to use the Squid caching
 func NewSquidCachingClient(serviceName, namespace string) (*http.Client, error) {
+	return NewSquidCachingClientWithOptions(serviceName, namespace, DefaultClientOptions)
+}
+
+// NewSquidCachingClientWithOptions creates an HTTP client configured to use the Squid caching
+// with the given timeouts, e.g. short ones for tests expecting a request to fail.
+//
+// Example usage:
+//
+//	client, err := NewSquidCachingClientWithOptions(serviceName, namespace, ClientOptions{
+//		DialTimeout: 2 * time.Second,
+//		Timeout:     5 * time.Second,
+//	})
+func NewSquidCachingClientWithOptions(serviceName, namespace string, options ClientOptions) (*http.Client, error) {
 	// Set up caching URL to squid service
 	cachingURL, err := url.Parse(fmt.Sprintf("http://%s.%s.svc.cluster.local:3128", serviceName, namespace))
 	if err != nil {
@@ -230,17 +717,42 @@ func NewSquidCachingClient(serviceName, namespace string) (*http.Client, error)
 
 	// Create HTTP client with caching configuration
 	transport := &http.Transport{
-		Proxy: http.ProxyURL(cachingURL),
-		// Disable keep-alive to ensure fresh connections for cache testing
-		DisableKeepAlives: true,
+		Proxy:                 http.ProxyURL(cachingURL),
+		DialContext:           options.dialContext(),
+		ResponseHeaderTimeout: options.ResponseHeaderTimeout,
+		// Keep-alive is disabled by default to ensure fresh connections for cache testing
+		DisableKeepAlives: !options.KeepAlive,
 	}
 
 	return &http.Client{
 		Transport: transport,
-		Timeout:   30 * time.Second,
+		Timeout:   options.Timeout,
 	}, nil
 }
 
+// dialContext returns a dial function connecting with DialTimeout and retrying up to DialAttempts times
+func (o ClientOptions) dialContext() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: o.DialTimeout}
+	attempts := max(o.DialAttempts, 1)
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var err error
+		for attempt := range attempts {
+			if attempt > 0 {
+				select {
+				case <-ctx.Done():
+					return nil, fmt.Errorf("%w (last dial error: %w)", ctx.Err(), err)
+				case <-time.After(o.DialRetryInterval):
+				}
+			}
+			var conn net.Conn
+			if conn, err = dialer.DialContext(ctx, network, addr); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
 // NewTrustedSquidCachingClient creates an HTTP client configured to use the Squid caching and trust both the Squid CA and test-server CA
 func NewTrustedSquidCachingClient(serviceName, namespace string, squidCACertPEM []byte, testServerCACertPEM []byte) (*http.Client, error) {
 	// Set up caching URL to squid service
@@ -285,6 +797,232 @@ func NewTrustedSquidCachingClient(serviceName, namespace string, squidCACertPEM
 	}, nil
 }
 
+// NewSquidCachingClientHTTP2 creates an HTTP client configured to use the Squid caching that
+// attempts HTTP/2 to HTTPS origins. caPEM holds the CAs to trust, e.g. the Squid CA for SSL-bumped
+// requests. After the CONNECT tunnel is established, h2 is offered via ALPN, so resp.Proto reports
+// the protocol negotiated with Squid's bumped TLS endpoint and tests can catch downgrades.
+func NewSquidCachingClientHTTP2(serviceName, namespace string, caPEM []byte) (*http.Client, error) {
+	cachingURL, err := url.Parse(fmt.Sprintf("http://%s.%s.svc.cluster.local:3128", serviceName, namespace))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse caching URL: %w", err)
+	}
+	return newHTTP2ProxyClient(cachingURL, caPEM)
+}
+
+// newHTTP2ProxyClient creates an HTTP client that tunnels through proxyURL and attempts HTTP/2
+func newHTTP2ProxyClient(proxyURL *url.URL, caPEM []byte) (*http.Client, error) {
+	caCertPool := x509.NewCertPool()
+	if !caCertPool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("failed to append CA certificates to pool")
+	}
+
+	transport := &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{RootCAs: caCertPool},
+		// A custom TLS config disables HTTP/2 unless explicitly requested
+		ForceAttemptHTTP2: true,
+		// Disable keep-alive to ensure fresh connections for cache testing
+		DisableKeepAlives: true,
+	}
+	if _, err := http2.ConfigureTransports(transport); err != nil {
+		return nil, fmt.Errorf("failed to configure HTTP/2 transport: %w", err)
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   30 * time.Second,
+	}, nil
+}
+
+// GetBumpedServerCert opens a CONNECT tunnel to targetHost through the proxy at proxyURL and
+// returns the leaf certificate presented in the TLS handshake. When the proxy bumps the
+// connection, this is the certificate Squid generated for targetHost. The handshake verifies that
+// the certificate is valid for targetHost and chains to caPEM, so an error means Squid minted a
+// certificate for the wrong host or signed it with another CA. targetHost defaults to port 443.
+//
+// Example usage:
+//
+//	cert, err := GetBumpedServerCert("http://squid.caching.svc.cluster.local:3128", "test-server.caching.svc.cluster.local", caPEM)
+func GetBumpedServerCert(proxyURL, targetHost string, caPEM []byte) (*x509.Certificate, error) {
+	proxy, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse proxy URL: %w", err)
+	}
+	caCertPool := x509.NewCertPool()
+	if !caCertPool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("failed to append CA certificates to pool")
+	}
+	hostname, _, err := net.SplitHostPort(targetHost)
+	if err != nil {
+		hostname = targetHost
+		targetHost = net.JoinHostPort(targetHost, "443")
+	}
+
+	conn, err := net.DialTimeout("tcp", proxy.Host, 30*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy %s: %w", proxy.Host, err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(30 * time.Second)); err != nil {
+		return nil, fmt.Errorf("failed to set connection deadline: %w", err)
+	}
+
+	connectReq := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: targetHost},
+		Host:   targetHost,
+		Header: make(http.Header),
+	}
+	if err := connectReq.Write(conn); err != nil {
+		return nil, fmt.Errorf("failed to send CONNECT request: %w", err)
+	}
+	// The server only speaks after the ClientHello, so nothing past the response is buffered. The
+	// body is left unread: a 200 response to CONNECT has none and draining it would block on the
+	// tunnel.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), connectReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CONNECT response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT to %s failed: %s", targetHost, resp.Status)
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: hostname, RootCAs: caCertPool})
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("TLS handshake with %s through the proxy failed: %w", targetHost, err)
+	}
+	return tlsConn.ConnectionState().PeerCertificates[0], nil
+}
+
+// GetCABundle returns the Squid CA bundle from the trust-manager ConfigMap in namespace.
+// trust-manager populates the ConfigMap asynchronously, so it retries for up to Timeout until
+// the ConfigMap exists and contains a PEM-parseable bundle, returning the last error otherwise.
+func GetCABundle(ctx context.Context, client kubernetes.Interface, namespace string) ([]byte, error) {
+	var bundle []byte
+	var lastErr error
+	err := wait.PollUntilContextTimeout(ctx, Interval, Timeout, true, func(ctx context.Context) (bool, error) {
+		bundle, lastErr = fetchCABundle(ctx, client, namespace)
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		if lastErr == nil {
+			lastErr = err
+		}
+		return nil, fmt.Errorf("timed out waiting for the CA bundle: %w", lastErr)
+	}
+	return bundle, nil
+}
+
+// fetchCABundle returns the CA bundle from the trust-manager ConfigMap if it contains certificates
+func fetchCABundle(ctx context.Context, client kubernetes.Interface, namespace string) ([]byte, error) {
+	name := namespace + SquidCABundleConfigMapSuffix
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s ConfigMap: %w", name, err)
+	}
+	bundle, ok := cm.Data[SquidCABundleKey]
+	if !ok || bundle == "" {
+		return nil, fmt.Errorf("%s ConfigMap has no %s", name, SquidCABundleKey)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM([]byte(bundle)) {
+		return nil, fmt.Errorf("%s in %s ConfigMap contains no PEM certificates", SquidCABundleKey, name)
+	}
+	return []byte(bundle), nil
+}
+
+// GetPodTrustedCAs reads the PEM CA bundle at path from the squid container of pod and parses its
+// certificates. Unlike GetCABundle, it returns what is actually mounted in the pod, so a CA that
+// trust-manager has not distributed yet is missing here even when its ConfigMap is up to date.
+//
+// Example usage:
+//
+//	certs, err := GetPodTrustedCAs(ctx, client, restConfig, namespace, pod.Name, SquidTestServerCAPath)
+func GetPodTrustedCAs(ctx context.Context, client kubernetes.Interface, restConfig *rest.Config,
+	namespace, pod, path string) ([]*x509.Certificate, error) {
+	stdout, stderr, err := ExecCommandInPod(ctx, client, restConfig, namespace, pod, SquidContainerName,
+		[]string{"cat", path})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from pod %s: %w: %s", path, pod, err, stderr)
+	}
+	certs, err := parsePEMCertificates([]byte(stdout))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CA bundle %s in pod %s: %w", path, pod, err)
+	}
+	return certs, nil
+}
+
+// WaitForPodTrustedCA waits until every certificate in caPEM is in the CA bundle at path in pod,
+// see GetPodTrustedCAs. Mounted ConfigMaps are updated by the kubelet with a delay, so a CA can
+// reach the pod well after trust-manager wrote it.
+func WaitForPodTrustedCA(ctx context.Context, client kubernetes.Interface, restConfig *rest.Config,
+	namespace, pod, path string, caPEM []byte) error {
+	want, err := parsePEMCertificates(caPEM)
+	if err != nil {
+		return fmt.Errorf("invalid expected CA: %w", err)
+	}
+
+	var lastErr error
+	err = wait.PollUntilContextTimeout(ctx, Interval, Timeout, true, func(ctx context.Context) (bool, error) {
+		var trusted []*x509.Certificate
+		trusted, lastErr = GetPodTrustedCAs(ctx, client, restConfig, namespace, pod, path)
+		if lastErr != nil {
+			return false, nil
+		}
+		for _, cert := range want {
+			if !slices.ContainsFunc(trusted, cert.Equal) {
+				lastErr = fmt.Errorf("CA %q is not in %s in pod %s", cert.Subject, path, pod)
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		if lastErr == nil {
+			lastErr = err
+		}
+		return fmt.Errorf("timed out waiting for the CA in pod %s: %w", pod, lastErr)
+	}
+	return nil
+}
+
+// parsePEMCertificates parses the CERTIFICATE blocks of a PEM bundle, failing when there are none
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM certificates found")
+	}
+	return certs, nil
+}
+
+// NewSquidPullTransport returns a transport that proxies through the Squid service in namespace
+// and trusts its CA bundle, suitable for remote.WithTransport and PullContainerImage
+func NewSquidPullTransport(ctx context.Context, client kubernetes.Interface, namespace string) (http.RoundTripper, error) {
+	caBundle, err := GetCABundle(ctx, client, namespace)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := NewTrustedSquidCachingClient(SquidServiceName, namespace, caBundle, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trusted squid caching client: %w", err)
+	}
+	return httpClient.Transport, nil
+}
+
 // MakeCachingRequest makes an HTTP request through the Squid caching and returns the response
 func MakeCachingRequest(client *http.Client, url string) (*http.Response, []byte, error) {
 	resp, err := client.Get(url)
@@ -301,6 +1039,45 @@ func MakeCachingRequest(client *http.Client, url string) (*http.Response, []byte
 	return resp, body, nil
 }
 
+// MakeAuthorizedCachingRequest makes an HTTP request with a bearer token through the Squid caching
+// and returns the parsed test server response, including the headers that reached the origin
+func MakeAuthorizedCachingRequest(client *http.Client, url, token string) (*TestServerResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	return ParseTestServerResponse(body)
+}
+
+// ValidateAuthorizationStripped verifies that no Authorization header reached the origin
+func ValidateAuthorizationStripped(response *TestServerResponse) {
+	Expect(response.Headers).NotTo(BeNil(), "Test server response should include the received headers")
+	Expect(response.Headers.Values("Authorization")).To(BeEmpty(),
+		"Authorization header should be removed before the request reaches the origin")
+}
+
+// ValidateAuthorizationForwarded verifies that the bearer token reached the origin unmodified
+func ValidateAuthorizationForwarded(response *TestServerResponse, token string) {
+	Expect(response.Headers).NotTo(BeNil(), "Test server response should include the received headers")
+	Expect(response.Headers.Get("Authorization")).To(Equal("Bearer "+token),
+		"Authorization header should be forwarded to the origin")
+}
+
 // ParseTestServerResponse parses a JSON response from a test server
 func ParseTestServerResponse(body []byte) (*TestServerResponse, error) {
 	fmt.Printf("DEBUG: Raw response body: %s\n", string(body))
@@ -328,6 +1105,34 @@ func ValidateCacheHit(originalResponse, cachedResponse *TestServerResponse, expe
 		"Cached response should show same server hit count")
 }
 
+// ValidateCachedBodyMatches verifies that a cache HIT returned exactly what the preceding MISS
+// returned: status, content length, content type, and the body byte for byte.
+// It also checks each body's length against its Content-Length to catch truncated cache entries.
+func ValidateCachedBodyMatches(missResp, hitResp *http.Response, missBody, hitBody []byte) {
+	Expect(hitResp.StatusCode).To(Equal(missResp.StatusCode),
+		"Cached response should have the same status code as the original response")
+	Expect(hitResp.Header.Get("Content-Type")).To(Equal(missResp.Header.Get("Content-Type")),
+		"Cached response should have the same content type as the original response")
+
+	if missResp.ContentLength >= 0 {
+		Expect(missBody).To(HaveLen(int(missResp.ContentLength)),
+			"Original response body should match its Content-Length")
+	}
+	if hitResp.ContentLength >= 0 {
+		Expect(hitBody).To(HaveLen(int(hitResp.ContentLength)),
+			"Cached response body should match its Content-Length")
+	}
+	if missResp.ContentLength >= 0 && hitResp.ContentLength >= 0 {
+		Expect(hitResp.ContentLength).To(Equal(missResp.ContentLength),
+			"Cached response should have the same content length as the original response")
+	}
+
+	Expect(hitBody).To(HaveLen(len(missBody)),
+		"Cached response body should have the same length as the original response body")
+	Expect(bytes.Equal(hitBody, missBody)).To(BeTrue(),
+		"Cached response body should be byte-for-byte identical to the original response body")
+}
+
 // ValidateCacheHeaders verifies that appropriate cache headers are present
 func ValidateCacheHeaders(resp *http.Response) {
 	Expect(resp.Header.Get("Cache-Control")).To(ContainSubstring("max-age=300"),
@@ -346,13 +1151,19 @@ func ValidateServerHit(response *TestServerResponse, expectedRequestID float64,
 
 // WaitForStatefulSetReady waits for a statefulset to be ready and all replica pods to be present
 func WaitForStatefulSetReady(ctx context.Context, client kubernetes.Interface, name string) (*v1.StatefulSet, error) {
+	return WaitForStatefulSetReadyInNamespace(ctx, client, Namespace, name)
+}
+
+// WaitForStatefulSetReadyInNamespace waits for a statefulset in the given namespace to have all
+// replicas ready and for its pods to be running, excluding pods terminating from a rolling update
+func WaitForStatefulSetReadyInNamespace(ctx context.Context, client kubernetes.Interface, namespace, name string) (*v1.StatefulSet, error) {
 	fmt.Printf("Waiting for %s statefulset to be ready...\n", name)
 
 	var expectedReplicas int32
 	var statefulSet *v1.StatefulSet
 	Eventually(func() error {
 		var err error
-		statefulSet, err = client.AppsV1().StatefulSets(Namespace).Get(ctx, name, metav1.GetOptions{})
+		statefulSet, err = client.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
 		if err != nil {
 			return fmt.Errorf("failed to get statefulsets: %w", err)
 		}
@@ -370,7 +1181,7 @@ func WaitForStatefulSetReady(ctx context.Context, client kubernetes.Interface, n
 	}, 120*time.Second, 5*time.Second).Should(Succeed())
 
 	fmt.Printf("Waiting for %d pod(s) to be present and ready...\n", expectedReplicas)
-	pods, err := GetPods(ctx, client, Namespace, name)
+	pods, err := GetPods(ctx, client, namespace, name)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get pods: %w", err)
 	}
@@ -381,6 +1192,21 @@ func WaitForStatefulSetReady(ctx context.Context, client kubernetes.Interface, n
 
 type CacheValues struct {
 	AllowList []string `json:"allowList"`
+	// DenyList patterns are never cached, even when they match AllowList
+	DenyList []string `json:"denyList,omitempty"`
+	// DryRun logs allow list decisions without enforcing them
+	DryRun bool `json:"dryRun,omitempty"`
+	// StoreLog logs objects written to and removed from the cache, see ParseStoreLog
+	StoreLog bool `json:"storeLog,omitempty"`
+	// DiskSizeMB is the cache volume size in MiB; squid's cache_dir uses 80% of it
+	DiskSizeMB int `json:"size,omitempty"`
+	// MaximumObjectSizeMB is the largest object squid caches, in MiB
+	MaximumObjectSizeMB int `json:"maxObjectSize,omitempty"`
+	// MemMB is the memory cache (cache_mem) size in MiB; 0 disables the memory cache
+	MemMB int `json:"memSize,omitempty"`
+	// MaxStaleSeconds is how long expired objects are served when the origin fails; nil keeps the
+	// chart default and 0 never serves stale objects
+	MaxStaleSeconds *int `json:"maxStaleSeconds,omitempty"`
 }
 
 type TLSOutgoingOptionsValues struct {
@@ -393,24 +1219,125 @@ type ServiceValues struct {
 	TrafficDistribution string `json:"trafficDistribution,omitempty"`
 }
 
+// ForwardingValues holds the Squid forwarding header configuration
+type ForwardingValues struct {
+	// Via is "on" or "off"
+	Via string `json:"via,omitempty"`
+	// ForwardedFor is "on", "off", "transparent" or "delete"
+	ForwardedFor string `json:"forwardedFor,omitempty"`
+	// PodHeader adds an X-Squid-Pod response header naming the serving pod
+	PodHeader bool `json:"podHeader,omitempty"`
+}
+
+// HeaderAccessValues holds the headers squid removes (request_header_access/reply_header_access)
+type HeaderAccessValues struct {
+	// Request headers are removed before requests are forwarded to origins
+	Request []string `json:"request,omitempty"`
+	// Reply headers are removed before responses are sent to clients
+	Reply []string `json:"reply,omitempty"`
+}
+
+// ParentProxyValues holds the parent proxy (cache_peer) configuration
+type ParentProxyValues struct {
+	Host string `json:"host,omitempty"`
+	Port int    `json:"port,omitempty"`
+	// Type is "parent" or "sibling"
+	Type string `json:"type,omitempty"`
+	// Options are extra cache_peer options, e.g. "no-query default"
+	Options string `json:"options,omitempty"`
+}
+
+// DNSValues holds the Squid DNS resolver configuration
+type DNSValues struct {
+	// Nameservers are IP addresses queried instead of those in /etc/resolv.conf
+	Nameservers []string `json:"nameservers,omitempty"`
+	// TimeoutSeconds is dns_timeout, 0 for Squid's default
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+	// RetransmitIntervalSeconds is dns_retransmit_interval, 0 for Squid's default
+	RetransmitIntervalSeconds int `json:"retransmitIntervalSeconds,omitempty"`
+}
+
 type SquidValues struct {
 	Enabled *bool  `json:"enabled,omitempty"`
 	Name    string `json:"name,omitempty"`
 }
 
 type SquidHelmValues struct {
-	Squid              *SquidValues              `json:"squid,omitempty"`
-	SquidExporter      *SquidExporterValues      `json:"squidExporter,omitempty"`
-	Cache              *CacheValues              `json:"cache,omitempty"`
-	Environment        string                    `json:"environment,omitempty"`
-	ReplicaCount       int                       `json:"replicaCount,omitempty"`
-	TLSOutgoingOptions *TLSOutgoingOptionsValues `json:"tlsOutgoingOptions,omitempty"`
-	Affinity           json.RawMessage           `json:"affinity,omitempty"`
-	Volumes            []corev1.Volume           `json:"volumes,omitempty"`
-	VolumeMounts       []corev1.VolumeMount      `json:"volumeMounts,omitempty"`
-	Nginx              *NginxValues              `json:"nginx,omitempty"`
-	Service            *ServiceValues            `json:"service,omitempty"`
-	Prometheus         *PrometheusValues         `json:"prometheus,omitempty"`
+	Squid            *SquidValues         `json:"squid,omitempty"`
+	SquidExporter    *SquidExporterValues `json:"squidExporter,omitempty"`
+	Cache            *CacheValues         `json:"cache,omitempty"`
+	AccessLogFilters []string             `json:"accessLogFilters,omitempty"`
+	// CollapsedForwarding collapses concurrent misses for the same URL into one origin fetch
+	CollapsedForwarding bool                      `json:"collapsedForwarding,omitempty"`
+	Environment         string                    `json:"environment,omitempty"`
+	ReplicaCount        int                       `json:"replicaCount,omitempty"`
+	TLSOutgoingOptions  *TLSOutgoingOptionsValues `json:"tlsOutgoingOptions,omitempty"`
+	Forwarding          *ForwardingValues         `json:"forwarding,omitempty"`
+	HeaderAccess        *HeaderAccessValues       `json:"headerAccess,omitempty"`
+	ParentProxy         *ParentProxyValues        `json:"parentProxy,omitempty"`
+	DNS                 *DNSValues                `json:"dns,omitempty"`
+	Affinity            json.RawMessage           `json:"affinity,omitempty"`
+	Volumes             []corev1.Volume           `json:"volumes,omitempty"`
+	VolumeMounts        []corev1.VolumeMount      `json:"volumeMounts,omitempty"`
+	Nginx               *NginxValues              `json:"nginx,omitempty"`
+	Service             *ServiceValues            `json:"service,omitempty"`
+	Prometheus          *PrometheusValues         `json:"prometheus,omitempty"`
+	StoreID             *StoreIDValues            `json:"storeId,omitempty"`
+	ICAPServer          *ICAPServerValues         `json:"icapServer,omitempty"`
+	PerSiteExporter     *PerSiteExporterValues    `json:"perSiteExporter,omitempty"`
+	Tuning              *TuningValues             `json:"tuning,omitempty"`
+}
+
+// TuningValues holds Squid process tuning for heavy parallel load
+type TuningValues struct {
+	// MaxFileDescriptors limits the file descriptors per Squid process; 0 keeps Squid's default
+	MaxFileDescriptors int `json:"maxFileDescriptors,omitempty"`
+	// WorkersSMP is the number of Squid SMP workers, each with its own share of the cache
+	WorkersSMP int `json:"workers,omitempty"`
+}
+
+// PerSiteExporterValues holds per-site exporter configuration
+type PerSiteExporterValues struct {
+	Enabled     *bool                     `json:"enabled,omitempty"`
+	Port        int                       `json:"port,omitempty"`
+	MetricsPath string                    `json:"metricsPath,omitempty"`
+	Readiness   *PerSiteExporterReadiness `json:"readiness,omitempty"`
+	// PodLabel adds a pod label with the pod name to the per-site metrics
+	PodLabel bool `json:"podLabel,omitempty"`
+}
+
+// PerSiteExporterReadiness holds the per-site exporter readiness gate configuration
+type PerSiteExporterReadiness struct {
+	Enabled bool   `json:"enabled"`
+	Warmup  string `json:"warmup,omitempty"`
+}
+
+// ICAPServerValues holds ICAP server sidecar configuration
+type ICAPServerValues struct {
+	RateLimit *ICAPRateLimitValues `json:"rateLimit,omitempty"`
+	Metrics   *ICAPMetricsValues   `json:"metrics,omitempty"`
+}
+
+// ICAPMetricsValues holds the ICAP server metrics endpoint configuration
+type ICAPMetricsValues struct {
+	Enabled bool `json:"enabled,omitempty"`
+	Port    int  `json:"port,omitempty"`
+}
+
+// ICAPRateLimitValues holds the ICAP server per-host rate limit configuration
+type ICAPRateLimitValues struct {
+	RequestsPerSecond float64 `json:"requestsPerSecond,omitempty"`
+	Burst             int     `json:"burst,omitempty"`
+}
+
+// StoreIDValues holds store-id helper configuration
+type StoreIDValues struct {
+	PackageRegistries bool   `json:"packageRegistries,omitempty"`
+	MinSizeBytes      int64  `json:"minSizeBytes,omitempty"`
+	SoftDeadline      string `json:"softDeadline,omitempty"`
+	BackgroundAuth    bool   `json:"backgroundAuth,omitempty"`
+	SkipAuthCheck     bool   `json:"skipAuthCheck,omitempty"`
+	Audit             bool   `json:"audit,omitempty"`
 }
 
 // SquidExporterValues holds squid-exporter configuration
@@ -446,6 +1373,12 @@ func BoolPtr(b bool) *bool {
 	return &b
 }
 
+// IntPtr is a helper function to create a pointer to an int value.
+// Used in tests to explicitly set int values, including 0, that use pointer types with omitempty.
+func IntPtr(i int) *int {
+	return &i
+}
+
 // parseImageReference extracts repository and tag from an image reference
 // Handles both digest (@sha256:xxx) and tag (:vX.Y.Z) formats
 func parseImageReference(image string) (repo, tag string) {
@@ -528,6 +1461,20 @@ func ConfigureSquidWithHelm(ctx context.Context, client kubernetes.Interface, va
 
 	values.Environment = environment
 
+	// Squid exits on startup when an url_regex ACL does not compile, which otherwise only shows
+	// as a CrashLoopBackOff once the rollout times out
+	if values.Cache != nil {
+		if err := ValidateAllowListPatterns(values.Cache.AllowList); err != nil {
+			return fmt.Errorf("%w: cache.allowList: %w", ErrChartPreflight, err)
+		}
+		if err := ValidateAllowListPatterns(values.Cache.DenyList); err != nil {
+			return fmt.Errorf("%w: cache.denyList: %w", ErrChartPreflight, err)
+		}
+	}
+	if err := ValidateAllowListPatterns(values.AccessLogFilters); err != nil {
+		return fmt.Errorf("%w: accessLogFilters: %w", ErrChartPreflight, err)
+	}
+
 	// Always enable nginx (disabled by default in values.yaml)
 	if values.Nginx == nil {
 		values.Nginx = &NginxValues{Enabled: true}
@@ -579,6 +1526,11 @@ func ConfigureSquidWithHelm(ctx context.Context, client kubernetes.Interface, va
 	// Build helm arguments based on environment and platform
 	extraArgs := buildExtraHelmArgs(environment, openshift, statefulSet)
 
+	// Fail fast on values the chart cannot render instead of waiting for the upgrade to time out
+	if err := PreflightChart(chartPath, valuesFile, extraArgs); err != nil {
+		return err
+	}
+
 	err = UpgradeChartWithArgs("caching", chartPath, valuesFile, extraArgs)
 	if err != nil {
 		return fmt.Errorf("failed to upgrade squid with helm: %w", err)
@@ -597,6 +1549,46 @@ func ConfigureSquidWithHelm(ctx context.Context, client kubernetes.Interface, va
 	return nil
 }
 
+// squidContainerImage returns the image of the squid container of the squid statefulset in namespace
+func squidContainerImage(ctx context.Context, client kubernetes.Interface, namespace string) (string, error) {
+	statefulSet, err := client.AppsV1().StatefulSets(namespace).Get(ctx, SquidStatefulSetName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get squid statefulset: %w", err)
+	}
+	for _, container := range statefulSet.Spec.Template.Spec.Containers {
+		if container.Name == SquidContainerName {
+			return container.Image, nil
+		}
+	}
+	return "", fmt.Errorf("squid statefulset has no %s container", SquidContainerName)
+}
+
+// AssertImagePreservedAcrossUpgrade fails the current test if upgradeFn, typically a
+// ConfigureSquidWithHelm call, changes the image of the squid container, e.g. by reverting a
+// pipeline-deployed snapshot image to the chart's default tag. When SNAPSHOT_SQUID_IMAGE is set,
+// the image after the upgrade must be that image instead.
+//
+// Example usage:
+//
+//	AssertImagePreservedAcrossUpgrade(ctx, clientset, namespace, func() error {
+//		return ConfigureSquidWithHelm(ctx, clientset, SquidHelmValues{})
+//	})
+func AssertImagePreservedAcrossUpgrade(ctx context.Context, client kubernetes.Interface, namespace string, upgradeFn func() error) {
+	before, err := squidContainerImage(ctx, client, namespace)
+	Expect(err).NotTo(HaveOccurred(), "Failed to get the squid image before the upgrade")
+
+	expected := before
+	if snapshotImage := os.Getenv("SNAPSHOT_SQUID_IMAGE"); snapshotImage != "" {
+		expected = snapshotImage
+	}
+
+	Expect(upgradeFn()).To(Succeed(), "Upgrade should succeed")
+
+	after, err := squidContainerImage(ctx, client, namespace)
+	Expect(err).NotTo(HaveOccurred(), "Failed to get the squid image after the upgrade")
+	Expect(after).To(Equal(expected), "Squid image changed from %s across the upgrade", before)
+}
+
 // UpgradeChart performs a helm upgrade with the specified chart and values file
 func UpgradeChart(releaseName, chartName string, valuesFile string) error {
 	return UpgradeChartWithArgs(releaseName, chartName, valuesFile, nil)
@@ -633,6 +1625,51 @@ func UpgradeChartWithArgs(releaseName, chartName string, valuesFile string, extr
 	return nil
 }
 
+// ErrChartPreflight is wrapped by PreflightChart errors, telling template and lint errors apart
+// from rollout failures
+var ErrChartPreflight = errors.New("chart pre-flight failed")
+
+// PreflightChart renders chartPath with the values file and extra arguments of an upgrade and
+// returns an error wrapping ErrChartPreflight if rendering fails. When HELM_PREFLIGHT_LINT is
+// "true", the chart is also linted with the same values and, if kubeconform is installed, the
+// rendered manifests are validated against the Kubernetes schemas.
+func PreflightChart(chartPath, valuesFile string, extraArgs []string) error {
+	templateArgs := append([]string{"template", "caching", chartPath, "--values", valuesFile}, extraArgs...)
+	rendered, err := runHelm("", templateArgs...)
+	if err != nil {
+		return fmt.Errorf("%w: helm template: %w", ErrChartPreflight, err)
+	}
+
+	if os.Getenv("HELM_PREFLIGHT_LINT") != "true" {
+		return nil
+	}
+
+	lintArgs := append([]string{"lint", chartPath, "--values", valuesFile}, extraArgs...)
+	if _, err := runHelm("", lintArgs...); err != nil {
+		return fmt.Errorf("%w: helm lint: %w", ErrChartPreflight, err)
+	}
+
+	if _, err := exec.LookPath("kubeconform"); err == nil {
+		cmd := exec.Command("kubeconform", "-strict", "-ignore-missing-schemas", "-summary", "-")
+		cmd.Stdin = strings.NewReader(rendered)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%w: kubeconform: %w\n%s", ErrChartPreflight, err, string(output))
+		}
+	}
+	return nil
+}
+
+// runHelm runs helm with args in dir (the current directory if empty) and returns its combined output
+func runHelm(dir string, args ...string) (string, error) {
+	cmd := exec.Command("helm", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%w\n%s", err, string(output))
+	}
+	return string(output), nil
+}
+
 // RenderHelmTemplate renders the Helm template with the given values and returns the YAML output
 func RenderHelmTemplate(chartPath string, values SquidHelmValues) (string, error) {
 	return RenderHelmTemplateWithKubeVersion(chartPath, values, "")
@@ -655,24 +1692,22 @@ func RenderHelmTemplateWithKubeVersion(chartPath string, values SquidHelmValues,
 	}
 	defer os.Remove(valuesFile)
 
-	cmdParts := []string{"helm", "template", "test-release", chartPath, "--values", valuesFile}
+	args := []string{"template", "test-release", chartPath, "--values", valuesFile}
 	if kubeVersion != "" {
-		cmdParts = append(cmdParts, "--kube-version", kubeVersion)
+		args = append(args, "--kube-version", kubeVersion)
 	}
 
-	cmd := exec.Command(cmdParts[0], cmdParts[1:]...)
 	// Set working directory to chart parent directory to ensure relative paths work
 	chartParentDir, err := FindChartDirectory()
 	if err != nil {
 		return "", fmt.Errorf("failed to find chart directory: %w", err)
 	}
-	cmd.Dir = chartParentDir
-	output, err := cmd.CombinedOutput()
+	output, err := runHelm(chartParentDir, args...)
 	if err != nil {
-		return "", fmt.Errorf("helm template failed: %w\n%s", err, string(output))
+		return "", fmt.Errorf("helm template failed: %w", err)
 	}
 
-	return string(output), nil
+	return output, nil
 }
 
 // writeValuesToFile writes the given values in YAML format to a temp file and returns the path to the file
@@ -863,6 +1898,54 @@ func GetPods(ctx context.Context, client kubernetes.Interface, namespace string,
 	return result, err
 }
 
+// ScaleSquid scales the squid statefulset to replicas through the scale subresource, which is faster
+// than a helm upgrade, and waits for the pods to be ready. The returned restore function scales the
+// statefulset back to its previous replica count and waits again; callers typically defer it:
+//
+//	restore, err := ScaleSquid(ctx, clientset, Namespace, 1)
+//	Expect(err).NotTo(HaveOccurred())
+//	DeferCleanup(restore)
+//
+// Note: The next ConfigureSquidWithHelm call resets the replica count to the helm values.
+func ScaleSquid(ctx context.Context, client kubernetes.Interface, namespace string, replicas int32) (func(), error) {
+	previous, err := setStatefulSetReplicas(ctx, client, namespace, SquidStatefulSetName, replicas)
+	if err != nil {
+		return nil, err
+	}
+
+	restore := func() {
+		fmt.Printf("Restoring %s statefulset to %d replicas\n", SquidStatefulSetName, previous)
+		_, err := setStatefulSetReplicas(ctx, client, namespace, SquidStatefulSetName, previous)
+		Expect(err).NotTo(HaveOccurred(), "Failed to restore squid replica count")
+	}
+
+	return restore, nil
+}
+
+// setStatefulSetReplicas updates the replica count of a statefulset, waits for it to be ready,
+// and returns the previous replica count
+func setStatefulSetReplicas(ctx context.Context, client kubernetes.Interface, namespace, name string, replicas int32) (int32, error) {
+	scale, err := client.AppsV1().StatefulSets(namespace).GetScale(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get %s statefulset scale: %w", name, err)
+	}
+
+	previous := scale.Spec.Replicas
+	if previous != replicas {
+		fmt.Printf("Scaling %s statefulset from %d to %d replicas\n", name, previous, replicas)
+		scale.Spec.Replicas = replicas
+		if _, err := client.AppsV1().StatefulSets(namespace).UpdateScale(ctx, name, scale, metav1.UpdateOptions{}); err != nil {
+			return 0, fmt.Errorf("failed to scale %s statefulset: %w", name, err)
+		}
+	}
+
+	if _, err := WaitForStatefulSetReadyInNamespace(ctx, client, namespace, name); err != nil {
+		return 0, fmt.Errorf("failed to wait for %s statefulset to be ready: %w", name, err)
+	}
+
+	return previous, nil
+}
+
 // GetPodLogsSince retrieves logs from a pod container since a specific timestamp
 func GetPodLogsSince(ctx context.Context, client kubernetes.Interface, namespace, podName, containerName string, since *metav1.Time) ([]byte, error) {
 	logOptions := &corev1.PodLogOptions{
@@ -873,15 +1956,106 @@ func GetPodLogsSince(ctx context.Context, client kubernetes.Interface, namespace
 	return client.CoreV1().Pods(namespace).GetLogs(podName, logOptions).Do(ctx).Raw()
 }
 
-// PullContainerImage pulls a container image and all its layers while discarding the content
+// StreamPodLogsUntil follows the logs of a pod container since a specific timestamp and returns
+// the first line for which match returns true, or an error once timeout elapses, ctx is canceled
+// or the stream ends without a match. Unlike polling GetPodLogsSince, it returns as soon as the
+// line is written. Take since before the requests under test, so that lines logged by earlier
+// tests cannot match; a nil since streams the whole container log.
+func StreamPodLogsUntil(ctx context.Context, client kubernetes.Interface, namespace, podName, containerName string, since *metav1.Time, match func(line string) bool, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	logOptions := &corev1.PodLogOptions{
+		Container: containerName,
+		Follow:    true,
+		SinceTime: since,
+	}
+	stream, err := client.CoreV1().Pods(namespace).GetLogs(podName, logOptions).Stream(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to stream logs of pod %s: %w", podName, err)
+	}
+	defer stream.Close()
+
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); match(line) {
+			return line, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("no matching log line from pod %s within %s", podName, timeout)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read logs of pod %s: %w", podName, err)
+	}
+	return "", fmt.Errorf("log stream of pod %s ended without a matching line", podName)
+}
+
+// PullRetryOptions configures how PullContainerImageWithRetry retries transient failures: HTTP 408,
+// 429 and 5xx responses, connection resets and truncated layer downloads
+type PullRetryOptions struct {
+	// Attempts is the maximum number of attempts of each request and layer download, including the first
+	Attempts int
+	// InitialBackoff is the delay before the first retry. It doubles on every further retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries
+	MaxBackoff time.Duration
+}
+
+// DefaultPullRetryOptions are the retry options of PullContainerImage
+var DefaultPullRetryOptions = PullRetryOptions{
+	Attempts:       4,
+	InitialBackoff: time.Second,
+	MaxBackoff:     10 * time.Second,
+}
+
+// retryablePullStatusCodes are the registry response codes PullContainerImageWithRetry retries
+var retryablePullStatusCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// backoff returns the exponential backoff of the options
+func (o PullRetryOptions) backoff() wait.Backoff {
+	return wait.Backoff{Duration: o.InitialBackoff, Factor: 2, Steps: o.Attempts, Cap: o.MaxBackoff}
+}
+
+// isRetryablePullError reports whether err is a transient failure worth retrying
+func isRetryablePullError(err error) bool {
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) {
+		return slices.Contains(retryablePullStatusCodes, transportErr.StatusCode)
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// PullContainerImage pulls a container image and all its layers while discarding the content,
+// retrying transient failures with DefaultPullRetryOptions
 // Note: Does NOT support image references pointing to manifest lists
 func PullContainerImage(t *http.RoundTripper, imageRef string) error {
+	return PullContainerImageWithRetry(t, imageRef, DefaultPullRetryOptions)
+}
+
+// PullContainerImageWithRetry pulls a container image like PullContainerImage, retrying transient
+// failures with exponential backoff as configured by retry. Registry requests are retried by the
+// registry client; layer downloads that fail mid-stream are restarted.
+// Note: Does NOT support image references pointing to manifest lists
+func PullContainerImageWithRetry(t *http.RoundTripper, imageRef string, retry PullRetryOptions) error {
 	ref, err := name.ParseReference(imageRef)
 	if err != nil {
 		return err
 	}
 
-	desc, err := remote.Get(ref, remote.WithTransport(*t))
+	desc, err := remote.Get(ref,
+		remote.WithTransport(*t),
+		remote.WithRetryBackoff(remote.Backoff(retry.backoff())),
+		remote.WithRetryStatusCodes(retryablePullStatusCodes...),
+	)
 	if err != nil {
 		return err
 	}
@@ -899,21 +2073,181 @@ func PullContainerImage(t *http.RoundTripper, imageRef string) error {
 	}
 
 	for _, layer := range layers {
-		cr, err := layer.Compressed()
+		backoff := retry.backoff()
+		for attempt := 1; ; attempt++ {
+			err = copyLayer(layer)
+			if err == nil || attempt >= retry.Attempts || !isRetryablePullError(err) {
+				break
+			}
+			delay := backoff.Step()
+			fmt.Printf("Retrying layer download in %s (attempt %d/%d): %v\n", delay, attempt+1, retry.Attempts, err)
+			time.Sleep(delay)
+		}
 		if err != nil {
 			return err
 		}
+	}
+
+	return nil
+}
+
+// copyLayer downloads the compressed content of layer and discards it
+func copyLayer(layer ggcrv1.Layer) error {
+	cr, err := layer.Compressed()
+	if err != nil {
+		return err
+	}
+	defer cr.Close()
+	written, err := io.Copy(io.Discard, cr)
+	if err != nil {
+		return err
+	}
+	if written == 0 {
+		return fmt.Errorf("no bytes written")
+	}
+	return nil
+}
+
+// LayerPullStats describes how a single image layer was served
+type LayerPullStats struct {
+	Digest   string
+	Bytes    int64
+	CacheHit bool
+	// SquidPod is the Squid pod that served the layer, if known from the Via header
+	SquidPod string
+}
+
+// PullStats summarizes an image pull through the proxy
+type PullStats struct {
+	Layers     []LayerPullStats
+	TotalBytes int64
+}
+
+// LayerCount returns the number of layers pulled
+func (s *PullStats) LayerCount() int {
+	return len(s.Layers)
+}
+
+// CacheHits returns the number of layers served from the Squid cache
+func (s *PullStats) CacheHits() int {
+	hits := 0
+	for _, layer := range s.Layers {
+		if layer.CacheHit {
+			hits++
+		}
+	}
+	return hits
+}
+
+// lastResponseRecorder is a RoundTripper that remembers the headers of the most recent response,
+// i.e. the final hop of a redirect chain
+type lastResponseRecorder struct {
+	base http.RoundTripper
+
+	mu   sync.Mutex
+	last *http.Response
+}
+
+func (r *lastResponseRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.base.RoundTrip(req)
+	if err == nil {
+		r.mu.Lock()
+		r.last = &http.Response{StatusCode: resp.StatusCode, Header: resp.Header.Clone()}
+		r.mu.Unlock()
+	}
+	return resp, err
+}
+
+func (r *lastResponseRecorder) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.last = nil
+}
+
+func (r *lastResponseRecorder) lastResponse() *http.Response {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
+
+// IsSquidCacheHit reports whether Squid served the response from its cache, based on the
+// "X-Cache: HIT from <pod>" header. Headers added by upstream CDNs (e.g. "Hit from cloudfront")
+// are ignored by requiring the hostname to match the Squid pod from the Via header, when present.
+func IsSquidCacheHit(resp *http.Response) bool {
+	pod := ""
+	if via, err := ParseViaHeader(resp); err == nil {
+		pod = via.Pod
+	}
+	for _, value := range resp.Header.Values("X-Cache") {
+		host, found := strings.CutPrefix(value, "HIT from ")
+		if found && (pod == "" || host == pod) {
+			return true
+		}
+	}
+	return false
+}
+
+// PullContainerImageWithStats pulls a container image like PullContainerImage and reports,
+// per layer, the number of bytes received and whether Squid served it from cache
+// Note: Does NOT support image references pointing to manifest lists
+func PullContainerImageWithStats(t *http.RoundTripper, imageRef string) (*PullStats, error) {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return nil, err
+	}
+
+	recorder := &lastResponseRecorder{base: *t}
+	desc, err := remote.Get(ref, remote.WithTransport(recorder))
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := desc.Image()
+	if err != nil {
+		return nil, err
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("no layers found in image")
+	}
+
+	stats := &PullStats{}
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, err
+		}
+
+		// Layers are fetched sequentially, so the last response belongs to this layer
+		recorder.reset()
+		cr, err := layer.Compressed()
+		if err != nil {
+			return nil, err
+		}
 		written, err := io.Copy(io.Discard, cr)
 		cr.Close()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if written == 0 {
-			return fmt.Errorf("no bytes written")
+			return nil, fmt.Errorf("no bytes written for layer %s", digest)
+		}
+
+		layerStats := LayerPullStats{Digest: digest.String(), Bytes: written}
+		if resp := recorder.lastResponse(); resp != nil {
+			layerStats.CacheHit = IsSquidCacheHit(resp)
+			if via, err := ParseViaHeader(resp); err == nil {
+				layerStats.SquidPod = via.Pod
+			}
 		}
+		stats.Layers = append(stats.Layers, layerStats)
+		stats.TotalBytes += written
 	}
 
-	return nil
+	return stats, nil
 }
 
 // GetMetricValue extracts a metric value from Prometheus metrics content with matching labels.
@@ -926,48 +2260,78 @@ func PullContainerImage(t *http.RoundTripper, imageRef string) error {
 //	value, err := GetMetricValue(metricsContent, "http_requests_total", map[string]string{"status": "200"})
 //	// value will be 42
 func GetMetricValue(metricsContent, metricName string, labels map[string]string) (float64, error) {
-	// Parse the metrics using expfmt
-	parser := expfmt.NewTextParser(model.LegacyValidation)
-	metricFamilies, err := parser.TextToMetricFamilies(strings.NewReader(metricsContent))
+	sample, err := GetMetricSample(metricsContent, metricName, labels)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse metrics: %w", err)
+		return 0, err
+	}
+	return sample.Value, nil
+}
+
+// MetricSample is a metric value together with its exposition timestamp.
+// Timestamp is the zero time when the exposition does not include one.
+type MetricSample struct {
+	Value     float64
+	Timestamp time.Time
+}
+
+// GetMetricSample extracts a metric sample from Prometheus metrics content with matching labels.
+// It matches metrics like GetMetricValue, additionally returning the sample timestamp when present.
+func GetMetricSample(metricsContent, metricName string, labels map[string]string) (*MetricSample, error) {
+	metricFamilies, err := ParseMetricFamilies(metricsContent)
+	if err != nil {
+		return nil, err
 	}
 
 	// Find the metric family with the requested name
 	metricFamily, found := metricFamilies[metricName]
 	if !found {
-		return 0, fmt.Errorf("metric %s not found", metricName)
+		return nil, fmt.Errorf("metric %s not found", metricName)
 	}
 
 	// Iterate through metrics in the family to find the one with matching labels
 	for _, metric := range metricFamily.Metric {
 		// If no labels specified, return the first metric
 		if len(labels) == 0 {
-			return extractMetricValue(metricFamily, metric)
+			return extractMetricSample(metricFamily, metric)
 		}
 
-		// Check if this metric has all the required labels with matching values
-		allLabelsMatch := true
-		for requiredKey, requiredValue := range labels {
-			labelFound := false
-			for _, label := range metric.Label {
-				if label.GetName() == requiredKey && label.GetValue() == requiredValue {
-					labelFound = true
-					break
-				}
-			}
-			if !labelFound {
-				allLabelsMatch = false
+		if metricMatchesLabels(metric, labels) {
+			return extractMetricSample(metricFamily, metric)
+		}
+	}
+
+	return nil, fmt.Errorf("metric %s with labels %v not found", metricName, labels)
+}
+
+// metricMatchesLabels returns true if metric has all of labels with exactly matching values
+func metricMatchesLabels(metric *dto.Metric, labels map[string]string) bool {
+	for requiredKey, requiredValue := range labels {
+		labelFound := false
+		for _, label := range metric.Label {
+			if label.GetName() == requiredKey && label.GetValue() == requiredValue {
+				labelFound = true
 				break
 			}
 		}
+		if !labelFound {
+			return false
+		}
+	}
+	return true
+}
 
-		if allLabelsMatch {
-			return extractMetricValue(metricFamily, metric)
-		}
+// extractMetricSample extracts the value and optional timestamp of a Prometheus metric
+func extractMetricSample(metricFamily *dto.MetricFamily, metric *dto.Metric) (*MetricSample, error) {
+	value, err := extractMetricValue(metricFamily, metric)
+	if err != nil {
+		return nil, err
 	}
 
-	return 0, fmt.Errorf("metric %s with labels %v not found", metricName, labels)
+	sample := &MetricSample{Value: value}
+	if metric.TimestampMs != nil {
+		sample.Timestamp = time.UnixMilli(metric.GetTimestampMs())
+	}
+	return sample, nil
 }
 
 // extractMetricValue extracts the numeric value from a Prometheus metric based on its type.
@@ -995,6 +2359,135 @@ func GetPerSiteMetricsValue(metricsContent, metricName, hostname string) (float6
 	return GetMetricValue(metricsContent, metricName, map[string]string{"hostname": hostname})
 }
 
+// GetPerSiteMetricsSample extracts a metric sample from Prometheus metrics content for a specific hostname
+func GetPerSiteMetricsSample(metricsContent, metricName, hostname string) (*MetricSample, error) {
+	return GetMetricSample(metricsContent, metricName, map[string]string{"hostname": hostname})
+}
+
+// AssertMetricAbsent fails the current test if metricsContent contains a sample of metricName
+// for hostname. Unlike a substring check, only an exact hostname label value matches, so
+// "example.com" is not confused with "cdn.example.com".
+// This is the complement to GetPerSiteMetricsValue, e.g. for asserting idle hosts were evicted.
+func AssertMetricAbsent(metricsContent, metricName, hostname string) {
+	metricFamilies, err := ParseMetricFamilies(metricsContent)
+	Expect(err).NotTo(HaveOccurred(), "Failed to parse metrics")
+
+	metricFamily, found := metricFamilies[metricName]
+	if !found {
+		return
+	}
+	for _, metric := range metricFamily.Metric {
+		Expect(metricMatchesLabels(metric, map[string]string{"hostname": hostname})).To(BeFalse(),
+			"Metric %s should have no sample for hostname %q", metricName, hostname)
+	}
+}
+
+// AssertMetricFresh fails the current test if the metric returned by fetch is stale.
+// When the sample carries a timestamp, it must be no older than window.
+// Otherwise the value must change from its first reading within window, so the caller
+// must generate traffic that moves the metric (e.g. in another goroutine or before scraping).
+//
+// Example usage:
+//
+//	AssertMetricFresh(func() (*MetricSample, error) {
+//		content, err := fetchMetrics(pod)
+//		if err != nil {
+//			return nil, err
+//		}
+//		return GetPerSiteMetricsSample(content, "squid_site_requests_total", "example.com")
+//	}, 30*time.Second)
+func AssertMetricFresh(fetch func() (*MetricSample, error), window time.Duration) {
+	initial, err := fetch()
+	Expect(err).NotTo(HaveOccurred(), "Failed to fetch metric")
+
+	if !initial.Timestamp.IsZero() {
+		Expect(time.Since(initial.Timestamp)).To(BeNumerically("<=", window),
+			"Metric sample timestamp %s is older than %s", initial.Timestamp, window)
+		return
+	}
+
+	Eventually(func(g Gomega) {
+		sample, err := fetch()
+		g.Expect(err).NotTo(HaveOccurred(), "Failed to fetch metric")
+		g.Expect(sample.Value).NotTo(Equal(initial.Value), "Metric value has not changed from %v", initial.Value)
+	}, window, min(Interval, window)).Should(Succeed(), "Metric did not change within %s", window)
+}
+
+// PodScrapeStat is the outcome of scraping the per-site exporter of one pod
+type PodScrapeStat struct {
+	Pod      string
+	Duration time.Duration
+	Err      error
+}
+
+// ScrapeStats records per-pod scrape durations, so metric polling timeouts can tell slow
+// scrapes apart from genuinely absent metrics
+type ScrapeStats struct {
+	Pods []PodScrapeStat
+}
+
+// record appends the outcome of a pod scrape
+func (s *ScrapeStats) record(pod string, duration time.Duration, err error) {
+	s.Pods = append(s.Pods, PodScrapeStat{Pod: pod, Duration: duration, Err: err})
+}
+
+// Slowest returns the pod scrape that took the longest, or false if no pod was scraped
+func (s *ScrapeStats) Slowest() (PodScrapeStat, bool) {
+	if len(s.Pods) == 0 {
+		return PodScrapeStat{}, false
+	}
+	slowest := s.Pods[0]
+	for _, stat := range s.Pods[1:] {
+		if stat.Duration > slowest.Duration {
+			slowest = stat
+		}
+	}
+	return slowest, true
+}
+
+// String describes the scrape duration of each pod, e.g. "squid-0 took 120ms, squid-1 took 8s (error: ...)"
+func (s *ScrapeStats) String() string {
+	if len(s.Pods) == 0 {
+		return "no pods scraped"
+	}
+	parts := make([]string, 0, len(s.Pods))
+	for _, stat := range s.Pods {
+		part := fmt.Sprintf("%s took %s", stat.Pod, stat.Duration.Round(time.Millisecond))
+		if stat.Err != nil {
+			part += fmt.Sprintf(" (error: %v)", stat.Err)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// scrapePodMetrics fetches the per-site exporter metrics of pod and records the scrape duration in stats
+func scrapePodMetrics(metricsHTTPClient *http.Client, pod *corev1.Pod, stats *ScrapeStats) (string, error) {
+	start := time.Now()
+	body, err := fetchPodMetrics(metricsHTTPClient, pod)
+	stats.record(pod.Name, time.Since(start), err)
+	return body, err
+}
+
+// fetchPodMetrics fetches the per-site exporter metrics of pod
+func fetchPodMetrics(metricsHTTPClient *http.Client, pod *corev1.Pod) (string, error) {
+	metricsURL := fmt.Sprintf("https://%s:9302/metrics", pod.Status.PodIP)
+	resp, err := metricsHTTPClient.Get(metricsURL)
+	if err != nil {
+		return "", fmt.Errorf("error querying pod %s: %w", pod.Name, err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading response from pod %s: %w", pod.Name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d from pod %s", resp.StatusCode, pod.Name)
+	}
+	return string(bodyBytes), nil
+}
+
 // GetAggregatedMetrics retrieves and aggregates metrics from all squid pods by querying each pod's metrics endpoint.
 // It returns the total sum of the specified metric across all pods.
 //
@@ -1002,93 +2495,381 @@ func GetPerSiteMetricsValue(metricsContent, metricName, hostname string) (float6
 //
 //	totalRequests := GetAggregatedMetrics(ctx, clientset, metricsClient, namespace, 3, "squid_site_requests_total", "example.com")
 func GetAggregatedMetrics(ctx context.Context, client kubernetes.Interface, metricsHTTPClient *http.Client, namespace string, expectedReplicas int32, metricName, hostname string) (float64, error) {
+	totalValue, _, err := GetAggregatedMetricsWithStats(ctx, client, metricsHTTPClient, namespace, expectedReplicas, metricName, hostname)
+	return totalValue, err
+}
+
+// GetAggregatedMetricsWithStats is GetAggregatedMetrics, additionally returning how long each pod took to scrape
+func GetAggregatedMetricsWithStats(ctx context.Context, client kubernetes.Interface, metricsHTTPClient *http.Client, namespace string, expectedReplicas int32, metricName, hostname string) (float64, *ScrapeStats, error) {
+	podMetrics, stats, err := GetPerPodMetricsWithStats(ctx, client, metricsHTTPClient, namespace, expectedReplicas, metricName, hostname)
+	if err != nil {
+		return 0, stats, err
+	}
+
 	var totalValue float64
+	for _, podValue := range podMetrics {
+		totalValue += podValue
+	}
+
+	fmt.Printf("DEBUG: Total aggregated %s for %s: %.0f\n", metricName, hostname, totalValue)
+	return totalValue, stats, nil
+}
+
+// GetPerPodMetrics retrieves metrics from all squid pods and returns a map of pod names to their metric values.
+// Unlike GetAggregatedMetrics, this method does NOT aggregate values - it returns individual pod metrics.
+//
+// Example usage:
+//
+//	podMetrics := GetPerPodMetrics(ctx, clientset, metricsClient, namespace, 3, "squid_site_bytes_total", "example.com")
+//	podMetrics will be: map[string]float64{"squid-xxx-pod1": 1234.5, "squid-xxx-pod2": 5678.9}
+func GetPerPodMetrics(ctx context.Context, client kubernetes.Interface, metricsHTTPClient *http.Client, namespace string, expectedReplicas int32, metricName, hostname string) (map[string]float64, error) {
+	podMetrics, _, err := GetPerPodMetricsWithStats(ctx, client, metricsHTTPClient, namespace, expectedReplicas, metricName, hostname)
+	return podMetrics, err
+}
+
+// GetPerPodMetricsWithStats is GetPerPodMetrics, additionally returning how long each pod took to scrape
+//
+// Example usage:
+//
+//	podMetrics, stats, err := GetPerPodMetricsWithStats(ctx, clientset, metricsClient, namespace, 3, "squid_site_bytes_total", "example.com")
+//	Expect(podMetrics).To(HaveKey(podName), "scrape durations: %s", stats)
+func GetPerPodMetricsWithStats(ctx context.Context, client kubernetes.Interface, metricsHTTPClient *http.Client, namespace string, expectedReplicas int32, metricName, hostname string) (map[string]float64, *ScrapeStats, error) {
+	podMetrics := make(map[string]float64)
+	stats := &ScrapeStats{}
 	pods, err := GetPods(ctx, client, namespace, SquidStatefulSetName)
 	if err != nil {
 		fmt.Printf("DEBUG: Error getting pods: %v\n", err)
-		return 0, fmt.Errorf("error getting pods: %w", err)
+		return podMetrics, stats, fmt.Errorf("error getting pods: %w", err)
 	}
 
 	for _, pod := range pods {
-		podIP := pod.Status.PodIP
-		metricsURL := fmt.Sprintf("https://%s:9302/metrics", podIP)
-
-		fmt.Printf("DEBUG: Querying metrics from pod %s (%s) at %s\n", pod.Name, podIP, metricsURL)
-		resp, err := metricsHTTPClient.Get(metricsURL)
+		fmt.Printf("DEBUG: Querying metrics from pod %s (%s)\n", pod.Name, pod.Status.PodIP)
+		bodyString, err := scrapePodMetrics(metricsHTTPClient, pod, stats)
 		if err != nil {
-			fmt.Printf("DEBUG: Error querying pod %s: %v\n", pod.Name, err)
-			continue
-		}
-		defer resp.Body.Close()
-
-		bodyBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			fmt.Printf("DEBUG: Error reading response from %s: %v\n", pod.Name, err)
+			fmt.Printf("DEBUG: %v\n", err)
 			continue
 		}
 
 		// Parse metrics for this pod
-		bodyString := string(bodyBytes)
 		podValue, err := GetPerSiteMetricsValue(bodyString, metricName, hostname)
 		if err != nil {
 			fmt.Printf("DEBUG: Error parsing metric %s for hostname %s from pod %s: %v\n", metricName, hostname, pod.Name, err)
 			continue
 		}
 
-		totalValue += podValue
+		podMetrics[pod.Name] = podValue
 		fmt.Printf("DEBUG: Pod %s %s for %s: %.0f\n", pod.Name, metricName, hostname, podValue)
 	}
 
-	fmt.Printf("DEBUG: Total aggregated %s for %s: %.0f\n", metricName, hostname, totalValue)
-	return totalValue, nil
+	fmt.Printf("DEBUG: Scrape durations: %s\n", stats)
+	return podMetrics, stats, nil
 }
 
-// GetPerPodMetrics retrieves metrics from all squid pods and returns a map of pod names to their metric values.
-// Unlike GetAggregatedMetrics, this method does NOT aggregate values - it returns individual pod metrics.
+// ParseMetricFamilies parses Prometheus text exposition content into metric families keyed by name
+func ParseMetricFamilies(metricsContent string) (map[string]*dto.MetricFamily, error) {
+	parser := expfmt.NewTextParser(model.LegacyValidation)
+	metricFamilies, err := parser.TextToMetricFamilies(strings.NewReader(metricsContent))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse metrics: %w", err)
+	}
+	return metricFamilies, nil
+}
+
+// ExpositionCheck is an additional check of ValidatePrometheusExposition beyond what the text
+// parser rejects on its own
+type ExpositionCheck int
+
+const (
+	// CheckDuplicateNames rejects metric families split across non-contiguous blocks and series
+	// exposed more than once with the same labels, which the text parser silently merges
+	CheckDuplicateNames ExpositionCheck = iota
+	// CheckConsistentTypes rejects families without a TYPE line and families whose names collide
+	// with the _bucket, _count or _sum series of a histogram or summary
+	CheckConsistentTypes
+)
+
+// ValidatePrometheusExposition fully parses Prometheus text exposition content with the canonical
+// parser and returns a descriptive error for malformed input, such as invalid metric names,
+// unparsable values or a second TYPE line for the same metric. Pass checks to also reject
+// duplicates and inconsistent types.
 //
 // Example usage:
 //
-//	podMetrics := GetPerPodMetrics(ctx, clientset, metricsClient, namespace, 3, "squid_site_bytes_total", "example.com")
-//	podMetrics will be: map[string]float64{"squid-xxx-pod1": 1234.5, "squid-xxx-pod2": 5678.9}
-func GetPerPodMetrics(ctx context.Context, client kubernetes.Interface, metricsHTTPClient *http.Client, namespace string, expectedReplicas int32, metricName, hostname string) (map[string]float64, error) {
-	podMetrics := make(map[string]float64)
+//	err := ValidatePrometheusExposition(body, CheckDuplicateNames, CheckConsistentTypes)
+func ValidatePrometheusExposition(content string, checks ...ExpositionCheck) error {
+	families, err := ParseMetricFamilies(content)
+	if err != nil {
+		return err
+	}
+	if len(families) == 0 {
+		return fmt.Errorf("exposition contains no metric families")
+	}
+	for _, check := range checks {
+		switch check {
+		case CheckDuplicateNames:
+			err = checkExpositionDuplicates(content, families)
+		case CheckConsistentTypes:
+			err = checkExpositionTypes(content, families)
+		default:
+			err = fmt.Errorf("unknown exposition check %d", check)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expositionFamilyName returns the metric family a sample or metadata name belongs to, mapping the
+// _bucket, _count and _sum series of histograms and summaries to their family
+func expositionFamilyName(name string, families map[string]*dto.MetricFamily) string {
+	if _, ok := families[name]; ok {
+		return name
+	}
+	for _, suffix := range []string{"_bucket", "_count", "_sum"} {
+		base, found := strings.CutSuffix(name, suffix)
+		if !found {
+			continue
+		}
+		if mf, ok := families[base]; ok &&
+			(mf.GetType() == dto.MetricType_HISTOGRAM || mf.GetType() == dto.MetricType_SUMMARY) {
+			return base
+		}
+	}
+	return name
+}
+
+// expositionLineName returns the metric name of a HELP, TYPE or sample line, or "" for other lines
+func expositionLineName(line string) string {
+	line = strings.TrimSpace(line)
+	if rest, ok := strings.CutPrefix(line, "# HELP "); ok {
+		line = rest
+	} else if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+		line = rest
+	} else if line == "" || strings.HasPrefix(line, "#") {
+		return ""
+	}
+	if i := strings.IndexAny(line, "{ \t"); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+func checkExpositionDuplicates(content string, families map[string]*dto.MetricFamily) error {
+	seen := make(map[string]bool)
+	current := ""
+	for i, line := range strings.Split(content, "\n") {
+		name := expositionLineName(line)
+		if name == "" {
+			continue
+		}
+		family := expositionFamilyName(name, families)
+		if family == current {
+			continue
+		}
+		if seen[family] {
+			return fmt.Errorf("line %d: metric family %q is split across non-contiguous blocks", i+1, family)
+		}
+		seen[family] = true
+		current = family
+	}
+
+	for name, mf := range families {
+		series := make(map[string]bool, len(mf.GetMetric()))
+		for _, m := range mf.GetMetric() {
+			pairs := make([]string, 0, len(m.GetLabel()))
+			for _, lp := range m.GetLabel() {
+				pairs = append(pairs, fmt.Sprintf("%s=%q", lp.GetName(), lp.GetValue()))
+			}
+			sort.Strings(pairs)
+			key := strings.Join(pairs, ",")
+			if series[key] {
+				return fmt.Errorf("metric %q exposes series {%s} more than once", name, key)
+			}
+			series[key] = true
+		}
+	}
+	return nil
+}
+
+func checkExpositionTypes(content string, families map[string]*dto.MetricFamily) error {
+	typed := make(map[string]bool)
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "# TYPE ") {
+			typed[expositionLineName(line)] = true
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !typed[name] {
+			return fmt.Errorf("metric %q has no TYPE line", name)
+		}
+		mf := families[name]
+		if mf.GetType() != dto.MetricType_HISTOGRAM && mf.GetType() != dto.MetricType_SUMMARY {
+			continue
+		}
+		for _, suffix := range []string{"_bucket", "_count", "_sum"} {
+			if other, ok := families[name+suffix]; ok {
+				return fmt.Errorf("metric %q of type %s collides with the %s series of %s %q",
+					name+suffix, other.GetType(), suffix, strings.ToLower(mf.GetType().String()), name)
+			}
+		}
+	}
+	return nil
+}
+
+// ScrapeAllPodMetrics scrapes the per-site exporter of all squid pods and merges the results into
+// a single snapshot, with the series of all pods appended to the same-named metric family.
+// Unlike GetAggregatedMetrics, a pod that cannot be scraped is an error, since a partial
+// snapshot would make DiffMetricSnapshots report spurious deltas.
+//
+// Example usage:
+//
+//	before, err := ScrapeAllPodMetrics(ctx, clientset, metricsClient, namespace)
+//	// ... generate traffic ...
+//	after, err := ScrapeAllPodMetrics(ctx, clientset, metricsClient, namespace)
+//	deltas := DiffMetricSnapshots(before, after, "squid_site_requests_total")
+func ScrapeAllPodMetrics(ctx context.Context, client kubernetes.Interface, metricsHTTPClient *http.Client, namespace string) (map[string]*dto.MetricFamily, error) {
+	snapshot, _, err := ScrapeAllPodMetricsWithStats(ctx, client, metricsHTTPClient, namespace)
+	return snapshot, err
+}
+
+// ScrapeAllPodMetricsWithStats is ScrapeAllPodMetrics, additionally returning how long each pod took
+// to scrape. The stats are returned on errors too, covering the pods scraped so far.
+func ScrapeAllPodMetricsWithStats(ctx context.Context, client kubernetes.Interface, metricsHTTPClient *http.Client, namespace string) (map[string]*dto.MetricFamily, *ScrapeStats, error) {
+	stats := &ScrapeStats{}
 	pods, err := GetPods(ctx, client, namespace, SquidStatefulSetName)
 	if err != nil {
-		fmt.Printf("DEBUG: Error getting pods: %v\n", err)
-		return podMetrics, fmt.Errorf("error getting pods: %w", err)
+		return nil, stats, fmt.Errorf("error getting pods: %w", err)
 	}
 
+	snapshot := make(map[string]*dto.MetricFamily)
 	for _, pod := range pods {
-		podIP := pod.Status.PodIP
-		metricsURL := fmt.Sprintf("https://%s:9302/metrics", podIP)
+		body, err := scrapePodMetrics(metricsHTTPClient, pod, stats)
+		if err != nil {
+			return nil, stats, err
+		}
 
-		fmt.Printf("DEBUG: Querying metrics from pod %s (%s) at %s\n", pod.Name, podIP, metricsURL)
-		resp, err := metricsHTTPClient.Get(metricsURL)
+		metricFamilies, err := ParseMetricFamilies(body)
 		if err != nil {
-			fmt.Printf("DEBUG: Error querying pod %s: %v\n", pod.Name, err)
-			continue
+			return nil, stats, fmt.Errorf("error parsing metrics from pod %s: %w", pod.Name, err)
+		}
+		for name, family := range metricFamilies {
+			if existing, found := snapshot[name]; found {
+				existing.Metric = append(existing.Metric, family.Metric...)
+			} else {
+				snapshot[name] = family
+			}
+		}
+	}
+	return snapshot, stats, nil
+}
+
+// DiffMetricSnapshots returns the change of metricName between two snapshots, keyed by the
+// series' label tuple (sorted `name="value"` pairs joined by commas, e.g. `hostname="example.com"`).
+// Series with the same labels (e.g. from different pods) are summed, and series that did not
+// change are omitted. A series missing from a snapshot counts as 0 there.
+// For HISTOGRAM and SUMMARY metrics the delta is the change in sample count.
+//
+// Example usage:
+//
+//	deltas := DiffMetricSnapshots(before, after, "squid_site_requests_total")
+//	// deltas will be: map[string]float64{`hostname="example.com"`: 3}
+func DiffMetricSnapshots(before, after map[string]*dto.MetricFamily, metricName string) map[string]float64 {
+	deltas := make(map[string]float64)
+	for key, value := range sumMetricSeries(after[metricName]) {
+		deltas[key] += value
+	}
+	for key, value := range sumMetricSeries(before[metricName]) {
+		deltas[key] -= value
+	}
+	for key, delta := range deltas {
+		if delta == 0 {
+			delete(deltas, key)
 		}
-		defer resp.Body.Close()
+	}
+	return deltas
+}
 
-		bodyBytes, err := io.ReadAll(resp.Body)
+// SampleCounterOverTime reads a counter with scrapeFn samples times, interval apart, starting
+// immediately. Use CounterDeltas on the result to check the counter moved by exactly the
+// generated load in each interval, rather than just by at least N overall.
+//
+// Example usage:
+//
+//	values, err := SampleCounterOverTime(func() (float64, error) {
+//		snapshot, err := ScrapeAllPodMetrics(ctx, clientset, metricsClient, namespace)
+//		if err != nil {
+//			return 0, err
+//		}
+//		return DiffMetricSnapshots(nil, snapshot, "squid_site_requests_total")[`hostname="example.com"`], nil
+//	}, 5, 2*time.Second)
+func SampleCounterOverTime(scrapeFn func() (float64, error), samples int, interval time.Duration) ([]float64, error) {
+	if samples < 1 {
+		return nil, fmt.Errorf("samples must be at least 1, got %d", samples)
+	}
+
+	values := make([]float64, 0, samples)
+	for i := range samples {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		value, err := scrapeFn()
 		if err != nil {
-			fmt.Printf("DEBUG: Error reading response from %s: %v\n", pod.Name, err)
-			continue
+			return values, fmt.Errorf("failed to scrape sample %d of %d: %w", i+1, samples, err)
 		}
+		values = append(values, value)
+	}
+	return values, nil
+}
 
-		// Parse metrics for this pod
-		bodyString := string(bodyBytes)
-		podValue, err := GetPerSiteMetricsValue(bodyString, metricName, hostname)
+// CounterDeltas returns the increase of a counter between consecutive samples, so n samples
+// give n-1 deltas. A decrease means the counter was reset (e.g. the pod restarted) and is an error,
+// since the deltas around it would not reflect the generated load.
+func CounterDeltas(samples []float64) ([]float64, error) {
+	if len(samples) < 2 {
+		return nil, nil
+	}
+	deltas := make([]float64, 0, len(samples)-1)
+	for i := 1; i < len(samples); i++ {
+		delta := samples[i] - samples[i-1]
+		if delta < 0 {
+			return nil, fmt.Errorf("counter decreased from %v to %v between samples %d and %d", samples[i-1], samples[i], i, i+1)
+		}
+		deltas = append(deltas, delta)
+	}
+	return deltas, nil
+}
+
+// sumMetricSeries returns the values of all series of metricFamily summed by label tuple
+func sumMetricSeries(metricFamily *dto.MetricFamily) map[string]float64 {
+	sums := make(map[string]float64)
+	if metricFamily == nil {
+		return sums
+	}
+	for _, metric := range metricFamily.Metric {
+		value, err := extractMetricValue(metricFamily, metric)
 		if err != nil {
-			fmt.Printf("DEBUG: Error parsing metric %s for hostname %s from pod %s: %v\n", metricName, hostname, pod.Name, err)
 			continue
 		}
-
-		podMetrics[pod.Name] = podValue
-		fmt.Printf("DEBUG: Pod %s %s for %s: %.0f\n", pod.Name, metricName, hostname, podValue)
+		sums[metricLabelKey(metric)] += value
 	}
+	return sums
+}
 
-	return podMetrics, nil
+// metricLabelKey returns the label tuple of metric as sorted `name="value"` pairs joined by commas
+func metricLabelKey(metric *dto.Metric) string {
+	pairs := make([]string, 0, len(metric.Label))
+	for _, label := range metric.Label {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", label.GetName(), label.GetValue()))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
 }
 
 // GetContainerRestartCounts returns a map of pod name to restart count for the
@@ -1170,8 +2951,17 @@ func GetRESTConfig() (*rest.Config, error) {
 	return config, nil
 }
 
+// ErrExecTimeout is wrapped by errors of pod exec commands that did not finish before their deadline
+var ErrExecTimeout = errors.New("command in pod timed out")
+
+// newExecutor creates the executor of pod exec requests; replaced in tests
+var newExecutor = func(config *rest.Config, method string, execURL *url.URL) (remotecommand.Executor, error) {
+	return remotecommand.NewSPDYExecutor(config, method, execURL)
+}
+
 // ExecCommandInPod executes a command in a container within a pod using kubectl exec.
 // It returns the stdout and stderr output as strings, or an error if execution fails.
+// The output captured before a failure or timeout is returned along with the error.
 // This is a convenience wrapper around ExecCommandInPodWithWriters that captures output.
 func ExecCommandInPod(ctx context.Context, client kubernetes.Interface, restConfig *rest.Config,
 	namespace, podName, containerName string, command []string) (stdout, stderr string, err error) {
@@ -1185,9 +2975,17 @@ func ExecCommandInPod(ctx context.Context, client kubernetes.Interface, restConf
 
 // ExecCommandInPodWithWriters executes a command in a container within a pod using kubectl exec.
 // stdout and stderr writers can be nil, in which case output will be discarded.
+// If ctx has no deadline, the command is bounded by ExecTimeout so a hung exec cannot block the suite;
+// errors of commands that run past their deadline wrap ErrExecTimeout.
 // This function uses the Kubernetes client-go remotecommand API to execute commands.
 func ExecCommandInPodWithWriters(ctx context.Context, client kubernetes.Interface, restConfig *rest.Config,
 	namespace, podName, containerName string, command []string, stdout, stderr io.Writer) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ExecTimeout)
+		defer cancel()
+	}
+
 	// Build the exec request
 	execReq := client.CoreV1().RESTClient().Post().
 		Resource("pods").
@@ -1204,7 +3002,7 @@ func ExecCommandInPodWithWriters(ctx context.Context, client kubernetes.Interfac
 	}
 
 	// Create the executor
-	executor, err := remotecommand.NewSPDYExecutor(restConfig, "POST", execReq.URL())
+	executor, err := newExecutor(restConfig, "POST", execReq.URL())
 	if err != nil {
 		return fmt.Errorf("failed to create executor: %w", err)
 	}
@@ -1223,12 +3021,113 @@ func ExecCommandInPodWithWriters(ctx context.Context, client kubernetes.Interfac
 		Stderr: stderr,
 	})
 	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %v in pod %s: %w", ErrExecTimeout, command, podName, err)
+		}
 		return fmt.Errorf("failed to execute command: %w", err)
 	}
 
 	return nil
 }
 
+// squidCacheManagerObjectsURL is the cache manager report listing the store entries, only served to localhost
+const squidCacheManagerObjectsURL = "http://127.0.0.1:3128/squid-internal-mgr/objects"
+
+// WaitForCachedObject polls the Squid cache manager inside pod until it lists a complete (STORE_OK)
+// entry for objectURL, so tests can expect a HIT after a MISS without sleeping. objectURL is the
+// URL as cached by Squid, i.e. the store-id for normalized URLs.
+func WaitForCachedObject(ctx context.Context, client kubernetes.Interface, restConfig *rest.Config,
+	namespace, pod, objectURL string, timeout time.Duration) error {
+	var lastErr error
+	err := wait.PollUntilContextTimeout(ctx, min(Interval, timeout), timeout, true, func(ctx context.Context) (bool, error) {
+		stdout, stderr, err := ExecCommandInPod(ctx, client, restConfig, namespace, pod, SquidContainerName,
+			[]string{"curl", "-sf", squidCacheManagerObjectsURL})
+		if err != nil {
+			lastErr = fmt.Errorf("failed to query the cache manager: %w: %s", err, stderr)
+			return false, nil
+		}
+		if !CacheManagerHasObject(stdout, objectURL) {
+			lastErr = fmt.Errorf("cache manager does not list a complete entry for %s", objectURL)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		if lastErr == nil {
+			lastErr = err
+		}
+		return fmt.Errorf("timed out waiting for pod %s to cache %s: %w", pod, objectURL, lastErr)
+	}
+	return nil
+}
+
+// AssertSameStoreID fails the current test unless the store-id helper configured by values maps
+// urlA and urlB to one store-id, assuming the origin authorizes both. Pass the StoreIDValues the
+// chart was installed with; the zero value is the chart default.
+//
+// Example usage:
+//
+//	AssertSameStoreID(
+//		"https://cdn01.quay.io/quayio-production-s3/sha256/3f/<digest>?X-Amz-Signature=abc",
+//		"https://cdn02.quay.io/quayio-production-s3/sha256/3f/<digest>?X-Amz-Signature=def",
+//		StoreIDValues{})
+func AssertSameStoreID(urlA, urlB string, values StoreIDValues) {
+	ginkgo.GinkgoHelper()
+	options := storeid.Options{PackageRegistries: values.PackageRegistries}
+	Expect(storeid.Compute(urlA, options)).To(Equal(storeid.Compute(urlB, options)),
+		"%s and %s should share a store-id", urlA, urlB)
+}
+
+// CacheManagerHasObject reports whether the cache manager objects report lists a complete (STORE_OK)
+// entry for objectURL. Each entry starts with a "KEY <hash>" line, followed by indented lines with
+// the entry's state flags and its "<method> <url>".
+func CacheManagerHasObject(objects, objectURL string) bool {
+	var complete, matches bool
+	for line := range strings.Lines(objects) {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "KEY" {
+			if complete && matches {
+				return true
+			}
+			complete, matches = false, false
+			continue
+		}
+		for _, field := range fields {
+			switch field {
+			case "STORE_OK":
+				complete = true
+			case objectURL:
+				matches = true
+			}
+		}
+	}
+	return complete && matches
+}
+
+// GetSquidCacheDiskUsage returns the bytes used by the Squid cache_dir in pod, as reported by
+// "du -sb". Comparing snapshots taken around a pull shows that objects were written to disk,
+// rather than inferring it from TCP_HIT log entries.
+func GetSquidCacheDiskUsage(ctx context.Context, client kubernetes.Interface, restConfig *rest.Config,
+	namespace, pod string) (int64, error) {
+	stdout, stderr, err := ExecCommandInPod(ctx, client, restConfig, namespace, pod, SquidContainerName,
+		[]string{"du", "-sb", SquidCacheDir})
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure the cache disk usage of pod %s: %w: %s", pod, err, stderr)
+	}
+	fields := strings.Fields(stdout)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected du output from pod %s: %q", pod, stdout)
+	}
+	usage, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected du output from pod %s: %q: %w", pod, stdout, err)
+	}
+	return usage, nil
+}
+
 // GetNginxTestBackendURL returns the URL for the nginx test backend service.
 func GetNginxTestBackendURL() string {
 	return fmt.Sprintf("http://%s.%s.svc.cluster.local:%d", NginxTestBackendServiceName, Namespace, NginxTestBackendPort)
@@ -1257,3 +3156,97 @@ func CreateAuthSecret(ctx context.Context, client kubernetes.Interface, secretNa
 	fmt.Printf("Created auth secret '%s' in namespace '%s'\n", secretName, Namespace)
 	return nil
 }
+
+// TestServerCAIssuerName is the ClusterIssuer signing the test-server certificates. Squid trusts its
+// CA through the test-server-bundle ConfigMap (see tlsOutgoingOptions.caFile).
+const TestServerCAIssuerName = "test-server-ca-issuer"
+
+// CreateTestServerTLS creates a cert-manager Certificate for dnsNames, signed by the test-server CA
+// issuer, and waits for it to be issued. It returns the name of the TLS secret holding the serving
+// certificate and the PEM-encoded CA that clients and Squid use to trust it. The cleanup function
+// deletes the Certificate; cert-manager only deletes the secret when certificate owner references
+// are enabled.
+func CreateTestServerTLS(ctx context.Context, client certmanagerclient.Interface, namespace string,
+	dnsNames []string) (string, []byte, func(), error) {
+	if len(dnsNames) == 0 {
+		return "", nil, nil, fmt.Errorf("at least one DNS name is required")
+	}
+
+	certName := fmt.Sprintf("test-server-tls-%x", time.Now().UnixNano())
+	cert := &certmanagerv1.Certificate{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      certName,
+			Namespace: namespace,
+		},
+		Spec: certmanagerv1.CertificateSpec{
+			SecretName: certName,
+			CommonName: dnsNames[0],
+			DNSNames:   dnsNames,
+			Duration:   &metav1.Duration{Duration: time.Hour * 24},
+			PrivateKey: &certmanagerv1.CertificatePrivateKey{
+				Algorithm: certmanagerv1.ECDSAKeyAlgorithm,
+				Size:      256,
+			},
+			IssuerRef: certmanagermeta.ObjectReference{
+				Name:  TestServerCAIssuerName,
+				Kind:  "ClusterIssuer",
+				Group: "cert-manager.io",
+			},
+		},
+	}
+
+	certificates := client.CertmanagerV1().Certificates(namespace)
+	if _, err := certificates.Create(ctx, cert, metav1.CreateOptions{}); err != nil {
+		return "", nil, nil, fmt.Errorf("failed to create certificate %s: %w", certName, err)
+	}
+	cleanup := func() {
+		err := certificates.Delete(context.Background(), certName, metav1.DeleteOptions{})
+		if err != nil {
+			fmt.Printf("Failed to delete certificate %s: %v\n", certName, err)
+		}
+	}
+
+	var caPEM []byte
+	err := wait.PollUntilContextTimeout(ctx, Interval, Timeout, true, func(ctx context.Context) (bool, error) {
+		current, err := certificates.Get(ctx, certName, metav1.GetOptions{})
+		if err != nil || !isCertificateReady(current) {
+			return false, nil
+		}
+		requests, err := client.CertmanagerV1().CertificateRequests(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, nil
+		}
+		caPEM = certificateRequestCA(requests.Items, certName)
+		return len(caPEM) > 0, nil
+	})
+	if err != nil {
+		cleanup()
+		return "", nil, nil, fmt.Errorf("timed out waiting for certificate %s to be issued: %w", certName, err)
+	}
+
+	return certName, caPEM, cleanup, nil
+}
+
+// isCertificateReady reports whether the Certificate has a true Ready condition
+func isCertificateReady(cert *certmanagerv1.Certificate) bool {
+	for _, condition := range cert.Status.Conditions {
+		if condition.Type == certmanagerv1.CertificateConditionReady {
+			return condition.Status == certmanagermeta.ConditionTrue
+		}
+	}
+	return false
+}
+
+// certificateRequestCA returns the CA recorded on the CertificateRequests issued for the named
+// Certificate, or nil when none has been signed yet
+func certificateRequestCA(requests []certmanagerv1.CertificateRequest, certName string) []byte {
+	for _, request := range requests {
+		if request.Annotations[certmanagerv1.CertificateNameKey] != certName {
+			continue
+		}
+		if len(request.Status.CA) > 0 {
+			return request.Status.CA
+		}
+	}
+	return nil
+}
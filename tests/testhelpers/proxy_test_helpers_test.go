@@ -0,0 +1,1851 @@
+package testhelpers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	certmanagermeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	certmanagerfake "github.com/cert-manager/cert-manager/pkg/client/clientset/versioned/fake"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// responseWithVia builds a response carrying the given Via header (omitted when empty)
+func responseWithVia(via string) *http.Response {
+	resp := &http.Response{Header: make(http.Header)}
+	if via != "" {
+		resp.Header.Set("Via", via)
+	}
+	return resp
+}
+
+var _ = Describe("ParseViaHeader", func() {
+	When("given a well-formed Via header", func() {
+		It("should return the protocol, pod and version", func() {
+			via, err := ParseViaHeader(responseWithVia("1.1 squid-0 (squid/6.10)"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(via).To(Equal(&ViaHeaderInfo{Protocol: "1.1", Pod: "squid-0", Version: "6.10"}))
+		})
+
+		It("should pick the Squid entry when there are several hops", func() {
+			via, err := ParseViaHeader(responseWithVia("1.1 upstream-proxy, 1.1 squid-2 (squid/6.10)"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(via.Pod).To(Equal("squid-2"))
+			Expect(via.Version).To(Equal("6.10"))
+		})
+	})
+
+	When("given a malformed Via header", func() {
+		DescribeTable("should return an error",
+			func(header string) {
+				_, err := ParseViaHeader(responseWithVia(header))
+				Expect(err).To(MatchError(ContainSubstring("malformed via header")))
+			},
+			Entry("protocol only", "1.1"),
+			Entry("no version comment", "1.1 squid-0"),
+			Entry("non-squid comment", "1.1 proxy-0 (nginx/1.26)"),
+			Entry("empty version", "1.1 squid-0 (squid/)"),
+			Entry("unterminated comment", "1.1 squid-0 (squid/6.10"),
+		)
+	})
+
+	When("the Via header is missing", func() {
+		It("should return an error", func() {
+			_, err := ParseViaHeader(responseWithVia(""))
+			Expect(err).To(MatchError("via header not found"))
+		})
+	})
+})
+
+var _ = Describe("ExtractSquidVersionFromViaHeader", func() {
+	It("should return the Squid version", func() {
+		version, err := ExtractSquidVersionFromViaHeader(responseWithVia("1.1 squid-0 (squid/6.10)"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(version).To(Equal("6.10"))
+	})
+
+	It("should return an error when the header is malformed", func() {
+		version, err := ExtractSquidVersionFromViaHeader(responseWithVia("1.1 squid-0"))
+		Expect(err).To(HaveOccurred())
+		Expect(version).To(BeEmpty())
+	})
+
+	It("should not change ExtractSquidPodFromViaHeader behavior", func() {
+		Expect(ExtractSquidPodFromViaHeader(responseWithVia("1.1 squid-0 (squid/6.10)"))).To(Equal("squid-0"))
+		Expect(ExtractSquidPodFromViaHeader(responseWithVia(""))).To(BeEmpty())
+	})
+})
+
+var _ = Describe("ExtractSquidPodFromViaHeader", func() {
+	It("should fall back to the X-Squid-Pod header when Via is disabled", func() {
+		resp := responseWithVia("")
+		resp.Header.Set(SquidPodHeader, "squid-1")
+		Expect(ExtractSquidPodFromViaHeader(resp)).To(Equal("squid-1"))
+	})
+
+	It("should prefer the Via header when both are present", func() {
+		resp := responseWithVia("1.1 squid-0 (squid/6.10)")
+		resp.Header.Set(SquidPodHeader, "squid-1")
+		Expect(ExtractSquidPodFromViaHeader(resp)).To(Equal("squid-0"))
+	})
+})
+
+var _ = Describe("CountDistinctServingPods", func() {
+	It("should tally the pods that served the requests on new connections", func() {
+		pods := []string{"squid-0", "squid-1", "squid-0"}
+		var requests, closing int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Close {
+				closing++
+			}
+			w.Header().Set("Via", "1.1 "+pods[requests%len(pods)]+" (squid/6.10)")
+			requests++
+		}))
+		DeferCleanup(server.Close)
+
+		servedBy, err := CountDistinctServingPods(server.Client(), server.URL, 6)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(servedBy).To(Equal(map[string]int{"squid-0": 4, "squid-1": 2}))
+		Expect(closing).To(Equal(6), "every request should use a new connection")
+	})
+
+	It("should return an error when a response does not name the serving pod", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		DeferCleanup(server.Close)
+
+		_, err := CountDistinctServingPods(server.Client(), server.URL, 1)
+		Expect(err).To(MatchError(ContainSubstring("does not name the serving squid pod")))
+	})
+})
+
+var _ = Describe("RunConcurrentProxyLoad", func() {
+	It("should make every request concurrently and tally statuses and pods", func() {
+		// The first requests wait until all workers are in flight, bounded in case they never are
+		var (
+			mutex                 sync.Mutex
+			inFlight, maxInFlight int
+			releaseOnce           sync.Once
+		)
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			inFlight++
+			maxInFlight = max(maxInFlight, inFlight)
+			if inFlight == 4 {
+				releaseOnce.Do(func() { close(release) })
+			}
+			mutex.Unlock()
+			defer func() {
+				mutex.Lock()
+				inFlight--
+				mutex.Unlock()
+			}()
+			select {
+			case <-release:
+			case <-time.After(5 * time.Second):
+			}
+			w.Header().Set("Via", "1.1 squid-0 (squid/6.10)")
+			if r.URL.Path == "/missing" {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		DeferCleanup(server.Close)
+
+		result, err := RunConcurrentProxyLoad(server.Client(), []string{server.URL + "/a", server.URL + "/missing"}, 4, 3)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Requests).To(Equal(12))
+		Expect(result.Errors).To(BeEmpty())
+		Expect(result.Successes).To(Equal(6))
+		Expect(result.Statuses).To(Equal(map[int]int{http.StatusOK: 6, http.StatusNotFound: 6}))
+		Expect(result.Pods).To(Equal(map[string]int{"squid-0": 12}))
+		Expect(maxInFlight).To(Equal(4), "all workers should request concurrently")
+	})
+
+	It("should record failed requests without stopping the load", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server.Close()
+
+		result, err := RunConcurrentProxyLoad(server.Client(), []string{server.URL}, 2, 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Requests).To(Equal(4))
+		Expect(result.Errors).To(HaveLen(4))
+		Expect(result.Successes).To(BeZero())
+	})
+
+	DescribeTable("should reject invalid arguments",
+		func(urls []string, workers, iterations int) {
+			_, err := RunConcurrentProxyLoad(http.DefaultClient, urls, workers, iterations)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("no URLs", nil, 1, 1),
+		Entry("no workers", []string{"http://example.com"}, 0, 1),
+		Entry("no iterations", []string{"http://example.com"}, 1, 0),
+	)
+})
+
+var _ = Describe("AssertCollapsedForwarding", func() {
+	var server *CachingTestServer
+
+	BeforeEach(func() {
+		var err error
+		server, err = NewCachingTestServer("collapsed forwarding", "127.0.0.1", 0)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(server.Close)
+	})
+
+	It("should fail when every concurrent request reaches the origin", func() {
+		Expect(InterceptGomegaFailures(func() {
+			AssertCollapsedForwarding(http.DefaultClient, server, server.URL, 3, 1, 100*time.Millisecond)
+		})).NotTo(BeEmpty())
+		Expect(server.GetRequestCount()).To(BeEquivalentTo(3))
+	})
+
+	It("should pass when the origin requests stay within the bound", func() {
+		Expect(InterceptGomegaFailures(func() {
+			AssertCollapsedForwarding(http.DefaultClient, server, server.URL, 3, 3, 100*time.Millisecond)
+		})).To(BeEmpty())
+	})
+
+	It("should delay the responses so that the requests overlap", func() {
+		start := time.Now()
+		AssertCollapsedForwarding(http.DefaultClient, server, server.URL, 3, 3, 200*time.Millisecond)
+		Expect(time.Since(start)).To(BeNumerically("<", 600*time.Millisecond), "the requests should be in flight at the same time")
+
+		start = time.Now()
+		resp, _, err := MakeCachingRequest(http.DefaultClient, server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(time.Since(start)).To(BeNumerically("<", 200*time.Millisecond), "the delay should be reset afterwards")
+	})
+})
+
+var _ = Describe("ShutdownAndAssertStaleHit", func() {
+	newServer := func(cacheControl string) *CachingTestServer {
+		server, err := NewCachingTestServerWithOptions("stale", "127.0.0.1", 0, CachingTestServerOptions{
+			Headers: http.Header{"Cache-Control": {cacheControl}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(server.Close)
+		return server
+	}
+
+	It("should pass when the cached response is served after the origin shuts down", func() {
+		server := newServer("public, max-age=0")
+		client := &http.Client{Transport: &fakeStaleTransport{bodies: map[string][]byte{}}}
+
+		Expect(InterceptGomegaFailures(func() {
+			ShutdownAndAssertStaleHit(server, client, server.URL+"/stale")
+		})).To(BeEmpty())
+		Expect(server.GetRequestCount()).To(BeEquivalentTo(1))
+	})
+
+	It("should fail when nothing is served after the origin shuts down", func() {
+		server := newServer("public, max-age=0")
+
+		Expect(InterceptGomegaFailures(func() {
+			ShutdownAndAssertStaleHit(server, http.DefaultClient, server.URL+"/stale")
+		})).NotTo(BeEmpty())
+	})
+
+	It("should fail without waiting for a response that stays fresh too long", func() {
+		server := newServer("public, max-age=300")
+		client := &http.Client{Transport: &fakeStaleTransport{bodies: map[string][]byte{}}}
+
+		start := time.Now()
+		Expect(InterceptGomegaFailures(func() {
+			ShutdownAndAssertStaleHit(server, client, server.URL+"/stale")
+		})).To(ConsistOf(ContainSubstring("use a shorter max-age")))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+})
+
+var _ = Describe("responseFreshness", func() {
+	DescribeTable("should return the remaining max-age plus a second",
+		func(header http.Header, expected time.Duration) {
+			Expect(responseFreshness(&http.Response{Header: header})).To(Equal(expected))
+		},
+		Entry("max-age", http.Header{"Cache-Control": {"public, max-age=5"}}, 6*time.Second),
+		Entry("max-age in a second header", http.Header{"Cache-Control": {"public", "MAX-AGE=5"}}, 6*time.Second),
+		Entry("age", http.Header{"Cache-Control": {"max-age=5"}, "Age": {"3"}}, 3*time.Second),
+		Entry("expired", http.Header{"Cache-Control": {"max-age=5"}, "Age": {"10"}}, time.Second),
+		Entry("no max-age", http.Header{"Cache-Control": {"no-cache"}}, time.Second),
+	)
+})
+
+// fakeStaleTransport simulates a Squid pod that serves the last response of a URL when the
+// origin can't be reached
+type fakeStaleTransport struct {
+	mu     sync.Mutex
+	bodies map[string][]byte
+}
+
+func (f *fakeStaleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	header := http.Header{"Via": {"1.1 squid-0 (squid/6.10)"}}
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		body, ok := f.bodies[req.URL.String()]
+		if !ok {
+			return nil, err
+		}
+		header.Set("X-Cache", "HIT from squid-0")
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(bytes.NewReader(body))}, nil
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.bodies[req.URL.String()] = body
+	header.Set("Cache-Control", resp.Header.Get("Cache-Control"))
+	header.Set("X-Cache", "MISS from squid-0")
+	return &http.Response{StatusCode: resp.StatusCode, Header: header, Body: io.NopCloser(bytes.NewReader(body))}, nil
+}
+
+// fakeCachingTransport simulates a Squid pod that serves repeated requests from its cache
+// unless the origin response has Cache-Control: no-store
+type fakeCachingTransport struct {
+	mu     sync.Mutex
+	cached map[string]bool
+	header http.Header
+}
+
+func (f *fakeCachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.header = req.Header.Clone()
+	if f.cached[req.URL.String()] {
+		header := http.Header{"Via": {"1.1 squid-0 (squid/6.10)"}, "X-Cache": {"HIT from squid-0"}}
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader("cached"))}, nil
+	}
+
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Header.Set("Via", "1.1 squid-0 (squid/6.10)")
+	resp.Header.Set("X-Cache", "MISS from squid-0")
+	f.cached[req.URL.String()] = !strings.Contains(resp.Header.Get("Cache-Control"), "no-store")
+	return resp, nil
+}
+
+var _ = Describe("NewCachingTestServerWithOptions", func() {
+	It("should replace and remove the default response headers", func() {
+		server, err := NewCachingTestServerWithOptions("options", "127.0.0.1", 0, CachingTestServerOptions{
+			Headers: http.Header{
+				"Cache-Control": {"no-store"},
+				"Content-Type":  {""},
+				"Vary":          {"Accept-Encoding", "Accept"},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(server.Close)
+
+		resp, body, err := MakeCachingRequest(http.DefaultClient, server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.Header.Values("Cache-Control")).To(Equal([]string{"no-store"}))
+		Expect(resp.Header.Values("Vary")).To(Equal([]string{"Accept-Encoding", "Accept"}))
+		Expect(resp.Header.Get("Content-Type")).NotTo(Equal("application/json"), "the default Content-Type should be removed")
+		Expect(ParseTestServerResponse(body)).To(HaveField("Message", "options"))
+	})
+
+	Context("with sized responses", func() {
+		var server *CachingTestServer
+
+		BeforeEach(func() {
+			var err error
+			server, err = NewCachingTestServerWithOptions("sized", "127.0.0.1", 0, CachingTestServerOptions{
+				MaxResponseSize: 1 << 20,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(server.Close)
+		})
+
+		DescribeTable("should serve a body of the requested size",
+			func(size int) {
+				resp, body, err := MakeCachingRequest(http.DefaultClient, fmt.Sprintf("%s/sized?size=%d", server.URL, size))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.ContentLength).To(BeEquivalentTo(size))
+				Expect(body).To(HaveLen(size))
+				Expect(resp.Header.Get("Content-Type")).To(Equal("application/octet-stream"))
+				Expect(resp.Header.Get("Cache-Control")).To(Equal("public, max-age=300"))
+			},
+			Entry("empty", 0),
+			Entry("smaller than a chunk", 1000),
+			Entry("several chunks", 100*1024+1),
+			Entry("the maximum size", 1<<20),
+		)
+
+		DescribeTable("should reject invalid sizes",
+			func(size string) {
+				resp, _, err := MakeCachingRequest(http.DefaultClient, server.URL+"/sized?size="+size)
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			},
+			Entry("over the maximum size", "1048577"),
+			Entry("negative", "-1"),
+			Entry("not a number", "large"),
+		)
+
+		It("should serve the JSON response without a size", func() {
+			resp, body, err := MakeCachingRequest(http.DefaultClient, server.URL+"/sized")
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(ParseTestServerResponse(body)).To(HaveField("Message", "sized"))
+		})
+	})
+
+	It("should ignore the size unless sized responses are enabled", func() {
+		server, err := NewCachingTestServerWithOptions("unsized", "127.0.0.1", 0, CachingTestServerOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(server.Close)
+
+		resp, body, err := MakeCachingRequest(http.DefaultClient, server.URL+"/unsized?size=1000")
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(ParseTestServerResponse(body)).To(HaveField("Message", "unsized"))
+	})
+})
+
+var _ = Describe("CheckCacheability", func() {
+	newServer := func(headers http.Header) *CachingTestServer {
+		server, err := NewCachingTestServerWithOptions("cacheability", "127.0.0.1", 0, CachingTestServerOptions{Headers: headers})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(server.Close)
+		return server
+	}
+
+	It("should report a response served from the cache", func() {
+		server := newServer(nil)
+		transport := &fakeCachingTransport{cached: map[string]bool{}}
+
+		result, err := CheckCacheability(&http.Client{Transport: transport}, server, server.URL+"/cached", nil, 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(&CacheabilityResult{Cached: true, Requests: 3, OriginRequests: 1}))
+	})
+
+	It("should report a response that is never served from the cache", func() {
+		server := newServer(http.Header{"Cache-Control": {"no-store"}})
+		transport := &fakeCachingTransport{cached: map[string]bool{}}
+
+		result, err := CheckCacheability(&http.Client{Transport: transport}, server, server.URL+"/uncached", nil, 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(&CacheabilityResult{Cached: false, Requests: 2, OriginRequests: 2}))
+	})
+
+	It("should send the request headers", func() {
+		server := newServer(nil)
+		transport := &fakeCachingTransport{cached: map[string]bool{}}
+
+		_, err := CheckCacheability(&http.Client{Transport: transport}, server, server.URL, http.Header{"Authorization": {"Bearer token"}}, 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(transport.header.Get("Authorization")).To(Equal("Bearer token"))
+	})
+
+	It("should return an error for unsuccessful responses", func() {
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		DeferCleanup(origin.Close)
+		server := newServer(nil)
+
+		_, err := CheckCacheability(http.DefaultClient, server, origin.URL, nil, 1)
+		Expect(err).To(MatchError(ContainSubstring("unexpected status 403")))
+	})
+})
+
+var _ = Describe("GetMetricSample", func() {
+	const metricsContent = `# TYPE squid_site_requests_total counter
+squid_site_requests_total{hostname="fresh.example.com"} 7 1732700000123
+squid_site_requests_total{hostname="untimed.example.com"} 3
+`
+
+	It("should return the value and timestamp when the sample has one", func() {
+		sample, err := GetPerSiteMetricsSample(metricsContent, "squid_site_requests_total", "fresh.example.com")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sample.Value).To(Equal(7.0))
+		Expect(sample.Timestamp).To(Equal(time.UnixMilli(1732700000123)))
+	})
+
+	It("should return a zero timestamp when the sample has none", func() {
+		sample, err := GetPerSiteMetricsSample(metricsContent, "squid_site_requests_total", "untimed.example.com")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sample.Value).To(Equal(3.0))
+		Expect(sample.Timestamp.IsZero()).To(BeTrue())
+	})
+
+	It("should return an error when the metric is missing", func() {
+		_, err := GetPerSiteMetricsSample(metricsContent, "squid_site_requests_total", "missing.example.com")
+		Expect(err).To(MatchError(ContainSubstring("not found")))
+	})
+})
+
+var _ = Describe("StreamPodLogsUntil", func() {
+	// The fake clientset serves "fake logs" as the log of every pod
+	var client *fake.Clientset
+
+	BeforeEach(func() {
+		client = fake.NewClientset()
+	})
+
+	It("should return the first matching line", func() {
+		line, err := StreamPodLogsUntil(context.Background(), client, "caching", "squid-0", SquidContainerName, nil,
+			func(line string) bool { return strings.Contains(line, "fake") }, time.Second)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(line).To(Equal("fake logs"))
+	})
+
+	It("should fail when the stream ends without a match", func() {
+		_, err := StreamPodLogsUntil(context.Background(), client, "caching", "squid-0", SquidContainerName, nil,
+			func(line string) bool { return strings.Contains(line, "TCP_HIT") }, time.Second)
+		Expect(err).To(MatchError("log stream of pod squid-0 ended without a matching line"))
+	})
+})
+
+var _ = Describe("newPodProxyClient", func() {
+	It("should proxy through the pod IP on the squid port", func() {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "squid-1"},
+			Status:     corev1.PodStatus{PodIP: "10.0.0.7"},
+		}
+		client, err := newPodProxyClient(pod)
+		Expect(err).NotTo(HaveOccurred())
+
+		transport, ok := client.Transport.(*http.Transport)
+		Expect(ok).To(BeTrue())
+		proxyURL, err := transport.Proxy(httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(proxyURL.String()).To(Equal("http://10.0.0.7:3128"))
+	})
+
+	It("should fail for pods without an IP", func() {
+		_, err := newPodProxyClient(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "squid-1"}})
+		Expect(err).To(MatchError("pod squid-1 has no IP"))
+	})
+})
+
+var _ = Describe("ValidatePrometheusExposition", func() {
+	const validContent = `# HELP squid_site_requests_total Total requests per site
+# TYPE squid_site_requests_total counter
+squid_site_requests_total{hostname="a.example.com"} 7
+squid_site_requests_total{hostname="b.example.com"} 3
+# HELP squid_site_response_time_seconds Response time per site
+# TYPE squid_site_response_time_seconds histogram
+squid_site_response_time_seconds_bucket{hostname="a.example.com",le="0.1"} 1
+squid_site_response_time_seconds_bucket{hostname="a.example.com",le="+Inf"} 2
+squid_site_response_time_seconds_sum{hostname="a.example.com"} 0.3
+squid_site_response_time_seconds_count{hostname="a.example.com"} 2
+`
+
+	It("should accept a valid exposition with all checks", func() {
+		Expect(ValidatePrometheusExposition(validContent, CheckDuplicateNames, CheckConsistentTypes)).To(Succeed())
+	})
+
+	DescribeTable("should reject malformed expositions",
+		func(content, reason string) {
+			Expect(ValidatePrometheusExposition(content)).To(MatchError(ContainSubstring(reason)))
+		},
+		Entry("empty content", "", "no metric families"),
+		Entry("unquoted label value", "m{x=1} 1\n", "label value"),
+		Entry("non-numeric value", "m one\n", "float"),
+		Entry("invalid type", "# TYPE m countr\nm 1\n", "unknown metric type"),
+		Entry("second TYPE line", "# TYPE m counter\nm 1\n# TYPE m gauge\n", "second TYPE line"),
+	)
+
+	DescribeTable("should reject what the parser accepts only when checked",
+		func(content string, check ExpositionCheck, reason string) {
+			Expect(ValidatePrometheusExposition(content)).To(Succeed())
+			Expect(ValidatePrometheusExposition(content, check)).To(MatchError(ContainSubstring(reason)))
+		},
+		Entry("split family", "# TYPE a counter\na 1\n# TYPE b gauge\nb 1\na{x=\"1\"} 2\n",
+			CheckDuplicateNames, `"a" is split across non-contiguous blocks`),
+		Entry("duplicate series", "# TYPE a counter\na{x=\"1\",y=\"2\"} 1\na{y=\"2\",x=\"1\"} 2\n",
+			CheckDuplicateNames, "more than once"),
+		Entry("missing TYPE line", "# TYPE a counter\na 1\nb 1\n",
+			CheckConsistentTypes, `"b" has no TYPE line`),
+		Entry("histogram suffix collision", "# TYPE squid_site_response_time_seconds_count gauge\n"+
+			"squid_site_response_time_seconds_count 1\n"+validContent,
+			CheckConsistentTypes, "collides with the _count series of histogram"),
+	)
+})
+
+var _ = Describe("AssertMetricAbsent", func() {
+	const metricsContent = `# TYPE squid_site_requests_total counter
+squid_site_requests_total{hostname="cdn.example.com"} 7
+# TYPE squid_site_hits_total counter
+squid_site_hits_total{hostname="example.com"} 1
+squid_site_hits_total{hostname="other.example.com"} 2
+`
+
+	DescribeTable("should pass when no sample matches",
+		func(metricName, hostname string) {
+			Expect(InterceptGomegaFailures(func() {
+				AssertMetricAbsent(metricsContent, metricName, hostname)
+			})).To(BeEmpty())
+		},
+		Entry("hostname that is a suffix of an existing label value", "squid_site_requests_total", "example.com"),
+		Entry("hostname only present in another metric", "squid_site_requests_total", "other.example.com"),
+		Entry("metric missing from the exposition", "squid_site_misses_total", "example.com"),
+	)
+
+	It("should fail when a sample for the hostname exists", func() {
+		Expect(InterceptGomegaFailures(func() {
+			AssertMetricAbsent(metricsContent, "squid_site_requests_total", "cdn.example.com")
+		})).NotTo(BeEmpty())
+	})
+
+	It("should fail on unparsable metrics", func() {
+		Expect(InterceptGomegaFailures(func() {
+			AssertMetricAbsent("squid_site_requests_total{hostname=", "squid_site_requests_total", "example.com")
+		})).NotTo(BeEmpty())
+	})
+})
+
+var _ = Describe("SampleCounterOverTime", func() {
+	It("should read the counter the requested number of times", func() {
+		var value float64
+		samples, err := SampleCounterOverTime(func() (float64, error) {
+			value += 2
+			return value, nil
+		}, 4, time.Millisecond)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(samples).To(Equal([]float64{2, 4, 6, 8}))
+	})
+
+	It("should return the samples read before a scrape error", func() {
+		calls := 0
+		samples, err := SampleCounterOverTime(func() (float64, error) {
+			calls++
+			if calls == 3 {
+				return 0, errors.New("connection refused")
+			}
+			return float64(calls), nil
+		}, 5, time.Millisecond)
+		Expect(err).To(MatchError(ContainSubstring("failed to scrape sample 3 of 5")))
+		Expect(samples).To(Equal([]float64{1, 2}))
+	})
+
+	It("should reject fewer than one sample", func() {
+		_, err := SampleCounterOverTime(func() (float64, error) { return 0, nil }, 0, time.Millisecond)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("CounterDeltas", func() {
+	It("should return the increase between consecutive samples", func() {
+		deltas, err := CounterDeltas([]float64{10, 11, 11, 14})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deltas).To(Equal([]float64{1, 0, 3}))
+	})
+
+	It("should return no deltas for fewer than two samples", func() {
+		deltas, err := CounterDeltas([]float64{10})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deltas).To(BeEmpty())
+	})
+
+	It("should report a counter reset", func() {
+		_, err := CounterDeltas([]float64{10, 12, 1})
+		Expect(err).To(MatchError(ContainSubstring("counter decreased from 12 to 1 between samples 2 and 3")))
+	})
+})
+
+var _ = Describe("DiffMetricSnapshots", func() {
+	parse := func(metricsContent string) map[string]*dto.MetricFamily {
+		metricFamilies, err := ParseMetricFamilies(metricsContent)
+		Expect(err).NotTo(HaveOccurred())
+		return metricFamilies
+	}
+
+	It("should return the change per series and omit unchanged series", func() {
+		before := parse(`# TYPE squid_site_requests_total counter
+squid_site_requests_total{hostname="example.com"} 2
+squid_site_requests_total{hostname="idle.example.com"} 5
+`)
+		after := parse(`# TYPE squid_site_requests_total counter
+squid_site_requests_total{hostname="example.com"} 5
+squid_site_requests_total{hostname="idle.example.com"} 5
+squid_site_requests_total{hostname="new.example.com"} 1
+`)
+
+		Expect(DiffMetricSnapshots(before, after, "squid_site_requests_total")).To(Equal(map[string]float64{
+			`hostname="example.com"`:     3,
+			`hostname="new.example.com"`: 1,
+		}))
+	})
+
+	It("should key series by their sorted label tuple", func() {
+		before := parse(`# TYPE squid_site_requests_total counter
+squid_site_requests_total{port="443",hostname="example.com"} 1
+`)
+		after := parse(`# TYPE squid_site_requests_total counter
+squid_site_requests_total{port="443",hostname="example.com"} 2
+`)
+
+		Expect(DiffMetricSnapshots(before, after, "squid_site_requests_total")).To(Equal(map[string]float64{
+			`hostname="example.com",port="443"`: 1,
+		}))
+	})
+
+	It("should sum series with the same labels from several pods", func() {
+		before := parse(`# TYPE squid_site_requests_total counter
+squid_site_requests_total{hostname="example.com"} 1
+`)
+		after := parse(`# TYPE squid_site_requests_total counter
+squid_site_requests_total{hostname="example.com"} 2
+`)
+		podB := parse(`# TYPE squid_site_requests_total counter
+squid_site_requests_total{hostname="example.com"} 4
+`)
+		after["squid_site_requests_total"].Metric = append(after["squid_site_requests_total"].Metric, podB["squid_site_requests_total"].Metric...)
+
+		Expect(DiffMetricSnapshots(before, after, "squid_site_requests_total")).To(Equal(map[string]float64{
+			`hostname="example.com"`: 5,
+		}))
+	})
+
+	It("should report negative deltas for series that disappeared", func() {
+		before := parse(`# TYPE squid_site_hit_ratio gauge
+squid_site_hit_ratio{hostname="example.com"} 0.5
+`)
+
+		Expect(DiffMetricSnapshots(before, map[string]*dto.MetricFamily{}, "squid_site_hit_ratio")).To(Equal(map[string]float64{
+			`hostname="example.com"`: -0.5,
+		}))
+	})
+
+	It("should diff histogram sample counts", func() {
+		before := parse(`# TYPE squid_site_response_time_seconds histogram
+squid_site_response_time_seconds_bucket{hostname="example.com",le="+Inf"} 1
+squid_site_response_time_seconds_sum{hostname="example.com"} 0.1
+squid_site_response_time_seconds_count{hostname="example.com"} 1
+`)
+		after := parse(`# TYPE squid_site_response_time_seconds histogram
+squid_site_response_time_seconds_bucket{hostname="example.com",le="+Inf"} 3
+squid_site_response_time_seconds_sum{hostname="example.com"} 0.3
+squid_site_response_time_seconds_count{hostname="example.com"} 3
+`)
+
+		Expect(DiffMetricSnapshots(before, after, "squid_site_response_time_seconds")).To(Equal(map[string]float64{
+			`hostname="example.com"`: 2,
+		}))
+	})
+
+	It("should return no deltas when the metric is in neither snapshot", func() {
+		Expect(DiffMetricSnapshots(nil, nil, "squid_site_requests_total")).To(BeEmpty())
+	})
+})
+
+var _ = Describe("ScrapeStats", func() {
+	It("should describe the scrape duration and error of each pod", func() {
+		stats := &ScrapeStats{}
+		stats.record("squid-0", 120*time.Millisecond, nil)
+		stats.record("squid-1", 8*time.Second, errors.New("connection refused"))
+
+		Expect(stats.String()).To(Equal("squid-0 took 120ms, squid-1 took 8s (error: connection refused)"))
+	})
+
+	It("should return the slowest pod scrape", func() {
+		stats := &ScrapeStats{}
+		stats.record("squid-0", time.Second, nil)
+		stats.record("squid-1", 8*time.Second, nil)
+		stats.record("squid-2", 2*time.Second, nil)
+
+		slowest, ok := stats.Slowest()
+		Expect(ok).To(BeTrue())
+		Expect(slowest.Pod).To(Equal("squid-1"))
+		Expect(slowest.Duration).To(Equal(8 * time.Second))
+	})
+
+	It("should report when no pods were scraped", func() {
+		stats := &ScrapeStats{}
+		_, ok := stats.Slowest()
+		Expect(ok).To(BeFalse())
+		Expect(stats.String()).To(Equal("no pods scraped"))
+	})
+})
+
+var _ = Describe("AssertMetricFresh", func() {
+	It("should pass when the sample timestamp is within the window", func() {
+		failures := InterceptGomegaFailures(func() {
+			AssertMetricFresh(func() (*MetricSample, error) {
+				return &MetricSample{Value: 1, Timestamp: time.Now()}, nil
+			}, time.Minute)
+		})
+		Expect(failures).To(BeEmpty())
+	})
+
+	It("should fail when the sample timestamp is older than the window", func() {
+		failures := InterceptGomegaFailures(func() {
+			AssertMetricFresh(func() (*MetricSample, error) {
+				return &MetricSample{Value: 1, Timestamp: time.Now().Add(-time.Hour)}, nil
+			}, time.Minute)
+		})
+		Expect(failures).NotTo(BeEmpty())
+	})
+
+	It("should pass when an untimed value moves within the window", func() {
+		value := 0.0
+		failures := InterceptGomegaFailures(func() {
+			AssertMetricFresh(func() (*MetricSample, error) {
+				value++
+				return &MetricSample{Value: value}, nil
+			}, time.Second)
+		})
+		Expect(failures).To(BeEmpty())
+	})
+
+	It("should fail when an untimed value does not move within the window", func() {
+		failures := InterceptGomegaFailures(func() {
+			AssertMetricFresh(func() (*MetricSample, error) {
+				return &MetricSample{Value: 42}, nil
+			}, 200*time.Millisecond)
+		})
+		Expect(failures).NotTo(BeEmpty())
+	})
+})
+
+var _ = Describe("ValidateCachedBodyMatches", func() {
+	newResponse := func(status int, contentType string, body []byte) *http.Response {
+		resp := &http.Response{StatusCode: status, Header: make(http.Header), ContentLength: int64(len(body))}
+		resp.Header.Set("Content-Type", contentType)
+		return resp
+	}
+	body := []byte(`{"message":"hello"}`)
+
+	It("should pass for identical responses", func() {
+		failures := InterceptGomegaFailures(func() {
+			ValidateCachedBodyMatches(
+				newResponse(200, "application/json", body),
+				newResponse(200, "application/json", body),
+				body, append([]byte(nil), body...))
+		})
+		Expect(failures).To(BeEmpty())
+	})
+
+	It("should pass when the content length is unknown", func() {
+		miss := newResponse(200, "application/json", body)
+		miss.ContentLength = -1
+		failures := InterceptGomegaFailures(func() {
+			ValidateCachedBodyMatches(miss, newResponse(200, "application/json", body), body, body)
+		})
+		Expect(failures).To(BeEmpty())
+	})
+
+	DescribeTable("should fail when the cached response differs",
+		func(hit *http.Response, hitBody []byte) {
+			failures := InterceptGomegaFailures(func() {
+				ValidateCachedBodyMatches(newResponse(200, "application/json", body), hit, body, hitBody)
+			})
+			Expect(failures).NotTo(BeEmpty())
+		},
+		Entry("different status", newResponse(206, "application/json", body), body),
+		Entry("different content type", newResponse(200, "text/plain", body), body),
+		Entry("truncated body", newResponse(200, "application/json", body), body[:5]),
+		Entry("corrupted body", newResponse(200, "application/json", body), []byte(`{"message":"hellO"}`)),
+	)
+})
+
+var _ = Describe("MakeAuthorizedCachingRequest", func() {
+	var server *CachingTestServer
+
+	BeforeEach(func() {
+		var err error
+		server, err = NewCachingTestServer("header echo", "127.0.0.1", 0)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(server.Close)
+	})
+
+	It("should send the bearer token and return the headers received by the test server", func() {
+		response, err := MakeAuthorizedCachingRequest(http.DefaultClient, server.URL+"/blobs/sha256/ab", "secret-token")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.Message).To(Equal("header echo"))
+
+		ValidateAuthorizationForwarded(response, "secret-token")
+		Expect(InterceptGomegaFailures(func() { ValidateAuthorizationStripped(response) })).NotTo(BeEmpty())
+	})
+
+	It("should detect a stripped Authorization header", func() {
+		response, err := MakeAuthorizedCachingRequest(http.DefaultClient, server.URL, "secret-token")
+		Expect(err).NotTo(HaveOccurred())
+		response.Headers.Del("Authorization")
+
+		ValidateAuthorizationStripped(response)
+		Expect(InterceptGomegaFailures(func() { ValidateAuthorizationForwarded(response, "secret-token") })).NotTo(BeEmpty())
+	})
+})
+
+var _ = Describe("NewSquidCachingClientWithOptions", func() {
+	It("should keep the defaults of NewSquidCachingClient", func() {
+		client, err := NewSquidCachingClient("squid", "caching")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(client.Timeout).To(Equal(30 * time.Second))
+		transport, ok := client.Transport.(*http.Transport)
+		Expect(ok).To(BeTrue())
+		Expect(transport.DisableKeepAlives).To(BeTrue(), "keep-alive should be disabled by default")
+		Expect(transport.ResponseHeaderTimeout).To(BeZero())
+		proxyURL, err := transport.Proxy(httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(proxyURL.String()).To(Equal("http://squid.caching.svc.cluster.local:3128"))
+	})
+
+	It("should apply the configured timeouts and keep-alive", func() {
+		client, err := NewSquidCachingClientWithOptions("squid", "caching", ClientOptions{
+			ResponseHeaderTimeout: 2 * time.Second,
+			Timeout:               5 * time.Second,
+			KeepAlive:             true,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(client.Timeout).To(Equal(5 * time.Second))
+		transport, ok := client.Transport.(*http.Transport)
+		Expect(ok).To(BeTrue())
+		Expect(transport.DisableKeepAlives).To(BeFalse())
+		Expect(transport.ResponseHeaderTimeout).To(Equal(2 * time.Second))
+	})
+
+	Describe("dialing the proxy", func() {
+		var addr string
+
+		BeforeEach(func() {
+			// Reserve a free port and release it, so dialing it is refused until it is listened on again
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			addr = listener.Addr().String()
+			Expect(listener.Close()).To(Succeed())
+		})
+
+		It("should retry connecting until the proxy accepts connections", func() {
+			dial := ClientOptions{DialAttempts: 50, DialRetryInterval: 20 * time.Millisecond}.dialContext()
+			listening := make(chan net.Listener, 1)
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				listener, err := net.Listen("tcp", addr)
+				if err == nil {
+					listening <- listener
+				}
+				close(listening)
+			}()
+
+			conn, err := dial(context.Background(), "tcp", addr)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conn.Close()).To(Succeed())
+			listener, ok := <-listening
+			Expect(ok).To(BeTrue(), "the port should be free to listen on again")
+			Expect(listener.Close()).To(Succeed())
+		})
+
+		It("should give up after DialAttempts", func() {
+			dial := ClientOptions{DialAttempts: 3, DialRetryInterval: 10 * time.Millisecond}.dialContext()
+
+			start := time.Now()
+			_, err := dial(context.Background(), "tcp", addr)
+			Expect(err).To(MatchError(syscall.ECONNREFUSED))
+			Expect(time.Since(start)).To(BeNumerically(">=", 20*time.Millisecond), "attempts should be spaced by DialRetryInterval")
+		})
+
+		It("should stop retrying when the request is canceled", func() {
+			dial := ClientOptions{DialAttempts: 100, DialRetryInterval: time.Second}.dialContext()
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			DeferCleanup(cancel)
+
+			_, err := dial(ctx, "tcp", addr)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+			Expect(err).To(MatchError(syscall.ECONNREFUSED), "the last dial error should be kept")
+		})
+	})
+})
+
+var _ = Describe("NewSquidPullTransport", func() {
+	const namespace = "caching"
+
+	It("should proxy through squid and trust the CA bundle", func() {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+		DeferCleanup(server.Close)
+		caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		client := fake.NewSimpleClientset(caBundleConfigMap(namespace, map[string]string{SquidCABundleKey: string(caPEM)}))
+
+		transport, err := NewSquidPullTransport(context.Background(), client, namespace)
+		Expect(err).NotTo(HaveOccurred())
+
+		httpTransport, ok := transport.(*http.Transport)
+		Expect(ok).To(BeTrue())
+		proxyURL, err := httpTransport.Proxy(httptest.NewRequest(http.MethodGet, "https://quay.io/v2/", nil))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(proxyURL.String()).To(Equal("http://squid.caching.svc.cluster.local:3128"))
+		Expect(httpTransport.TLSClientConfig.RootCAs.Equal(func() *x509.CertPool {
+			pool := x509.NewCertPool()
+			pool.AddCert(server.Certificate())
+			return pool
+		}())).To(BeTrue())
+	})
+
+	It("should return an error when the ConfigMap is missing", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		DeferCleanup(cancel)
+
+		_, err := NewSquidPullTransport(ctx, fake.NewSimpleClientset(), namespace)
+		Expect(err).To(MatchError(ContainSubstring("failed to get caching-ca-bundle ConfigMap")))
+	})
+})
+
+var _ = Describe("GetCABundle", func() {
+	const namespace = "caching"
+
+	var caPEM []byte
+
+	BeforeEach(func() {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+		server.Close()
+		caPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	})
+
+	It("should return a populated CA bundle", func() {
+		client := fake.NewSimpleClientset(caBundleConfigMap(namespace, map[string]string{SquidCABundleKey: string(caPEM)}))
+
+		bundle, err := GetCABundle(context.Background(), client, namespace)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bundle).To(Equal(caPEM))
+	})
+
+	It("should wait for trust-manager to populate the ConfigMap", func() {
+		client := fake.NewSimpleClientset(caBundleConfigMap(namespace, map[string]string{}))
+		go func() {
+			defer GinkgoRecover()
+			time.Sleep(100 * time.Millisecond)
+			_, err := client.CoreV1().ConfigMaps(namespace).Update(context.Background(),
+				caBundleConfigMap(namespace, map[string]string{SquidCABundleKey: string(caPEM)}), metav1.UpdateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		}()
+
+		bundle, err := GetCABundle(context.Background(), client, namespace)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bundle).To(Equal(caPEM))
+	})
+
+	DescribeTable("should return the last error when the bundle never becomes valid",
+		func(data map[string]string, message string) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			DeferCleanup(cancel)
+			client := fake.NewSimpleClientset(caBundleConfigMap(namespace, data))
+
+			_, err := GetCABundle(ctx, client, namespace)
+			Expect(err).To(MatchError(ContainSubstring("timed out waiting for the CA bundle")))
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("missing key", map[string]string{"other": "data"}, "has no ca-bundle.crt"),
+		Entry("empty bundle", map[string]string{SquidCABundleKey: ""}, "has no ca-bundle.crt"),
+		Entry("no PEM certificates", map[string]string{SquidCABundleKey: "not a certificate"}, "contains no PEM certificates"),
+	)
+})
+
+// caBundleConfigMap returns a trust-manager CA bundle ConfigMap for namespace with the given data
+func caBundleConfigMap(namespace string, data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: namespace + SquidCABundleConfigMapSuffix, Namespace: namespace},
+		Data:       data,
+	}
+}
+
+// fakeExecutor writes output and then fails with err, succeeds when exit is set, or otherwise
+// blocks until the context is done. outputs, when set, replaces stdout on successive calls.
+type fakeExecutor struct {
+	stdout, stderr string
+	outputs        []string
+	err            error
+	exit           bool
+	calls          int
+	deadline       time.Time
+	hasDeadline    bool
+}
+
+func (e *fakeExecutor) Stream(remotecommand.StreamOptions) error {
+	return errors.New("Stream is not supported, use StreamWithContext")
+}
+
+func (e *fakeExecutor) StreamWithContext(ctx context.Context, options remotecommand.StreamOptions) error {
+	e.deadline, e.hasDeadline = ctx.Deadline()
+	stdout := e.stdout
+	if len(e.outputs) > 0 {
+		stdout = e.outputs[min(e.calls, len(e.outputs)-1)]
+	}
+	e.calls++
+	_, _ = io.WriteString(options.Stdout, stdout)
+	_, _ = io.WriteString(options.Stderr, e.stderr)
+	if e.err != nil {
+		return e.err
+	}
+	if e.exit {
+		return nil
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// newTestCAPEM returns a new self-signed PEM CA certificate with commonName
+func newTestCAPEM(commonName string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+var _ = Describe("GetPodTrustedCAs", func() {
+	var (
+		client   kubernetes.Interface
+		executor *fakeExecutor
+	)
+
+	BeforeEach(func() {
+		var err error
+		client, err = kubernetes.NewForConfig(&rest.Config{Host: "http://127.0.0.1:1"})
+		Expect(err).NotTo(HaveOccurred())
+
+		executor = &fakeExecutor{exit: true}
+		old := newExecutor
+		newExecutor = func(*rest.Config, string, *url.URL) (remotecommand.Executor, error) { return executor, nil }
+		DeferCleanup(func() { newExecutor = old })
+	})
+
+	It("should parse every certificate of the mounted bundle", func() {
+		executor.stdout = string(newTestCAPEM("squid-ca")) + string(newTestCAPEM("test-server-ca"))
+
+		certs, err := GetPodTrustedCAs(context.Background(), client, &rest.Config{}, "caching", "squid-0", SquidTestServerCAPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(certs).To(HaveLen(2))
+		Expect(certs[0].Subject.CommonName).To(Equal("squid-ca"))
+		Expect(certs[1].Subject.CommonName).To(Equal("test-server-ca"))
+	})
+
+	It("should include stderr when the bundle cannot be read", func() {
+		executor.stderr = "cat: /etc/squid/trust/test-server/ca.crt: No such file or directory"
+		executor.err = errors.New("command terminated with exit code 1")
+
+		_, err := GetPodTrustedCAs(context.Background(), client, &rest.Config{}, "caching", "squid-0", SquidTestServerCAPath)
+		Expect(err).To(MatchError(ContainSubstring("squid-0")))
+		Expect(err).To(MatchError(ContainSubstring("No such file or directory")))
+	})
+
+	It("should reject a bundle without certificates", func() {
+		executor.stdout = "not a certificate\n"
+
+		_, err := GetPodTrustedCAs(context.Background(), client, &rest.Config{}, "caching", "squid-0", SquidTestServerCAPath)
+		Expect(err).To(MatchError(ContainSubstring("no PEM certificates")))
+	})
+
+	Describe("WaitForPodTrustedCA", func() {
+		It("should wait until the CA is distributed to the pod", func() {
+			squidCA, testServerCA := newTestCAPEM("squid-ca"), newTestCAPEM("test-server-ca")
+			executor.outputs = []string{string(squidCA), string(squidCA) + string(testServerCA)}
+
+			err := WaitForPodTrustedCA(context.Background(), client, &rest.Config{}, "caching", "squid-0", SquidTestServerCAPath, testServerCA)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(executor.calls).To(Equal(2))
+		})
+
+		It("should name the missing CA when it is never distributed", func() {
+			executor.stdout = string(newTestCAPEM("squid-ca"))
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			DeferCleanup(cancel)
+
+			err := WaitForPodTrustedCA(ctx, client, &rest.Config{}, "caching", "squid-0", SquidTestServerCAPath, newTestCAPEM("test-server-ca"))
+			Expect(err).To(MatchError(ContainSubstring(`CA "CN=test-server-ca" is not in`)))
+		})
+	})
+})
+
+var _ = Describe("ExecCommandInPod", func() {
+	var (
+		client   kubernetes.Interface
+		executor *fakeExecutor
+	)
+
+	BeforeEach(func() {
+		var err error
+		client, err = kubernetes.NewForConfig(&rest.Config{Host: "http://127.0.0.1:1"})
+		Expect(err).NotTo(HaveOccurred())
+
+		executor = &fakeExecutor{}
+		old := newExecutor
+		newExecutor = func(*rest.Config, string, *url.URL) (remotecommand.Executor, error) { return executor, nil }
+		DeferCleanup(func() { newExecutor = old })
+	})
+
+	It("should return a timeout error and the partial output of a hung command", func() {
+		executor.stdout = "partial stdout"
+		executor.stderr = "partial stderr"
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		DeferCleanup(cancel)
+
+		stdout, stderr, err := ExecCommandInPod(ctx, client, &rest.Config{}, "caching", "squid-0", "squid", []string{"sleep", "3600"})
+		Expect(err).To(MatchError(ErrExecTimeout))
+		Expect(err).To(MatchError(ContainSubstring("squid-0")))
+		Expect(stdout).To(Equal("partial stdout"))
+		Expect(stderr).To(Equal("partial stderr"))
+	})
+
+	It("should bound commands without a deadline by ExecTimeout", func() {
+		executor.err = errors.New("command terminated with exit code 1")
+
+		_, _, err := ExecCommandInPod(context.Background(), client, &rest.Config{}, "caching", "squid-0", "squid", []string{"false"})
+		Expect(err).To(HaveOccurred())
+		Expect(executor.hasDeadline).To(BeTrue())
+		Expect(time.Until(executor.deadline)).To(BeNumerically("~", ExecTimeout, time.Minute))
+	})
+
+	It("should not report command failures as timeouts", func() {
+		executor.stderr = "cat: /missing: No such file or directory"
+		executor.err = errors.New("command terminated with exit code 1")
+
+		_, stderr, err := ExecCommandInPod(context.Background(), client, &rest.Config{}, "caching", "squid-0", "squid", []string{"cat", "/missing"})
+		Expect(err).To(MatchError(ContainSubstring("failed to execute command")))
+		Expect(errors.Is(err, ErrExecTimeout)).To(BeFalse())
+		Expect(stderr).To(ContainSubstring("No such file"))
+	})
+})
+
+var _ = Describe("PreflightChart", func() {
+	var argsFile string
+
+	// installFakeHelm puts a helm script on PATH that records its arguments and
+	// fails the given subcommand
+	installFakeHelm := func(failing string) {
+		dir := GinkgoT().TempDir()
+		argsFile = filepath.Join(dir, "args")
+		script := "#!/bin/sh\necho \"$@\" >> " + argsFile + "\n" +
+			"if [ \"$1\" = \"" + failing + "\" ]; then echo \"Error: template: caching/templates/configmap.yaml: bad value\"; exit 1; fi\n"
+		Expect(os.WriteFile(filepath.Join(dir, "helm"), []byte(script), 0o755)).To(Succeed())
+		GinkgoT().Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	}
+
+	recordedArgs := func() string {
+		data, err := os.ReadFile(argsFile)
+		Expect(err).NotTo(HaveOccurred())
+		return string(data)
+	}
+
+	It("should render the chart with the upgrade values and arguments", func() {
+		installFakeHelm("none")
+
+		Expect(PreflightChart("./caching", "/tmp/values.yaml", []string{"--set", "mirrord.enabled=false"})).To(Succeed())
+		Expect(recordedArgs()).To(Equal("template caching ./caching --values /tmp/values.yaml --set mirrord.enabled=false\n"))
+	})
+
+	It("should return a distinct error with the helm output when rendering fails", func() {
+		installFakeHelm("template")
+
+		err := PreflightChart("./caching", "/tmp/values.yaml", nil)
+		Expect(err).To(MatchError(ErrChartPreflight))
+		Expect(err).To(MatchError(ContainSubstring("bad value")))
+	})
+
+	It("should lint the chart when HELM_PREFLIGHT_LINT is set", func() {
+		installFakeHelm("lint")
+		GinkgoT().Setenv("HELM_PREFLIGHT_LINT", "true")
+
+		err := PreflightChart("./caching", "/tmp/values.yaml", nil)
+		Expect(err).To(MatchError(ErrChartPreflight))
+		Expect(err).To(MatchError(ContainSubstring("helm lint")))
+		Expect(recordedArgs()).To(ContainSubstring("lint ./caching --values /tmp/values.yaml"))
+	})
+})
+
+var _ = Describe("CacheManagerHasObject", func() {
+	const objectURL = "https://test-server.caching.svc.cluster.local/ssl-bump-cache-test/1732700000"
+
+	entry := func(state, url string) string {
+		return "KEY 5B6C9A0D2F4E8A1C3B5D7F9E0A2C4E6F\n" +
+			"\tSTORE_" + state + "      NOT_IN_MEMORY SWAPOUT_DONE PING_DONE   \n" +
+			"\tCACHABLE,DISPATCHED,VALIDATED\n" +
+			"\tLV:1732700000 LU:1732700001 LM:-1        EX:1732786400\n" +
+			"\t0 locks, 0 clients, 1 refs\n" +
+			"\tSwap Dir 0, File 0X000012\n" +
+			"\tGET " + url + "\n" +
+			"\n"
+	}
+
+	It("should find a complete entry for the URL", func() {
+		objects := entry("OK", "http://other.example.com/") + entry("OK", objectURL)
+		Expect(CacheManagerHasObject(objects, objectURL)).To(BeTrue())
+	})
+
+	It("should ignore entries that are still being stored", func() {
+		Expect(CacheManagerHasObject(entry("PENDING", objectURL), objectURL)).To(BeFalse())
+	})
+
+	It("should not combine the state and URL of different entries", func() {
+		objects := entry("OK", "http://other.example.com/") + entry("PENDING", objectURL)
+		Expect(CacheManagerHasObject(objects, objectURL)).To(BeFalse())
+	})
+
+	It("should match the URL exactly", func() {
+		Expect(CacheManagerHasObject(entry("OK", objectURL+"/suffix"), objectURL)).To(BeFalse())
+		Expect(CacheManagerHasObject("", objectURL)).To(BeFalse())
+	})
+})
+
+var _ = Describe("WaitForCachedObject", func() {
+	const objectURL = "http://test-server.caching.svc.cluster.local/cached"
+
+	var (
+		client   kubernetes.Interface
+		executor *fakeExecutor
+	)
+
+	BeforeEach(func() {
+		var err error
+		client, err = kubernetes.NewForConfig(&rest.Config{Host: "http://127.0.0.1:1"})
+		Expect(err).NotTo(HaveOccurred())
+
+		executor = &fakeExecutor{exit: true}
+		old := newExecutor
+		newExecutor = func(*rest.Config, string, *url.URL) (remotecommand.Executor, error) { return executor, nil }
+		DeferCleanup(func() { newExecutor = old })
+	})
+
+	It("should poll until the object is stored", func() {
+		executor.outputs = []string{
+			"KEY 01\n\tSTORE_PENDING NOT_IN_MEMORY\n\tGET " + objectURL + "\n",
+			"KEY 01\n\tSTORE_OK NOT_IN_MEMORY SWAPOUT_DONE\n\tGET " + objectURL + "\n",
+		}
+
+		Expect(WaitForCachedObject(context.Background(), client, &rest.Config{}, "caching", "squid-0", objectURL, 10*time.Second)).To(Succeed())
+		Expect(executor.calls).To(Equal(2))
+	})
+
+	It("should time out with the reason when the object never appears", func() {
+		executor.stdout = "KEY 01\n\tSTORE_OK\n\tGET http://other.example.com/\n"
+
+		err := WaitForCachedObject(context.Background(), client, &rest.Config{}, "caching", "squid-0", objectURL, 100*time.Millisecond)
+		Expect(err).To(MatchError(ContainSubstring("timed out waiting for pod squid-0 to cache")))
+		Expect(err).To(MatchError(ContainSubstring("does not list a complete entry")))
+	})
+})
+
+var _ = Describe("GetSquidCacheDiskUsage", func() {
+	var (
+		client   kubernetes.Interface
+		executor *fakeExecutor
+	)
+
+	BeforeEach(func() {
+		var err error
+		client, err = kubernetes.NewForConfig(&rest.Config{Host: "http://127.0.0.1:1"})
+		Expect(err).NotTo(HaveOccurred())
+
+		executor = &fakeExecutor{exit: true}
+		old := newExecutor
+		newExecutor = func(*rest.Config, string, *url.URL) (remotecommand.Executor, error) { return executor, nil }
+		DeferCleanup(func() { newExecutor = old })
+	})
+
+	It("should return the bytes reported by du", func() {
+		executor.stdout = "52428800\t/var/spool/squid/cache\n"
+
+		usage, err := GetSquidCacheDiskUsage(context.Background(), client, &rest.Config{}, "caching", "squid-0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(usage).To(BeEquivalentTo(52428800))
+	})
+
+	It("should include stderr when du fails", func() {
+		executor.stderr = "du: cannot access '/var/spool/squid/cache': No such file or directory"
+		executor.err = errors.New("command terminated with exit code 1")
+
+		_, err := GetSquidCacheDiskUsage(context.Background(), client, &rest.Config{}, "caching", "squid-0")
+		Expect(err).To(MatchError(ContainSubstring("squid-0")))
+		Expect(err).To(MatchError(ContainSubstring("No such file or directory")))
+	})
+
+	It("should reject unexpected output", func() {
+		executor.stdout = "52M\t/var/spool/squid/cache\n"
+
+		_, err := GetSquidCacheDiskUsage(context.Background(), client, &rest.Config{}, "caching", "squid-0")
+		Expect(err).To(MatchError(ContainSubstring("unexpected du output")))
+	})
+})
+
+var _ = Describe("CreateTestServerTLS", func() {
+	caPEM := []byte("-----BEGIN CERTIFICATE-----\ntest-ca\n-----END CERTIFICATE-----\n")
+
+	It("should return the secret name and the CA of the issued certificate", func() {
+		client := certmanagerfake.NewClientset()
+		client.PrependReactor("create", "certificates", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			cert := action.(k8stesting.CreateAction).GetObject().(*certmanagerv1.Certificate)
+			cert.Status.Conditions = []certmanagerv1.CertificateCondition{
+				{Type: certmanagerv1.CertificateConditionReady, Status: certmanagermeta.ConditionTrue},
+			}
+			request := &certmanagerv1.CertificateRequest{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        cert.Name + "-1",
+					Namespace:   cert.Namespace,
+					Annotations: map[string]string{certmanagerv1.CertificateNameKey: cert.Name},
+				},
+				Status: certmanagerv1.CertificateRequestStatus{CA: caPEM},
+			}
+			return false, nil, client.Tracker().Add(request)
+		})
+
+		secretName, ca, cleanup, err := CreateTestServerTLS(context.Background(), client, "caching",
+			[]string{"origin.caching.svc", "origin.caching.svc.cluster.local"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ca).To(Equal(caPEM))
+
+		cert, err := client.CertmanagerV1().Certificates("caching").Get(context.Background(), secretName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cert.Spec.SecretName).To(Equal(secretName))
+		Expect(cert.Spec.CommonName).To(Equal("origin.caching.svc"))
+		Expect(cert.Spec.IssuerRef.Name).To(Equal(TestServerCAIssuerName))
+
+		cleanup()
+		_, err = client.CertmanagerV1().Certificates("caching").Get(context.Background(), secretName, metav1.GetOptions{})
+		Expect(err).To(HaveOccurred(), "cleanup should delete the certificate")
+	})
+
+	It("should require at least one DNS name", func() {
+		_, _, _, err := CreateTestServerTLS(context.Background(), certmanagerfake.NewClientset(), "caching", nil)
+		Expect(err).To(MatchError(ContainSubstring("at least one DNS name")))
+	})
+
+	It("should ignore CertificateRequests of other certificates", func() {
+		requests := []certmanagerv1.CertificateRequest{
+			{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{certmanagerv1.CertificateNameKey: "other"}},
+				Status:     certmanagerv1.CertificateRequestStatus{CA: caPEM},
+			},
+			{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{certmanagerv1.CertificateNameKey: "mine"}}},
+		}
+		Expect(certificateRequestCA(requests, "mine")).To(BeNil())
+	})
+})
+
+// newConnectProxy starts a proxy that tunnels CONNECT requests to their target
+func newConnectProxy() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		go func() {
+			defer upstream.Close()
+			_, _ = io.Copy(upstream, conn)
+		}()
+		go func() {
+			defer conn.Close()
+			_, _ = io.Copy(conn, upstream)
+		}()
+	}))
+}
+
+var _ = Describe("newHTTP2ProxyClient", func() {
+	var proxyURL *url.URL
+
+	BeforeEach(func() {
+		proxy := newConnectProxy()
+		DeferCleanup(proxy.Close)
+		var err error
+		proxyURL, err = url.Parse(proxy.URL)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	startOrigin := func(enableHTTP2 bool) (*httptest.Server, []byte) {
+		origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}))
+		origin.EnableHTTP2 = enableHTTP2
+		origin.StartTLS()
+		DeferCleanup(origin.Close)
+		caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: origin.Certificate().Raw})
+		return origin, caPEM
+	}
+
+	It("should negotiate HTTP/2 through the CONNECT tunnel", func() {
+		origin, caPEM := startOrigin(true)
+		client, err := newHTTP2ProxyClient(proxyURL, caPEM)
+		Expect(err).NotTo(HaveOccurred())
+
+		resp, body, err := MakeCachingRequest(client, origin.URL)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.Proto).To(Equal("HTTP/2.0"))
+		Expect(string(body)).To(Equal("ok"))
+	})
+
+	It("should report a downgrade when the TLS endpoint only speaks HTTP/1.1", func() {
+		origin, caPEM := startOrigin(false)
+		client, err := newHTTP2ProxyClient(proxyURL, caPEM)
+		Expect(err).NotTo(HaveOccurred())
+
+		resp, _, err := MakeCachingRequest(client, origin.URL)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.Proto).To(Equal("HTTP/1.1"))
+	})
+
+	It("should reject an invalid CA bundle", func() {
+		_, err := newHTTP2ProxyClient(proxyURL, []byte("not a certificate"))
+		Expect(err).To(MatchError(ContainSubstring("failed to append CA certificates")))
+	})
+})
+
+var _ = Describe("GetBumpedServerCert", func() {
+	var (
+		proxyURL string
+		origin   *httptest.Server
+		caPEM    []byte
+	)
+
+	BeforeEach(func() {
+		proxy := newConnectProxy()
+		DeferCleanup(proxy.Close)
+		proxyURL = proxy.URL
+
+		origin = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+		DeferCleanup(origin.Close)
+		caPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: origin.Certificate().Raw})
+	})
+
+	It("should return the certificate presented through the CONNECT tunnel", func() {
+		cert, err := GetBumpedServerCert(proxyURL, origin.Listener.Addr().String(), caPEM)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cert.Raw).To(Equal(origin.Certificate().Raw))
+		Expect(cert.VerifyHostname("127.0.0.1")).To(Succeed())
+	})
+
+	It("should fail when the certificate is not valid for the target host", func() {
+		_, port, err := net.SplitHostPort(origin.Listener.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = GetBumpedServerCert(proxyURL, net.JoinHostPort("localhost", port), caPEM)
+		Expect(err).To(MatchError(ContainSubstring("TLS handshake")))
+	})
+
+	It("should fail when the certificate is signed by another CA", func() {
+		// httptest servers share one certificate, so the other CA is generated here
+		otherCA := newTestCAPEM("other-ca")
+
+		_, err := GetBumpedServerCert(proxyURL, origin.Listener.Addr().String(), otherCA)
+		Expect(err).To(MatchError(ContainSubstring("TLS handshake")))
+	})
+
+	It("should report a refused CONNECT", func() {
+		plain := httptest.NewServer(http.NotFoundHandler())
+		defer plain.Close()
+
+		_, err := GetBumpedServerCert(plain.URL, origin.Listener.Addr().String(), caPEM)
+		Expect(err).To(MatchError(ContainSubstring("CONNECT to")))
+	})
+})
+
+var _ = Describe("ConfigureSquidWithHelm", func() {
+	It("should reject invalid allowList patterns before contacting the cluster or running helm", func() {
+		client := fake.NewSimpleClientset()
+
+		err := ConfigureSquidWithHelm(context.Background(), client, SquidHelmValues{
+			Cache: &CacheValues{AllowList: []string{"^https://cdn(0-9.quay\\.io/"}},
+		})
+		Expect(err).To(MatchError(ErrChartPreflight))
+		Expect(err).To(MatchError(ContainSubstring("cache.allowList: pattern 0")))
+		Expect(client.Actions()).To(BeEmpty())
+	})
+
+	It("should reject invalid denyList patterns", func() {
+		err := ConfigureSquidWithHelm(context.Background(), fake.NewSimpleClientset(), SquidHelmValues{
+			Cache: &CacheValues{DenyList: []string{"[latest"}},
+		})
+		Expect(err).To(MatchError(ContainSubstring("cache.denyList: pattern 0")))
+	})
+
+	It("should reject invalid accessLogFilters patterns", func() {
+		err := ConfigureSquidWithHelm(context.Background(), fake.NewSimpleClientset(), SquidHelmValues{
+			AccessLogFilters: []string{"/healthz", "/ready("},
+		})
+		Expect(err).To(MatchError(ContainSubstring("accessLogFilters: pattern 1")))
+	})
+})
+
+var _ = Describe("AssertSameStoreID", func() {
+	const (
+		digest     = "3fa1c9e6b0d24d0c6a3c5e8f1b2d4a6c8e0f2a4b6c8d0e2f4a6b8c0d2e4f6a8b"
+		npmTarball = "https://registry.npmjs.org/left-pad/-/left-pad-1.3.0.tgz"
+	)
+
+	It("should pass for signed URLs of the same blob on different CDN hosts", func() {
+		AssertSameStoreID(
+			"https://cdn01.quay.io/quayio-production-s3/sha256/3f/"+digest+"?X-Amz-Signature=abc",
+			"https://cdn02.quay.io/quayio-production-s3/sha256/3f/"+digest+"?X-Amz-Signature=def",
+			StoreIDValues{},
+		)
+	})
+
+	It("should pass for package registry URLs differing only in their query when enabled", func() {
+		AssertSameStoreID(npmTarball+"?cache=1", npmTarball, StoreIDValues{PackageRegistries: true})
+	})
+
+	DescribeTable("should fail for URLs with different store-ids",
+		func(urlA, urlB string, values StoreIDValues) {
+			failures := InterceptGomegaFailures(func() {
+				AssertSameStoreID(urlA, urlB, values)
+			})
+			Expect(failures).To(HaveLen(1))
+		},
+		Entry("different digests",
+			"https://cdn01.quay.io/quayio-production-s3/sha256/3f/"+digest+"?X-Amz-Signature=abc",
+			"https://cdn01.quay.io/quayio-production-s3/sha256/9c/"+strings.Repeat("9c", 32)+"?X-Amz-Signature=abc",
+			StoreIDValues{}),
+		Entry("URLs that are not content-addressable",
+			"https://quay.io/v2/konflux-ci/caching/manifests/latest?ns=a",
+			"https://quay.io/v2/konflux-ci/caching/manifests/latest?ns=b",
+			StoreIDValues{}),
+		Entry("package registry URLs with the chart default", npmTarball+"?cache=1", npmTarball, StoreIDValues{}),
+	)
+})
+
+var _ = Describe("AssertImagePreservedAcrossUpgrade", func() {
+	const (
+		namespace     = "caching"
+		snapshotImage = "quay.io/konflux-ci/caching/squid@sha256:0123"
+	)
+
+	var client *fake.Clientset
+
+	BeforeEach(func() {
+		GinkgoT().Setenv("SNAPSHOT_SQUID_IMAGE", "")
+		client = fake.NewClientset(&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: SquidStatefulSetName, Namespace: namespace},
+			Spec: appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "squid-exporter", Image: "quay.io/konflux-ci/caching/squid-exporter:latest"},
+				{Name: SquidContainerName, Image: snapshotImage},
+			}}}},
+		})
+	})
+
+	// setImage returns an upgrade that sets the squid container image to image
+	setImage := func(image string) func() error {
+		return func() error {
+			statefulSet, err := client.AppsV1().StatefulSets(namespace).Get(context.Background(), SquidStatefulSetName, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			statefulSet.Spec.Template.Spec.Containers[1].Image = image
+			_, err = client.AppsV1().StatefulSets(namespace).Update(context.Background(), statefulSet, metav1.UpdateOptions{})
+			return err
+		}
+	}
+
+	It("passes when the upgrade keeps the image", func() {
+		Expect(InterceptGomegaFailures(func() {
+			AssertImagePreservedAcrossUpgrade(context.Background(), client, namespace, setImage(snapshotImage))
+		})).To(BeEmpty())
+	})
+
+	It("fails when the upgrade reverts the image to latest", func() {
+		failures := InterceptGomegaFailures(func() {
+			AssertImagePreservedAcrossUpgrade(context.Background(), client, namespace, setImage("quay.io/konflux-ci/caching/squid:latest"))
+		})
+		Expect(failures).To(ConsistOf(ContainSubstring("Squid image changed from " + snapshotImage)))
+	})
+
+	It("fails when the upgrade fails", func() {
+		failures := InterceptGomegaFailures(func() {
+			AssertImagePreservedAcrossUpgrade(context.Background(), client, namespace, func() error {
+				return errors.New("helm upgrade timed out")
+			})
+		})
+		Expect(failures).To(ConsistOf(ContainSubstring("helm upgrade timed out")))
+	})
+
+	It("expects SNAPSHOT_SQUID_IMAGE when set", func() {
+		GinkgoT().Setenv("SNAPSHOT_SQUID_IMAGE", "quay.io/konflux-ci/caching/squid@sha256:4567")
+
+		Expect(InterceptGomegaFailures(func() {
+			AssertImagePreservedAcrossUpgrade(context.Background(), client, namespace, setImage("quay.io/konflux-ci/caching/squid@sha256:4567"))
+		})).To(BeEmpty())
+		Expect(InterceptGomegaFailures(func() {
+			AssertImagePreservedAcrossUpgrade(context.Background(), client, namespace, setImage(snapshotImage))
+		})).NotTo(BeEmpty())
+	})
+
+	It("fails when the squid statefulset is missing", func() {
+		failures := InterceptGomegaFailures(func() {
+			AssertImagePreservedAcrossUpgrade(context.Background(), fake.NewClientset(), namespace, func() error { return nil })
+		})
+		Expect(failures).To(ContainElement(ContainSubstring("before the upgrade")))
+	})
+})
+
+var _ = Describe("ScaleSquid", func() {
+	const namespace = "caching"
+
+	var (
+		client *fake.Clientset
+		// scaleUpdates records the replica counts written through the scale subresource
+		scaleUpdates []int32
+	)
+
+	statefulSetsResource := appsv1.SchemeGroupVersion.WithResource("statefulsets")
+	podsResource := corev1.SchemeGroupVersion.WithResource("pods")
+	labels := map[string]string{
+		"app.kubernetes.io/name":      "caching",
+		"app.kubernetes.io/component": SquidComponentLabel,
+	}
+
+	squidPod := func(i int32) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s-%d", SquidStatefulSetName, i),
+				Namespace: namespace,
+				Labels:    labels,
+			},
+			Status: corev1.PodStatus{
+				Phase:             corev1.PodRunning,
+				ContainerStatuses: []corev1.ContainerStatus{{Name: SquidContainerName, Ready: true}},
+			},
+		}
+	}
+
+	getStatefulSet := func() *appsv1.StatefulSet {
+		obj, err := client.Tracker().Get(statefulSetsResource, namespace, SquidStatefulSetName)
+		Expect(err).NotTo(HaveOccurred())
+		return obj.(*appsv1.StatefulSet)
+	}
+
+	// rollOut does what the statefulset controller does once the pods are started: it creates
+	// or deletes pods to match replicas and reports them ready
+	rollOut := func(replicas int32) {
+		defer GinkgoRecover()
+		statefulSet := getStatefulSet()
+		for i := statefulSet.Status.ReadyReplicas; i < replicas; i++ {
+			Expect(client.Tracker().Create(podsResource, squidPod(i), namespace)).To(Succeed())
+		}
+		for i := replicas; i < statefulSet.Status.ReadyReplicas; i++ {
+			Expect(client.Tracker().Delete(podsResource, namespace, squidPod(i).Name)).To(Succeed())
+		}
+		statefulSet.Status.ReadyReplicas = replicas
+		Expect(client.Tracker().Update(statefulSetsResource, statefulSet, namespace)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		replicas := int32(1)
+		client = fake.NewClientset(&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: SquidStatefulSetName, Namespace: namespace, Labels: labels},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+			Status:     appsv1.StatefulSetStatus{ReadyReplicas: replicas},
+		}, squidPod(0))
+		scaleUpdates = nil
+
+		// The fake clientset does not implement the scale subresource
+		client.PrependReactor("get", "statefulsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "scale" {
+				return false, nil, nil
+			}
+			statefulSet := getStatefulSet()
+			return true, &autoscalingv1.Scale{
+				ObjectMeta: metav1.ObjectMeta{Name: statefulSet.Name, Namespace: statefulSet.Namespace},
+				Spec:       autoscalingv1.ScaleSpec{Replicas: *statefulSet.Spec.Replicas},
+			}, nil
+		})
+		client.PrependReactor("update", "statefulsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "scale" {
+				return false, nil, nil
+			}
+			scale := action.(k8stesting.UpdateAction).GetObject().(*autoscalingv1.Scale)
+			scaleUpdates = append(scaleUpdates, scale.Spec.Replicas)
+
+			statefulSet := getStatefulSet()
+			statefulSet.Spec.Replicas = &scale.Spec.Replicas
+			Expect(client.Tracker().Update(statefulSetsResource, statefulSet, namespace)).To(Succeed())
+			// The pods become ready some time after the scale update
+			time.AfterFunc(100*time.Millisecond, func() { rollOut(scale.Spec.Replicas) })
+			return true, scale, nil
+		})
+	})
+
+	It("should scale the statefulset, wait for the pods, and restore the previous replicas", func() {
+		restore, err := ScaleSquid(context.Background(), client, namespace, 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(scaleUpdates).To(Equal([]int32{2}))
+		Expect(getStatefulSet().Status.ReadyReplicas).To(Equal(int32(2)), "ScaleSquid should wait for the pods to be ready")
+		pods, err := client.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pods.Items).To(HaveLen(2))
+
+		restore()
+		Expect(scaleUpdates).To(Equal([]int32{2, 1}))
+		Expect(getStatefulSet().Status.ReadyReplicas).To(Equal(int32(1)), "restore should wait for the pods to be ready")
+	})
+
+	It("should not update the scale when the statefulset already has the replicas", func() {
+		restore, err := ScaleSquid(context.Background(), client, namespace, 1)
+		Expect(err).NotTo(HaveOccurred())
+		restore()
+		Expect(scaleUpdates).To(BeEmpty())
+	})
+
+	It("should return an error when the scale cannot be updated", func() {
+		client.PrependReactor("update", "statefulsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("forbidden")
+		})
+
+		_, err := ScaleSquid(context.Background(), client, namespace, 2)
+		Expect(err).To(MatchError(ContainSubstring("failed to scale squid statefulset: forbidden")))
+	})
+})
@@ -0,0 +1,216 @@
+package testhelpers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeSquidTransport simulates Squid response headers: the first fetch of a blob is a MISS,
+// later fetches are HITs
+type fakeSquidTransport struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func (f *fakeSquidTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp.Header.Set("Via", "1.1 squid-0 (squid/6.10)")
+	resp.Header.Add("X-Cache", "Hit from cloudfront")
+	if strings.Contains(req.URL.Path, "/blobs/") && f.seen[req.URL.Path] {
+		resp.Header.Add("X-Cache", "HIT from squid-0")
+	} else {
+		resp.Header.Add("X-Cache", "MISS from squid-0")
+	}
+	f.seen[req.URL.Path] = true
+	return resp, nil
+}
+
+var _ = Describe("PullContainerImageWithStats", func() {
+	var (
+		imageRef  string
+		transport http.RoundTripper
+	)
+
+	BeforeEach(func() {
+		server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+		DeferCleanup(server.Close)
+
+		imageRef = strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+		ref, err := name.ParseReference(imageRef)
+		Expect(err).NotTo(HaveOccurred())
+		img, err := random.Image(1024, 3)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(remote.Write(ref, img)).To(Succeed())
+
+		transport = &fakeSquidTransport{seen: make(map[string]bool)}
+	})
+
+	It("should report per-layer bytes and cache misses on the first pull", func() {
+		stats, err := PullContainerImageWithStats(&transport, imageRef)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.LayerCount()).To(Equal(3))
+		Expect(stats.CacheHits()).To(Equal(0))
+
+		var total int64
+		for _, layer := range stats.Layers {
+			Expect(layer.Digest).To(HavePrefix("sha256:"))
+			Expect(layer.Bytes).To(BeNumerically(">", 0))
+			Expect(layer.SquidPod).To(Equal("squid-0"))
+			total += layer.Bytes
+		}
+		Expect(stats.TotalBytes).To(Equal(total))
+	})
+
+	It("should report cache hits when layers are pulled again", func() {
+		_, err := PullContainerImageWithStats(&transport, imageRef)
+		Expect(err).NotTo(HaveOccurred())
+
+		stats, err := PullContainerImageWithStats(&transport, imageRef)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.CacheHits()).To(Equal(3))
+	})
+})
+
+var _ = Describe("IsSquidCacheHit", func() {
+	DescribeTable("should classify X-Cache headers",
+		func(via string, xCache []string, expected bool) {
+			resp := responseWithVia(via)
+			for _, value := range xCache {
+				resp.Header.Add("X-Cache", value)
+			}
+			Expect(IsSquidCacheHit(resp)).To(Equal(expected))
+		},
+		Entry("hit from the squid pod", "1.1 squid-1 (squid/6.10)", []string{"HIT from squid-1"}, true),
+		Entry("miss from the squid pod", "1.1 squid-1 (squid/6.10)", []string{"MISS from squid-1"}, false),
+		Entry("CDN hit but squid miss", "1.1 squid-1 (squid/6.10)", []string{"Hit from cloudfront", "MISS from squid-1"}, false),
+		Entry("hit from another host", "1.1 squid-1 (squid/6.10)", []string{"HIT from upstream"}, false),
+		Entry("hit without a Via header", "", []string{"HIT from squid-1"}, true),
+		Entry("no X-Cache header", "1.1 squid-1 (squid/6.10)", nil, false),
+	)
+})
+
+// flakyRegistryTransport fails the first failures requests whose path contains pathPart, either
+// with status or, when status is 0, by resetting the connection after part of the response body
+type flakyRegistryTransport struct {
+	pathPart string
+	failures int
+	status   int
+
+	mu       sync.Mutex
+	requests int
+}
+
+func (f *flakyRegistryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	matched := strings.Contains(req.URL.Path, f.pathPart)
+	if matched {
+		f.requests++
+	}
+	fail := matched && f.requests <= f.failures
+	f.mu.Unlock()
+
+	if fail && f.status != 0 {
+		return &http.Response{
+			StatusCode: f.status,
+			Status:     http.StatusText(f.status),
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader("")),
+			Request:    req,
+		}, nil
+	}
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil || !fail {
+		return resp, err
+	}
+	resp.Body = &resettingBody{ReadCloser: resp.Body, remaining: 16}
+	return resp, nil
+}
+
+// requestCount returns the number of requests matching pathPart
+func (f *flakyRegistryTransport) requestCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+// resettingBody returns a connection reset after remaining bytes
+type resettingBody struct {
+	io.ReadCloser
+	remaining int
+}
+
+func (b *resettingBody) Read(p []byte) (int, error) {
+	if b.remaining == 0 {
+		return 0, syscall.ECONNRESET
+	}
+	n, err := b.ReadCloser.Read(p[:min(len(p), b.remaining)])
+	b.remaining -= n
+	return n, err
+}
+
+var _ = Describe("PullContainerImageWithRetry", func() {
+	retry := PullRetryOptions{Attempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+	var imageRef string
+
+	BeforeEach(func() {
+		server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+		DeferCleanup(server.Close)
+
+		imageRef = strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+		ref, err := name.ParseReference(imageRef)
+		Expect(err).NotTo(HaveOccurred())
+		img, err := random.Image(1024, 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(remote.Write(ref, img)).To(Succeed())
+	})
+
+	DescribeTable("should succeed after transient failures",
+		func(pathPart string, status int) {
+			flaky := &flakyRegistryTransport{pathPart: pathPart, failures: 2, status: status}
+			var transport http.RoundTripper = flaky
+
+			Expect(PullContainerImageWithRetry(&transport, imageRef, retry)).To(Succeed())
+			Expect(flaky.requestCount()).To(BeNumerically(">", 2), "The failed requests should be retried")
+		},
+		Entry("manifest 503", "/manifests/", http.StatusServiceUnavailable),
+		Entry("manifest 429", "/manifests/", http.StatusTooManyRequests),
+		Entry("layer 502", "/blobs/", http.StatusBadGateway),
+		Entry("layer connection reset", "/blobs/", 0),
+	)
+
+	It("should give up after the configured attempts", func() {
+		flaky := &flakyRegistryTransport{pathPart: "/manifests/", failures: 10, status: http.StatusServiceUnavailable}
+		var transport http.RoundTripper = flaky
+
+		Expect(PullContainerImageWithRetry(&transport, imageRef, retry)).To(MatchError(ContainSubstring("503")))
+		Expect(flaky.requestCount()).To(Equal(retry.Attempts))
+	})
+
+	It("should not retry permanent failures", func() {
+		flaky := &flakyRegistryTransport{pathPart: "/manifests/", failures: 10, status: http.StatusNotFound}
+		var transport http.RoundTripper = flaky
+
+		Expect(PullContainerImageWithRetry(&transport, imageRef, retry)).NotTo(Succeed())
+		Expect(flaky.requestCount()).To(Equal(1))
+	})
+})
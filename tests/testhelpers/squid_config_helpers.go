@@ -0,0 +1,60 @@
+package testhelpers
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ParseSquidDirective returns the whitespace-separated tokens following the first occurrence of
+// directive in the squid.conf content conf. Comment lines are ignored. Asserting on individual
+// tokens keeps tests independent of whitespace and of unrelated changes to the line.
+//
+// Example usage:
+//
+//	args, err := ParseSquidDirective(squidConf, "cache_dir")
+//	// args: []string{"aufs", "/var/spool/squid/cache", "819", "16", "256"}
+func ParseSquidDirective(conf, directive string) ([]string, error) {
+	scanner := bufio.NewScanner(strings.NewReader(conf))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == directive {
+			return fields[1:], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read squid configuration: %w", err)
+	}
+	return nil, fmt.Errorf("directive %s not found in squid configuration", directive)
+}
+
+// ValidateAllowListPatterns checks that every pattern compiles the way Squid compiles url_regex
+// ACL values: the chart writes each pattern after "acl <name> url_regex", so Squid splits it on
+// whitespace and compiles every token other than the -i and +i flags as a POSIX extended regular
+// expression. It returns an error naming the first offending pattern, so a typo fails before the
+// rollout instead of crash-looping Squid.
+//
+// Example usage:
+//
+//	err := ValidateAllowListPatterns([]string{"^https://cdn([0-9]{2})?\\.quay\\.io/"})
+func ValidateAllowListPatterns(patterns []string) error {
+	for i, pattern := range patterns {
+		tokens := strings.Fields(pattern)
+		if len(tokens) == 0 {
+			return fmt.Errorf("pattern %d is empty", i)
+		}
+		for _, token := range tokens {
+			if token == "-i" || token == "+i" {
+				continue
+			}
+			if _, err := regexp.CompilePOSIX(token); err != nil {
+				return fmt.Errorf("pattern %d %q is not a valid POSIX extended regular expression: %w", i, pattern, err)
+			}
+		}
+	}
+	return nil
+}
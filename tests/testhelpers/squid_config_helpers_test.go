@@ -0,0 +1,72 @@
+package testhelpers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseSquidDirective", func() {
+	const conf = `# cache_dir ufs /tmp/commented 100 16 256
+http_port 3128
+
+  cache_dir   aufs /var/spool/squid/cache	819 16 256
+maximum_object_size 192 MB
+refresh_pattern ^ftp: 1440 20% 10080
+refresh_pattern . 0 20% 4320
+cache_mem 0
+`
+
+	It("should return the tokens following the directive regardless of whitespace", func() {
+		Expect(ParseSquidDirective(conf, "cache_dir")).To(Equal([]string{"aufs", "/var/spool/squid/cache", "819", "16", "256"}))
+		Expect(ParseSquidDirective(conf, "maximum_object_size")).To(Equal([]string{"192", "MB"}))
+	})
+
+	It("should return the first occurrence of a repeated directive", func() {
+		Expect(ParseSquidDirective(conf, "refresh_pattern")).To(Equal([]string{"^ftp:", "1440", "20%", "10080"}))
+	})
+
+	It("should not match directives that only share a prefix", func() {
+		_, err := ParseSquidDirective("cache_mem 0\n", "cache")
+		Expect(err).To(MatchError(ContainSubstring("directive cache not found")))
+	})
+
+	It("should ignore commented-out directives", func() {
+		_, err := ParseSquidDirective("# cache_dir ufs /tmp 100 16 256\n", "cache_dir")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("ValidateAllowListPatterns", func() {
+	It("should accept the chart's documented CDN patterns", func() {
+		Expect(ValidateAllowListPatterns([]string{
+			"^https://cdn([0-9]{2})?\\.quay\\.io/.+/sha256/.+/[a-f0-9]{64}",
+			"^https://s3\\.[a-z0-9-]+\\.amazonaws\\.com/quayio-production-s3/sha256/.+/[a-f0-9]{64}",
+			"^http://.*/do-cache.*",
+		})).To(Succeed())
+	})
+
+	It("should accept an empty list", func() {
+		Expect(ValidateAllowListPatterns(nil)).To(Succeed())
+	})
+
+	It("should accept case-insensitivity flags and several patterns on one line", func() {
+		Expect(ValidateAllowListPatterns([]string{"-i ^https://QUAY\\.io/ ^https://cdn\\.quay\\.io/"})).To(Succeed())
+	})
+
+	DescribeTable("should reject patterns Squid cannot compile",
+		func(pattern, message string) {
+			err := ValidateAllowListPatterns([]string{"^https://quay\\.io/", pattern})
+			Expect(err).To(MatchError(ContainSubstring("pattern 1 %q", pattern)))
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("unbalanced parenthesis", "^https://cdn(0-9.quay\\.io/", "missing closing )"),
+		Entry("unterminated bracket", "^https://[a-z.quay\\.io/", "missing closing ]"),
+		Entry("Perl group syntax", "(?i)^https://quay\\.io/", "missing argument to repetition operator"),
+		Entry("Perl character class", "^https://cdn\\d+\\.quay\\.io/", "invalid escape sequence"),
+		Entry("inverted repeat count", "^https://cdn[0-9]{2,1}\\.quay\\.io/", "invalid repeat count"),
+	)
+
+	It("should reject empty patterns", func() {
+		Expect(ValidateAllowListPatterns([]string{"  "})).To(MatchError("pattern 0 is empty"))
+	})
+})
@@ -0,0 +1,463 @@
+package testhelpers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/konflux-ci/caching/internal/credentials"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// SquidAccessLogEntry is a single parsed line of the Squid native access log format:
+// timestamp elapsed client code/status bytes method URL user peerstatus/peerhost type
+type SquidAccessLogEntry struct {
+	Pod         string
+	Timestamp   time.Time
+	Elapsed     time.Duration
+	Client      string
+	Code        string
+	Status      int
+	Bytes       int64
+	Method      string
+	URL         string
+	Host        string
+	User        string
+	PeerStatus  string
+	PeerHost    string
+	ContentType string
+}
+
+// IsCacheHit reports whether the entry was served from cache.
+// TCP_REFRESH_UNMODIFIED is a cache hit after revalidation (origin returned 304 Not Modified).
+func (e SquidAccessLogEntry) IsCacheHit() bool {
+	return strings.HasSuffix(e.Code, "_HIT") || e.Code == "TCP_REFRESH_UNMODIFIED"
+}
+
+// ParseSquidAccessLogLine parses a single Squid access log line
+func ParseSquidAccessLogLine(line string) (*SquidAccessLogEntry, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 7 {
+		return nil, fmt.Errorf("malformed access log entry: need >=7 fields, got %d: %q", len(fields), line)
+	}
+
+	timestamp, err := parseSquidTimestamp(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp %q: %w", fields[0], err)
+	}
+	elapsed, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid elapsed time %q: %w", fields[1], err)
+	}
+	code, statusStr, found := strings.Cut(fields[3], "/")
+	if !found {
+		return nil, fmt.Errorf("invalid code/status %q", fields[3])
+	}
+	status, err := strconv.Atoi(statusStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid status %q: %w", statusStr, err)
+	}
+	size, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bytes %q: %w", fields[4], err)
+	}
+
+	entry := &SquidAccessLogEntry{
+		Timestamp: timestamp,
+		Elapsed:   time.Duration(elapsed) * time.Millisecond,
+		Client:    fields[2],
+		Code:      code,
+		Status:    status,
+		Bytes:     size,
+		Method:    fields[5],
+		URL:       fields[6],
+	}
+
+	// CONNECT requests log "host:port" without a scheme
+	if parsedURL, err := url.Parse(entry.URL); err == nil && parsedURL.Host != "" {
+		entry.Host = parsedURL.Hostname()
+	} else if host, _, found := strings.Cut(entry.URL, ":"); found && entry.Method == "CONNECT" {
+		entry.Host = host
+	}
+
+	if len(fields) > 7 {
+		entry.User = fields[7]
+	}
+	if len(fields) > 8 {
+		entry.PeerStatus, entry.PeerHost, _ = strings.Cut(fields[8], "/")
+	}
+	if len(fields) > 9 {
+		entry.ContentType = fields[9]
+	}
+
+	return entry, nil
+}
+
+// parseSquidTimestamp parses a Squid "seconds.milliseconds" timestamp
+func parseSquidTimestamp(value string) (time.Time, error) {
+	secStr, msStr, _ := strings.Cut(value, ".")
+	sec, err := strconv.ParseInt(secStr, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var ms int64
+	if msStr != "" {
+		if ms, err = strconv.ParseInt(msStr, 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Unix(sec, ms*int64(time.Millisecond)).UTC(), nil
+}
+
+// ParseSquidAccessLogs parses Squid access log output, skipping lines that are not access log entries
+func ParseSquidAccessLogs(logs []byte) []SquidAccessLogEntry {
+	var entries []SquidAccessLogEntry
+	scanner := bufio.NewScanner(bytes.NewReader(logs))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry, err := ParseSquidAccessLogLine(scanner.Text())
+		if err != nil {
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+	return entries
+}
+
+// CollectSquidAccessLogs retrieves the squid container logs of all given pods since a specific
+// timestamp and parses them into structured access log entries
+func CollectSquidAccessLogs(ctx context.Context, client kubernetes.Interface, namespace string, pods []*corev1.Pod, since *metav1.Time) ([]SquidAccessLogEntry, error) {
+	var entries []SquidAccessLogEntry
+	for _, pod := range pods {
+		logs, err := GetPodLogsSince(ctx, client, namespace, pod.Name, SquidContainerName, since)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get logs from pod %s: %w", pod.Name, err)
+		}
+		for _, entry := range ParseSquidAccessLogs(logs) {
+			entry.Pod = pod.Name
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// Store log actions of StoreLogEntry
+const (
+	// StoreLogSwapOut is logged when an object was written to the cache
+	StoreLogSwapOut = "SWAPOUT"
+	// StoreLogRelease is logged when an object was removed from the cache or not stored at all
+	StoreLogRelease = "RELEASE"
+)
+
+// StoreLogEntry is a single parsed line of Squid's store log (cache.storeLog):
+// time action dir_number file_number hash status datehdr lastmod expires type expect-len/real-len method key
+type StoreLogEntry struct {
+	Timestamp time.Time
+	// Action is StoreLogSwapOut, StoreLogRelease, SWAPIN or CREATE
+	Action     string
+	FileNumber string
+	Status     int
+	// ContentType is "unknown" when the response had none
+	ContentType string
+	// ExpectedSize is the Content-Length of the response, or -1 if unknown
+	ExpectedSize int64
+	// Size is the number of body bytes actually stored
+	Size   int64
+	Method string
+	// URL is the URL, or store-id if the store-id helper rewrote it, the object is stored under
+	URL string
+}
+
+// ParseStoreLog parses the store log lines in logs, skipping lines that are not store log entries,
+// such as the access log and cache.log lines of the squid container. Unlike the access log, which
+// infers caching from TCP_HIT and TCP_MISS, a SWAPOUT entry records that an object was written to
+// the cache.
+func ParseStoreLog(logs string) []StoreLogEntry {
+	var entries []StoreLogEntry
+	for line := range strings.Lines(logs) {
+		if entry, err := parseStoreLogLine(line); err == nil {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries
+}
+
+// parseStoreLogLine parses a single store log line
+func parseStoreLogLine(line string) (*StoreLogEntry, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 13 {
+		return nil, fmt.Errorf("malformed store log entry: need >=13 fields, got %d: %q", len(fields), line)
+	}
+	switch fields[1] {
+	case StoreLogSwapOut, StoreLogRelease, "SWAPIN", "CREATE":
+	default:
+		return nil, fmt.Errorf("unknown store log action %q", fields[1])
+	}
+
+	timestamp, err := parseSquidTimestamp(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp %q: %w", fields[0], err)
+	}
+	status, err := strconv.Atoi(fields[5])
+	if err != nil {
+		return nil, fmt.Errorf("invalid status %q: %w", fields[5], err)
+	}
+	expectedStr, sizeStr, found := strings.Cut(fields[10], "/")
+	if !found {
+		return nil, fmt.Errorf("invalid expect-len/real-len %q", fields[10])
+	}
+	expectedSize, err := strconv.ParseInt(expectedStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expected length %q: %w", expectedStr, err)
+	}
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid length %q: %w", sizeStr, err)
+	}
+
+	return &StoreLogEntry{
+		Timestamp:    timestamp,
+		Action:       fields[1],
+		FileNumber:   fields[3],
+		Status:       status,
+		ContentType:  fields[9],
+		ExpectedSize: expectedSize,
+		Size:         size,
+		Method:       fields[11],
+		URL:          fields[12],
+	}, nil
+}
+
+// CollectStoreLogs retrieves the squid container logs of all given pods since a specific
+// timestamp and parses their store log entries. The chart must be configured with cache.storeLog.
+func CollectStoreLogs(ctx context.Context, client kubernetes.Interface, namespace string, pods []*corev1.Pod, since *metav1.Time) ([]StoreLogEntry, error) {
+	var entries []StoreLogEntry
+	for _, pod := range pods {
+		logs, err := GetPodLogsSince(ctx, client, namespace, pod.Name, SquidContainerName, since)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get logs from pod %s: %w", pod.Name, err)
+		}
+		entries = append(entries, ParseStoreLog(string(logs))...)
+	}
+	return entries, nil
+}
+
+// AssertSSLBumpActive retrieves the squid container logs of pod since a specific timestamp and
+// verifies that requests to host were SSL-bumped: the access log must contain both the CONNECT
+// tunnel and a decrypted GET of an https:// URL for host. It returns a descriptive error otherwise.
+func AssertSSLBumpActive(ctx context.Context, client kubernetes.Interface, namespace, pod, host string, since *metav1.Time) error {
+	logs, err := GetPodLogsSince(ctx, client, namespace, pod, SquidContainerName, since)
+	if err != nil {
+		return fmt.Errorf("failed to get logs from pod %s: %w", pod, err)
+	}
+	if err := VerifySSLBumpEntries(ParseSquidAccessLogs(logs), host); err != nil {
+		return fmt.Errorf("pod %s: %w", pod, err)
+	}
+	return nil
+}
+
+// VerifySSLBumpEntries checks that entries contain a CONNECT tunnel and a decrypted https:// GET for host
+func VerifySSLBumpEntries(entries []SquidAccessLogEntry, host string) error {
+	var tunnel, decrypted bool
+	for _, entry := range entries {
+		if entry.Host != host {
+			continue
+		}
+		switch {
+		case entry.Method == "CONNECT":
+			tunnel = true
+		case entry.Method == "GET" && strings.HasPrefix(entry.URL, "https://"):
+			decrypted = true
+		}
+	}
+
+	switch {
+	case !tunnel && !decrypted:
+		return fmt.Errorf("no access log entries for host %s among %d entries", host, len(entries))
+	case !tunnel:
+		return fmt.Errorf("no CONNECT tunnel logged for host %s", host)
+	case !decrypted:
+		return fmt.Errorf("CONNECT tunnel logged for host %s but no decrypted GET https:// request; SSL-bump is not active", host)
+	}
+	return nil
+}
+
+// AssertCacheHitForHost checks that the Squid access logs contain a cache hit (see IsCacheHit) for
+// a URL served from host, a regular expression matched against the start of the URL without its
+// scheme. The pattern may include a path for CDNs that share a storage host, e.g.
+// `s3\.[a-z0-9-]+\.amazonaws\.com/quayio-production-s3`. The error names the hosts that served
+// the cache hits instead, so a CDN migration that moves blobs to another backend is easy to spot.
+func AssertCacheHitForHost(logs string, host string) error {
+	hostRegexp, err := regexp.Compile(`^(?:` + host + `)`)
+	if err != nil {
+		return fmt.Errorf("invalid host pattern %q: %w", host, err)
+	}
+
+	var otherHosts []string
+	for _, entry := range ParseSquidAccessLogs([]byte(logs)) {
+		if !entry.IsCacheHit() || entry.Host == "" {
+			continue
+		}
+		_, hostAndPath, found := strings.Cut(entry.URL, "://")
+		if !found {
+			continue
+		}
+		if hostRegexp.MatchString(hostAndPath) {
+			return nil
+		}
+		if !slices.Contains(otherHosts, entry.Host) {
+			otherHosts = append(otherHosts, entry.Host)
+		}
+	}
+	if len(otherHosts) == 0 {
+		return fmt.Errorf("no cache hit logged for host %q", host)
+	}
+	return fmt.Errorf("no cache hit logged for host %q, cache hits were served from: %s", host, strings.Join(otherHosts, ", "))
+}
+
+// FindPeerStatus returns the peer status (e.g. HIER_DIRECT or FIRSTUP_PARENT) of the most recent
+// entry for requestURL, telling whether Squid forwarded it directly to the origin or via a parent
+func FindPeerStatus(entries []SquidAccessLogEntry, requestURL string) (string, error) {
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].URL == requestURL {
+			if entries[i].PeerStatus == "" {
+				return "", fmt.Errorf("access log entry for %s has no peer status", requestURL)
+			}
+			return entries[i].PeerStatus, nil
+		}
+	}
+	return "", fmt.Errorf("no access log entry for %s among %d entries", requestURL, len(entries))
+}
+
+// GetPeerStatusForRequest sends a GET request for requestURL through the Squid proxy of pod and returns
+// the peer status logged for it. The request goes to the pod IP rather than the service, so the entry
+// is logged by pod. Only plain http:// URLs are supported, as no CA is trusted for SSL-bumped requests.
+func GetPeerStatusForRequest(ctx context.Context, client kubernetes.Interface, namespace, pod string, requestURL string, since *metav1.Time) (string, error) {
+	squidPod, err := client.CoreV1().Pods(namespace).Get(ctx, pod, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get pod %s: %w", pod, err)
+	}
+	httpClient, err := newPodProxyClient(squidPod)
+	if err != nil {
+		return "", err
+	}
+	resp, err := httpClient.Get(requestURL)
+	if err != nil {
+		return "", fmt.Errorf("request for %s through pod %s failed: %w", requestURL, pod, err)
+	}
+	// Drain the body so Squid completes (and logs) the transaction
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// Squid writes the access log entry after the transaction completes, so it may lag the response
+	var peerStatus string
+	var lastErr error
+	err = wait.PollUntilContextTimeout(ctx, Interval, Timeout, true, func(ctx context.Context) (bool, error) {
+		logs, err := GetPodLogsSince(ctx, client, namespace, pod, SquidContainerName, since)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to get logs from pod %s: %w", pod, err)
+			return false, nil
+		}
+		peerStatus, lastErr = FindPeerStatus(ParseSquidAccessLogs(logs), requestURL)
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		if lastErr == nil {
+			lastErr = err
+		}
+		return "", fmt.Errorf("pod %s: %w", pod, lastErr)
+	}
+	return peerStatus, nil
+}
+
+// AssertNoCredentialsInLogs fails the current test if logs contain credentials such as
+// Authorization headers, token query parameters, signed URL signatures, URL userinfo or bearer tokens
+func AssertNoCredentialsInLogs(logs string) {
+	findings := credentials.Find(logs)
+	Expect(findings).To(BeEmpty(), "Logs should not contain credentials")
+}
+
+// compileURLRegexACL compiles the patterns of a url_regex ACL the way Squid matches them: each
+// whitespace-separated token is a POSIX extended regular expression, and tokens after -i match
+// case-insensitively until +i
+func compileURLRegexACL(patterns []string) ([]*regexp.Regexp, error) {
+	if err := ValidateAllowListPatterns(patterns); err != nil {
+		return nil, err
+	}
+	var regexps []*regexp.Regexp
+	for _, pattern := range patterns {
+		ignoreCase := false
+		for _, token := range strings.Fields(pattern) {
+			switch token {
+			case "-i":
+				ignoreCase = true
+				continue
+			case "+i":
+				ignoreCase = false
+				continue
+			}
+			// CompilePOSIX has no case-insensitive flag; the validated expression is also valid RE2
+			compile := regexp.CompilePOSIX
+			if ignoreCase {
+				token = "(?i)" + token
+				compile = regexp.Compile
+			}
+			re, err := compile(token)
+			if err != nil {
+				return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+			}
+			regexps = append(regexps, re)
+		}
+	}
+	return regexps, nil
+}
+
+// FindFilteredAccessLogEntries returns the entries whose URL matches one of the accessLogFilters
+// patterns, i.e. requests that Squid should have omitted from the access log
+func FindFilteredAccessLogEntries(entries []SquidAccessLogEntry, filters []string) ([]SquidAccessLogEntry, error) {
+	regexps, err := compileURLRegexACL(filters)
+	if err != nil {
+		return nil, err
+	}
+	var matches []SquidAccessLogEntry
+	for _, entry := range entries {
+		for _, re := range regexps {
+			if re.MatchString(entry.URL) {
+				matches = append(matches, entry)
+				break
+			}
+		}
+	}
+	return matches, nil
+}
+
+// AssertAccessLogFiltered fails the current test if entries contain requests matching one of the
+// accessLogFilters patterns configured with SquidHelmValues.AccessLogFilters
+//
+// Example usage:
+//
+//	entries, err := CollectSquidAccessLogs(ctx, clientset, namespace, pods, &start)
+//	Expect(err).NotTo(HaveOccurred())
+//	AssertAccessLogFiltered(entries, []string{"/healthz$"})
+func AssertAccessLogFiltered(entries []SquidAccessLogEntry, filters []string) {
+	matches, err := FindFilteredAccessLogEntries(entries, filters)
+	Expect(err).NotTo(HaveOccurred(), "Access log filters should be valid url_regex patterns")
+
+	var logged []string
+	for _, entry := range matches {
+		logged = append(logged, fmt.Sprintf("%s %s (pod %s)", entry.Method, entry.URL, entry.Pod))
+	}
+	Expect(logged).To(BeEmpty(), "Requests matching the access log filters %q should not be logged", filters)
+}
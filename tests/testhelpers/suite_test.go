@@ -0,0 +1,13 @@
+package testhelpers
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestTestHelpersUnit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Test Helpers Unit Suite (package testhelpers)")
+}
@@ -0,0 +1,7 @@
+module github.com/intra-sh/icap
+
+go 1.21
+
+require (
+	
+)
\ No newline at end of file